@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/lifecycle"
+	"servicegraph-builder/pkg/security"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	readinessTimeout  = 3 * time.Second
+	readinessInterval = 10 * time.Second
+)
+
+type checkResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type readinessReport struct {
+	Ready  bool          `json:"ready"`
+	Phase  string        `json:"phase"`
+	Checks []checkResult `json:"checks"`
+}
+
+// startAdminServer serves the admin endpoints until shutdown, over TLS
+// and requiring a bearer token for all but the probes and metrics if
+// configured.
+func startAdminServer(scfg config.ServerConfig) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /api/v1/graph", handleGraph)
+	mux.HandleFunc("GET /api/v1/topology/diff", handleTopologyDiff)
+	mux.HandleFunc("GET /api/v1/sampling", handleSampling)
+	mux.HandleFunc("GET /api/v1/dlq", handleDLQList)
+	mux.HandleFunc("GET /api/v1/dlq/{id}", handleDLQGet)
+	mux.HandleFunc("DELETE /api/v1/dlq/{id}", handleDLQDelete)
+	mux.HandleFunc("POST /api/v1/dlq/replay", handleDLQReplay)
+	mux.HandleFunc("GET /api/v1/events", handleEvents)
+	mux.HandleFunc("POST /api/v1/alerts", handleAlertWebhook)
+	mux.HandleFunc("GET /api/v1/alerts", handleAlerts)
+	mux.HandleFunc("GET /api/v1/incidents", handleIncidents)
+	mux.HandleFunc("GET /api/v1/incidents/{id}", handleIncident)
+	mux.HandleFunc("POST /api/v1/incidents/{id}/close", handleCloseIncident)
+	mux.HandleFunc("POST /api/v1/incidents/{id}/timeline", handleIncidentTimeline)
+	mux.HandleFunc("PUT /api/v1/incidents/{id}/report", handleIncidentReport)
+	mux.HandleFunc("POST /api/v1/incidents/{id}/analyze", handleAnalyzeIncident)
+	mux.HandleFunc("GET /api/v1/incidents/{id}/stream", handleIncidentStream)
+	mux.HandleFunc("GET /api/v1/incidents/{id}/ws", handleIncidentWebSocket)
+	mux.HandleFunc("GET /api/v1/maintenance", handleMaintenanceWindows)
+	mux.HandleFunc("POST /api/v1/maintenance", handleCreateMaintenanceWindow)
+	mux.HandleFunc("GET /api/v1/maintenance/audit", handleMaintenanceAudit)
+	mux.HandleFunc("GET /api/v1/maintenance/{id}", handleMaintenanceWindow)
+	mux.HandleFunc("POST /api/v1/maintenance/{id}/expire", handleExpireMaintenanceWindow)
+	mux.HandleFunc("GET /api/v1/slos", handleSLOs)
+	mux.HandleFunc("POST /api/v1/slos", handleCreateSLO)
+	mux.HandleFunc("GET /api/v1/slos/{id}", handleSLO)
+	mux.HandleFunc("PUT /api/v1/slos/{id}", handleUpdateSLO)
+	mux.HandleFunc("DELETE /api/v1/slos/{id}", handleDeleteSLO)
+	mux.HandleFunc("POST /api/v1/context", handleContextPack)
+	mux.HandleFunc("GET /api/v1/metrics/services/{name}", handleServiceMetrics)
+	mux.HandleFunc("GET /api/v1/metrics/edges/{caller}/{callee}", handleEdgeMetrics)
+
+	var handler http.Handler = mux
+	if scfg.AdminAuth.Enabled() {
+		if !scfg.AdminTLS.Enabled {
+			log.Warn().Msg("Admin bearer token auth is enabled without TLS; tokens are sent in clear text")
+		}
+		auth, err := security.NewTokenAuth(scfg.AdminAuth, scfg.AdminTLS.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		handler = auth.Middleware(mux, probe)
+	}
+
+	lis, err := net.Listen("tcp", scfg.AdminAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on admin address: %w", err)
+	}
+	if scfg.AdminTLS.Enabled {
+		reloader, err := security.NewCertReloader(scfg.AdminTLS)
+		if err != nil {
+			lis.Close()
+			return nil, err
+		}
+		lis = tls.NewListener(lis, reloader.TLSConfig())
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("admin server error")
+		}
+	}()
+	log.Info().Bool("tls", scfg.AdminTLS.Enabled).Bool("auth", scfg.AdminAuth.Enabled()).
+		Msgf("Starting admin server on %s", scfg.AdminAddress)
+	return srv, nil
+}
+
+// probe lets the kubelet's probes and Prometheus scrapes through without
+// a token. Everything else, reads included, exposes the graph or
+// incidents.
+func probe(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return r.Method == http.MethodGet || r.Method == http.MethodHead
+	}
+	return false
+}
+
+// handleHealthz is the liveness probe: the process is up and hasn't
+// finished shutting down. It deliberately ignores dependencies so a
+// Neo4j outage doesn't get the pod restarted.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if lc.Phase() == lifecycle.PhaseStopped {
+		http.Error(w, "stopped", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz is the readiness probe: serving, storage reachable and
+// Kubernetes caches synced.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := checkReadiness(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func checkReadiness(ctx context.Context) readinessReport {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	phase := lc.Phase()
+	checks := []checkResult{
+		{Name: "lifecycle", OK: phase == lifecycle.PhaseServing},
+		checkStorage(ctx),
+		{Name: "kubernetes", OK: k8sSynced()},
+	}
+	if !checks[2].OK {
+		checks[2].Error = "informer caches not synced"
+	}
+
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.OK
+	}
+	return readinessReport{Ready: ready, Phase: string(phase), Checks: checks}
+}
+
+// checkStorage passes while writes are being spooled, so the collector
+// keeps sending during a storage outage instead of backing off.
+func checkStorage(ctx context.Context) checkResult {
+	res := check("storage", pingStorage(ctx))
+	if rs, ok := store.(*db.ResilientStore); ok && !res.OK && rs.Spooling() {
+		res.OK = true
+		res.Error = "spooling: " + res.Error
+	}
+	return res
+}
+
+func pingStorage(ctx context.Context) error {
+	if store == nil {
+		return errors.New("not connected")
+	}
+	return store.Ping(ctx)
+}
+
+func check(name string, err error) checkResult {
+	if err != nil {
+		return checkResult{Name: name, Error: err.Error()}
+	}
+	return checkResult{Name: name, OK: true}
+}
+
+// watchReadiness mirrors readiness into the gRPC health service on the
+// OTLP port until ctx is done.
+func watchReadiness(ctx context.Context, hs *health.Server) {
+	ticker := time.NewTicker(readinessInterval)
+	defer ticker.Stop()
+	for {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if checkReadiness(ctx).Ready {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(coltracepb.TraceService_ServiceDesc.ServiceName, status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
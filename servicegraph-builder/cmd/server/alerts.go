@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/alerts"
+	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// maxAlertPayloadBytes bounds an Alertmanager notification body.
+	maxAlertPayloadBytes = 4 << 20
+	// defaultAlertsSince is how far back GET /api/v1/alerts looks by default.
+	defaultAlertsSince = 24 * time.Hour
+)
+
+type alertReceipt struct {
+	GroupKey string `json:"group_key"`
+	Alerts   int    `json:"alerts"`
+	// Unmapped counts alerts that couldn't be tied to a service.
+	Unmapped int `json:"unmapped"`
+	// Incidents are the IDs of the incidents the alerts are in.
+	Incidents []string `json:"incidents,omitempty"`
+	// Suppressed counts alerts kept out of incidents by maintenance
+	// windows.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+type alertGroupList struct {
+	Count  int                 `json:"count"`
+	Groups []models.AlertGroup `json:"groups"`
+}
+
+// handleAlertWebhook receives Alertmanager webhook notifications, persists
+// the group and clusters its alerts into incidents. Storage failures
+// answer 5xx so Alertmanager retries.
+func handleAlertWebhook(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+	p, err := alerts.Decode(http.MaxBytesReader(w, r.Body, maxAlertPayloadBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	topo := lazyGraph(r.Context())
+	services := func() []models.Service { return topo().Services }
+	now := time.Now()
+	group := p.Group(now, func(a alerts.Alert) (string, []string) {
+		return alertServices(alerts.TargetOf(a.Labels, p.CommonLabels, cfg.Alerts), services)
+	})
+	receipt := alertReceipt{GroupKey: group.GroupKey, Alerts: len(group.Alerts)}
+	for _, a := range group.Alerts {
+		metrics.AlertsReceived.WithLabelValues(a.Status).Inc()
+		if len(a.Services) == 0 {
+			receipt.Unmapped++
+			metrics.AlertsUnmapped.Inc()
+			log.Warn().Str("alert", a.Name).Str("fingerprint", a.Fingerprint).Msg("Alert matches no service")
+		}
+	}
+
+	if err := store.WriteAlertGroup(r.Context(), group); err != nil {
+		log.Error().Err(err).Str("group_key", group.GroupKey).Msg("Failed to store alert group")
+		status := http.StatusInternalServerError
+		if errors.Is(err, db.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	receipt.Incidents, receipt.Suppressed = correlateAlerts(r.Context(), group, topo(), now)
+	log.Info().
+		Str("group_key", group.GroupKey).
+		Str("status", p.Status).
+		Int("alerts", receipt.Alerts).
+		Int("unmapped", receipt.Unmapped).
+		Msg("Received alert group")
+	writeJSON(w, receipt)
+}
+
+// handleAlerts serves stored alert groups, most recently updated first.
+// ?status= and ?service= filter, ?since= takes a duration back from now
+// or an RFC 3339 time.
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since := time.Now().Add(-defaultAlertsSince)
+	if s := q.Get("since"); s != "" {
+		t, err := parseSince(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	status, service := q.Get("status"), strings.ToLower(q.Get("service"))
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	groups, err := store.ReadAlertGroups(r.Context(), since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	out := make([]models.AlertGroup, 0, len(groups))
+	for _, g := range groups {
+		if status != "" && g.Status != status {
+			continue
+		}
+		if service != "" && !slices.ContainsFunc(g.Alerts, func(a models.Alert) bool {
+			return slices.Contains(a.Services, service)
+		}) {
+			continue
+		}
+		out = append(out, g)
+	}
+	writeJSON(w, alertGroupList{Count: len(out), Groups: out})
+}
+
+// parseSince reads a duration back from now or an RFC 3339 time.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("invalid since: want a duration or an RFC 3339 time")
+}
+
+// lazyGraph returns a function returning the graph, read from storage on
+// first use. An unreadable graph is empty.
+func lazyGraph(ctx context.Context) func() *models.Graph {
+	var g *models.Graph
+	return func() *models.Graph {
+		if g == nil {
+			var err error
+			if g, err = store.ReadGraph(ctx); err != nil {
+				log.Warn().Err(err).Msg("Cannot read graph to map and cluster alerts")
+				g = &models.Graph{}
+			}
+		}
+		return g
+	}
+}
+
+// alertServices maps an alert target onto graph services. A service
+// label is taken as is. A pod maps to the Kubernetes Services selecting
+// it or, failing that, to the graph service whose name prefixes the pod
+// name, the way Deployment and StatefulSet pods are named.
+func alertServices(t alerts.Target, services func() []models.Service) (string, []string) {
+	if t.Service != "" {
+		return t.Namespace, []string{t.Service}
+	}
+	if t.Pod == "" {
+		return t.Namespace, nil
+	}
+	if ns, names := podServices(t.Namespace, t.Pod); len(names) > 0 {
+		return ns, names
+	}
+
+	best := ""
+	for _, svc := range services() {
+		if t.Namespace != "" && svc.Namespace != "" && svc.Namespace != t.Namespace {
+			continue
+		}
+		if strings.HasPrefix(t.Pod, svc.Name+"-") && len(svc.Name) > len(best) {
+			best = svc.Name
+		}
+	}
+	if best == "" {
+		return t.Namespace, nil
+	}
+	return t.Namespace, []string{best}
+}
+
+// podServices returns the namespace of the named pod and the names of the
+// Services whose selectors match it, from the informer caches.
+func podServices(ns, name string) (string, []string) {
+	if podLister == nil {
+		return ns, nil
+	}
+	var pod *corev1.Pod
+	if ns != "" {
+		pod, _ = podLister.Pods(ns).Get(name)
+	} else {
+		pods, _ := listPods("", labels.Everything())
+		for _, p := range pods {
+			if p.Name == name {
+				pod = p
+				break
+			}
+		}
+	}
+	if pod == nil {
+		return ns, nil
+	}
+	svcs, err := svcLister.Services(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		return pod.Namespace, nil
+	}
+	var names []string
+	for _, svc := range svcs {
+		if len(svc.Spec.Selector) > 0 && labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			names = append(names, strings.ToLower(svc.Name))
+		}
+	}
+	sort.Strings(names)
+	return pod.Namespace, names
+}
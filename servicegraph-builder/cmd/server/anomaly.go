@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"servicegraph-builder/pkg/anomaly"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/webhook"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultEventsSince is how far back GET /api/v1/events looks by default.
+	defaultEventsSince = 24 * time.Hour
+	defaultEventsLimit = 100
+)
+
+var (
+	detector     *anomaly.Detector
+	eventWebhook *webhook.Client
+)
+
+// startAnomalyDetection seeds the baseline from storage and evaluates it
+// every window until shutdown.
+func startAnomalyDetection(ctx context.Context) {
+	acfg := cfg.Anomaly
+	if !acfg.Enabled {
+		return
+	}
+	detector = anomaly.New(acfg, time.Now())
+	if acfg.Webhook.URL != "" {
+		eventWebhook = webhook.New(acfg.Webhook)
+	}
+
+	// Without a seed every stored edge would be new once learning ends;
+	// with storage down they are only reported if seen after it
+	if g, err := store.ReadGraph(ctx); err != nil {
+		log.Warn().Err(err).Msg("Cannot seed anomaly baseline from storage")
+	} else {
+		var edges []models.Edge
+		for _, e := range g.Edges {
+			if e.Origin == models.OriginObserved || e.Origin == "" {
+				edges = append(edges, e.Edge)
+			}
+		}
+		detector.Seed(edges)
+	}
+	metrics.AnomalyBaselineEdges.Set(float64(detector.Len()))
+	log.Info().
+		Dur("window", acfg.Window).
+		Dur("learning_period", acfg.LearningPeriod).
+		Int("known_edges", detector.Len()).
+		Msg("Anomaly detection enabled")
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(acfg.Window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case now := <-ticker.C:
+				publishEvents(runCtx, detector.Evaluate(now))
+				metrics.AnomalyBaselineEdges.Set(float64(detector.Len()))
+			}
+		}
+	}()
+	lc.OnShutdown("anomaly-detection", func(sctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+	})
+}
+
+// publishEvents stores events and sends them to the webhook. Neither
+// failing holds up detection.
+func publishEvents(ctx context.Context, events []models.GraphEvent) {
+	if len(events) == 0 {
+		return
+	}
+	for _, ev := range events {
+		metrics.GraphEvents.WithLabelValues(ev.Kind).Inc()
+		log.Info().Str("kind", ev.Kind).Str("caller", ev.Caller).Str("callee", ev.Callee).Msg(ev.Message)
+	}
+	if err := store.WriteEvents(ctx, events); err != nil {
+		log.Error().Err(err).Int("events", len(events)).Msg("Failed to store graph events")
+	}
+	recordChanges(ctx, events)
+	if eventWebhook != nil {
+		if err := eventWebhook.Post(ctx, eventList{Count: len(events), Events: events}); err != nil {
+			metrics.WebhookDeliveries.WithLabelValues("failure").Inc()
+			log.Error().Err(err).Int("events", len(events)).Msg("Failed to deliver graph events")
+		} else {
+			metrics.WebhookDeliveries.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+type eventList struct {
+	Count  int                 `json:"count"`
+	Events []models.GraphEvent `json:"events"`
+}
+
+// handleEvents serves stored graph events, newest first. ?since= takes a
+// duration back from now or an RFC 3339 time, ?kind= filters, ?limit=
+// caps the result.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since := time.Now().Add(-defaultEventsSince)
+	if s := q.Get("since"); s != "" {
+		t, err := parseSince(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	limit := defaultEventsLimit
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	kind := q.Get("kind")
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Filter by kind after reading, so read everything in range
+	readLimit := limit
+	if kind != "" {
+		readLimit = 0
+	}
+	events, err := store.ReadEvents(r.Context(), since, readLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	out := make([]models.GraphEvent, 0, len(events))
+	for _, ev := range events {
+		if (kind == "" || ev.Kind == kind) && len(out) < limit {
+			out = append(out, ev)
+		}
+	}
+	writeJSON(w, eventList{Count: len(out), Events: out})
+}
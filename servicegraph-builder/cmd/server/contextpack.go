@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/alerts"
+	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/graph"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/rca"
+	"servicegraph-builder/pkg/red"
+
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// firingAlertsSince is how far back alert groups are read for the alerts
+// firing on a pack's services. Alertmanager re-sends firing groups well
+// within it.
+const firingAlertsSince = 24 * time.Hour
+
+var redStats *red.Tracker
+
+// observeSpan feeds a span to anomaly detection and the RED stats,
+// before deduplication hides repeats. It goes by the service and edge
+// Export decoded, so the span needn't be enriched.
+func observeSpan(it *spanItem) {
+	if it.service == "unknown" {
+		return
+	}
+	isError := it.pspan.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR
+	now := time.Now()
+	service := strings.ToLower(strings.TrimSpace(it.service))
+	// A failed call is as much the callee's error as the caller's
+	sampled := []string{service}
+	if edge, ok := db.ObservedEdge(it.caller, it.callee, it.pspan.Name); ok {
+		if edge.Callee != service {
+			sampled = append(sampled, edge.Callee)
+		}
+		if detector != nil {
+			detector.Observe(edge)
+		}
+		if redStats != nil {
+			redStats.Record(now, red.Call{
+				Caller:   edge.Caller,
+				Callee:   edge.Callee,
+				Duration: spanDuration(it.pspan),
+				Error:    isError,
+			})
+		}
+	}
+	if redStats != nil {
+		for _, s := range errorSamples(it.pspan, service, isError, now) {
+			for _, name := range sampled {
+				redStats.AddSample(name, s)
+			}
+		}
+	}
+}
+
+func spanDuration(p *tracepb.Span) time.Duration {
+	if p.EndTimeUnixNano <= p.StartTimeUnixNano {
+		return 0
+	}
+	return time.Duration(p.EndTimeUnixNano - p.StartTimeUnixNano)
+}
+
+// errorSamples turns a span's exception events, or its error status, into
+// samples. Spans without either give none.
+func errorSamples(p *tracepb.Span, service string, isError bool, now time.Time) []red.Sample {
+	base := red.Sample{
+		Time:      now,
+		Service:   service,
+		TraceID:   hex.EncodeToString(p.TraceId),
+		SpanID:    hex.EncodeToString(p.SpanId),
+		Operation: p.Name,
+	}
+	if p.EndTimeUnixNano > 0 {
+		base.Time = time.Unix(0, int64(p.EndTimeUnixNano)).UTC()
+	}
+	var out []red.Sample
+	for _, ev := range p.Events {
+		if ev.Name != "exception" {
+			continue
+		}
+		s := base
+		for _, kv := range ev.Attributes {
+			switch kv.Key {
+			case "exception.type":
+				s.Type = kv.Value.GetStringValue()
+			case "exception.message":
+				s.Message = kv.Value.GetStringValue()
+			}
+		}
+		if ev.TimeUnixNano > 0 {
+			s.Time = time.Unix(0, int64(ev.TimeUnixNano)).UTC()
+		}
+		out = append(out, s)
+	}
+	if len(out) == 0 && isError {
+		s := base
+		s.Message = p.GetStatus().GetMessage()
+		if s.Message == "" {
+			s.Message = "span status error"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// contextPack is everything the RCA agents need about an alert, in one
+// document.
+type contextPack struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Window      string         `json:"window"`
+	Depth       int            `json:"depth"`
+	Alerts      []models.Alert `json:"alerts"`
+	// Unmapped counts alerts that couldn't be tied to a service.
+	Unmapped int           `json:"unmapped_alerts"`
+	Services []packService `json:"services"`
+	Edges    []packEdge    `json:"edges"`
+	// Changes are newest first.
+	Changes []packChange `json:"changes"`
+	// Warnings name the parts of the pack that couldn't be filled in.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type packService struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	OwnerKind string `json:"owner_kind,omitempty"`
+	OwnerName string `json:"owner_name,omitempty"`
+	// Role is alerting, upstream, downstream or both.
+	Role  string `json:"role"`
+	Depth int    `json:"depth"`
+	// FiringAlerts names the alerts firing on the service.
+	FiringAlerts []string `json:"firing_alerts,omitempty"`
+	// Inbound is the RED stats of all calls into the service.
+	Inbound *red.Stats `json:"inbound,omitempty"`
+	// SLOs is the latest status of the service's SLOs.
+	SLOs []models.SLOStatus `json:"slos,omitempty"`
+	// Pods and LogSamples are filled in for alerting services.
+	Pods       []podStatus  `json:"pods,omitempty"`
+	LogSamples []red.Sample `json:"log_samples,omitempty"`
+}
+
+type packEdge struct {
+	models.GraphEdge
+	RED *red.Stats `json:"red,omitempty"`
+}
+
+type packChange struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Service string    `json:"service"`
+	// Reason is a restarted container's termination reason.
+	Reason string `json:"reason,omitempty"`
+	// Caller and Callee are set for graph events.
+	Caller  string `json:"caller,omitempty"`
+	Callee  string `json:"callee,omitempty"`
+	Message string `json:"message"`
+}
+
+type podStatus struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Phase     string `json:"phase"`
+	Ready     bool   `json:"ready"`
+	Restarts  int32  `json:"restarts"`
+	Node      string `json:"node,omitempty"`
+	// Reason is why a container is waiting or last terminated, e.g.
+	// CrashLoopBackOff or OOMKilled.
+	Reason    string    `json:"reason,omitempty"`
+	StartedAt time.Time `json:"started_at,omitzero"`
+}
+
+// handleContextPack builds a context pack for the Alertmanager payload in
+// the body. ?depth= overrides the neighbourhood depth and ?window= how
+// far back stats and changes reach, both capped by the config.
+func handleContextPack(w http.ResponseWriter, r *http.Request) {
+	ccfg := cfg.Context
+	q := r.URL.Query()
+	depth := ccfg.Depth
+	if d := q.Get("depth"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 0 || n > ccfg.MaxDepth {
+			http.Error(w, fmt.Sprintf("invalid depth: want 0 to %d", ccfg.MaxDepth), http.StatusBadRequest)
+			return
+		}
+		depth = n
+	}
+	window := ccfg.Window
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute || d > ccfg.Window {
+			http.Error(w, fmt.Sprintf("invalid window: want 1m to %s", ccfg.Window), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	p, err := alerts.Decode(http.MaxBytesReader(w, r.Body, maxAlertPayloadBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := r.Context()
+	g, err := store.ReadGraph(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	now := time.Now()
+	group := p.Group(now, func(a alerts.Alert) (string, []string) {
+		return alertServices(alerts.TargetOf(a.Labels, p.CommonLabels, cfg.Alerts), func() []models.Service { return g.Services })
+	})
+
+	unmapped := 0
+	var roots []string
+	for _, a := range group.Alerts {
+		if len(a.Services) == 0 {
+			unmapped++
+		}
+		for _, s := range a.Services {
+			if !slices.Contains(roots, s) {
+				roots = append(roots, s)
+			}
+		}
+	}
+	pack := buildContextPack(ctx, g, roots, group.Alerts, depth, window, now)
+	pack.Unmapped = unmapped
+	writeJSON(w, pack)
+}
+
+// buildContextPack gathers the context of the current alerts on roots:
+// their neighbourhood in g up to depth hops, with RED stats, firing
+// alerts, changes and pods over the window ending at now.
+func buildContextPack(ctx context.Context, g *models.Graph, roots []string, current []models.Alert, depth int, window time.Duration, now time.Time) contextPack {
+	since := now.Add(-window)
+	pack := contextPack{
+		GeneratedAt: now.UTC(),
+		Window:      window.String(),
+		Depth:       depth,
+		Alerts:      current,
+		Services:    []packService{},
+		Edges:       []packEdge{},
+		Changes:     []packChange{},
+	}
+	roots = slices.Clone(roots)
+	sort.Strings(roots)
+
+	neighbours, edges := graph.Neighbourhood(g, roots, depth)
+	known := make(map[string]models.Service, len(g.Services))
+	for _, s := range g.Services {
+		known[s.Name] = s
+	}
+	inPack := make(map[string]bool)
+	add := func(name, role string, depth int) {
+		inPack[name] = true
+		svc := known[name]
+		ps := packService{
+			Name:      name,
+			Namespace: svc.Namespace,
+			OwnerKind: svc.OwnerKind,
+			OwnerName: svc.OwnerName,
+			Role:      role,
+			Depth:     depth,
+		}
+		if redStats != nil {
+			if st, ok := redStats.Inbound(name, window, now); ok {
+				ps.Inbound = &st
+			}
+		}
+		ps.SLOs = serviceSLOs(name)
+		pack.Services = append(pack.Services, ps)
+	}
+	for _, name := range roots {
+		add(name, rca.RoleAlerting, 0)
+	}
+	for _, n := range neighbours {
+		add(n.Name, n.Direction, n.Depth)
+	}
+
+	for _, e := range edges {
+		pe := packEdge{GraphEdge: e}
+		if redStats != nil {
+			if st, ok := redStats.Edge(e.Caller, e.Callee, window, now); ok {
+				pe.RED = &st
+			}
+		}
+		pack.Edges = append(pack.Edges, pe)
+	}
+
+	// Alerts firing on any pack service, this payload's included
+	firing := make(map[string][]string)
+	addFiring := func(a models.Alert) {
+		if a.Status != models.AlertFiring {
+			return
+		}
+		for _, s := range a.Services {
+			if inPack[s] && !slices.Contains(firing[s], a.Name) {
+				firing[s] = append(firing[s], a.Name)
+			}
+		}
+	}
+	for _, a := range current {
+		addFiring(a)
+	}
+	if groups, err := store.ReadAlertGroups(ctx, now.Add(-firingAlertsSince)); err != nil {
+		pack.Warnings = append(pack.Warnings, "alerts: "+err.Error())
+	} else {
+		for _, g := range groups {
+			for _, a := range g.Alerts {
+				addFiring(a)
+			}
+		}
+	}
+
+	if events, err := store.ReadEvents(ctx, since, 0); err != nil {
+		pack.Warnings = append(pack.Warnings, "graph events: "+err.Error())
+	} else {
+		for _, ev := range events {
+			service := ev.Caller
+			if !inPack[service] {
+				if service = ev.Callee; !inPack[service] {
+					continue
+				}
+			}
+			pack.Changes = append(pack.Changes, packChange{
+				Time:    ev.DetectedAt,
+				Kind:    ev.Kind,
+				Service: service,
+				Caller:  ev.Caller,
+				Callee:  ev.Callee,
+				Message: ev.Message,
+			})
+		}
+	}
+
+	if podLister == nil {
+		pack.Warnings = append(pack.Warnings, "kubernetes: lookups disabled")
+	}
+	for i := range pack.Services {
+		ps := &pack.Services[i]
+		sort.Strings(firing[ps.Name])
+		ps.FiringAlerts = firing[ps.Name]
+
+		pods := servicePods(ps.Name, ps.Namespace)
+		if ps.OwnerKind == "" && len(pods) > 0 {
+			ps.OwnerKind, ps.OwnerName = podOwner(pods[0])
+		}
+		pack.Changes = append(pack.Changes, workloadChanges(ps.Name, pods, since)...)
+		if ps.Role != rca.RoleAlerting {
+			continue
+		}
+		for _, pod := range pods {
+			ps.Pods = append(ps.Pods, podStatusOf(pod))
+		}
+		if redStats != nil {
+			ps.LogSamples = redStats.Samples(ps.Name, since, cfg.Context.LogSamples)
+		}
+	}
+	sort.SliceStable(pack.Changes, func(i, j int) bool { return pack.Changes[i].Time.After(pack.Changes[j].Time) })
+
+	log.Info().
+		Strs("services", roots).
+		Int("neighbours", len(neighbours)).
+		Int("changes", len(pack.Changes)).
+		Msg("Built context pack")
+	return pack
+}
+
+// servicePods returns the pods behind the Kubernetes Service called name,
+// or labelled app=name, from the informer caches.
+func servicePods(name, ns string) []*corev1.Pod {
+	if podLister == nil {
+		return nil
+	}
+	if svc, err := findService(ns, name); err == nil && svc != nil && len(svc.Spec.Selector) > 0 {
+		pods, _ := listPods(svc.Namespace, labels.SelectorFromSet(svc.Spec.Selector))
+		return pods
+	}
+	pods, _ := listPods(ns, labels.SelectorFromSet(labels.Set{"app": name}))
+	return pods
+}
+
+// podOwner walks a pod's controller references up to its workload.
+func podOwner(pod *corev1.Pod) (kind, name string) {
+	refs := pod.OwnerReferences
+	for len(refs) > 0 {
+		ref := refs[0]
+		kind, name = ref.Kind, ref.Name
+		if ref.Kind != "ReplicaSet" {
+			break
+		}
+		rs, _ := rsLister.ReplicaSets(pod.Namespace).Get(ref.Name)
+		if rs == nil {
+			break
+		}
+		refs = rs.OwnerReferences
+	}
+	return kind, name
+}
+
+// workloadChanges reports rollouts of the pods' Deployments and container
+// restarts since since.
+func workloadChanges(service string, pods []*corev1.Pod, since time.Time) []packChange {
+	var out []packChange
+	deployments := make(map[string]bool)
+	for _, pod := range pods {
+		if kind, name := podOwner(pod); kind == "Deployment" {
+			deployments[pod.Namespace+"/"+name] = true
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			t := cs.LastTerminationState.Terminated
+			if t == nil || t.FinishedAt.Time.Before(since) {
+				continue
+			}
+			out = append(out, packChange{
+				Time:    t.FinishedAt.Time.UTC(),
+				Kind:    rca.ChangeRestart,
+				Service: service,
+				Reason:  t.Reason,
+				Message: fmt.Sprintf("container %s in pod %s restarted: %s (exit code %d)", cs.Name, pod.Name, t.Reason, t.ExitCode),
+			})
+		}
+	}
+
+	for key := range deployments {
+		ns, name, _ := strings.Cut(key, "/")
+		rss, err := rsLister.ReplicaSets(ns).List(labels.Everything())
+		if err != nil {
+			continue
+		}
+		for _, rs := range rss {
+			if !ownedBy(rs, "Deployment", name) || rs.CreationTimestamp.Time.Before(since) {
+				continue
+			}
+			out = append(out, packChange{
+				Time:    rs.CreationTimestamp.Time.UTC(),
+				Kind:    rca.ChangeRollout,
+				Service: service,
+				Message: fmt.Sprintf("Deployment %s rolled out ReplicaSet %s (revision %s)", name, rs.Name, rs.Annotations["deployment.kubernetes.io/revision"]),
+			})
+		}
+	}
+	return out
+}
+
+func ownedBy(rs *appsv1.ReplicaSet, kind, name string) bool {
+	for _, ref := range rs.OwnerReferences {
+		if ref.Kind == kind && ref.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func podStatusOf(pod *corev1.Pod) podStatus {
+	ps := podStatus{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Phase:     string(pod.Status.Phase),
+		Node:      pod.Spec.NodeName,
+	}
+	if pod.Status.StartTime != nil {
+		ps.StartedAt = pod.Status.StartTime.Time.UTC()
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			ps.Ready = c.Status == corev1.ConditionTrue
+		}
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		ps.Restarts += cs.RestartCount
+		switch {
+		case cs.State.Waiting != nil && cs.State.Waiting.Reason != "":
+			ps.Reason = cs.State.Waiting.Reason
+		case ps.Reason == "" && cs.LastTerminationState.Terminated != nil:
+			ps.Reason = cs.LastTerminationState.Terminated.Reason
+		}
+	}
+	return ps
+}
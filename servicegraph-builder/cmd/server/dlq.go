@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/dlq"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+
+	"github.com/rs/zerolog/log"
+)
+
+// dlqPollInterval is how often the queue is checked for due retries.
+const dlqPollInterval = time.Second
+
+var (
+	deadLetters *dlq.Queue
+	// dlqWake has the retry worker look for due entries before its next
+	// poll
+	dlqWake = make(chan struct{}, 1)
+)
+
+// startDeadLetterQueue opens the configured queue and retries due
+// entries until shutdown. Retries only ever run on its one worker, so
+// an entry is never retried twice at once.
+func startDeadLetterQueue() error {
+	dcfg := cfg.DLQ
+	if dcfg.Dir == "" {
+		return nil
+	}
+	q, err := dlq.Open(dcfg.Dir, dcfg.MaxEntries, dlq.Backoff{Initial: dcfg.InitialBackoff, Max: dcfg.MaxBackoff})
+	if err != nil {
+		return err
+	}
+	deadLetters = q
+	metrics.DLQDepth.Set(float64(q.Len()))
+	log.Info().Str("dir", dcfg.Dir).Int("entries", q.Len()).Msg("Dead-letter queue opened")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(dlqPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-dlqWake:
+			}
+			for _, e := range q.Due(time.Now()) {
+				if ctx.Err() != nil {
+					return
+				}
+				retryDeadLetter(ctx, e)
+			}
+		}
+	}()
+	lc.OnShutdown("dlq-retry", func(sctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+	})
+	return nil
+}
+
+// deadLetter queues a span that failed in stage.
+func deadLetter(stage string, span models.EnrichedSpan, resourceAttrs map[string]interface{}, cause error) {
+	if deadLetters == nil {
+		return
+	}
+	metrics.DLQEnqueued.WithLabelValues(stage).Inc()
+	evicted, err := deadLetters.Put(stage, span, k8sResourceAttrs(resourceAttrs), cause)
+	if err != nil {
+		log.Error().Err(err).Str("edge", span.HashableName).Msg("Failed to dead-letter span")
+	}
+	metrics.DLQEvicted.Add(float64(evicted))
+	metrics.DLQDepth.Set(float64(deadLetters.Len()))
+}
+
+// k8sResourceAttrs keeps the string k8s.* resource attributes, which is
+// all addK8sMeta reads.
+func k8sResourceAttrs(attrs map[string]interface{}) map[string]string {
+	out := make(map[string]string)
+	for k, v := range attrs {
+		if any, ok := v.(*commonpb.AnyValue); ok && strings.HasPrefix(k, "k8s.") {
+			out[k] = any.GetStringValue()
+		}
+	}
+	return out
+}
+
+// retryDeadLetter redoes the Kubernetes lookup and the write for e. The
+// entry is removed only when both succeed, and the span hasn't failed
+// again meanwhile; otherwise it is requeued with a longer backoff.
+func retryDeadLetter(ctx context.Context, e dlq.Entry) {
+	attrs := make(map[string]interface{}, len(e.Resource))
+	for k, v := range e.Resource {
+		attrs[k] = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	}
+	span := e.Span
+	span.K8sMetadata = models.K8sMetadata{}
+	k8sErr := addK8sMeta(&span, attrs)
+
+	err := errors.New("storage not connected")
+	if store != nil {
+		err = store.WriteSpan(ctx, &span)
+	}
+	switch {
+	case err != nil:
+		deadLetter(dlq.StageWrite, span, attrs, err)
+	case k8sErr != nil:
+		seenSpans.Set(span.HashableName, span, int64(cfg.Cache.TTL.Seconds()))
+		deadLetter(dlq.StageK8s, span, attrs, k8sErr)
+		err = k8sErr
+	default:
+		seenSpans.Set(span.HashableName, span, int64(cfg.Cache.TTL.Seconds()))
+		if _, derr := deadLetters.Resolve(e.ID, e.Attempts); derr != nil {
+			log.Error().Err(derr).Str("id", e.ID).Msg("Failed to remove dead-letter entry")
+		}
+		metrics.DLQDepth.Set(float64(deadLetters.Len()))
+	}
+
+	if err != nil {
+		metrics.DLQRetries.WithLabelValues("failure").Inc()
+		log.Warn().Err(err).Str("edge", e.Edge).Int("attempts", e.Attempts+1).Msg("Dead-letter retry failed")
+		return
+	}
+	metrics.DLQRetries.WithLabelValues("success").Inc()
+	log.Info().Str("edge", e.Edge).Int("attempts", e.Attempts).Msg("Dead-letter retry succeeded")
+}
+
+type dlqList struct {
+	Count   int         `json:"count"`
+	Entries []dlq.Entry `json:"entries"`
+}
+
+type dlqReplay struct {
+	Queued int `json:"queued"`
+}
+
+// requireDeadLetters writes a 404 and returns false when no queue is
+// configured.
+func requireDeadLetters(w http.ResponseWriter) bool {
+	if deadLetters == nil {
+		http.Error(w, "dead-letter queue not configured", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// handleDLQList serves every queued entry, oldest first.
+func handleDLQList(w http.ResponseWriter, r *http.Request) {
+	if !requireDeadLetters(w) {
+		return
+	}
+	entries := deadLetters.List()
+	writeJSON(w, dlqList{Count: len(entries), Entries: entries})
+}
+
+func handleDLQGet(w http.ResponseWriter, r *http.Request) {
+	if !requireDeadLetters(w) {
+		return
+	}
+	e, err := deadLetters.Get(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, e)
+}
+
+func handleDLQDelete(w http.ResponseWriter, r *http.Request) {
+	if !requireDeadLetters(w) {
+		return
+	}
+	if err := deadLetters.Delete(r.PathValue("id")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, dlq.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	metrics.DLQDepth.Set(float64(deadLetters.Len()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDLQReplay has every entry, or just ?id=, retried now regardless
+// of its backoff. The retry worker does it; the outcome shows in the
+// queue and the retry metrics.
+func handleDLQReplay(w http.ResponseWriter, r *http.Request) {
+	if !requireDeadLetters(w) {
+		return
+	}
+	n, err := deadLetters.RetryNow(r.URL.Query().Get("id"), time.Now())
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, dlq.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	select {
+	case dlqWake <- struct{}{}:
+	default:
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dlqReplay{Queued: n})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/graph"
+	"servicegraph-builder/pkg/models"
+)
+
+// exportMain implements `servicegraph-builder export`: it reads the graph
+// from the configured storage backend and writes it in one of the export
+// formats.
+func exportMain(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	flags := config.RegisterFlags(fs)
+	format := fs.String("format", graph.FormatDOT, "output format: "+strings.Join(graph.Formats, ", "))
+	output := fs.String("o", "", "write to this file instead of stdout")
+	var filter graph.Filter
+	fs.StringVar(&filter.Namespace, "namespace", "", "only edges touching services in this namespace")
+	fs.StringVar(&filter.Root, "root", "", "only services reachable from this service")
+	fs.IntVar(&filter.Depth, "depth", 0, "hops to follow from --root (0 = unlimited)")
+	fs.StringVar(&filter.Direction, "direction", graph.DirectionBoth, "direction to follow from --root: both, downstream or upstream")
+	fs.Parse(args)
+
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		os.Exit(2)
+	}
+	if loadConfig(flags) {
+		return
+	}
+	setupLogging(cfg.Log)
+
+	ctx := context.Background()
+	s, err := db.Open(cfg.Storage)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: failed to initialize %s storage: %v\n", cfg.Storage.Backend, err)
+		os.Exit(1)
+	}
+	defer s.Close(ctx)
+
+	g, err := exportGraph(ctx, s, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Encode to a buffer first so a bad format doesn't leave a partial file
+	var buf bytes.Buffer
+	if err := graph.Encode(&buf, g, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		os.Exit(2)
+	}
+	if *output == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(*output, buf.Bytes(), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func exportGraph(ctx context.Context, s db.Store, filter graph.Filter) (*models.Graph, error) {
+	g, err := s.ReadGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return graph.Apply(g, filter), nil
+}
+
+// handleGraph serves GET /api/v1/graph?format=&namespace=&root=&depth=&direction=.
+// With ?metrics=true, services and edges carry Prometheus metric
+// snapshots: the scheduled ones if snapshots are scheduled, otherwise
+// queried for the request. With ?slo=true, services carry the status of
+// their SLOs, and the other formats mark those burning their budgets.
+func handleGraph(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = graph.FormatJSON
+	}
+	filter := graph.Filter{
+		Namespace: q.Get("namespace"),
+		Root:      q.Get("root"),
+		Direction: q.Get("direction"),
+	}
+	if d := q.Get("depth"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil {
+			http.Error(w, "invalid depth: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Depth = n
+	}
+	if err := filter.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	withMetrics := false
+	if m := q.Get("metrics"); m != "" {
+		b, err := strconv.ParseBool(m)
+		if err != nil {
+			http.Error(w, "invalid metrics: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		withMetrics = b
+	}
+	withSLOs := false
+	if v := q.Get("slo"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "invalid slo: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		withSLOs = b
+	}
+	if withMetrics && promClient == nil {
+		http.Error(w, "prometheus not configured", http.StatusNotFound)
+		return
+	}
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	g, err := exportGraph(r.Context(), store, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if withMetrics && !annotateFromSnapshots(g) {
+		annotateGraph(r.Context(), g, cfg.Prometheus.Window, time.Now())
+	}
+	if withSLOs {
+		annotateSLOs(g)
+	}
+	var buf bytes.Buffer
+	if err := graph.Encode(&buf, g, format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", graph.ContentType(format))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cache "servicegraph-builder/pkg/cache"
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/otlpfile"
+	"servicegraph-builder/pkg/sampling"
+	"servicegraph-builder/pkg/topology"
+
+	"github.com/rs/zerolog"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Each directory under testdata/golden is one case:
+//
+//	request.json   OTLP JSON ExportTraceServiceRequest(s), as replay reads them
+//	cluster.yaml   optional Kubernetes manifests loaded into a fake clientset;
+//	               edges inferred from them are checked too
+//	config.yaml    optional builder config overlaid on the defaults
+//	expected.json  golden output; regenerate with `go test -run Golden -update`
+var update = flag.Bool("update", false, "rewrite golden files")
+
+type goldenResult struct {
+	Spans    []goldenSpan            `json:"spans"`
+	Edges    []models.Edge           `json:"edges"`
+	Inferred []topology.InferredEdge `json:"inferred,omitempty"`
+	// Sampling holds the sampler's counts when the case enables it.
+	Sampling map[string]sampling.ServiceStats `json:"sampling,omitempty"`
+}
+
+type goldenSpan struct {
+	Name     string    `json:"name"`
+	Service  string    `json:"service"`
+	Caller   string    `json:"caller"`
+	Callee   string    `json:"callee"`
+	Health   bool      `json:"health,omitempty"`
+	Ignored  bool      `json:"ignored,omitempty"`
+	K8s      goldenK8s `json:"k8s"`
+	K8sError string    `json:"k8s_error,omitempty"`
+}
+
+type goldenK8s struct {
+	Namespace string `json:"namespace,omitempty"`
+	OwnerKind string `json:"owner_kind,omitempty"`
+	OwnerName string `json:"owner_name,omitempty"`
+	OwnerUID  string `json:"owner_uid,omitempty"`
+}
+
+func TestGolden(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	dirs, err := filepath.Glob(filepath.Join("testdata", "golden", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("no golden cases found")
+	}
+	for _, dir := range dirs {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			got := runGoldenCase(t, dir)
+			checkGolden(t, filepath.Join(dir, "expected.json"), got)
+		})
+	}
+}
+
+func runGoldenCase(t *testing.T, dir string) goldenResult {
+	t.Helper()
+
+	cfg = config.Default()
+	if path := filepath.Join(dir, "config.yaml"); fileExists(path) {
+		if err := cfg.LoadFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.Storage.Backend = config.BackendMemory
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	useFakeCluster(t, filepath.Join(dir, "cluster.yaml"))
+
+	reqs, err := otlpfile.ReadFile(filepath.Join(dir, "request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Heuristics, span by span, without dedup so every input is visible
+	var result goldenResult
+	for _, req := range reqs {
+		for _, rs := range req.ResourceSpans {
+			attrs := make(map[string]interface{}, len(rs.Resource.Attributes))
+			for _, kv := range rs.Resource.Attributes {
+				attrs[kv.Key] = kv.Value
+			}
+			for _, scope := range rs.ScopeSpans {
+				for _, pspan := range scope.Spans {
+					enriched := enrichSpan(pspan, attrs)
+					gs := goldenSpan{
+						Name:    pspan.Name,
+						Service: enriched.ServiceName,
+						Caller:  enriched.CallerService,
+						Callee:  enriched.CalleeService,
+						Health:  isHealthSpan(pspan),
+						Ignored: isIgnoredService(enriched.ServiceName),
+					}
+					if err := addK8sMeta(&enriched, attrs); err != nil {
+						gs.K8sError = err.Error()
+					}
+					gs.K8s = goldenK8s(enriched.K8sMetadata)
+					result.Spans = append(result.Spans, gs)
+				}
+			}
+		}
+	}
+
+	// Edges, through the full Export pipeline into a memory store
+	mem := db.NewMemoryStore()
+	store = mem
+	seenSpans = cache.NewWithMaxEntries(cfg.Cache.MaxEntries)
+	if cfg.Sampling.Enabled() {
+		sampler = sampling.New(cfg.Sampling)
+	}
+	t.Cleanup(func() { store, seenSpans, sampler = nil, nil, nil })
+	t.Cleanup(startSpanPipeline())
+
+	server := &TraceServiceServer{}
+	for _, req := range reqs {
+		if _, err := server.Export(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result.Edges = mem.Edges()
+	if sampler != nil {
+		result.Sampling = sampler.Stats()
+	}
+
+	// Edges the cluster state alone suggests
+	if k8sClient != nil {
+		cluster, err := topology.LoadCluster(context.Background(), k8sClient, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result.Inferred = topology.Infer(cluster).Edges
+	}
+	return result
+}
+
+// useFakeCluster points the Kubernetes globals at a fake clientset holding
+// the objects in path, or disables lookups when path doesn't exist.
+func useFakeCluster(t *testing.T, path string) {
+	t.Helper()
+	t.Cleanup(func() {
+		k8sClient, k8sInformers, k8sHasSynced = nil, nil, nil
+		podLister, svcLister, rsLister = nil, nil, nil
+	})
+	if !fileExists(path) {
+		return
+	}
+
+	objs, err := topology.DecodeManifests([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k8sClient = fake.NewClientset(objs...)
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	startK8sInformers(stop)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !k8sSynced() {
+		if time.Now().After(deadline) {
+			t.Fatal("fake informers did not sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func checkGolden(t *testing.T, path string, got goldenResult) {
+	t.Helper()
+	data, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+
+	if *update {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(want, data) {
+		t.Errorf("%s mismatch (run with -update to accept)\n--- want\n%s\n--- got\n%s", path, want, data)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
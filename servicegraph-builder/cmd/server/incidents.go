@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/graph"
+	"servicegraph-builder/pkg/incident"
+	"servicegraph-builder/pkg/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultIncidentsLimit = 100
+	// incidentPruneInterval is how often incidents past retention are
+	// deleted.
+	incidentPruneInterval = time.Hour
+	// maxIncidentBodyBytes bounds timeline and report bodies.
+	maxIncidentBodyBytes = 1 << 20
+)
+
+var (
+	incidents     *incident.Correlator
+	incidentStore incident.Store
+	// incidentMu keeps each change to the open incidents and its write to
+	// the store together, so writes can't land out of order.
+	incidentMu sync.Mutex
+)
+
+// startIncidents opens the incident store, takes up the incidents still
+// open in it and prunes old ones until shutdown.
+func startIncidents(ctx context.Context) error {
+	icfg := cfg.Incidents
+	scfg := icfg.Store
+	scfg.Path = cfg.DataPath(scfg.Path)
+	s, err := incident.OpenStore(scfg)
+	if err != nil {
+		return err
+	}
+	open, err := s.Incidents(ctx, models.IncidentOpen, 0)
+	if err != nil {
+		s.Close()
+		return err
+	}
+	incidentStore = s
+	incidents = incident.New(icfg)
+	incidents.Restore(open)
+	if err := startMaintenance(ctx); err != nil {
+		s.Close()
+		return err
+	}
+
+	pctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(incidentPruneInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := s.Prune(pctx, time.Now().Add(-icfg.Retention)); err != nil && pctx.Err() == nil {
+				log.Error().Err(err).Msg("Failed to prune incidents")
+			} else if n > 0 {
+				log.Info().Int("incidents", n).Msg("Pruned old incidents")
+			}
+			select {
+			case <-pctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	lc.OnShutdown("incidents", func(sctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+		return s.Close()
+	})
+	log.Info().
+		Str("backend", icfg.Store.Backend).
+		Int("open", len(open)).
+		Msg("Incident store ready")
+	return nil
+}
+
+// correlateAlerts clusters a received alert group into incidents and
+// records the changes, leaving out alerts under maintenance, then queues
+// the incidents alerts joined for analysis. It returns the IDs of the
+// incidents the group's alerts are in and how many alerts were
+// suppressed.
+func correlateAlerts(ctx context.Context, group models.AlertGroup, topo *models.Graph, now time.Time) ([]string, int) {
+	incidentMu.Lock()
+	defer incidentMu.Unlock()
+	group, suppressed := suppressAlerts(ctx, group, topo, now)
+	var ids []string
+	var analyze []models.Incident
+	for _, u := range incidents.Add(group, topo, now) {
+		inc := u.Incident
+		if inc.Status != models.IncidentMerged {
+			ids = append(ids, inc.ID)
+		}
+		if err := incidentStore.SaveIncident(ctx, inc); err != nil {
+			log.Error().Err(err).Str("incident", inc.ID).Msg("Failed to store incident")
+			continue
+		}
+		g := incidentGraph(topo, inc.Services)
+		if u.Opened {
+			if err := incidentStore.SaveGraph(ctx, inc.ID, g); err != nil {
+				log.Error().Err(err).Str("incident", inc.ID).Msg("Failed to store incident graph")
+			}
+			notifyIncident(ctx, config.NotifyOpened, inc, g, nil)
+		}
+		if err := appendTimeline(ctx, inc.ID, u.Events); err != nil {
+			log.Error().Err(err).Str("incident", inc.ID).Msg("Failed to store incident timeline")
+		}
+		if inc.Status == models.IncidentResolved && slices.ContainsFunc(u.Events, func(ev models.TimelineEvent) bool {
+			return ev.Kind == models.TimelineResolved
+		}) {
+			notifyIncident(ctx, config.NotifyResolved, inc, g, nil)
+		}
+		if inc.Status == models.IncidentOpen && slices.ContainsFunc(u.Events, func(ev models.TimelineEvent) bool {
+			return ev.Kind == models.TimelineAlertFired
+		}) {
+			analyze = append(analyze, inc)
+		}
+		log.Info().
+			Str("incident", inc.ID).
+			Str("status", inc.Status).
+			Str("origin", inc.Origin).
+			Strs("services", inc.Services).
+			Msg("Incident updated")
+	}
+	// Analyses run on their own worker, outside the lock
+	queueAnalyses(analyze, topo)
+	return ids, suppressed
+}
+
+// incidentGraph cuts the neighbourhood of services out of g.
+func incidentGraph(g *models.Graph, services []string) *models.Graph {
+	neighbours, edges := graph.Neighbourhood(g, services, cfg.Incidents.MaxHops)
+	keep := slices.Clone(services)
+	for _, n := range neighbours {
+		keep = append(keep, n.Name)
+	}
+	out := &models.Graph{Services: []models.Service{}, Edges: edges}
+	for _, s := range g.Services {
+		if slices.Contains(keep, s.Name) {
+			out.Services = append(out.Services, s)
+		}
+	}
+	if out.Edges == nil {
+		out.Edges = []models.GraphEdge{}
+	}
+	return out
+}
+
+// recordChanges adds graph events on the services of open incidents to
+// their timelines.
+func recordChanges(ctx context.Context, events []models.GraphEvent) {
+	if incidents == nil {
+		return
+	}
+	incidentMu.Lock()
+	defer incidentMu.Unlock()
+	for _, inc := range incidents.Open() {
+		var changes []models.TimelineEvent
+		for _, ev := range events {
+			service := ev.Callee
+			if !slices.Contains(inc.Services, service) {
+				if service = ev.Caller; !slices.Contains(inc.Services, service) {
+					continue
+				}
+			}
+			data, _ := json.Marshal(ev)
+			changes = append(changes, models.TimelineEvent{
+				Time:    ev.DetectedAt,
+				Kind:    models.TimelineChange,
+				Service: service,
+				Message: ev.Message,
+				Data:    data,
+			})
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		if err := appendTimeline(ctx, inc.ID, changes); err != nil {
+			log.Error().Err(err).Str("incident", inc.ID).Msg("Failed to store incident timeline")
+		}
+	}
+}
+
+type incidentList struct {
+	Count     int               `json:"count"`
+	Incidents []models.Incident `json:"incidents"`
+}
+
+// handleIncidents serves incidents, most recently updated first.
+// ?status= filters on open, resolved, merged or closed; ?limit= caps the
+// count.
+func handleIncidents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	switch status {
+	case "", models.IncidentOpen, models.IncidentResolved, models.IncidentMerged, models.IncidentClosed:
+	default:
+		http.Error(w, "invalid status: want open, resolved, merged or closed", http.StatusBadRequest)
+		return
+	}
+	limit := defaultIncidentsLimit
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	list, err := incidentStore.Incidents(r.Context(), status, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, incidentList{Count: len(list), Incidents: list})
+}
+
+// handleIncident serves an incident with its graph, timeline and report.
+func handleIncident(w http.ResponseWriter, r *http.Request) {
+	d, err := incidentStore.Incident(r.Context(), r.PathValue("id"))
+	if err != nil {
+		incidentError(w, err)
+		return
+	}
+	writeJSON(w, d)
+}
+
+type closeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// handleCloseIncident closes an open incident. Its alerts open a new
+// incident if they fire again.
+func handleCloseIncident(w http.ResponseWriter, r *http.Request) {
+	var req closeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIncidentBodyBytes)).Decode(&req); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	id := r.PathValue("id")
+
+	incidentMu.Lock()
+	defer incidentMu.Unlock()
+	inc, ok := incidents.Close(id, time.Now())
+	if !ok {
+		d, err := incidentStore.Incident(r.Context(), id)
+		if err != nil {
+			incidentError(w, err)
+			return
+		}
+		http.Error(w, "incident is "+d.Status, http.StatusConflict)
+		return
+	}
+	ev := models.TimelineEvent{Time: inc.ClosedAt, Kind: models.TimelineClosed, Message: "Closed"}
+	if req.Reason != "" {
+		ev.Message += ": " + req.Reason
+	}
+	if err := incidentStore.SaveIncident(r.Context(), inc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := appendTimeline(r.Context(), id, []models.TimelineEvent{ev}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Info().Str("incident", id).Str("reason", req.Reason).Msg("Incident closed")
+	notifyIncident(r.Context(), config.NotifyClosed, inc, nil, nil)
+	writeJSON(w, inc)
+}
+
+// handleIncidentTimeline adds an event to an incident's timeline, e.g.
+// rca_started from the agents. The time defaults to now.
+func handleIncidentTimeline(w http.ResponseWriter, r *http.Request) {
+	var ev models.TimelineEvent
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIncidentBodyBytes)).Decode(&ev); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ev.Kind == "" {
+		http.Error(w, "kind is required", http.StatusBadRequest)
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	if err := appendTimeline(r.Context(), r.PathValue("id"), []models.TimelineEvent{ev}); err != nil {
+		incidentError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleIncidentReport stores an incident's RCA report and marks the
+// analysis finished on its timeline.
+func handleIncidentReport(w http.ResponseWriter, r *http.Request) {
+	var rep models.RCAReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIncidentBodyBytes)).Decode(&rep); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rep.Source == "" || rep.Summary == "" {
+		http.Error(w, "source and summary are required", http.StatusBadRequest)
+		return
+	}
+	if err := saveReport(r.Context(), r.PathValue("id"), rep); err != nil {
+		incidentError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveReport stores an incident's report and, when it's the first, from
+// another source or blames another service than the last, notifies the
+// sinks.
+func saveReport(ctx context.Context, id string, rep models.RCAReport) error {
+	if rep.CreatedAt.IsZero() {
+		rep.CreatedAt = time.Now().UTC()
+	}
+	d, err := incidentStore.Incident(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := incidentStore.SaveReport(ctx, id, rep); err != nil {
+		return err
+	}
+	if err := appendTimeline(ctx, id, []models.TimelineEvent{{
+		Time:    rep.CreatedAt,
+		Kind:    models.TimelineRCAFinished,
+		Service: rep.RootCause,
+		Message: rep.Source + ": " + rep.Summary,
+	}}); err != nil {
+		return err
+	}
+	if prev := d.Report; prev == nil || prev.Source != rep.Source || prev.RootCause != rep.RootCause {
+		notifyIncident(ctx, config.NotifyReport, d.Incident, d.Graph, &rep)
+	}
+	return nil
+}
+
+func incidentError(w http.ResponseWriter, err error) {
+	if errors.Is(err, incident.ErrNotFound) {
+		http.Error(w, "no such incident", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, incident.ErrWindowNotFound) {
+		http.Error(w, "no such maintenance window", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+	"sort"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+
+	"github.com/rs/zerolog/log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const k8sResync = 10 * time.Minute
+
+var (
+	k8sClient    kubernetes.Interface
+	k8sInformers informers.SharedInformerFactory
+	k8sHasSynced []func() bool
+	podLister    corelisters.PodLister
+	svcLister    corelisters.ServiceLister
+	rsLister     appslisters.ReplicaSetLister
+)
+
+func initK8sClient(kcfg config.KubernetesConfig) error {
+	if k8sClient != nil {
+		return nil
+	}
+	if kcfg.Mode == config.K8sModeDisabled {
+		log.Info().Msg("Kubernetes lookups disabled")
+		return nil
+	}
+
+	var restCfg *rest.Config
+	var err error
+	if kcfg.Mode != config.K8sModeKubeconfig {
+		// First try in-cluster config (when running inside Kubernetes)
+		restCfg, err = rest.InClusterConfig()
+		if err == nil {
+			log.Info().Msg("Using in-cluster Kubernetes configuration")
+		} else if kcfg.Mode == config.K8sModeInCluster {
+			return fmt.Errorf("cannot load in-cluster config: %w", err)
+		}
+	}
+	if restCfg == nil {
+		log.Info().Msg("Not running in cluster, falling back to kubeconfig")
+		// Fall back to kubeconfig for local development
+		kubeconfig := kcfg.Kubeconfig
+		if kubeconfig == "" {
+			kubeconfig = clientcmd.RecommendedHomeFile
+			log.Info().Str("kubeconfig", kubeconfig).Msg("Using default kubeconfig location")
+		}
+		restCfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return fmt.Errorf("cannot build kubeconfig: %w", err)
+		}
+	}
+
+	client, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return fmt.Errorf("cannot create kubernetes client: %w", err)
+	}
+	k8sClient = client
+	log.Info().Msg("Successfully initialized Kubernetes client")
+	return nil
+}
+
+// startK8sInformers watches pods, services and replica sets so metadata
+// lookups are served from a local cache instead of the API server. It is
+// a no-op when Kubernetes lookups are disabled.
+func startK8sInformers(stop <-chan struct{}) {
+	if k8sClient == nil {
+		return
+	}
+	k8sInformers = informers.NewSharedInformerFactory(k8sClient, k8sResync)
+
+	pods := k8sInformers.Core().V1().Pods()
+	svcs := k8sInformers.Core().V1().Services()
+	rss := k8sInformers.Apps().V1().ReplicaSets()
+	podLister, svcLister, rsLister = pods.Lister(), svcs.Lister(), rss.Lister()
+	k8sHasSynced = []func() bool{
+		pods.Informer().HasSynced,
+		svcs.Informer().HasSynced,
+		rss.Informer().HasSynced,
+	}
+
+	k8sInformers.Start(stop)
+}
+
+// k8sSynced reports whether the informer caches have done their initial
+// list. It is always true when Kubernetes lookups are disabled.
+func k8sSynced() bool {
+	for _, synced := range k8sHasSynced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+func addK8sMeta(span *models.EnrichedSpan, resourceAttrs map[string]interface{}) error {
+	// namespace from OTLP
+	if ns, ok := resourceAttrs["k8s.namespace.name"]; ok {
+		if any, ok := ns.(*commonpb.AnyValue); ok {
+			span.K8sMetadata.Namespace = any.GetStringValue()
+		}
+	}
+
+	// Deployment / ReplicaSet from OTLP
+	switch {
+	case resourceAttrs["k8s.deployment.name"] != nil:
+		any := resourceAttrs["k8s.deployment.name"].(*commonpb.AnyValue)
+		span.K8sMetadata.OwnerKind, span.K8sMetadata.OwnerName = "Deployment", any.GetStringValue()
+	case resourceAttrs["k8s.replicaset.name"] != nil:
+		any := resourceAttrs["k8s.replicaset.name"].(*commonpb.AnyValue)
+		span.K8sMetadata.OwnerKind, span.K8sMetadata.OwnerName = "ReplicaSet", any.GetStringValue()
+	}
+
+	// If we already have workload data, stop here
+	if span.K8sMetadata.OwnerKind != "" || k8sInformers == nil {
+		return nil
+	}
+
+	// ---- Fallback: Service → Pods → ownerReferences chain ----
+	svcName := span.ServiceName
+	if svcName == "unknown" {
+		svcName = span.OperationName // worst-case fallback
+	}
+	ns := span.K8sMetadata.Namespace // empty string = all namespaces
+
+	// First try to find the service by name
+	svc, err := findService(ns, svcName)
+	if err != nil || svc == nil {
+		// If service not found, try to find pods directly with the service name as label
+		pods, err := listPods(ns, labels.SelectorFromSet(labels.Set{"app": svcName}))
+		if err != nil || len(pods) == 0 {
+			return err
+		}
+		// Use the first pod's owner reference
+		if len(pods[0].OwnerReferences) > 0 {
+			ref := pods[0].OwnerReferences[0]
+			span.K8sMetadata = models.K8sMetadata{
+				Namespace: pods[0].Namespace,
+				OwnerKind: ref.Kind,
+				OwnerName: ref.Name,
+				OwnerUID:  string(ref.UID),
+			}
+		}
+		return nil
+	}
+
+	pods, err := listPods(svc.Namespace, labels.SelectorFromSet(svc.Spec.Selector))
+	if err != nil || len(pods) == 0 {
+		return err
+	}
+
+	// Walk ownerReferences
+	owner := pods[0].OwnerReferences
+	for len(owner) > 0 {
+		ref := owner[0]
+		span.K8sMetadata = models.K8sMetadata{
+			Namespace: svc.Namespace,
+			OwnerKind: ref.Kind,
+			OwnerName: ref.Name,
+			OwnerUID:  string(ref.UID),
+		}
+		if ref.Controller != nil && *ref.Controller {
+			break // reached Deployment / DaemonSet / Job
+		}
+		rs, _ := rsLister.ReplicaSets(svc.Namespace).Get(ref.Name)
+		if rs == nil || len(rs.OwnerReferences) == 0 {
+			break
+		}
+		owner = rs.OwnerReferences
+	}
+	return nil
+}
+
+// findService returns the service called name in ns, or in any namespace
+// when ns is empty. It returns nil if there is no such service.
+func findService(ns, name string) (*corev1.Service, error) {
+	if ns != "" {
+		svc, err := svcLister.Services(ns).Get(name)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return svc, err
+	}
+	svcs, err := svcLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(svcs, func(i, j int) bool { return svcs[i].Namespace < svcs[j].Namespace })
+	for _, svc := range svcs {
+		if svc.Name == name {
+			return svc, nil
+		}
+	}
+	return nil, nil
+}
+
+// listPods returns the pods matching selector in ns, or in all namespaces
+// when ns is empty, ordered like an API list.
+func listPods(ns string, selector labels.Selector) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	var err error
+	if ns == "" {
+		pods, err = podLister.List(selector)
+	} else {
+		pods, err = podLister.Pods(ns).List(selector)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+	return pods, err
+}
@@ -2,13 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
 	cache "servicegraph-builder/pkg/cache"
+	"servicegraph-builder/pkg/config"
 	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/lifecycle"
+	"servicegraph-builder/pkg/metrics"
 	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/otlpfile"
+	"servicegraph-builder/pkg/red"
+	"servicegraph-builder/pkg/sampling"
+	"servicegraph-builder/pkg/security"
+	"servicegraph-builder/pkg/telemetry"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
 
 	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
 	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
@@ -16,23 +30,25 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-const (
-	CACHE_TTL = 600
-)
+var tracer = otel.Tracer("servicegraph-builder")
 
 var (
-	seenSpans   = cache.New()
-	k8sClient   kubernetes.Interface
-	neo4jClient *db.Neo4jClient
+	cfg       *config.Config
+	lc        *lifecycle.Manager
+	seenSpans *cache.Cache
+	store     db.Store
+	recorder  *otlpfile.Recorder
+	sampler   *sampling.Sampler
 )
 
 type TraceServiceServer struct {
@@ -60,261 +76,455 @@ type EnrichedSpan struct {
 	K8sMetadata   K8sMetadata
 }
 
-func initK8sClient() error {
-	if k8sClient != nil {
-		return nil
+func enrichSpan(p *tracepb.Span, resourceAttrs map[string]interface{}) models.EnrichedSpan {
+	span := models.Span{
+		OperationName: p.Name,
+		Attributes:    make(map[string]string, len(p.Attributes)),
 	}
-
-	// First try in-cluster config (when running inside Kubernetes)
-	cfg, err := rest.InClusterConfig()
-	if err == nil {
-		log.Info().Msg("Using in-cluster Kubernetes configuration")
-	} else {
-		log.Info().Msg("Not running in cluster, falling back to kubeconfig")
-		// Fall back to kubeconfig for local development
-		kubeconfig := os.Getenv("KUBECONFIG")
-		if kubeconfig == "" {
-			kubeconfig = clientcmd.RecommendedHomeFile
-			log.Info().Str("kubeconfig", kubeconfig).Msg("Using default kubeconfig location")
-		}
-		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			log.Fatal().Err(err).Msg("cannot build kubeconfig")
-		}
+	for _, kv := range p.Attributes {
+		span.Attributes[kv.Key] = kv.Value.GetStringValue()
 	}
 
-	client, err := kubernetes.NewForConfig(cfg)
-	if err != nil {
-		log.Fatal().Err(err).Msg("cannot create kubernetes client")
+	serviceName := resourceServiceName(resourceAttrs)
+	caller, callee := spanEdge(p, serviceName)
+	hash := edgeHash(serviceName, caller, callee)
+
+	return models.EnrichedSpan{
+		Span:          span,
+		ServiceName:   serviceName,
+		HashableName:  hash,
+		CallerService: caller,
+		CalleeService: callee,
 	}
-	k8sClient = client
-	log.Info().Msg("Successfully initialized Kubernetes client")
-	return nil
 }
 
-func addK8sMeta(span *models.EnrichedSpan, resourceAttrs map[string]interface{}) error {
-	// namespace from OTLP
-	if ns, ok := resourceAttrs["k8s.namespace.name"]; ok {
-		if any, ok := ns.(*commonpb.AnyValue); ok {
-			span.K8sMetadata.Namespace = any.GetStringValue()
+// resourceServiceName returns the service.name resource attribute.
+func resourceServiceName(resourceAttrs map[string]interface{}) string {
+	if raw, ok := resourceAttrs["service.name"]; ok {
+		if any, ok := raw.(*commonpb.AnyValue); ok {
+			return any.GetStringValue()
 		}
 	}
+	return "unknown"
+}
 
-	// Deployment / ReplicaSet from OTLP
-	switch {
-	case resourceAttrs["k8s.deployment.name"] != nil:
-		any := resourceAttrs["k8s.deployment.name"].(*commonpb.AnyValue)
-		span.K8sMetadata.OwnerKind, span.K8sMetadata.OwnerName = "Deployment", any.GetStringValue()
-	case resourceAttrs["k8s.replicaset.name"] != nil:
-		any := resourceAttrs["k8s.replicaset.name"].(*commonpb.AnyValue)
-		span.K8sMetadata.OwnerKind, span.K8sMetadata.OwnerName = "ReplicaSet", any.GetStringValue()
+// spanEdge works out the caller and callee straight from the OTLP
+// attributes, so the sampler can look an edge up without enriching the
+// span. server.address wins over client.address when both are set.
+func spanEdge(p *tracepb.Span, serviceName string) (caller, callee string) {
+	caller, callee = "unknown", "unknown"
+	server, hasServer := "", false
+	for _, kv := range p.Attributes {
+		switch kv.Key {
+		case "client.address":
+			caller, callee = kv.Value.GetStringValue(), serviceName
+		case "server.address":
+			server, hasServer = kv.Value.GetStringValue(), true
+		}
+	}
+	if hasServer {
+		parts := strings.SplitN(server, ":", 2)
+		callee, caller = parts[0], serviceName
 	}
+	return caller, callee
+}
 
-	// If we already have workload data, stop here
-	if span.K8sMetadata.OwnerKind != "" {
-		return nil
+// edgeHash is the dedup cache key for an edge.
+func edgeHash(serviceName, caller, callee string) string {
+	return fmt.Sprintf("%s-%s-%s", serviceName, caller, callee)
+}
+
+func (s *TraceServiceServer) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	ctx, span := tracer.Start(ctx, "TraceService/Export", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	if recorder != nil {
+		if err := recorder.Record(req); err != nil {
+			log.Error().Err(err).Msg("Failed to record export request")
+		}
 	}
 
-	// ---- Fallback: Service → Pods → ownerReferences chain ----
-	svcName := span.ServiceName
-	if svcName == "unknown" {
-		svcName = span.OperationName // worst-case fallback
+	var errorTraces, submitted map[string]bool
+	if sampler != nil {
+		errorTraces = errorTraceIDs(req)
+		submitted = make(map[string]bool)
 	}
-	ns := span.K8sMetadata.Namespace // empty string = all namespaces
 
-	// First try to find the service by name
-	svcs, err := k8sClient.CoreV1().Services(ns).List(
-		context.TODO(),
-		metav1.ListOptions{FieldSelector: "metadata.name=" + svcName},
-	)
-	if err != nil || len(svcs.Items) == 0 {
-		// If service not found, try to find pods directly with the service name as label
-		pods, err := k8sClient.CoreV1().Pods(ns).List(
-			context.TODO(),
-			metav1.ListOptions{LabelSelector: "app=" + svcName},
-		)
-		if err != nil || len(pods.Items) == 0 {
-			return err
+	// Decode here, then hand spans to the pipeline and wait for them so
+	// the collector is only acknowledged once they are stored
+	var wg sync.WaitGroup
+	var submitErr error
+	total, sampledOut := 0, 0
+decode:
+	for _, resource := range req.ResourceSpans {
+		globalAttrs := make(map[string]interface{}, len(resource.Resource.Attributes))
+		for _, attr := range resource.Resource.Attributes {
+			globalAttrs[attr.Key] = attr.Value
+		}
+
+		// Our own self-traces must never become graph edges
+		if isSelfResource(globalAttrs) {
+			n := float64(countSpans(resource))
+			metrics.SpansReceived.Add(n)
+			metrics.SpansFiltered.WithLabelValues(metrics.FilterSelf).Add(n)
+			continue
 		}
-		// Use the first pod's owner reference
-		if len(pods.Items[0].OwnerReferences) > 0 {
-			ref := pods.Items[0].OwnerReferences[0]
-			span.K8sMetadata = models.K8sMetadata{
-				Namespace: pods.Items[0].Namespace,
-				OwnerKind: ref.Kind,
-				OwnerName: ref.Name,
-				OwnerUID:  string(ref.UID),
+
+		serviceName := resourceServiceName(globalAttrs)
+		for _, scope := range resource.ScopeSpans {
+			for _, pspan := range scope.Spans {
+				total++
+				caller, callee := spanEdge(pspan, serviceName)
+				edge := edgeHash(serviceName, caller, callee)
+				item := &spanItem{ctx: ctx, pspan: pspan, resourceAttrs: globalAttrs,
+					service: serviceName, caller: caller, callee: callee, edge: edge, wg: &wg}
+				if sampler != nil {
+					if sampleSpan(pspan, serviceName, edge, errorTraces, submitted) {
+						submitted[edge] = true
+					} else {
+						sampledOut++
+						item.sampledOut = true
+					}
+				}
+				wg.Add(1)
+				if err := spanPipeline.Submit(ctx, item); err != nil {
+					wg.Done()
+					submitErr = err
+					break decode
+				}
 			}
 		}
-		return nil
 	}
-
-	svc := svcs.Items[0]
-	selector := labels.SelectorFromSet(svc.Spec.Selector).String()
-	pods, err := k8sClient.CoreV1().Pods(svc.Namespace).List(
-		context.TODO(),
-		metav1.ListOptions{LabelSelector: selector},
+	wg.Wait()
+	span.SetAttributes(
+		attribute.Int("servicegraph.resource_spans", len(req.ResourceSpans)),
+		attribute.Int("servicegraph.spans", total),
+		attribute.Int("servicegraph.sampled_out", sampledOut),
 	)
-	if err != nil || len(pods.Items) == 0 {
-		return err
+
+	if submitErr != nil {
+		recordError(span, submitErr)
+		return nil, submitError(submitErr)
 	}
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
 
-	// Walk ownerReferences
-	owner := pods.Items[0].OwnerReferences
-	for len(owner) > 0 {
-		ref := owner[0]
-		span.K8sMetadata = models.K8sMetadata{
-			Namespace: svc.Namespace,
-			OwnerKind: ref.Kind,
-			OwnerName: ref.Name,
-			OwnerUID:  string(ref.UID),
-		}
-		if ref.Controller != nil && *ref.Controller {
-			break // reached Deployment / DaemonSet / Job
-		}
-		rs, _ := k8sClient.AppsV1().
-			ReplicaSets(svc.Namespace).
-			Get(context.TODO(), ref.Name, metav1.GetOptions{})
-		if rs == nil || len(rs.OwnerReferences) == 0 {
-			break
+// dropSpan records that a span was filtered out for reason.
+func dropSpan(span trace.Span, reason string) {
+	metrics.SpansFiltered.WithLabelValues(reason).Inc()
+	span.SetAttributes(attribute.String("servicegraph.dropped", reason))
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(otelcodes.Error, err.Error())
+}
+
+// isSelfResource returns true for resources emitted by the builder's own
+// self-tracing: those marked as such, and while self-tracing is enabled,
+// those named like it.
+func isSelfResource(resourceAttrs map[string]interface{}) bool {
+	if raw, ok := resourceAttrs[telemetry.SelfAttribute]; ok {
+		if any, ok := raw.(*commonpb.AnyValue); ok && any.GetBoolValue() {
+			return true
 		}
-		owner = rs.OwnerReferences
 	}
-	return nil
+	// A collector may drop the marker, so go by the name too, but only
+	// while there are self-traces: otherwise a service sharing the name
+	// would vanish from the graph
+	if !cfg.Telemetry.Enabled {
+		return false
+	}
+	return resourceServiceName(resourceAttrs) == cfg.Telemetry.ServiceName
 }
 
-func enrichSpan(p *tracepb.Span, resourceAttrs map[string]interface{}) models.EnrichedSpan {
-	span := models.Span{
-		OperationName: p.Name,
-		Attributes:    make(map[string]string, len(p.Attributes)),
+func countSpans(resource *tracepb.ResourceSpans) int {
+	n := 0
+	for _, scope := range resource.ScopeSpans {
+		n += len(scope.Spans)
 	}
+	return n
+}
+
+// isHealthSpan returns true for common k8s health/liveness/readiness probes.
+func isHealthSpan(p *tracepb.Span) bool {
+	// 1) Check the http.route attribute if present
 	for _, kv := range p.Attributes {
-		span.Attributes[kv.Key] = kv.Value.GetStringValue()
+		if kv.Key != "http.route" {
+			continue
+		}
+		route := kv.Value.GetStringValue()
+		for _, prefix := range cfg.Filter.HealthRoutePrefixes {
+			if strings.HasPrefix(route, prefix) {
+				return true
+			}
+		}
 	}
 
-	// Service name from resource attrs
-	serviceName := "unknown"
-	if raw, ok := resourceAttrs["service.name"]; ok {
-		if any, ok2 := raw.(*commonpb.AnyValue); ok2 {
-			serviceName = any.GetStringValue()
+	// 2) Fallback to inspecting the operation name
+	op := strings.ToLower(p.Name)
+	for _, keyword := range cfg.Filter.HealthOperationKeywords {
+		if strings.Contains(op, strings.ToLower(keyword)) {
+			return true
 		}
 	}
 
-	caller, callee := "unknown", "unknown"
+	return false
+}
+
+// isIgnoredService returns true for spans reported by a service the
+// config says to leave out of the graph.
+func isIgnoredService(service string) bool {
+	return slices.Contains(cfg.Filter.IgnoreServices, service)
+}
 
-	if c, ok := span.Attributes["client.address"]; ok {
-		caller, callee = c, serviceName
+// grpcServerOptions returns the TLS credentials and auth interceptors for
+// the OTLP listener.
+func grpcServerOptions(scfg config.ServerConfig) ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+	if scfg.TLS.Enabled {
+		reloader, err := security.NewCertReloader(scfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))
 	}
-	if s, ok := span.Attributes["server.address"]; ok {
-		parts := strings.SplitN(s, ":", 2)
-		callee, caller = parts[0], serviceName
+	if scfg.Auth.Enabled() {
+		if !scfg.TLS.Enabled {
+			log.Warn().Msg("Bearer token auth is enabled without TLS; tokens are sent in clear text")
+		}
+		auth, err := security.NewTokenAuth(scfg.Auth, scfg.TLS.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(auth.UnaryInterceptor()),
+			grpc.ChainStreamInterceptor(auth.StreamInterceptor()),
+		)
 	}
+	return opts, nil
+}
 
-	hash := fmt.Sprintf("%s-%s-%s", serviceName, caller, callee)
-
-	return models.EnrichedSpan{
-		Span:          span,
-		ServiceName:   serviceName,
-		HashableName:  hash,
-		CallerService: caller,
-		CalleeService: callee,
+// setupLogging configures the global logger from the log config.
+func setupLogging(lcfg config.LogConfig) {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	if lcfg.Format == "console" {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	} else {
+		log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
 	}
+	level, _ := zerolog.ParseLevel(lcfg.Level) // already validated
+	zerolog.SetGlobalLevel(level)
 }
 
-func (s *TraceServiceServer) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
-	for _, resource := range req.ResourceSpans {
-		globalAttrs := make(map[string]interface{}, len(resource.Resource.Attributes))
-		for _, attr := range resource.Resource.Attributes {
-			globalAttrs[attr.Key] = attr.Value
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replayMain(os.Args[2:])
+			return
+		case "export":
+			exportMain(os.Args[2:])
+			return
+		case "topology":
+			topologyMain(os.Args[2:])
+			return
 		}
+	}
 
-		for _, scope := range resource.ScopeSpans {
-			for _, pspan := range scope.Spans {
-				enriched := enrichSpan(pspan, globalAttrs)
+	flags := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if loadConfig(flags) {
+		return
+	}
 
-				if isHealthSpan(enriched) {
-					continue
-				}
+	// Setup logging
+	setupLogging(cfg.Log)
 
-				if _, ok := seenSpans.Get(enriched.HashableName); ok {
-					continue
-				} else {
-					log.Info().Str("span_name", enriched.HashableName).Msg("New span, writing to database")
-					seenSpans.Set(enriched.HashableName, enriched, CACHE_TTL)
-				}
+	if err := run(); err != nil {
+		log.Fatal().Err(err).Msg("servicegraph-builder exited with error")
+	}
+	log.Info().Msg("servicegraph-builder stopped")
+}
 
-				if err := addK8sMeta(&enriched, globalAttrs); err != nil {
-					log.Error().Err(err).Msg("cannot add k8s meta")
-				}
+// loadConfig sets cfg from flags, exiting on invalid configuration. It
+// returns true if --print-config was handled and the caller should exit.
+func loadConfig(flags *config.Flags) bool {
+	var err error
+	cfg, err = flags.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
 
-				log.Info().Any("enriched_span", enriched).Msg("Enriched span")
+	if flags.PrintConfig {
+		out, err := cfg.Redacted().YAML()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot render configuration: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return true
+	}
+	return false
+}
 
-				if enriched.ServiceName == "unknown" {
-					log.Error().Msg("cannot determine service name")
-					continue
-				}
+// run starts the builder and blocks until SIGINT/SIGTERM or a fatal
+// serve error, then drains everything registered with the lifecycle.
+func run() (err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	lc = lifecycle.New()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		err = errors.Join(err, lc.Shutdown(shutdownCtx))
+	}()
+
+	seenSpans = cache.NewWithMaxEntries(cfg.Cache.MaxEntries)
+	if cfg.Sampling.Enabled() {
+		sampler = sampling.New(cfg.Sampling)
+		log.Info().
+			Float64("probability", cfg.Sampling.Probability).
+			Float64("rate_per_service", cfg.Sampling.RatePerService).
+			Msg("Ingress sampling enabled")
+	}
 
-				// Write to Neo4j
-				if err := neo4jClient.WriteSpan(ctx, &enriched); err != nil {
-					log.Error().Err(err).Msg("Failed to write span to Neo4j")
-				}
-			}
-		}
+	// Admin endpoints come up first so probes can see startup progress
+	adminServer, err := startAdminServer(cfg.Server)
+	if err != nil {
+		return err
 	}
+	lc.OnShutdown("admin-server", adminServer.Shutdown)
 
-	return &coltracepb.ExportTraceServiceResponse{}, nil
-}
+	shutdownTelemetry, err := telemetry.Setup(ctx, cfg.Telemetry)
+	if err != nil {
+		return err
+	}
+	lc.OnShutdown("telemetry", shutdownTelemetry)
 
-// isHealthSpan returns true for common k8s health/liveness/readiness probes.
-func isHealthSpan(span models.EnrichedSpan) bool {
-	// 1) Check the http.route attribute if present
-	if route, ok := span.Attributes["http.route"]; ok {
-		if strings.HasPrefix(route, "/health") ||
-			strings.HasPrefix(route, "/live") ||
-			strings.HasPrefix(route, "/ready") {
-			return true
+	// Initialize storage. An unreachable backend doesn't stop startup:
+	// it is retried in the background and writes are spooled meanwhile
+	resilient, err := db.OpenResilient(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s storage: %w", cfg.Storage.Backend, err)
+	}
+	store = resilient
+	lc.OnShutdown("storage", store.Close)
+
+	if err := mergeDeclaredTopology(ctx); err != nil {
+		return err
+	}
+
+	if cfg.Record.Dir != "" {
+		recorder, err = otlpfile.NewRecorder(cfg.Record.Dir, cfg.Record.Format)
+		if err != nil {
+			return err
 		}
+		log.Info().Str("dir", cfg.Record.Dir).Msg("Recording export requests")
 	}
 
-	// 2) Fallback to inspecting the operation name
-	op := strings.ToLower(span.OperationName)
-	if strings.Contains(op, "health") ||
-		strings.Contains(op, "live") ||
-		strings.Contains(op, "ready") {
-		return true
+	// Initialize Kubernetes client
+	if err := initK8sClient(cfg.Kubernetes); err != nil {
+		return fmt.Errorf("cannot initialize kubernetes client: %w", err)
 	}
+	informerStop := make(chan struct{})
+	startK8sInformers(informerStop)
+	lc.OnShutdown("k8s-informers", func(ctx context.Context) error {
+		close(informerStop)
+		if k8sInformers != nil {
+			k8sInformers.Shutdown()
+		}
+		return nil
+	})
 
-	return false
-}
+	startTopologyInference()
 
-func main() {
-	// Setup logging
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	if err := startMetricSnapshots(); err != nil {
+		return err
+	}
 
-	// Initialize Neo4j client
-	var err error
-	neo4jClient, err = db.NewNeo4jClient()
+	if err := startDeadLetterQueue(); err != nil {
+		return err
+	}
+
+	startAnomalyDetection(ctx)
+	redStats = red.New(cfg.Context.Window)
+	if err := startIncidents(ctx); err != nil {
+		return fmt.Errorf("failed to open incident store: %w", err)
+	}
+	// Registered after the incident store so streams end before it closes
+	startProgressStreams()
+	if err := startNotifications(); err != nil {
+		return fmt.Errorf("failed to set up notifications: %w", err)
+	}
+	// Analyses publish progress and notify, so they stop before those do
+	startAnalyses()
+	// Burn-rate alerts feed incidents, so this stops before they do
+	if err := startSLOs(); err != nil {
+		return fmt.Errorf("failed to set up SLO evaluation: %w", err)
+	}
+
+	// Registered before the OTLP server so it drains after in-flight exports
+	spanPipeline = newSpanPipeline(cfg.Pipeline)
+	spanPipeline.Start()
+	lc.OnShutdown("pipeline", spanPipeline.Stop)
+
+	lis, err := net.Listen("tcp", cfg.Server.OTLPAddress)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize Neo4j client")
+		return fmt.Errorf("failed to listen: %w", err)
 	}
-	defer neo4jClient.Close(context.TODO())
 
-	lis, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", 8083))
+	opts, err := grpcServerOptions(cfg.Server)
 	if err != nil {
-		log.Fatal().Msgf("failed to listen: %v", err)
+		lis.Close()
+		return fmt.Errorf("cannot configure OTLP listener security: %w", err)
 	}
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(opts...)
 	traceServer := &TraceServiceServer{}
 	coltracepb.RegisterTraceServiceServer(grpcServer, traceServer)
-
-	// Initialize Kubernetes client
-	if err := initK8sClient(); err != nil {
-		log.Fatal().Err(err).Msg("cannot initialize kubernetes client")
-		return
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	lc.OnShutdown("otlp-server", func(ctx context.Context) error {
+		healthServer.Shutdown()
+		return stopGRPC(ctx, grpcServer)
+	})
+
+	log.Info().
+		Bool("tls", cfg.Server.TLS.Enabled).
+		Str("client_auth", cfg.Server.TLS.ClientAuth).
+		Bool("bearer_auth", cfg.Server.Auth.Enabled()).
+		Msgf("Starting trace service on %s", cfg.Server.OTLPAddress)
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- grpcServer.Serve(lis) }()
+	lc.SetPhase(lifecycle.PhaseServing)
+	go watchReadiness(ctx, healthServer)
+
+	select {
+	case <-ctx.Done():
+		log.Info().Dur("timeout", cfg.Server.ShutdownTimeout).Msg("Shutdown signal received, draining")
+		return nil
+	case err := <-serveErr:
+		return fmt.Errorf("trace service stopped: %w", err)
 	}
+}
 
-	log.Info().Msgf("Starting trace service on 0.0.0.0:8083")
-	grpcServer.Serve(lis)
+// stopGRPC stops accepting new exports and waits for in-flight ones to
+// finish, forcing the server closed if ctx expires first.
+func stopGRPC(ctx context.Context, s *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return fmt.Errorf("in-flight exports abandoned: %w", ctx.Err())
+	}
 }
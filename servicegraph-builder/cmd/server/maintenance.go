@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/maintenance"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultMaintenanceLimit = 100
+	// maxUpstreamHops caps how far upstream a window may reach.
+	maxUpstreamHops = 10
+)
+
+// maintenanceWindows are the windows on or coming up.
+var maintenanceWindows *maintenance.Schedule
+
+// startMaintenance takes up the windows not yet ended in the incident
+// store.
+func startMaintenance(ctx context.Context) error {
+	ws, err := incidentStore.Windows(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	maintenanceWindows = maintenance.New(ws)
+	return nil
+}
+
+// suppressAlerts drops the firing alerts maintenance windows cover from
+// group and logs them to the audit, once per alert and window however
+// often Alertmanager re-sends them. Alerts already in an open incident
+// are kept, so it still learns when they resolve.
+func suppressAlerts(ctx context.Context, group models.AlertGroup, topo *models.Graph, now time.Time) (models.AlertGroup, int) {
+	kept := make([]models.Alert, 0, len(group.Alerts))
+	var audit []models.AuditEntry
+	for _, a := range group.Alerts {
+		if a.Status != models.AlertFiring || incidents.Tracks(a.Fingerprint) {
+			kept = append(kept, a)
+			continue
+		}
+		ws := maintenanceWindows.Covering(a, topo, now)
+		if len(ws) == 0 {
+			kept = append(kept, a)
+			continue
+		}
+		ws = maintenanceWindows.Suppress(a.Fingerprint, ws)
+		if len(ws) == 0 {
+			continue
+		}
+		msg := a.Name + " firing"
+		if len(a.Services) > 0 {
+			msg += " on " + strings.Join(a.Services, ", ")
+		}
+		msg += " (" + a.Fingerprint + ")"
+		for _, w := range ws {
+			audit = append(audit, models.AuditEntry{Time: now.UTC(), Action: models.AuditSuppressed, Window: w.ID, Message: msg})
+		}
+		metrics.AlertsSuppressed.Inc()
+		log.Info().Str("alert", a.Name).Str("fingerprint", a.Fingerprint).Str("window", ws[0].ID).Msg("Alert suppressed by maintenance window")
+	}
+	if len(audit) > 0 {
+		if err := incidentStore.AppendAudit(ctx, audit); err != nil {
+			log.Error().Err(err).Msg("Failed to store maintenance audit")
+		}
+	}
+	suppressed := len(group.Alerts) - len(kept)
+	group.Alerts = kept
+	return group, suppressed
+}
+
+type windowList struct {
+	Count   int                        `json:"count"`
+	Windows []models.MaintenanceWindow `json:"windows"`
+}
+
+// handleMaintenanceWindows serves maintenance windows, latest starting
+// first. ?status= filters on scheduled, active or ended; ?limit= caps
+// the count.
+func handleMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	switch status {
+	case "", models.MaintenanceScheduled, models.MaintenanceActive, models.MaintenanceEnded:
+	default:
+		http.Error(w, "invalid status: want scheduled, active or ended", http.StatusBadRequest)
+		return
+	}
+	limit, ok := queryLimit(w, r, defaultMaintenanceLimit)
+	if !ok {
+		return
+	}
+	var after time.Time
+	now := time.Now()
+	if status == models.MaintenanceScheduled || status == models.MaintenanceActive {
+		after = now
+	}
+	ws, err := incidentStore.Windows(r.Context(), after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	out := []models.MaintenanceWindow{}
+	for _, mw := range ws {
+		mw.Status = mw.StatusAt(now)
+		if (status == "" || mw.Status == status) && len(out) < limit {
+			out = append(out, mw)
+		}
+	}
+	writeJSON(w, windowList{Count: len(out), Windows: out})
+}
+
+// handleMaintenanceWindow serves a maintenance window.
+func handleMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	mw, err := incidentStore.Window(r.Context(), r.PathValue("id"))
+	if err != nil {
+		incidentError(w, err)
+		return
+	}
+	mw.Status = mw.StatusAt(time.Now())
+	writeJSON(w, mw)
+}
+
+type windowRequest struct {
+	Services     []string `json:"services"`
+	Namespaces   []string `json:"namespaces"`
+	UpstreamHops int      `json:"upstream_hops"`
+	// StartsAt defaults to now.
+	StartsAt time.Time `json:"starts_at"`
+	// EndsAt or Duration, e.g. "2h", ends the window.
+	EndsAt    time.Time `json:"ends_at"`
+	Duration  string    `json:"duration"`
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// handleCreateMaintenanceWindow opens a maintenance window, now or later.
+func handleCreateMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	var req windowRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIncidentBodyBytes)).Decode(&req); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC()
+	mw, err := req.window(now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mw.CreatedBy = actor(r, req.CreatedBy)
+	if err := incidentStore.SaveWindow(r.Context(), mw); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	maintenanceWindows.Put(mw, now)
+	auditWindow(r.Context(), models.AuditCreated, mw.ID, mw.CreatedBy, describeWindow(mw))
+	log.Info().Str("window", mw.ID).Strs("services", mw.Services).Strs("namespaces", mw.Namespaces).
+		Time("ends_at", mw.EndsAt).Msg("Maintenance window created")
+	mw.Status = mw.StatusAt(now)
+	writeJSON(w, mw)
+}
+
+func (req *windowRequest) window(now time.Time) (models.MaintenanceWindow, error) {
+	mw := models.MaintenanceWindow{
+		ID:           maintenance.NewID(),
+		Services:     req.Services,
+		Namespaces:   req.Namespaces,
+		UpstreamHops: req.UpstreamHops,
+		Reason:       req.Reason,
+		CreatedAt:    now,
+		StartsAt:     req.StartsAt.UTC(),
+	}
+	if len(mw.Services) == 0 && len(mw.Namespaces) == 0 {
+		return mw, errors.New("services or namespaces are required")
+	}
+	if mw.UpstreamHops < 0 || mw.UpstreamHops > maxUpstreamHops {
+		return mw, fmt.Errorf("upstream_hops must be between 0 and %d", maxUpstreamHops)
+	}
+	if mw.StartsAt.IsZero() {
+		mw.StartsAt = now
+	}
+	switch {
+	case req.Duration != "" && !req.EndsAt.IsZero():
+		return mw, errors.New("set ends_at or duration, not both")
+	case req.Duration != "":
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			return mw, errors.New("invalid duration")
+		}
+		mw.EndsAt = mw.StartsAt.Add(d)
+	case !req.EndsAt.IsZero():
+		mw.EndsAt = req.EndsAt.UTC()
+	default:
+		return mw, errors.New("ends_at or duration is required")
+	}
+	if !mw.EndsAt.After(mw.StartsAt) || !mw.EndsAt.After(now) {
+		return mw, errors.New("the window must end after it starts and in the future")
+	}
+	return mw, nil
+}
+
+type expireRequest struct {
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+}
+
+// handleExpireMaintenanceWindow ends a maintenance window now, or calls
+// off one yet to start.
+func handleExpireMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	var req expireRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIncidentBodyBytes)).Decode(&req); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	mw, err := incidentStore.Window(r.Context(), r.PathValue("id"))
+	if err != nil {
+		incidentError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	if mw.StatusAt(now) == models.MaintenanceEnded {
+		http.Error(w, "window has ended", http.StatusConflict)
+		return
+	}
+	mw.EndsAt, mw.ExpiredAt, mw.ExpiredBy = now, now, actor(r, req.By)
+	if err := incidentStore.SaveWindow(r.Context(), mw); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	maintenanceWindows.Put(mw, now)
+	msg := "Expired"
+	if req.Reason != "" {
+		msg += ": " + req.Reason
+	}
+	auditWindow(r.Context(), models.AuditExpired, mw.ID, mw.ExpiredBy, msg)
+	log.Info().Str("window", mw.ID).Str("by", mw.ExpiredBy).Msg("Maintenance window expired")
+	mw.Status = mw.StatusAt(now)
+	writeJSON(w, mw)
+}
+
+type auditList struct {
+	Count   int                 `json:"count"`
+	Entries []models.AuditEntry `json:"entries"`
+}
+
+// handleMaintenanceAudit serves the maintenance audit log, newest first.
+// ?window= filters on a window; ?limit= caps the count.
+func handleMaintenanceAudit(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r, defaultMaintenanceLimit)
+	if !ok {
+		return
+	}
+	entries, err := incidentStore.Audit(r.Context(), r.URL.Query().Get("window"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, auditList{Count: len(entries), Entries: entries})
+}
+
+// queryLimit parses ?limit=, answering the request itself if it's
+// invalid.
+func queryLimit(w http.ResponseWriter, r *http.Request, def int) (int, bool) {
+	l := r.URL.Query().Get("limit")
+	if l == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(l)
+	if err != nil || n < 1 {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+func auditWindow(ctx context.Context, action, id, who, msg string) {
+	e := models.AuditEntry{Time: time.Now().UTC(), Action: action, Window: id, Actor: who, Message: msg}
+	if err := incidentStore.AppendAudit(ctx, []models.AuditEntry{e}); err != nil {
+		log.Error().Err(err).Str("window", id).Msg("Failed to store maintenance audit")
+	}
+}
+
+// actor is who made a request: the name it gives, or failing that its
+// address.
+func actor(r *http.Request, name string) string {
+	if name != "" {
+		return name
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func describeWindow(mw models.MaintenanceWindow) string {
+	var scope []string
+	if len(mw.Services) > 0 {
+		scope = append(scope, strings.Join(mw.Services, ", "))
+	}
+	if len(mw.Namespaces) > 0 {
+		scope = append(scope, "namespaces "+strings.Join(mw.Namespaces, ", "))
+	}
+	msg := "Maintenance on " + strings.Join(scope, " and ")
+	switch mw.UpstreamHops {
+	case 0:
+	case 1:
+		msg += " and its callers"
+	default:
+		msg += fmt.Sprintf(" and callers up to %d hops away", mw.UpstreamHops)
+	}
+	msg += " from " + mw.StartsAt.Format(time.RFC3339) + " to " + mw.EndsAt.Format(time.RFC3339)
+	if mw.Reason != "" {
+		msg += ": " + mw.Reason
+	}
+	return msg
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/notify"
+
+	"github.com/rs/zerolog/log"
+)
+
+// notifier sends incident events to the configured sinks; nil without
+// any.
+var notifier *notify.Notifier
+
+func startNotifications() error {
+	ncfg := cfg.Notifications
+	if len(ncfg.Sinks) == 0 {
+		return nil
+	}
+	n, err := notify.New(ncfg)
+	if err != nil {
+		return err
+	}
+	n.Start()
+	notifier = n
+	lc.OnShutdown("notifications", n.Stop)
+	log.Info().Int("sinks", len(ncfg.Sinks)).Msg("Incident notifications ready")
+	return nil
+}
+
+// notifyIncident queues ev on inc for the sinks. Without g, the graph
+// stored with the incident is sent.
+func notifyIncident(ctx context.Context, ev string, inc models.Incident, g *models.Graph, rep *models.RCAReport) {
+	if notifier == nil {
+		return
+	}
+	if g == nil {
+		d, err := incidentStore.Incident(ctx, inc.ID)
+		if err != nil {
+			log.Warn().Err(err).Str("incident", inc.ID).Msg("Cannot read incident graph for notification")
+		} else {
+			g = d.Graph
+		}
+	}
+	notifier.Notify(ev, inc, g, rep)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/dlq"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/pipeline"
+
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var spanPipeline *pipeline.Pipeline[*spanItem]
+
+// spanItem is one span on its way through the pipeline. Export decodes
+// it and fills in everything up to edge; the stages do the rest.
+type spanItem struct {
+	ctx           context.Context
+	pspan         *tracepb.Span
+	resourceAttrs map[string]interface{}
+	// service, caller and callee are what the span's edge is made of,
+	// and edge is its dedup key, known before enrichment so every stage
+	// can shard on it
+	service, caller, callee string
+	edge                    string
+	// sampledOut spans are only counted for anomaly detection and the
+	// RED stats, never enriched or written
+	sampledOut bool
+	wg         *sync.WaitGroup
+
+	span     trace.Span
+	enriched models.EnrichedSpan
+}
+
+// newSpanPipeline builds filter → enrich → aggregate → k8s → write. Every
+// stage shards by edge, so spans of one edge are handled in order and a
+// slow Kubernetes lookup or write only holds up the edges sharing its
+// worker.
+//
+// Aggregation comes before the Kubernetes lookup so that only the first
+// span of an edge within the cache TTL, the one written, is looked up:
+// repeats are folded into that write and would only add lookups, and
+// dead-lettered ones for failed lookups, whose results are thrown away.
+func newSpanPipeline(pcfg config.PipelineConfig) *pipeline.Pipeline[*spanItem] {
+	stage := func(name string, fn func(*spanItem) bool) pipeline.Stage[*spanItem] {
+		return pipeline.Stage[*spanItem]{Name: name, Workers: pcfg.WorkersFor(name), Process: fn}
+	}
+	return pipeline.New(pcfg.QueueSize,
+		func(it *spanItem) string { return it.edge },
+		finishSpan,
+		stage(config.StageFilter, filterStage),
+		stage(config.StageEnrich, enrichStage),
+		stage(config.StageAggregate, aggregateStage),
+		stage(config.StageK8s, k8sStage),
+		stage(config.StageWrite, writeStage),
+	)
+}
+
+// startSpanPipeline starts a pipeline for the offline commands and
+// returns its stop function.
+func startSpanPipeline() func() {
+	spanPipeline = newSpanPipeline(cfg.Pipeline)
+	spanPipeline.Start()
+	return func() {
+		spanPipeline.Stop(context.Background())
+		spanPipeline = nil
+	}
+}
+
+// submitError maps a failed Submit to a gRPC status the collector retries.
+func submitError(err error) error {
+	if errors.Is(err, pipeline.ErrStopped) {
+		return status.Error(codes.Unavailable, "shutting down")
+	}
+	return status.FromContextError(err).Err()
+}
+
+func finishSpan(it *spanItem) {
+	if it.span != nil {
+		it.span.End()
+	}
+	it.wg.Done()
+}
+
+// filterStage drops health probes and ignored services before the work
+// of enriching them is done. Sampled-out spans were already counted as
+// received by the sampler and aren't traced.
+func filterStage(it *spanItem) bool {
+	if it.sampledOut {
+		return !isHealthSpan(it.pspan) && !isIgnoredService(resourceServiceName(it.resourceAttrs))
+	}
+	metrics.SpansReceived.Inc()
+	it.ctx, it.span = tracer.Start(it.ctx, "servicegraph.span",
+		trace.WithAttributes(attribute.String("servicegraph.edge", it.edge)))
+	if isHealthSpan(it.pspan) {
+		dropSpan(it.span, metrics.FilterHealth)
+		return false
+	}
+	if isIgnoredService(resourceServiceName(it.resourceAttrs)) {
+		dropSpan(it.span, metrics.FilterIgnoredService)
+		return false
+	}
+	return true
+}
+
+func enrichStage(it *spanItem) bool {
+	if it.sampledOut {
+		return true
+	}
+	it.enriched = enrichSpan(it.pspan, it.resourceAttrs)
+	return true
+}
+
+// aggregateStage counts the call for anomaly detection and RED stats, and
+// folds repeats of an edge already written within the cache TTL into that
+// write. Sampled-out spans stop here, once counted: sampling keeps every
+// error but only some successes, so counting the kept spans alone would
+// inflate error ratios and thin call rates.
+func aggregateStage(it *spanItem) bool {
+	observeSpan(it)
+	if it.sampledOut {
+		return false
+	}
+	if _, ok := seenSpans.Get(it.enriched.HashableName); ok {
+		metrics.SpansDeduped.Inc()
+		it.span.SetAttributes(attribute.Bool("servicegraph.deduped", true))
+		return false
+	}
+	log.Info().Str("span_name", it.enriched.HashableName).Msg("New span, writing to database")
+	seenSpans.Set(it.enriched.HashableName, it.enriched, int64(cfg.Cache.TTL.Seconds()))
+	return true
+}
+
+func k8sStage(it *spanItem) bool {
+	_, k8sSpan := tracer.Start(it.ctx, "servicegraph.k8s_lookup")
+	k8sErr := addK8sMeta(&it.enriched, it.resourceAttrs)
+	if k8sErr != nil {
+		metrics.K8sLookupErrors.Inc()
+		recordError(k8sSpan, k8sErr)
+		log.Error().Err(k8sErr).Msg("cannot add k8s meta")
+	}
+	k8sSpan.SetAttributes(
+		attribute.String("k8s.namespace.name", it.enriched.K8sMetadata.Namespace),
+		attribute.String("servicegraph.k8s.owner", it.enriched.K8sMetadata.OwnerKind+"/"+it.enriched.K8sMetadata.OwnerName),
+	)
+	k8sSpan.End()
+
+	log.Info().Any("enriched_span", it.enriched).Msg("Enriched span")
+
+	if it.enriched.ServiceName == "unknown" {
+		dropSpan(it.span, metrics.FilterUnknownService)
+		log.Error().Msg("cannot determine service name")
+		return false
+	}
+	if k8sErr != nil {
+		// The edge is still written; the queue retries the lookup
+		deadLetter(dlq.StageK8s, it.enriched, it.resourceAttrs, k8sErr)
+	}
+	return true
+}
+
+func writeStage(it *spanItem) bool {
+	writeCtx, writeSpan := tracer.Start(it.ctx, "servicegraph.storage_write",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", cfg.Storage.Backend)),
+	)
+	defer writeSpan.End()
+	start := time.Now()
+	err := store.WriteSpan(writeCtx, &it.enriched)
+	metrics.StorageWriteLatency.WithLabelValues(cfg.Storage.Backend).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.StorageWriteErrors.WithLabelValues(cfg.Storage.Backend).Inc()
+		recordError(writeSpan, err)
+		log.Error().Err(err).Msg("Failed to write span to storage")
+		// Let the next span of this edge try again instead of waiting
+		// out the cache TTL
+		seenSpans.Delete(it.enriched.HashableName)
+		deadLetter(dlq.StageWrite, it.enriched, it.resourceAttrs, err)
+		return false
+	}
+	metrics.SpansWritten.Inc()
+	return true
+}
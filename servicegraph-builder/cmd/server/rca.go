@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/rca"
+	"servicegraph-builder/pkg/stream"
+
+	"github.com/rs/zerolog/log"
+)
+
+type analysis struct {
+	Incident    string          `json:"incident"`
+	Explanation rca.Explanation `json:"explanation"`
+	// Saved is false when the incident has a report from elsewhere,
+	// which the rules leave be.
+	Saved bool `json:"saved"`
+}
+
+// handleAnalyzeIncident runs the RCA rules on an incident and stores the
+// outcome as its report, unless it has one from elsewhere, e.g. the
+// agents. Services without a scheduled metric snapshot get one queried
+// now.
+func handleAnalyzeIncident(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := r.Context()
+	d, err := incidentStore.Incident(ctx, r.PathValue("id"))
+	if err != nil {
+		incidentError(w, err)
+		return
+	}
+	g, err := store.ReadGraph(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	ex := analyzeIncident(ctx, d.Incident, g, true)
+	saved, err := saveAnalysis(ctx, d, ex)
+	if err != nil {
+		incidentError(w, err)
+		return
+	}
+	writeJSON(w, analysis{Incident: d.ID, Explanation: ex, Saved: saved})
+}
+
+// analysisQueueSize bounds the incidents waiting for automatic analysis.
+const analysisQueueSize = 64
+
+// analyses queues incidents for automatic analysis; nil when it's off.
+var analyses chan analysisJob
+
+type analysisJob struct {
+	id string
+	g  *models.Graph
+}
+
+// startAnalyses runs automatic analyses on a worker of their own, so a
+// slow one holds up neither the webhook nor other incident changes.
+func startAnalyses() {
+	if !cfg.RCA.Auto {
+		return
+	}
+	analyses = make(chan analysisJob, analysisQueueSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case job := <-analyses:
+				autoAnalyze(ctx, job.id, job.g)
+			}
+		}
+	}()
+	lc.OnShutdown("rca", func(sctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+	})
+}
+
+// queueAnalyses queues incidents alerts just joined for analysis in g,
+// skipping them if the queue is full; the next alert to join retries.
+func queueAnalyses(incs []models.Incident, g *models.Graph) {
+	if analyses == nil {
+		return
+	}
+	for _, inc := range incs {
+		select {
+		case analyses <- analysisJob{id: inc.ID, g: g}:
+		default:
+			log.Warn().Str("incident", inc.ID).Msg("Analysis queue full, skipping incident")
+		}
+	}
+}
+
+// autoAnalyze runs the RCA rules on an incident as it stands, using only
+// metric snapshots already taken.
+func autoAnalyze(ctx context.Context, id string, g *models.Graph) {
+	d, err := incidentStore.Incident(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Str("incident", id).Msg("Failed to read incident for analysis")
+		return
+	}
+	if _, err := saveAnalysis(ctx, d, analyzeIncident(ctx, d.Incident, g, false)); err != nil && ctx.Err() == nil {
+		log.Error().Err(err).Str("incident", id).Msg("Failed to store incident analysis")
+	}
+}
+
+// analyzeIncident evaluates the RCA rules on the context of inc's alerts
+// in g. With live set, Prometheus is queried for services the scheduled
+// snapshots don't cover.
+func analyzeIncident(ctx context.Context, inc models.Incident, g *models.Graph, live bool) rca.Explanation {
+	now := time.Now()
+	current := make([]models.Alert, 0, len(inc.Alerts))
+	alerting := make(map[string]time.Time)
+	for _, a := range inc.Alerts {
+		current = append(current, models.Alert{
+			Fingerprint: a.Fingerprint,
+			Name:        a.Name,
+			Status:      a.Status,
+			Severity:    a.Severity,
+			Summary:     a.Summary,
+			StartsAt:    a.StartsAt,
+			EndsAt:      a.EndsAt,
+			Services:    a.Services,
+		})
+		// A resolved incident is analysed on all its alerts
+		if a.Status != models.AlertFiring && inc.Status == models.IncidentOpen {
+			continue
+		}
+		for _, s := range a.Services {
+			if t, ok := alerting[s]; !ok || a.StartsAt.Before(t) {
+				alerting[s] = a.StartsAt
+			}
+		}
+	}
+	publish(inc.ID,
+		stream.AgentStarted(stream.AgentGraph, "ServiceGraph"),
+		stream.ToolCall(stream.AgentGraph, "context_pack", map[string]any{
+			"services": inc.Services,
+			"depth":    cfg.Context.Depth,
+			"window":   cfg.Context.Window.String(),
+		}))
+	pack := buildContextPack(ctx, g, inc.Services, current, cfg.Context.Depth, cfg.Context.Window, now)
+	publish(inc.ID,
+		stream.ToolOutput(stream.AgentGraph, "context_pack", map[string]any{
+			"services": len(pack.Services),
+			"edges":    len(pack.Edges),
+			"changes":  len(pack.Changes),
+			"warnings": pack.Warnings,
+		}),
+		stream.MessageOutput(stream.AgentGraph, packGraph(&pack)))
+
+	in := &rca.Input{Since: now.Add(-cfg.Context.Window), Alerting: alerting}
+	warnings := pack.Warnings
+	for _, ps := range pack.Services {
+		s := rca.Service{Name: ps.Name, Role: ps.Role, Inbound: ps.Inbound, SLOs: ps.SLOs}
+		for _, p := range ps.Pods {
+			s.Pods = append(s.Pods, rca.Pod{Name: p.Name, Ready: p.Ready, Restarts: p.Restarts, Reason: p.Reason})
+		}
+		snap, err := serviceSnapshot(ctx, ps.Name, live, now)
+		if err != nil {
+			warnings = append(warnings, "prometheus: "+err.Error())
+		}
+		s.Metrics = snap
+		in.Services = append(in.Services, s)
+	}
+	for _, e := range pack.Edges {
+		in.Edges = append(in.Edges, rca.Edge{Caller: e.Caller, Callee: e.Callee, RED: e.RED})
+	}
+	for _, c := range pack.Changes {
+		in.Changes = append(in.Changes, rca.Change{
+			Time:    c.Time,
+			Kind:    c.Kind,
+			Service: c.Service,
+			Reason:  c.Reason,
+			Caller:  c.Caller,
+			Callee:  c.Callee,
+			Message: c.Message,
+		})
+	}
+
+	publish(inc.ID, stream.AgentStarted(stream.AgentRules, "RuleEngine"))
+	ex := rca.Analyze(in, rca.Rules(cfg.RCA), func(rule string, found []rca.Finding) {
+		if found == nil {
+			found = []rca.Finding{}
+		}
+		publish(inc.ID,
+			stream.ToolCall(stream.AgentRules, rule, struct{}{}),
+			stream.ToolOutput(stream.AgentRules, rule, found))
+	})
+	ex.Warnings = warnings
+	publish(inc.ID, stream.MessageOutput(stream.AgentRules, ex.Summary))
+	log.Info().
+		Str("incident", inc.ID).
+		Str("root_cause", ex.RootCause).
+		Float64("confidence", ex.Confidence).
+		Int("candidates", len(ex.Candidates)).
+		Msg("Analysed incident")
+	return ex
+}
+
+// saveAnalysis stores ex as d's report unless d has a report from another
+// source. It reports whether it stored it.
+func saveAnalysis(ctx context.Context, d models.IncidentDetail, ex rca.Explanation) (bool, error) {
+	if d.Report != nil && d.Report.Source != rca.Source {
+		return false, nil
+	}
+	return true, saveReport(ctx, d.ID, ex.Report(time.Now()))
+}
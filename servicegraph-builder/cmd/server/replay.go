@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	cache "servicegraph-builder/pkg/cache"
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/otlpfile"
+
+	"github.com/rs/zerolog/log"
+)
+
+const k8sSyncTimeout = 30 * time.Second
+
+// replayMain implements `servicegraph-builder replay`: it feeds saved
+// OTLP export requests through the same Export pipeline the live server
+// uses and prints the edges that reached storage.
+func replayMain(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags := config.RegisterFlags(fs)
+	format := fs.String("format", "text", "edge output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: servicegraph-builder replay [flags] <file|dir>...\n\n")
+		fmt.Fprintf(fs.Output(), "Files ending in .json/.jsonl are OTLP JSON; anything else is a binary\n")
+		fmt.Fprintf(fs.Output(), "ExportTraceServiceRequest. Directories are read in name order.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "replay: unknown format %q\n", *format)
+		os.Exit(2)
+	}
+	if loadConfig(flags) {
+		return
+	}
+	setupLogging(cfg.Log)
+
+	edges, err := replay(context.Background(), fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		os.Exit(1)
+	}
+	if err := printEdges(edges, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func replay(ctx context.Context, paths []string) ([]models.Edge, error) {
+	files, err := otlpfile.Expand(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	seenSpans = cache.NewWithMaxEntries(cfg.Cache.MaxEntries)
+
+	backend, err := db.Open(cfg.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s storage: %w", cfg.Storage.Backend, err)
+	}
+	recording := db.NewRecordingStore(backend)
+	store = recording
+	defer store.Close(ctx)
+
+	if err := initK8sClient(cfg.Kubernetes); err != nil {
+		return nil, fmt.Errorf("cannot initialize kubernetes client: %w", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	startK8sInformers(stop)
+	if err := waitForK8sSync(k8sSyncTimeout); err != nil {
+		return nil, err
+	}
+
+	defer startSpanPipeline()()
+
+	server := &TraceServiceServer{}
+	requests := 0
+	for _, f := range files {
+		reqs, err := otlpfile.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		for _, req := range reqs {
+			if _, err := server.Export(ctx, req); err != nil {
+				return nil, fmt.Errorf("%s: %w", f, err)
+			}
+			requests++
+		}
+	}
+
+	edges := recording.Edges()
+	log.Info().Int("files", len(files)).Int("requests", requests).Int("edges", len(edges)).Msg("Replay finished")
+	return edges, nil
+}
+
+// waitForK8sSync blocks until the informer caches are synced, so replayed
+// spans see the same cluster state the live server would.
+func waitForK8sSync(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !k8sSynced() {
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for kubernetes caches to sync")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+func printEdges(edges []models.Edge, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(edges)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CALLER\tCALLEE\tNAMESPACE\tOWNER\tOPERATION")
+	for _, e := range edges {
+		owner := "-"
+		if e.OwnerKind != "" {
+			owner = e.OwnerKind + "/" + e.OwnerName
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Caller, e.Callee, dash(e.Namespace), owner, dash(e.Operation))
+	}
+	return w.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/sampling"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// errorTraceIDs returns the traces in req with at least one error span.
+// Every span of those traces is kept, so an error is never seen without
+// the calls that led to it. Collectors batch spans by trace, so this is
+// tail sampling over what one request holds.
+func errorTraceIDs(req *coltracepb.ExportTraceServiceRequest) map[string]bool {
+	ids := make(map[string]bool)
+	for _, resource := range req.ResourceSpans {
+		for _, scope := range resource.ScopeSpans {
+			for _, pspan := range scope.Spans {
+				if len(pspan.TraceId) > 0 && pspan.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR {
+					ids[string(pspan.TraceId)] = true
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// sampleSpan reports whether pspan should be processed. It only reads
+// what is cheap to get at: a dedup cache lookup for its edge and the
+// trace ID. submitted holds edges already queued from the same request,
+// which the dedup cache won't know about until they are processed.
+func sampleSpan(pspan *tracepb.Span, serviceName, edge string, errorTraces, submitted map[string]bool) bool {
+	_, seen := seenSpans.Get(edge)
+	seen = seen || submitted[edge]
+	keep, reason := sampler.Decide(sampling.Span{
+		Service: serviceName,
+		TraceID: pspan.TraceId,
+		Error:   errorTraces[string(pspan.TraceId)] || pspan.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR,
+		NewEdge: !seen,
+	})
+	metrics.SamplingDecisions.WithLabelValues(reason).Inc()
+	if !keep {
+		metrics.SpansReceived.Inc()
+	}
+	return keep
+}
+
+type samplingReport struct {
+	Enabled  bool                             `json:"enabled"`
+	Services map[string]sampling.ServiceStats `json:"services"`
+}
+
+// handleSampling serves per-service sampling counts since startup.
+func handleSampling(w http.ResponseWriter, r *http.Request) {
+	report := samplingReport{Services: map[string]sampling.ServiceStats{}}
+	if sampler != nil {
+		report.Enabled = true
+		report.Services = sampler.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
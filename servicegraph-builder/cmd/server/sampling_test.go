@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	cache "servicegraph-builder/pkg/cache"
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/rca"
+	"servicegraph-builder/pkg/red"
+	"servicegraph-builder/pkg/sampling"
+	"servicegraph-builder/pkg/slo"
+
+	"github.com/rs/zerolog"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// calls makes a request of frontend calling each callee 100 times, each
+// call its own trace, the first failing[callee] of them failing.
+func calls(failing map[string]int) *coltracepb.ExportTraceServiceRequest {
+	str := func(s string) *commonpb.AnyValue {
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+	}
+	start := uint64(time.Now().UnixNano())
+	var spans []*tracepb.Span
+	trace := uint64(0)
+	for _, callee := range []string{"orders", "payments"} {
+		for i := range 100 {
+			trace++
+			id := make([]byte, 16)
+			// Spread over the sampler's range, which reads the low bits
+			binary.BigEndian.PutUint64(id[8:], trace*0x9e3779b97f4a7c15)
+			span := &tracepb.Span{
+				TraceId:           id,
+				SpanId:            id[8:],
+				Name:              "GET /" + callee,
+				StartTimeUnixNano: start,
+				EndTimeUnixNano:   start + uint64(20*time.Millisecond),
+				Attributes:        []*commonpb.KeyValue{{Key: "server.address", Value: str(callee + ":8080")}},
+			}
+			if i < failing[callee] {
+				span.Status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR}
+			}
+			spans = append(spans, span)
+		}
+	}
+	return &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource:   &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{Key: "service.name", Value: str("frontend")}}},
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: spans}},
+	}}}
+}
+
+// startExport sets up what Export needs, sampling at probability if it's
+// below 1, and returns a function tearing it down again.
+func startExport(tb testing.TB, probability float64) func() {
+	tb.Helper()
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	cfg = config.Default()
+	cfg.Storage.Backend = config.BackendMemory
+	cfg.Sampling.Probability = probability
+	if err := cfg.Validate(); err != nil {
+		tb.Fatal(err)
+	}
+	store = db.NewMemoryStore()
+	seenSpans = cache.NewWithMaxEntries(cfg.Cache.MaxEntries)
+	redStats = red.New(cfg.Context.Window)
+	if cfg.Sampling.Enabled() {
+		sampler = sampling.New(cfg.Sampling)
+	}
+	stop := startSpanPipeline()
+	return func() {
+		stop()
+		store, seenSpans, sampler, redStats = nil, nil, nil, nil
+	}
+}
+
+// exportCalls runs req through Export, sampling at probability if it's
+// below 1, and returns the RED stats it left.
+func exportCalls(t *testing.T, req *coltracepb.ExportTraceServiceRequest, probability float64) *red.Tracker {
+	t.Helper()
+	defer startExport(t, probability)()
+	tracker := redStats
+	if _, err := (&TraceServiceServer{}).Export(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if sampler != nil && sampler.Stats()["frontend"].SampledOut == 0 {
+		t.Fatal("sampler dropped no spans")
+	}
+	return tracker
+}
+
+func TestSamplingKeepsBurnRate(t *testing.T) {
+	req := calls(map[string]int{"payments": 10})
+	objective := models.SLO{ID: "slo-payments", Service: "payments", Kind: models.SLOAvailability,
+		Objective: 0.99, WindowDays: 30, Source: models.SLOSourceRED}
+	burn := func(tracker *red.Tracker) []models.BurnWindow {
+		e, err := slo.New(cfg.SLO, tracker, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A minute on, so the minute the calls fell in is complete
+		st := e.Evaluate(context.Background(), []models.SLO{objective}, time.Now().Add(time.Minute))
+		return st[0].Windows
+	}
+
+	want := burn(exportCalls(t, req, 1))
+	got := burn(exportCalls(t, req, 0))
+	if *want[0].BurnRate != 10 {
+		t.Fatalf("unsampled burn rate %v, want 10", *want[0].BurnRate)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("burn rates with sampling = %v, want %v", burnRates(got), burnRates(want))
+	}
+}
+
+func burnRates(ws []models.BurnWindow) map[string]float64 {
+	out := make(map[string]float64)
+	for _, w := range ws {
+		if w.BurnRate != nil {
+			out[w.Window] = *w.BurnRate
+		}
+	}
+	return out
+}
+
+func TestSamplingKeepsRCARanking(t *testing.T) {
+	// orders fails below the rules' threshold, payments above it
+	req := calls(map[string]int{"orders": 2, "payments": 10})
+	analyze := func(tracker *red.Tracker) rca.Explanation {
+		now := time.Now()
+		in := &rca.Input{Since: now.Add(-cfg.Context.Window), Alerting: map[string]time.Time{"frontend": now}}
+		for _, callee := range []string{"orders", "payments"} {
+			st, ok := tracker.Edge("frontend", callee, cfg.Context.Window, now)
+			if !ok {
+				t.Fatalf("no RED stats for frontend → %s", callee)
+			}
+			in.Edges = append(in.Edges, rca.Edge{Caller: "frontend", Callee: callee, RED: &st})
+		}
+		return rca.Analyze(in, rca.Rules(cfg.RCA), nil)
+	}
+
+	want := analyze(exportCalls(t, req, 1))
+	got := analyze(exportCalls(t, req, 0))
+	for _, c := range want.Candidates {
+		if c.Service == "orders" {
+			t.Fatalf("unsampled analysis blames orders: %+v", c)
+		}
+	}
+	if want.RootCause != "payments" {
+		t.Fatalf("unsampled analysis blames %s, want payments", want.RootCause)
+	}
+	if got.RootCause != want.RootCause || !reflect.DeepEqual(got.Candidates, want.Candidates) {
+		t.Errorf("analysis with sampling = %+v, want %+v", got.Candidates, want.Candidates)
+	}
+}
+
+// BenchmarkExport compares Export with and without sampling. Sampled-out
+// spans are still counted, but never enriched, so sampling should stay
+// the cheaper of the two.
+func BenchmarkExport(b *testing.B) {
+	req := calls(map[string]int{"payments": 10})
+	for _, bc := range []struct {
+		name        string
+		probability float64
+	}{{"unsampled", 1}, {"sampled", 0}} {
+		b.Run(bc.name, func(b *testing.B) {
+			defer startExport(b, bc.probability)()
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := (&TraceServiceServer{}).Export(context.Background(), req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
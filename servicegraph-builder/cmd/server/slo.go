@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/prom"
+	"servicegraph-builder/pkg/slo"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// sloGroupPrefix starts the group keys of burn-rate alerts, one group
+	// per SLO.
+	sloGroupPrefix = "slo/"
+	// sloAlertName is the alert name of burn-rate alerts.
+	sloAlertName = "SLOErrorBudgetBurn"
+	// maxSLOBodyBytes bounds SLO bodies.
+	maxSLOBodyBytes = 64 << 10
+)
+
+var (
+	sloEvaluator *slo.Evaluator
+	// sloWake has the evaluator run now rather than at the next tick,
+	// once SLOs change.
+	sloWake = make(chan struct{}, 1)
+	// sloFiring holds the burn-rate alerts firing, by SLO ID, then alert
+	// name. Only the evaluation loop touches it.
+	sloFiring = make(map[string]map[string]models.Alert)
+)
+
+// startSLOs evaluates the stored SLOs every interval until shutdown,
+// raising and resolving their burn-rate alerts. Evaluation waits until
+// the alerts a previous run left firing are read back, so they are
+// neither raised twice nor left firing.
+func startSLOs() error {
+	e, err := slo.New(cfg.SLO, redStats, promClient)
+	if err != nil {
+		return err
+	}
+	sloEvaluator = e
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(cfg.SLO.Interval)
+		defer ticker.Stop()
+		seeded := false
+		for {
+			if !seeded {
+				err := seedSLOAlerts(runCtx)
+				if err != nil && runCtx.Err() == nil {
+					log.Warn().Err(err).Msg("Cannot read burn-rate alerts left firing, retrying before evaluating SLOs")
+				}
+				seeded = err == nil
+			}
+			if seeded {
+				if err := evaluateSLOs(runCtx, time.Now()); err != nil && runCtx.Err() == nil {
+					log.Error().Err(err).Msg("SLO evaluation failed")
+				}
+			}
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+			case <-sloWake:
+			}
+		}
+	}()
+	lc.OnShutdown("slo-evaluation", func(sctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+	})
+	log.Info().Dur("interval", cfg.SLO.Interval).Int("alerts", len(cfg.SLO.Alerts)).Msg("SLO evaluation enabled")
+	return nil
+}
+
+// seedSLOAlerts takes up the burn-rate alerts a previous run left firing,
+// so they are resolved once the burning has stopped.
+func seedSLOAlerts(ctx context.Context) error {
+	if store == nil {
+		return nil
+	}
+	groups, err := store.ReadAlertGroups(ctx, time.Now().Add(-firingAlertsSince))
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		id, ok := strings.CutPrefix(g.GroupKey, sloGroupPrefix)
+		if !ok {
+			continue
+		}
+		for _, a := range g.Alerts {
+			if a.Status != models.AlertFiring {
+				continue
+			}
+			if sloFiring[id] == nil {
+				sloFiring[id] = make(map[string]models.Alert)
+			}
+			sloFiring[id][a.Labels["burn_alert"]] = a
+		}
+	}
+	return nil
+}
+
+func wakeSLOs() {
+	select {
+	case sloWake <- struct{}{}:
+	default:
+	}
+}
+
+// evaluateSLOs evaluates the stored SLOs and sends the burn-rate alerts
+// that started or stopped firing through incident correlation.
+func evaluateSLOs(ctx context.Context, now time.Time) error {
+	if store == nil {
+		return nil
+	}
+	slos, err := store.ReadSLOs(ctx)
+	if err != nil {
+		return err
+	}
+	statuses := sloEvaluator.Evaluate(ctx, slos, now)
+
+	metrics.SLOBurnRate.Reset()
+	metrics.SLOBudgetRemaining.Reset()
+	topo := lazyGraph(ctx)
+	seen := make(map[string]bool, len(slos))
+	for i, s := range slos {
+		st := statuses[i]
+		seen[s.ID] = true
+		for _, w := range st.Windows {
+			if w.BurnRate != nil {
+				metrics.SLOBurnRate.WithLabelValues(s.ID, w.Window).Set(*w.BurnRate)
+			}
+		}
+		if st.BudgetRemaining != nil {
+			metrics.SLOBudgetRemaining.WithLabelValues(s.ID).Set(*st.BudgetRemaining)
+		}
+		if st.Error != "" {
+			log.Warn().Str("slo", s.ID).Str("service", s.Service).Str("error", st.Error).Msg("Incomplete SLO status")
+		}
+		raiseBurnAlerts(ctx, &s, st, topo, now)
+	}
+	// Deleted SLOs burn no longer
+	for id, firing := range sloFiring {
+		if !seen[id] && len(firing) > 0 {
+			raiseBurnAlerts(ctx, &models.SLO{ID: id}, models.SLOStatus{}, topo, now)
+		}
+	}
+	return nil
+}
+
+// raiseBurnAlerts fires the burn-rate alerts of s that st has firing and
+// resolves the others. Nothing changes if the alerts can't be stored, so
+// the next evaluation retries.
+func raiseBurnAlerts(ctx context.Context, s *models.SLO, st models.SLOStatus, topo func() *models.Graph, now time.Time) {
+	prev := sloFiring[s.ID]
+	next := make(map[string]models.Alert)
+	var changed, firing []models.Alert
+	for _, ac := range cfg.SLO.Alerts {
+		if !slices.Contains(st.Alerts, ac.Name) {
+			continue
+		}
+		a, was := prev[ac.Name]
+		if !was {
+			a = burnAlert(s, st, ac, now)
+			changed = append(changed, a)
+		} else {
+			firing = append(firing, a)
+		}
+		next[ac.Name] = a
+	}
+	// Including alerts no longer configured, and those of deleted SLOs
+	for name, a := range prev {
+		if _, ok := next[name]; !ok {
+			a.Status, a.EndsAt = models.AlertResolved, now.UTC()
+			changed = append(changed, a)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	group := models.AlertGroup{
+		GroupKey:    sloGroupPrefix + s.ID,
+		Receiver:    "slo",
+		Status:      models.AlertResolved,
+		GroupLabels: map[string]string{"slo": s.ID},
+		UpdatedAt:   now.UTC(),
+	}
+	group.Alerts = append(slices.Clone(changed), firing...)
+	if group.Firing() {
+		group.Status = models.AlertFiring
+	}
+	if err := store.WriteAlertGroup(ctx, group); err != nil {
+		log.Error().Err(err).Str("slo", s.ID).Msg("Failed to store burn-rate alerts")
+		return
+	}
+	for _, a := range changed {
+		metrics.SLOAlerts.WithLabelValues(a.Labels["burn_alert"], a.Status).Inc()
+		log.Info().Str("slo", s.ID).Str("alert", a.Labels["burn_alert"]).Str("summary", a.Summary).Msg("Burn-rate alert " + a.Status)
+	}
+	if len(next) == 0 {
+		delete(sloFiring, s.ID)
+	} else {
+		sloFiring[s.ID] = next
+	}
+	correlateAlerts(ctx, group, topo(), now)
+}
+
+// burnAlert is the alert ac raises on s.
+func burnAlert(s *models.SLO, st models.SLOStatus, ac config.BurnAlertConfig, now time.Time) models.Alert {
+	burn := func(d time.Duration) string {
+		w := prom.FormatDuration(d)
+		for _, bw := range st.Windows {
+			if bw.Window == w && bw.BurnRate != nil {
+				return strconv.FormatFloat(*bw.BurnRate, 'f', 1, 64) + "x over " + w
+			}
+		}
+		return "? over " + w
+	}
+	target := s.Service
+	if s.Endpoint != "" {
+		target += " " + s.Endpoint
+	}
+	summary := fmt.Sprintf("%s %s SLO (%s) is burning its error budget %s and %s",
+		target, s.Kind, objective(s.Objective), burn(ac.LongWindow), burn(ac.ShortWindow))
+	sum := sha256.Sum256([]byte(s.ID + "\x00" + ac.Name))
+	labels := map[string]string{
+		"alertname":  sloAlertName,
+		"slo":        s.ID,
+		"service":    s.Service,
+		"severity":   ac.Severity,
+		"burn_alert": ac.Name,
+	}
+	if s.Endpoint != "" {
+		labels["endpoint"] = s.Endpoint
+	}
+	return models.Alert{
+		Fingerprint: hex.EncodeToString(sum[:8]),
+		Name:        sloAlertName,
+		Status:      models.AlertFiring,
+		Severity:    ac.Severity,
+		Summary:     summary,
+		Labels:      labels,
+		StartsAt:    now.UTC(),
+		Services:    []string{s.Service},
+	}
+}
+
+// objective renders an objective as a percentage, e.g. 99.9%.
+func objective(o float64) string {
+	return strconv.FormatFloat(math.Round(o*1e6)/1e4, 'f', -1, 64) + "%"
+}
+
+// annotateSLOs sets the latest SLO status on g's services.
+func annotateSLOs(g *models.Graph) {
+	if sloEvaluator == nil {
+		return
+	}
+	byService := sloEvaluator.ByService()
+	for i := range g.Services {
+		g.Services[i].SLOs = byService[g.Services[i].Name]
+	}
+}
+
+// serviceSLOs returns the latest status of service's SLOs, if evaluated.
+func serviceSLOs(service string) []models.SLOStatus {
+	if sloEvaluator == nil {
+		return nil
+	}
+	return sloEvaluator.ByService()[service]
+}
+
+// sloView is an SLO with its latest status, if evaluated.
+type sloView struct {
+	models.SLO
+	Status *models.SLOStatus `json:"status,omitempty"`
+}
+
+type sloList struct {
+	Count int       `json:"count"`
+	SLOs  []sloView `json:"slos"`
+}
+
+func viewSLO(s models.SLO) sloView {
+	v := sloView{SLO: s}
+	if sloEvaluator == nil {
+		return v
+	}
+	if st, ok := sloEvaluator.Status(s.ID); ok {
+		v.Status = &st
+	}
+	return v
+}
+
+// handleSLOs serves the SLOs and their status. ?service= filters.
+func handleSLOs(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+	slos, err := store.ReadSLOs(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	service := strings.ToLower(r.URL.Query().Get("service"))
+	out := []sloView{}
+	for _, s := range slos {
+		if service == "" || s.Service == service {
+			out = append(out, viewSLO(s))
+		}
+	}
+	writeJSON(w, sloList{Count: len(out), SLOs: out})
+}
+
+// handleSLO serves an SLO and its status.
+func handleSLO(w http.ResponseWriter, r *http.Request) {
+	s, ok := findSLO(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, viewSLO(s))
+}
+
+type sloRequest struct {
+	Service  string `json:"service"`
+	Endpoint string `json:"endpoint"`
+	Kind     string `json:"kind"`
+	// Objective is a share, e.g. 0.999.
+	Objective   float64 `json:"objective"`
+	ThresholdMS float64 `json:"threshold_ms"`
+	// WindowDays defaults to 30.
+	WindowDays int `json:"window_days"`
+	// Source defaults to prometheus for endpoint SLOs, otherwise red.
+	Source      string `json:"source"`
+	Description string `json:"description"`
+}
+
+// handleCreateSLO stores a new SLO.
+func handleCreateSLO(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	s, ok := decodeSLO(w, r, models.SLO{ID: slo.NewID(), CreatedAt: now})
+	if !ok {
+		return
+	}
+	s.UpdatedAt = now
+	saveSLO(w, r, s)
+}
+
+// handleUpdateSLO replaces an SLO.
+func handleUpdateSLO(w http.ResponseWriter, r *http.Request) {
+	old, ok := findSLO(w, r)
+	if !ok {
+		return
+	}
+	s, ok := decodeSLO(w, r, models.SLO{ID: old.ID, CreatedAt: old.CreatedAt})
+	if !ok {
+		return
+	}
+	s.UpdatedAt = time.Now().UTC()
+	saveSLO(w, r, s)
+}
+
+// handleDeleteSLO deletes an SLO. Its burn-rate alerts resolve at the
+// next evaluation.
+func handleDeleteSLO(w http.ResponseWriter, r *http.Request) {
+	s, ok := findSLO(w, r)
+	if !ok {
+		return
+	}
+	if err := store.DeleteSLO(r.Context(), s.ID); err != nil {
+		sloWriteError(w, s.ID, err)
+		return
+	}
+	wakeSLOs()
+	log.Info().Str("slo", s.ID).Str("service", s.Service).Msg("SLO deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findSLO reads the SLO the path names, answering the request itself if
+// it can't.
+func findSLO(w http.ResponseWriter, r *http.Request) (models.SLO, bool) {
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return models.SLO{}, false
+	}
+	slos, err := store.ReadSLOs(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return models.SLO{}, false
+	}
+	i := slices.IndexFunc(slos, func(s models.SLO) bool { return s.ID == r.PathValue("id") })
+	if i < 0 {
+		http.Error(w, "no such SLO", http.StatusNotFound)
+		return models.SLO{}, false
+	}
+	return slos[i], true
+}
+
+// decodeSLO fills s in from the request body, answering the request
+// itself if it's invalid.
+func decodeSLO(w http.ResponseWriter, r *http.Request, s models.SLO) (models.SLO, bool) {
+	var req sloRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSLOBodyBytes)).Decode(&req); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return s, false
+	}
+	s.Service = strings.ToLower(strings.TrimSpace(req.Service))
+	s.Endpoint = strings.TrimSpace(req.Endpoint)
+	s.Kind = req.Kind
+	s.Objective = req.Objective
+	s.ThresholdMS = req.ThresholdMS
+	s.WindowDays = req.WindowDays
+	s.Source = req.Source
+	s.Description = req.Description
+	if s.WindowDays == 0 {
+		s.WindowDays = slo.DefaultWindowDays
+	}
+	if s.Source == "" {
+		s.Source = models.SLOSourceRED
+		if s.Endpoint != "" {
+			s.Source = models.SLOSourcePrometheus
+		}
+	}
+	if err := slo.Validate(&s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return s, false
+	}
+	if s.Source == models.SLOSourcePrometheus && promClient == nil {
+		http.Error(w, "prometheus not configured", http.StatusBadRequest)
+		return s, false
+	}
+	return s, true
+}
+
+func saveSLO(w http.ResponseWriter, r *http.Request, s models.SLO) {
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+	if err := store.WriteSLO(r.Context(), s); err != nil {
+		sloWriteError(w, s.ID, err)
+		return
+	}
+	wakeSLOs()
+	log.Info().Str("slo", s.ID).Str("service", s.Service).Str("kind", s.Kind).
+		Float64("objective", s.Objective).Msg("SLO saved")
+	writeJSON(w, viewSLO(s))
+}
+
+func sloWriteError(w http.ResponseWriter, id string, err error) {
+	log.Error().Err(err).Str("slo", id).Msg("Failed to store SLO")
+	status := http.StatusInternalServerError
+	if errors.Is(err, db.ErrUnavailable) {
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/prom"
+
+	"github.com/rs/zerolog/log"
+)
+
+var promClient *prom.Client
+
+// snapshots holds the latest scheduled metric snapshots.
+var snapshots struct {
+	sync.RWMutex
+	services map[string]models.MetricsSnapshot
+	edges    map[models.Edge]models.MetricsSnapshot
+}
+
+// startMetricSnapshots sets up the Prometheus client and, with an
+// interval configured, snapshots the whole graph on it until shutdown.
+func startMetricSnapshots() error {
+	pcfg := cfg.Prometheus
+	if pcfg.URL == "" {
+		return nil
+	}
+	c, err := prom.New(pcfg)
+	if err != nil {
+		return err
+	}
+	promClient = c
+	if pcfg.Interval == 0 {
+		log.Info().Str("url", pcfg.URL).Msg("Metric snapshots available on request")
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(pcfg.Interval)
+		defer ticker.Stop()
+		for {
+			if err := refreshSnapshots(ctx); err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("Metric snapshot failed")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	lc.OnShutdown("metric-snapshots", func(sctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+	})
+	log.Info().Str("url", pcfg.URL).Dur("interval", pcfg.Interval).Msg("Scheduled metric snapshots")
+	return nil
+}
+
+// refreshSnapshots snapshots every service and edge in the graph. Failed
+// snapshots keep whatever values they got.
+func refreshSnapshots(ctx context.Context) error {
+	if store == nil {
+		return nil
+	}
+	g, err := store.ReadGraph(ctx)
+	if err != nil {
+		return err
+	}
+	failed := annotateGraph(ctx, g, cfg.Prometheus.Window, time.Now())
+
+	services := make(map[string]models.MetricsSnapshot, len(g.Services))
+	for _, s := range g.Services {
+		services[s.Name] = *s.Metrics
+	}
+	edges := make(map[models.Edge]models.MetricsSnapshot, len(g.Edges))
+	for _, e := range g.Edges {
+		edges[snapshotKey(e.Edge)] = *e.Metrics
+	}
+	snapshots.Lock()
+	snapshots.services, snapshots.edges = services, edges
+	snapshots.Unlock()
+	log.Debug().
+		Int("services", len(services)).
+		Int("edges", len(edges)).
+		Int("failed", failed).
+		Msg("Refreshed metric snapshots")
+	return nil
+}
+
+// annotateGraph queries a snapshot for every service and edge in g and
+// returns how many failed.
+func annotateGraph(ctx context.Context, g *models.Graph, window time.Duration, at time.Time) int {
+	failed := 0
+	for i := range g.Services {
+		snap, err := promClient.Service(ctx, g.Services[i].Name, window, at)
+		if err != nil {
+			failed++
+			log.Warn().Err(err).Str("service", g.Services[i].Name).Msg("Incomplete metric snapshot")
+		}
+		g.Services[i].Metrics = &snap
+	}
+	for i := range g.Edges {
+		snap, err := promClient.Edge(ctx, g.Edges[i].Edge, window, at)
+		if err != nil {
+			failed++
+			log.Warn().Err(err).Str("caller", g.Edges[i].Caller).Str("callee", g.Edges[i].Callee).Msg("Incomplete metric snapshot")
+		}
+		g.Edges[i].Metrics = &snap
+	}
+	return failed
+}
+
+// annotateFromSnapshots sets the scheduled snapshots on g. It returns
+// false when none have been taken yet.
+func annotateFromSnapshots(g *models.Graph) bool {
+	snapshots.RLock()
+	defer snapshots.RUnlock()
+	if snapshots.services == nil {
+		return false
+	}
+	for i := range g.Services {
+		if snap, ok := snapshots.services[g.Services[i].Name]; ok {
+			g.Services[i].Metrics = &snap
+		}
+	}
+	for i := range g.Edges {
+		if snap, ok := snapshots.edges[snapshotKey(g.Edges[i].Edge)]; ok {
+			g.Edges[i].Metrics = &snap
+		}
+	}
+	return true
+}
+
+// serviceSnapshot returns the scheduled snapshot of a service or, with
+// live set and none taken, one queried at now. It returns nil without
+// Prometheus.
+func serviceSnapshot(ctx context.Context, name string, live bool, now time.Time) (*models.MetricsSnapshot, error) {
+	if promClient == nil {
+		return nil, nil
+	}
+	snapshots.RLock()
+	snap, ok := snapshots.services[name]
+	snapshots.RUnlock()
+	if ok {
+		return &snap, nil
+	}
+	if !live {
+		return nil, nil
+	}
+	snap, err := promClient.Service(ctx, name, cfg.Prometheus.Window, now)
+	return &snap, err
+}
+
+// snapshotKey is the part of an edge its snapshot depends on.
+func snapshotKey(e models.Edge) models.Edge {
+	return models.Edge{Caller: e.Caller, Callee: e.Callee, Operation: e.Operation}
+}
+
+// snapshotWindow reads ?window=, defaulting to the configured window.
+func snapshotWindow(r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("window")
+	if v == "" {
+		return cfg.Prometheus.Window, true
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d >= 30*time.Second
+}
+
+// handleServiceMetrics serves a snapshot of one service, queried now.
+func handleServiceMetrics(w http.ResponseWriter, r *http.Request) {
+	if promClient == nil {
+		http.Error(w, "prometheus not configured", http.StatusNotFound)
+		return
+	}
+	window, ok := snapshotWindow(r)
+	if !ok {
+		http.Error(w, "invalid window: want a duration of at least 30s", http.StatusBadRequest)
+		return
+	}
+	snap, err := promClient.Service(r.Context(), r.PathValue("name"), window, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, snap)
+}
+
+// handleEdgeMetrics serves a snapshot of every edge from caller to
+// callee in the graph, one per operation, queried now.
+func handleEdgeMetrics(w http.ResponseWriter, r *http.Request) {
+	if promClient == nil {
+		http.Error(w, "prometheus not configured", http.StatusNotFound)
+		return
+	}
+	window, ok := snapshotWindow(r)
+	if !ok {
+		http.Error(w, "invalid window: want a duration of at least 30s", http.StatusBadRequest)
+		return
+	}
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+	g, err := store.ReadGraph(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	caller, callee := r.PathValue("caller"), r.PathValue("callee")
+	now := time.Now()
+	out := []models.GraphEdge{}
+	for _, e := range g.Edges {
+		if e.Caller != caller || e.Callee != callee {
+			continue
+		}
+		snap, err := promClient.Edge(r.Context(), e.Edge, window, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		e.Metrics = &snap
+		out = append(out, e)
+	}
+	if len(out) == 0 {
+		http.Error(w, "no such edge", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, out)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/rca"
+	"servicegraph-builder/pkg/stream"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// streamHeartbeat is how often an idle stream is written to, so
+	// proxies keep it open and dead clients are noticed.
+	streamHeartbeat = 15 * time.Second
+	streamWriteWait = 10 * time.Second
+)
+
+var (
+	// progress carries incident progress to stream clients.
+	progress *stream.Hub
+	// streams tracks the handlers streaming to clients, so shutdown can
+	// let them say goodbye.
+	streams sync.WaitGroup
+)
+
+// publish sends msgs on the incident's stream, if streams are up.
+func publish(id string, msgs ...stream.Message) {
+	if progress != nil {
+		progress.Publish(id, msgs...)
+	}
+}
+
+// appendTimeline stores events on an incident's timeline and streams
+// them as status messages.
+func appendTimeline(ctx context.Context, id string, events []models.TimelineEvent) error {
+	if err := incidentStore.AppendTimeline(ctx, id, events); err != nil {
+		return err
+	}
+	msgs := make([]stream.Message, 0, len(events))
+	for _, ev := range events {
+		m := stream.Status(ev.Kind + ": " + ev.Message)
+		m.Time = ev.Time
+		msgs = append(msgs, m)
+	}
+	publish(id, msgs...)
+	return nil
+}
+
+// subscribe follows the incident in the request, resuming after the
+// Last-Event-ID header or ?after=. It answers the request itself and
+// returns nil if it can't.
+func subscribe(w http.ResponseWriter, r *http.Request) (*stream.Subscription, []stream.Message) {
+	id := r.PathValue("id")
+	if _, err := incidentStore.Incident(r.Context(), id); err != nil {
+		incidentError(w, err)
+		return nil, nil
+	}
+	var after int64
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("after")
+	}
+	if v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid event ID", http.StatusBadRequest)
+			return nil, nil
+		}
+		after = n
+	}
+	return progress.Subscribe(id, after)
+}
+
+// handleIncidentStream streams an incident's progress as Server-Sent
+// Events, one event per message named after its type.
+func handleIncidentStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	streams.Add(1)
+	defer streams.Done()
+	sub, backlog := subscribe(w, r)
+	if sub == nil {
+		return
+	}
+	defer progress.Unsubscribe(sub)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	send := func(m stream.Message) error {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", m.Seq, m.Type, b)
+		return err
+	}
+	for _, m := range backlog {
+		if send(m) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m, ok := <-sub.C:
+			if !ok || send(m) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// handleIncidentWebSocket streams an incident's progress over a
+// WebSocket, one JSON text message per message. Anything the client
+// sends is ignored.
+func handleIncidentWebSocket(w http.ResponseWriter, r *http.Request) {
+	streams.Add(1)
+	defer streams.Done()
+	sub, backlog := subscribe(w, r)
+	if sub == nil {
+		return
+	}
+	defer progress.Unsubscribe(sub)
+	upgrader := websocket.Upgrader{CheckOrigin: checkStreamOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has answered
+		return
+	}
+	defer conn.Close()
+
+	// Reading handles pings and notices the client going away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	send := func(m stream.Message) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+		return conn.WriteJSON(m)
+	}
+	for _, m := range backlog {
+		if send(m) != nil {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-gone:
+			return
+		case m, ok := <-sub.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(streamWriteWait))
+				return
+			}
+			if send(m) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// checkStreamOrigin lets same-host pages and the configured origins open
+// WebSockets.
+func checkStreamOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	origins := cfg.Incidents.StreamOrigins
+	if slices.Contains(origins, "*") || slices.Contains(origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// graphNode and graphView are the service graph as the frontend draws
+// it: each alerting service with its direct callers and callees.
+type graphNode struct {
+	Name       string            `json:"name"`
+	K8s        graphNodeK8s      `json:"k8s"`
+	Operation  *string           `json:"operation"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+type graphNodeK8s struct {
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	OwnerKind   string            `json:"owner_kind"`
+	OwnerName   string            `json:"owner_name"`
+	OwnerUID    string            `json:"owner_uid"`
+}
+
+type graphView struct {
+	Services []graphViewService `json:"services"`
+}
+
+type graphViewService struct {
+	Current    graphNode   `json:"current"`
+	Upstream   []graphNode `json:"upstream"`
+	Downstream []graphNode `json:"downstream"`
+}
+
+// packGraph renders the alerting services of pack and their direct
+// neighbours as a graph message.
+func packGraph(pack *contextPack) string {
+	services := make(map[string]packService, len(pack.Services))
+	for _, s := range pack.Services {
+		services[s.Name] = s
+	}
+	node := func(name, operation string) graphNode {
+		s := services[name]
+		n := graphNode{Name: name, K8s: graphNodeK8s{Namespace: s.Namespace, OwnerKind: s.OwnerKind, OwnerName: s.OwnerName}}
+		if operation != "" {
+			n.Operation = &operation
+		}
+		return n
+	}
+	view := graphView{Services: []graphViewService{}}
+	for _, s := range pack.Services {
+		if s.Role != rca.RoleAlerting {
+			continue
+		}
+		vs := graphViewService{Current: node(s.Name, ""), Upstream: []graphNode{}, Downstream: []graphNode{}}
+		for _, e := range pack.Edges {
+			switch s.Name {
+			case e.Callee:
+				vs.Upstream = append(vs.Upstream, node(e.Caller, e.Operation))
+			case e.Caller:
+				vs.Downstream = append(vs.Downstream, node(e.Callee, e.Operation))
+			}
+		}
+		view.Services = append(view.Services, vs)
+	}
+	b, _ := json.Marshal(view)
+	return string(b)
+}
+
+func startProgressStreams() {
+	progress = stream.NewHub()
+	lc.OnShutdown("incident-streams", func(ctx context.Context) error {
+		progress.Close()
+		done := make(chan struct{})
+		go func() {
+			streams.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	log.Info().Msg("Incident progress streams ready")
+}
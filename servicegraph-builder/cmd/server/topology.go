@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/db"
+	"servicegraph-builder/pkg/topology"
+
+	"github.com/rs/zerolog/log"
+)
+
+// mergeDeclaredTopology merges the configured declaration files into
+// storage. A broken file fails startup rather than leaving the graph
+// silently incomplete.
+func mergeDeclaredTopology(ctx context.Context) error {
+	files := cfg.Topology.DeclaredFiles
+	if len(files) == 0 {
+		return nil
+	}
+	decl, err := topology.LoadDeclared(files)
+	if err != nil {
+		return err
+	}
+	if err := store.MergeEdges(ctx, decl.Services, decl.Edges); err != nil {
+		return fmt.Errorf("failed to merge declared topology: %w", err)
+	}
+	log.Info().
+		Strs("files", files).
+		Int("services", len(decl.Services)).
+		Int("edges", len(decl.Edges)).
+		Msg("Merged declared topology")
+	return nil
+}
+
+// startTopologyInference merges inferred edges into storage now and then
+// every interval until shutdown.
+func startTopologyInference() {
+	icfg := cfg.Topology.Infer
+	if !icfg.Enabled {
+		return
+	}
+	if k8sClient == nil {
+		log.Warn().Msg("Topology inference needs a Kubernetes client; skipping")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(icfg.Interval)
+		defer ticker.Stop()
+		for {
+			if err := inferTopology(ctx); err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("Topology inference failed")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	lc.OnShutdown("topology-inference", func(sctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+	})
+}
+
+func inferTopology(ctx context.Context) error {
+	cluster, err := topology.LoadCluster(ctx, k8sClient, cfg.Topology.Infer.Namespaces)
+	if err != nil {
+		return err
+	}
+	inferred := topology.Infer(cluster)
+	if err := store.MergeEdges(ctx, inferred.Services, inferred.ModelEdges()); err != nil {
+		return fmt.Errorf("failed to merge inferred topology: %w", err)
+	}
+	log.Info().
+		Int("services", len(inferred.Services)).
+		Int("edges", len(inferred.Edges)).
+		Msg("Merged inferred topology")
+	return nil
+}
+
+// topologyMain implements the `servicegraph-builder topology` subcommands.
+func topologyMain(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "diff":
+			topologyDiffMain(args[1:])
+			return
+		case "infer":
+			topologyInferMain(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: servicegraph-builder topology diff|infer [flags]\n")
+	os.Exit(2)
+}
+
+// topologyInferMain prints the edges inference proposes, from manifests
+// or the live cluster, and merges them into storage with --apply.
+func topologyInferMain(args []string) {
+	fs := flag.NewFlagSet("topology infer", flag.ExitOnError)
+	flags := config.RegisterFlags(fs)
+	manifests := fs.String("manifests", "", "comma-separated manifest files or directories to read instead of the cluster")
+	apply := fs.Bool("apply", false, "merge the inferred edges into storage")
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "topology infer: unknown format %q\n", *format)
+		os.Exit(2)
+	}
+	if loadConfig(flags) {
+		return
+	}
+	setupLogging(cfg.Log)
+
+	ctx := context.Background()
+	inferred, err := loadInferred(ctx, *manifests)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "topology infer: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(inferred.Edges)
+	} else {
+		for _, e := range inferred.Edges {
+			fmt.Printf("%s -> %s\n", e.Caller, e.Callee)
+			for _, ev := range e.Evidence {
+				fmt.Printf("    %s\n", ev)
+			}
+		}
+	}
+
+	if !*apply {
+		return
+	}
+	s, err := db.Open(cfg.Storage)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "topology infer: failed to initialize %s storage: %v\n", cfg.Storage.Backend, err)
+		os.Exit(1)
+	}
+	defer s.Close(ctx)
+	if err := s.MergeEdges(ctx, inferred.Services, inferred.ModelEdges()); err != nil {
+		fmt.Fprintf(os.Stderr, "topology infer: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "merged %d inferred edges\n", len(inferred.Edges))
+}
+
+func loadInferred(ctx context.Context, manifests string) (*topology.Inferred, error) {
+	var cluster *topology.Cluster
+	var err error
+	if manifests != "" {
+		cluster, err = topology.ReadManifests(config.SplitList(manifests))
+	} else {
+		if err := initK8sClient(cfg.Kubernetes); err != nil {
+			return nil, fmt.Errorf("cannot initialize kubernetes client: %w", err)
+		}
+		if k8sClient == nil {
+			return nil, fmt.Errorf("kubernetes lookups are disabled; use --manifests")
+		}
+		cluster, err = topology.LoadCluster(ctx, k8sClient, cfg.Topology.Infer.Namespaces)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return topology.Infer(cluster), nil
+}
+
+// topologyDiffMain implements `servicegraph-builder topology diff`.
+func topologyDiffMain(args []string) {
+	fs := flag.NewFlagSet("topology diff", flag.ExitOnError)
+	flags := config.RegisterFlags(fs)
+	format := fs.String("format", "text", "report format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: servicegraph-builder topology diff [flags] [file...]\n\n")
+		fmt.Fprintf(fs.Output(), "Compares declared edges with the observed graph in storage. Files\n")
+		fmt.Fprintf(fs.Output(), "default to topology.declared_files from the config.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "topology diff: unknown format %q\n", *format)
+		os.Exit(2)
+	}
+	if loadConfig(flags) {
+		return
+	}
+	setupLogging(cfg.Log)
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = cfg.Topology.DeclaredFiles
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "topology diff: no declaration files given or configured")
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	s, err := db.Open(cfg.Storage)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "topology diff: failed to initialize %s storage: %v\n", cfg.Storage.Backend, err)
+		os.Exit(1)
+	}
+	defer s.Close(ctx)
+
+	report, err := diffTopology(ctx, s, files)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "topology diff: %v\n", err)
+		os.Exit(1)
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "topology diff: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// diffTopology re-reads files so edits show up without a restart.
+func diffTopology(ctx context.Context, s db.Store, files []string) (topology.Report, error) {
+	decl, err := topology.LoadDeclared(files)
+	if err != nil {
+		return topology.Report{}, err
+	}
+	g, err := s.ReadGraph(ctx)
+	if err != nil {
+		return topology.Report{}, err
+	}
+	return topology.Diff(decl.Edges, g), nil
+}
+
+// handleTopologyDiff serves the diff for the configured declaration files.
+func handleTopologyDiff(w http.ResponseWriter, r *http.Request) {
+	if len(cfg.Topology.DeclaredFiles) == 0 {
+		http.Error(w, "no declared topology configured", http.StatusNotFound)
+		return
+	}
+	if store == nil {
+		http.Error(w, "storage not connected", http.StatusServiceUnavailable)
+		return
+	}
+	report, err := diffTopology(r.Context(), store, cfg.Topology.DeclaredFiles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,192 @@
+// Package alerts decodes Alertmanager webhook notifications and works out
+// which graph services their alerts fire on.
+package alerts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+)
+
+// Payload is the Alertmanager webhook body, version 4.
+type Payload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Alert is one alert in a Payload.
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// Decode reads and validates a payload.
+func Decode(r io.Reader) (*Payload, error) {
+	var p Payload
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, fmt.Errorf("decode alertmanager payload: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate checks the fields the builder relies on.
+func (p *Payload) Validate() error {
+	var errs []error
+	if p.Version != "4" {
+		errs = append(errs, fmt.Errorf("unsupported payload version %q, want 4", p.Version))
+	}
+	if p.GroupKey == "" {
+		errs = append(errs, errors.New("groupKey is required"))
+	}
+	if !validStatus(p.Status) {
+		errs = append(errs, fmt.Errorf("unknown group status %q", p.Status))
+	}
+	for i, a := range p.Alerts {
+		if !validStatus(a.Status) {
+			errs = append(errs, fmt.Errorf("alerts[%d]: unknown status %q", i, a.Status))
+		}
+		if len(a.Labels) == 0 {
+			errs = append(errs, fmt.Errorf("alerts[%d]: labels are required", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validStatus(s string) bool {
+	return s == models.AlertFiring || s == models.AlertResolved
+}
+
+// Target is what an alert's labels point at.
+type Target struct {
+	// Service is the graph service named by a label, if any.
+	Service   string
+	Namespace string
+	Pod       string
+}
+
+// TargetOf reads the labels acfg names. Common labels fill in what the
+// alert's own labels lack.
+func TargetOf(labels, common map[string]string, acfg config.AlertsConfig) Target {
+	get := func(key string) string {
+		if v := strings.TrimSpace(labels[key]); v != "" {
+			return v
+		}
+		return strings.TrimSpace(common[key])
+	}
+	var t Target
+	for _, key := range acfg.ServiceLabels {
+		if v := get(key); v != "" {
+			t.Service = strings.ToLower(v)
+			break
+		}
+	}
+	t.Namespace = get(acfg.NamespaceLabel)
+	t.Pod = get(acfg.PodLabel)
+	return t
+}
+
+// Group converts p to an alert group received at now. services maps each
+// alert to the graph services it fires on.
+func (p *Payload) Group(now time.Time, services func(Alert) (namespace string, names []string)) models.AlertGroup {
+	g := models.AlertGroup{
+		GroupKey:          p.GroupKey,
+		Receiver:          p.Receiver,
+		Status:            p.Status,
+		GroupLabels:       p.GroupLabels,
+		CommonLabels:      p.CommonLabels,
+		CommonAnnotations: p.CommonAnnotations,
+		ExternalURL:       p.ExternalURL,
+		UpdatedAt:         now.UTC(),
+		Alerts:            make([]models.Alert, 0, len(p.Alerts)),
+	}
+	for _, a := range p.Alerts {
+		fp := a.Fingerprint
+		if fp == "" {
+			fp = Fingerprint(a.Labels)
+		}
+		ma := models.Alert{
+			Fingerprint:  fp,
+			Name:         a.Labels["alertname"],
+			Status:       a.Status,
+			Severity:     a.Labels["severity"],
+			Summary:      a.Annotations["summary"],
+			Labels:       a.Labels,
+			Annotations:  a.Annotations,
+			StartsAt:     a.StartsAt.UTC(),
+			GeneratorURL: a.GeneratorURL,
+		}
+		// Alertmanager sends the zero time, or a future one it will
+		// resolve at, for alerts still firing
+		if a.Status == models.AlertResolved {
+			ma.EndsAt = a.EndsAt.UTC()
+		}
+		ma.Namespace, ma.Services = services(a)
+		g.Alerts = append(g.Alerts, ma)
+	}
+	return g
+}
+
+// Fingerprint identifies an alert by its label set, for senders that
+// don't include Alertmanager's own fingerprint.
+func Fingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\x00%s\x00", k, labels[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// MergeGroup folds update into old: group fields are replaced, and
+// alerts are upserted by fingerprint, keeping ones update no longer
+// carries. The result's status is firing if any alert still is.
+func MergeGroup(old, update models.AlertGroup) models.AlertGroup {
+	byFP := make(map[string]int, len(old.Alerts))
+	merged := append([]models.Alert(nil), old.Alerts...)
+	for i, a := range merged {
+		byFP[a.Fingerprint] = i
+	}
+	for _, a := range update.Alerts {
+		if i, ok := byFP[a.Fingerprint]; ok {
+			merged[i] = a
+		} else {
+			byFP[a.Fingerprint] = len(merged)
+			merged = append(merged, a)
+		}
+	}
+	update.Alerts = merged
+	update.Status = models.AlertResolved
+	if update.Firing() {
+		update.Status = models.AlertFiring
+	}
+	return update
+}
@@ -0,0 +1,245 @@
+// Package anomaly learns which edges the graph normally has and how busy
+// they are, and reports changes as graph events: edges that appear or
+// vanish, traffic that moves from one callee to another, and call rates
+// that shift far from their baseline.
+//
+// Calls are counted per window before deduplication, so rates reflect
+// every span the builder received, including those ingress sampling
+// dropped before enrichment.
+package anomaly
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+)
+
+// minBaselineWindows is how many windows an edge must have carried calls
+// in before its rate shifts or disappearance are reported.
+const minBaselineWindows = 5
+
+type edgeKey struct {
+	caller, callee string
+}
+
+// baseline is what the detector knows about one edge.
+type baseline struct {
+	// rate is the moving average of calls per minute
+	rate float64
+	// windows is the number of windows rate has been averaged over; 0
+	// for edges only known from storage
+	windows int
+	// active is the number of those windows that had calls
+	active   int
+	lastSeen time.Time
+	// shifted is set while the rate is outside the baseline band, so a
+	// shift is reported once rather than every window
+	shifted bool
+}
+
+// Detector is safe for concurrent use.
+type Detector struct {
+	cfg     config.AnomalyConfig
+	alpha   float64
+	started time.Time
+
+	mu     sync.Mutex
+	counts map[edgeKey]int64 // calls in the current window
+	edges  map[edgeKey]*baseline
+}
+
+// New returns a detector whose learning period starts at now.
+func New(cfg config.AnomalyConfig, now time.Time) *Detector {
+	// An exponential moving average over roughly the learning period
+	n := max(float64(cfg.LearningPeriod)/float64(cfg.Window), 1)
+	return &Detector{
+		cfg:     cfg,
+		alpha:   2 / (n + 1),
+		started: now,
+		counts:  make(map[edgeKey]int64),
+		edges:   make(map[edgeKey]*baseline),
+	}
+}
+
+// Seed marks edges already in storage as known, so they aren't reported
+// as new after a restart. They get a rate baseline once traffic arrives.
+func (d *Detector) Seed(edges []models.Edge) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range edges {
+		k := edgeKey{e.Caller, e.Callee}
+		if _, ok := d.edges[k]; !ok {
+			d.edges[k] = &baseline{}
+		}
+	}
+}
+
+// Observe counts one call on edge in the current window.
+func (d *Detector) Observe(edge models.Edge) {
+	d.mu.Lock()
+	d.counts[edgeKey{edge.Caller, edge.Callee}]++
+	d.mu.Unlock()
+}
+
+// Len returns the number of edges in the baseline.
+func (d *Detector) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.edges)
+}
+
+// Evaluate closes the current window at now, folds it into the baseline
+// and returns the events it produced, ordered by kind, caller and callee.
+func (d *Detector) Evaluate(now time.Time) []models.GraphEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	counts := d.counts
+	d.counts = make(map[edgeKey]int64)
+	learning := now.Sub(d.started) < d.cfg.LearningPeriod
+	perMinute := float64(time.Minute) / float64(d.cfg.Window)
+
+	var added []edgeKey
+	for k := range counts {
+		b, ok := d.edges[k]
+		if !ok {
+			b = &baseline{}
+			d.edges[k] = b
+			if !learning {
+				added = append(added, k)
+			}
+		}
+		b.lastSeen = now
+	}
+
+	var events []models.GraphEvent
+	// dropped are established edges whose traffic fell sharply or
+	// vanished this window, keyed by caller, for pairing with new edges
+	dropped := make(map[string][]models.GraphEvent)
+	for k, b := range d.edges {
+		n := counts[k]
+		current := float64(n) * perMinute
+		if b.windows == 0 && n == 0 {
+			continue
+		}
+		established := b.active >= minBaselineWindows
+
+		if n == 0 && now.Sub(b.lastSeen) >= d.cfg.VanishAfter {
+			// Edges that only ever carried the odd call go quietly
+			delete(d.edges, k)
+			if established && !learning {
+				dropped[k.caller] = append(dropped[k.caller], d.event(models.EventVanishedEdge, k, b.rate, 0, now))
+			}
+			continue
+		}
+
+		if established && !learning && b.rate >= d.cfg.MinRate {
+			f := d.cfg.RateShiftFactor
+			out := current >= b.rate*f || current <= b.rate/f
+			if out && !b.shifted {
+				ev := d.event(models.EventRateShift, k, b.rate, current, now)
+				if current < b.rate {
+					dropped[k.caller] = append(dropped[k.caller], ev)
+				} else {
+					events = append(events, ev)
+				}
+			}
+			b.shifted = out
+		}
+
+		if b.windows == 0 {
+			b.rate = current
+		} else {
+			b.rate += d.alpha * (current - b.rate)
+		}
+		b.windows++
+		if n > 0 {
+			b.active++
+		}
+	}
+
+	// A new edge and a drop from the same caller in one window is
+	// traffic moving from one callee to another
+	sortKeys(added)
+	for _, evs := range dropped {
+		sort.Slice(evs, func(i, j int) bool { return evs[i].Callee < evs[j].Callee })
+	}
+	for _, k := range added {
+		current := float64(counts[k]) * perMinute
+		if prev := dropped[k.caller]; len(prev) > 0 {
+			ev := d.event(models.EventRerouted, k, prev[0].BaselineRate, current, now)
+			ev.PreviousCallee = prev[0].Callee
+			ev.ID = eventID(ev)
+			ev.Message = fmt.Sprintf("%s moved calls from %s (%.1f/min) to %s (%.1f/min)",
+				k.caller, ev.PreviousCallee, ev.BaselineRate, k.callee, current)
+			events = append(events, ev)
+			dropped[k.caller] = prev[1:]
+			continue
+		}
+		events = append(events, d.event(models.EventNewEdge, k, 0, current, now))
+	}
+	for _, evs := range dropped {
+		events = append(events, evs...)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Caller != b.Caller {
+			return a.Caller < b.Caller
+		}
+		return a.Callee < b.Callee
+	})
+	return events
+}
+
+func (d *Detector) event(kind string, k edgeKey, baselineRate, current float64, now time.Time) models.GraphEvent {
+	ev := models.GraphEvent{
+		Kind:         kind,
+		Caller:       k.caller,
+		Callee:       k.callee,
+		BaselineRate: round(baselineRate),
+		CurrentRate:  round(current),
+		DetectedAt:   now.UTC(),
+	}
+	switch kind {
+	case models.EventNewEdge:
+		ev.Message = fmt.Sprintf("%s started calling %s (%.1f/min)", k.caller, k.callee, ev.CurrentRate)
+	case models.EventVanishedEdge:
+		ev.Message = fmt.Sprintf("%s stopped calling %s (was %.1f/min, nothing for %s)",
+			k.caller, k.callee, ev.BaselineRate, d.cfg.VanishAfter)
+	case models.EventRateShift:
+		ev.Message = fmt.Sprintf("%s → %s call rate moved from %.1f/min to %.1f/min",
+			k.caller, k.callee, ev.BaselineRate, ev.CurrentRate)
+	}
+	ev.ID = eventID(ev)
+	return ev
+}
+
+// eventID derives a stable ID, so rewriting an event is idempotent.
+func eventID(ev models.GraphEvent) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%d",
+		ev.Kind, ev.Caller, ev.Callee, ev.PreviousCallee, ev.DetectedAt.UnixNano())))
+	return hex.EncodeToString(sum[:8])
+}
+
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+func sortKeys(keys []edgeKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].caller != keys[j].caller {
+			return keys[i].caller < keys[j].caller
+		}
+		return keys[i].callee < keys[j].callee
+	})
+}
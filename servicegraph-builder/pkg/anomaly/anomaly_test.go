@@ -0,0 +1,178 @@
+package anomaly_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"servicegraph-builder/pkg/anomaly"
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+)
+
+var t0 = time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+// detector steps a Detector learning for five one-minute windows, with
+// rates averaged over about five windows.
+type detector struct {
+	*anomaly.Detector
+	now time.Time
+}
+
+func newDetector() *detector {
+	cfg := config.AnomalyConfig{
+		Window:          time.Minute,
+		LearningPeriod:  5 * time.Minute,
+		VanishAfter:     3 * time.Minute,
+		RateShiftFactor: 3,
+		MinRate:         10,
+	}
+	return &detector{Detector: anomaly.New(cfg, t0), now: t0}
+}
+
+// step observes calls, keyed "caller>callee", in one window and returns
+// the events closing it produced.
+func (d *detector) step(calls map[string]int) []models.GraphEvent {
+	for edge, n := range calls {
+		caller, callee, _ := strings.Cut(edge, ">")
+		for range n {
+			d.Observe(models.Edge{Caller: caller, Callee: callee})
+		}
+	}
+	d.now = d.now.Add(time.Minute)
+	return d.Evaluate(d.now)
+}
+
+// steady steps n windows of calls, failing t on any event.
+func (d *detector) steady(t *testing.T, n int, calls map[string]int) {
+	t.Helper()
+	for range n {
+		if evs := d.step(calls); len(evs) > 0 {
+			t.Fatalf("%s: unexpected events %v", d.now, summary(evs))
+		}
+	}
+}
+
+func summary(evs []models.GraphEvent) []string {
+	out := make([]string, len(evs))
+	for i, ev := range evs {
+		out[i] = fmt.Sprintf("%s %s>%s", ev.Kind, ev.Caller, ev.Callee)
+		if ev.PreviousCallee != "" {
+			out[i] += " from " + ev.PreviousCallee
+		}
+	}
+	return out
+}
+
+func TestLearningPeriod(t *testing.T) {
+	d := newDetector()
+	// New and vanished edges are learnt quietly
+	d.steady(t, 2, map[string]int{"frontend>orders": 20, "frontend>legacy": 20})
+	d.steady(t, 2, map[string]int{"frontend>orders": 20})
+
+	evs := d.step(map[string]int{"frontend>orders": 20, "frontend>payments": 20})
+	if got := strings.Join(summary(evs), ", "); got != "new_edge frontend>payments" {
+		t.Fatalf("events after learning %s, want the new edge only", got)
+	}
+	if evs[0].CurrentRate != 20 || evs[0].ID == "" {
+		t.Errorf("new edge event %+v", evs[0])
+	}
+}
+
+func TestSeededEdgesNotVanished(t *testing.T) {
+	d := newDetector()
+	d.Seed([]models.Edge{{Caller: "frontend", Callee: "orders"}})
+	// Quiet well past the learning period and VanishAfter
+	d.steady(t, 10, nil)
+	if d.Len() != 1 {
+		t.Fatalf("baseline holds %d edges, want the seeded one", d.Len())
+	}
+	// Nor are they new once traffic arrives
+	d.steady(t, 1, map[string]int{"frontend>orders": 20})
+}
+
+func TestReroute(t *testing.T) {
+	d := newDetector()
+	d.steady(t, 6, map[string]int{"frontend>orders": 20, "frontend>payments": 20})
+
+	evs := d.step(map[string]int{"frontend>orders-v2": 20, "frontend>payments": 20, "batch>orders": 20})
+	want := "new_edge batch>orders, rerouted frontend>orders-v2 from orders"
+	if got := strings.Join(summary(evs), ", "); got != want {
+		t.Fatalf("events %s, want %s", got, want)
+	}
+	if ev := evs[1]; ev.BaselineRate != 20 || ev.CurrentRate != 20 {
+		t.Errorf("reroute from %v/min to %v/min, want 20 to 20", ev.BaselineRate, ev.CurrentRate)
+	}
+
+	// Gone for good, the old edge vanishes after VanishAfter
+	calls := map[string]int{"frontend>orders-v2": 20, "frontend>payments": 20}
+	d.steady(t, 1, calls)
+	if got := strings.Join(summary(d.step(calls)), ", "); got != "vanished_edge frontend>orders" {
+		t.Errorf("events %s, want the old edge vanished", got)
+	}
+}
+
+func TestVanishedEdge(t *testing.T) {
+	d := newDetector()
+	d.steady(t, 6, map[string]int{"frontend>orders": 20, "frontend>payments": 20})
+
+	// The drop is a rate shift; only after VanishAfter is it vanished
+	calls := map[string]int{"frontend>payments": 20}
+	var got []string
+	for range 3 {
+		got = append(got, summary(d.step(calls))...)
+	}
+	want := "rate_shift frontend>orders, vanished_edge frontend>orders"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("events %v, want %s", got, want)
+	}
+}
+
+func TestRateShiftThreshold(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		calls int
+		want  string
+	}{
+		{"just below three times", 59, ""},
+		{"three times", 60, "rate_shift frontend>orders"},
+		{"just above a third", 7, ""},
+		{"a third", 6, "rate_shift frontend>orders"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newDetector()
+			d.steady(t, 6, map[string]int{"frontend>orders": 20})
+			evs := d.step(map[string]int{"frontend>orders": tc.calls})
+			if got := strings.Join(summary(evs), ", "); got != tc.want {
+				t.Errorf("events %q, want %q", got, tc.want)
+			}
+			if len(evs) == 1 && (evs[0].BaselineRate != 20 || evs[0].CurrentRate != float64(tc.calls)) {
+				t.Errorf("shift from %v/min to %v/min", evs[0].BaselineRate, evs[0].CurrentRate)
+			}
+		})
+	}
+}
+
+func TestRateShiftFollowsBaseline(t *testing.T) {
+	d := newDetector()
+	d.steady(t, 6, map[string]int{"frontend>orders": 20})
+	// The moving average takes a third of each window's change: 20 → 30
+	d.steady(t, 1, map[string]int{"frontend>orders": 50})
+	d.steady(t, 1, map[string]int{"frontend>orders": 89})
+
+	// 30 + (89 - 30) / 3
+	evs := d.step(map[string]int{"frontend>orders": 150})
+	if len(evs) != 1 || evs[0].BaselineRate != 49.67 {
+		t.Fatalf("events %v, want a shift from a 49.67/min baseline", evs)
+	}
+	// Reported once while the rate stays out of the band
+	d.steady(t, 1, map[string]int{"frontend>orders": 300})
+}
+
+func TestQuietEdgesDontShift(t *testing.T) {
+	d := newDetector()
+	d.steady(t, 6, map[string]int{"frontend>orders": 5})
+	// Below MinRate
+	d.steady(t, 1, map[string]int{"frontend>orders": 50})
+}
@@ -1,23 +1,41 @@
 package cache
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type Item struct {
 	Value interface{}
 	Expiration int64
 }
 
+// Cache is safe for concurrent use.
 type Cache struct {
-	items map[string]Item
+	mu         sync.Mutex
+	items      map[string]Item
+	maxEntries int
 }
 
 func New() *Cache {
+	return NewWithMaxEntries(0)
+}
+
+// NewWithMaxEntries returns a cache holding at most maxEntries items.
+// A maxEntries of 0 means unbounded.
+func NewWithMaxEntries(maxEntries int) *Cache {
 	return &Cache{
-		items: make(map[string]Item),
+		items:      make(map[string]Item),
+		maxEntries: maxEntries,
 	}
 }
 
 func (c *Cache) Set(key string, value interface{}, expiration int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.items[key]; !exists && c.maxEntries > 0 && len(c.items) >= c.maxEntries {
+		c.evict()
+	}
 	c.items[key] = Item{
 		Value: value,
 		Expiration: expiration + time.Now().Unix(),
@@ -25,6 +43,8 @@ func (c *Cache) Set(key string, value interface{}, expiration int64) {
 }
 
 func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	item, found := c.items[key]
 	if !found {
 		return nil, false
@@ -37,9 +57,32 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 }
 
 func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	delete(c.items, key)
 }
 
 func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.items = make(map[string]Item)
 }
+
+// evict drops expired items, or the item closest to expiry if none have
+// expired yet. Callers hold c.mu.
+func (c *Cache) evict() {
+	now := time.Now().Unix()
+	oldestKey, oldest := "", int64(0)
+	for k, item := range c.items {
+		if item.Expiration > 0 && item.Expiration < now {
+			delete(c.items, k)
+			continue
+		}
+		if oldestKey == "" || item.Expiration < oldest {
+			oldestKey, oldest = k, item.Expiration
+		}
+	}
+	if len(c.items) >= c.maxEntries && oldestKey != "" {
+		delete(c.items, oldestKey)
+	}
+}
@@ -0,0 +1,1290 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// Kubernetes client modes.
+const (
+	K8sModeAuto       = "auto"
+	K8sModeInCluster  = "in-cluster"
+	K8sModeKubeconfig = "kubeconfig"
+	K8sModeDisabled   = "disabled"
+)
+
+// Storage backends.
+const (
+	BackendNeo4j  = "neo4j"
+	BackendMemory = "memory"
+)
+
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Cache      CacheConfig      `yaml:"cache"`
+	Filter     FilterConfig     `yaml:"filter"`
+	Kubernetes KubernetesConfig `yaml:"kubernetes"`
+	Log        LogConfig        `yaml:"log"`
+	Telemetry  TelemetryConfig  `yaml:"telemetry"`
+	Record     RecordConfig     `yaml:"record"`
+	Topology   TopologyConfig   `yaml:"topology"`
+	Sampling   SamplingConfig   `yaml:"sampling"`
+	Pipeline   PipelineConfig   `yaml:"pipeline"`
+	DLQ        DLQConfig        `yaml:"dlq"`
+	Anomaly    AnomalyConfig    `yaml:"anomaly"`
+	Alerts     AlertsConfig     `yaml:"alerts"`
+	Context    ContextConfig    `yaml:"context"`
+	Prometheus PrometheusConfig `yaml:"prometheus"`
+	Incidents  IncidentsConfig  `yaml:"incidents"`
+	RCA        RCAConfig        `yaml:"rca"`
+	// Notifications go out about incidents to the configured sinks.
+	Notifications NotificationsConfig `yaml:"notifications"`
+	SLO           SLOConfig           `yaml:"slo"`
+	// DataDir holds the builder's own state, such as the incident
+	// database, unless given absolute paths. It defaults to data in the
+	// working directory, so the builder runs without root; images set
+	// it to a volume.
+	DataDir string `yaml:"data_dir"`
+}
+
+// Client certificate policies for the OTLP listener.
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+type ServerConfig struct {
+	OTLPAddress string     `yaml:"otlp_address"`
+	TLS         TLSConfig  `yaml:"tls"`
+	Auth        AuthConfig `yaml:"auth"`
+	// AdminAddress serves /healthz, /readyz, /metrics and the /api/v1
+	// endpoints over HTTP. It defaults to every interface with AdminAuth
+	// configured and to loopback only without.
+	AdminAddress string `yaml:"admin_address"`
+	// AdminTLS serves the admin endpoints over HTTPS.
+	AdminTLS TLSConfig `yaml:"admin_tls"`
+	// AdminAuth requires a bearer token on every /api/v1 request. Probes
+	// and /metrics stay open.
+	AdminAuth AuthConfig `yaml:"admin_auth"`
+	// ShutdownTimeout bounds how long in-flight exports and pending writes
+	// are drained after SIGTERM. Keep it below the pod's grace period.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile is the CA bundle client certificates are verified against.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth is none, request (verify if presented) or require.
+	ClientAuth string `yaml:"client_auth"`
+	// ReloadInterval is how often the certificate and bearer token files
+	// are checked for changes.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+type AuthConfig struct {
+	// BearerTokens are accepted in the "authorization: Bearer" metadata.
+	BearerTokens []string `yaml:"bearer_tokens"`
+	// BearerTokenFile holds one accepted token per line and is re-read
+	// when it changes.
+	BearerTokenFile string `yaml:"bearer_token_file"`
+}
+
+// Enabled reports whether bearer-token auth is configured.
+func (a AuthConfig) Enabled() bool {
+	return len(a.BearerTokens) > 0 || a.BearerTokenFile != ""
+}
+
+type StorageConfig struct {
+	Backend string      `yaml:"backend"`
+	Neo4j   Neo4jConfig `yaml:"neo4j"`
+	// Reconnect is the backoff between connection attempts while the
+	// backend is unreachable.
+	Reconnect ReconnectConfig `yaml:"reconnect"`
+	// Spool keeps writes made while the backend is unreachable.
+	Spool SpoolConfig `yaml:"spool"`
+}
+
+type ReconnectConfig struct {
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// SpoolConfig is the local write-ahead log replayed into storage once it
+// is reachable again.
+type SpoolConfig struct {
+	// Dir holds the log; empty disables spooling, so writes fail while
+	// storage is down.
+	Dir string `yaml:"dir"`
+	// MaxBytes caps the log; writes fail once it is full.
+	MaxBytes int64 `yaml:"max_bytes"`
+}
+
+type Neo4jConfig struct {
+	URI            string        `yaml:"uri"`
+	Username       string        `yaml:"username"`
+	Password       string        `yaml:"password"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+}
+
+type CacheConfig struct {
+	// TTL is how long an edge stays in the dedup cache before it is
+	// written to storage again.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxEntries caps the dedup cache; 0 means unbounded.
+	MaxEntries int `yaml:"max_entries"`
+}
+
+type FilterConfig struct {
+	// HealthRoutePrefixes drop spans whose http.route starts with one of these.
+	HealthRoutePrefixes []string `yaml:"health_route_prefixes"`
+	// HealthOperationKeywords drop spans whose lower-cased operation name
+	// contains one of these.
+	HealthOperationKeywords []string `yaml:"health_operation_keywords"`
+	// IgnoreServices drop spans reported by these service names.
+	IgnoreServices []string `yaml:"ignore_services"`
+}
+
+type KubernetesConfig struct {
+	Mode       string `yaml:"mode"`
+	Kubeconfig string `yaml:"kubeconfig"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// TelemetryConfig controls the builder's traces of its own pipeline.
+type TelemetryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint is the OTLP gRPC host:port self-traces are exported to.
+	Endpoint string `yaml:"endpoint"`
+	Insecure bool   `yaml:"insecure"`
+	// SampleRatio is the fraction of Export calls traced.
+	SampleRatio float64 `yaml:"sample_ratio"`
+	// ServiceName is reported on self-traces; while they are enabled,
+	// spans carrying it are never added to the graph.
+	ServiceName string `yaml:"service_name"`
+}
+
+// RecordConfig makes the live server save incoming export requests for
+// offline replay.
+type RecordConfig struct {
+	// Dir receives one file per request; empty disables recording.
+	Dir string `yaml:"dir"`
+	// Format is protobuf or json.
+	Format string `yaml:"format"`
+}
+
+// TopologyConfig adds edges that don't come from traces.
+type TopologyConfig struct {
+	// DeclaredFiles are YAML service and edge declarations merged into
+	// the graph at startup with origin "declared".
+	DeclaredFiles []string `yaml:"declared_files"`
+	// Infer proposes edges from cluster state before traffic flows.
+	Infer InferConfig `yaml:"infer"`
+}
+
+// InferConfig controls the inference pass over NetworkPolicies, Service
+// selectors and workload env vars. Inferred edges have origin "inferred".
+type InferConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval is how often cluster state is re-read.
+	Interval time.Duration `yaml:"interval"`
+	// Namespaces limits inference; empty means all namespaces.
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// SamplingConfig sheds load on the Export path before spans are enriched.
+// Spans with an error status and spans for edges not in the dedup cache
+// are always kept.
+type SamplingConfig struct {
+	// Probability is the fraction of traces whose remaining spans are
+	// processed. The decision is made on the trace ID, so a trace is kept
+	// or dropped as a whole.
+	Probability float64 `yaml:"probability"`
+	// RatePerService is the spans per second admitted for each service
+	// after sampling; 0 means unlimited.
+	RatePerService float64 `yaml:"rate_per_service"`
+	// Burst is the token bucket size for each service.
+	Burst int `yaml:"burst"`
+	// ServiceRates overrides RatePerService for individual services.
+	ServiceRates map[string]float64 `yaml:"service_rates"`
+}
+
+// Enabled reports whether any span can be sampled out.
+func (s SamplingConfig) Enabled() bool {
+	return s.Probability < 1 || s.RatePerService > 0 || len(s.ServiceRates) > 0
+}
+
+// Span pipeline stages, in order.
+const (
+	StageFilter    = "filter"
+	StageEnrich    = "enrich"
+	StageAggregate = "aggregate"
+	StageK8s       = "k8s"
+	StageWrite     = "write"
+)
+
+// PipelineStages lists the span pipeline stages in order.
+var PipelineStages = []string{StageFilter, StageEnrich, StageAggregate, StageK8s, StageWrite}
+
+// PipelineConfig sizes the staged span pipeline behind Export. Spans of
+// the same edge are processed in order; different edges in parallel.
+type PipelineConfig struct {
+	// Workers is the number of workers for each stage.
+	Workers int `yaml:"workers"`
+	// StageWorkers overrides Workers for individual stages.
+	StageWorkers map[string]int `yaml:"stage_workers"`
+	// QueueSize is the capacity of each worker's queue.
+	QueueSize int `yaml:"queue_size"`
+}
+
+// WorkersFor returns the worker count for stage.
+func (p PipelineConfig) WorkersFor(stage string) int {
+	if n, ok := p.StageWorkers[stage]; ok {
+		return n
+	}
+	return p.Workers
+}
+
+// DLQConfig keeps spans whose Kubernetes lookup or storage write failed
+// on disk and retries them with exponential backoff.
+type DLQConfig struct {
+	// Dir holds one file per failed edge; empty disables the queue.
+	Dir string `yaml:"dir"`
+	// MaxEntries caps the queue, evicting the oldest; 0 means unbounded.
+	MaxEntries int `yaml:"max_entries"`
+	// InitialBackoff is the wait before the first retry, doubling up to
+	// MaxBackoff.
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// AnomalyConfig learns a baseline of observed edges and their call rates
+// and reports new, vanished and rerouted edges and rate shifts as graph
+// events.
+type AnomalyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Window is the interval calls are counted over and changes checked.
+	Window time.Duration `yaml:"window"`
+	// LearningPeriod is how long after startup nothing is reported while
+	// the baseline fills. Call rates are averaged over about this long.
+	LearningPeriod time.Duration `yaml:"learning_period"`
+	// VanishAfter is how long an established edge must go without calls
+	// before it is reported as vanished.
+	VanishAfter time.Duration `yaml:"vanish_after"`
+	// RateShiftFactor is how far an edge's call rate must rise or fall
+	// against its baseline to be reported.
+	RateShiftFactor float64 `yaml:"rate_shift_factor"`
+	// MinRate is the baseline calls per minute below which rate shifts
+	// are not reported, so quiet edges don't flap.
+	MinRate float64 `yaml:"min_rate"`
+	// Webhook receives every batch of events.
+	Webhook WebhookConfig `yaml:"webhook"`
+}
+
+// WebhookConfig is an HTTP endpoint events are POSTed to as JSON.
+type WebhookConfig struct {
+	// URL is the endpoint; empty disables the webhook.
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Headers are added to every request, e.g. Authorization.
+	Headers map[string]string `yaml:"headers"`
+	// Secret, if set, signs every request with HMAC-SHA256, see
+	// pkg/webhook.
+	Secret string `yaml:"secret"`
+}
+
+// AlertsConfig maps the labels of alerts received from Alertmanager onto
+// graph services.
+type AlertsConfig struct {
+	// ServiceLabels name the service an alert fires on; the first one
+	// set wins.
+	ServiceLabels []string `yaml:"service_labels"`
+	// NamespaceLabel scopes the pod lookup.
+	NamespaceLabel string `yaml:"namespace_label"`
+	// PodLabel names a pod, mapped to the services selecting it when no
+	// service label is set.
+	PodLabel string `yaml:"pod_label"`
+}
+
+// IncidentsConfig controls how received alerts are clustered into
+// incidents.
+type IncidentsConfig struct {
+	// Window is how far apart alerts may start and still be clustered.
+	Window time.Duration `yaml:"window"`
+	// MaxHops is how many calls apart two alerting services may be and
+	// still count as connected.
+	MaxHops int `yaml:"max_hops"`
+	// Retention is how long incidents are kept once no longer open.
+	Retention time.Duration `yaml:"retention"`
+	// Store is where incidents and their timelines are kept.
+	Store IncidentStoreConfig `yaml:"store"`
+	// StreamOrigins are the page origins, besides the builder's own,
+	// allowed to open incident WebSockets; "*" allows any.
+	StreamOrigins []string `yaml:"stream_origins"`
+}
+
+// Incident store backends.
+const (
+	IncidentStoreSQLite = "sqlite"
+	IncidentStoreMemory = "memory"
+)
+
+type IncidentStoreConfig struct {
+	Backend string `yaml:"backend"`
+	// Path is the SQLite database file, relative to data_dir unless
+	// absolute.
+	Path string `yaml:"path"`
+}
+
+// ContextConfig shapes the RCA context packs built for alerts.
+type ContextConfig struct {
+	// Depth is how many hops of neighbours a pack includes by default.
+	Depth int `yaml:"depth"`
+	// MaxDepth caps the depth a request can ask for.
+	MaxDepth int `yaml:"max_depth"`
+	// Window is how far back RED stats, changes and log samples reach.
+	// Per-edge RED stats are kept in memory this long.
+	Window time.Duration `yaml:"window"`
+	// LogSamples is the number of recent errors included per alerting
+	// service.
+	LogSamples int `yaml:"log_samples"`
+}
+
+// RCAConfig tunes the rule-based first pass of root cause analysis run
+// on incidents.
+type RCAConfig struct {
+	// Auto analyses an incident whenever an alert joins it.
+	Auto bool `yaml:"auto"`
+	// MinErrorRate is the error rate from which calls count as failing.
+	MinErrorRate float64 `yaml:"min_error_rate"`
+	// MinCalls is the fewest calls an error rate is judged on.
+	MinCalls int64 `yaml:"min_calls"`
+}
+
+// Notification sink types.
+const (
+	SinkWebhook = "webhook"
+	SinkSlack   = "slack"
+	SinkEmail   = "email"
+)
+
+// Incident events notifications are sent on.
+const (
+	NotifyOpened   = "opened"
+	NotifyResolved = "resolved"
+	NotifyClosed   = "closed"
+	// NotifyReport is sent when an incident gets its first RCA report,
+	// or one from another source or blaming another service.
+	NotifyReport = "report"
+)
+
+// Severities are the alert severities notifications are routed on,
+// lowest first. Others rank below info.
+var Severities = []string{"info", "warning", "error", "critical"}
+
+// DefaultSinkTimeout applies to sinks that don't set a timeout.
+const DefaultSinkTimeout = 10 * time.Second
+
+// NotificationsConfig sends incident events to webhooks, Slack and email.
+type NotificationsConfig struct {
+	// BaseURL is the frontend's address; notifications link to
+	// BaseURL/incidents/<id>. Empty leaves the link out.
+	BaseURL string       `yaml:"base_url"`
+	Sinks   []SinkConfig `yaml:"sinks"`
+}
+
+// SinkConfig is somewhere notifications are sent, and which.
+type SinkConfig struct {
+	// Name identifies the sink in logs and metrics.
+	Name string `yaml:"name"`
+	// Type is webhook, a JSON POST; slack, an incoming-webhook message;
+	// or email.
+	Type string `yaml:"type"`
+	// Webhook is where webhook and slack sinks POST to.
+	Webhook WebhookConfig `yaml:"webhook"`
+	// SMTP is the mail server email sinks send through.
+	SMTP SMTPConfig `yaml:"smtp"`
+	// Template is a text/template over the notification replacing the
+	// sink type's message: the body of a webhook, which must render
+	// JSON, the text of a Slack message or the body of an email.
+	Template string `yaml:"template"`
+	// Subject is a text/template for email subjects.
+	Subject string `yaml:"subject"`
+	// Events are the events sent; empty sends all.
+	Events []string `yaml:"events"`
+	// MinSeverity, one of Severities, holds back incidents whose alerts
+	// are all less severe.
+	MinSeverity string `yaml:"min_severity"`
+	// Namespaces holds back incidents on no service in one of them;
+	// empty sends incidents on any.
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// SMTPConfig is a mail server. STARTTLS is used when the server offers
+// it, and credentials are only sent over TLS or to localhost.
+type SMTPConfig struct {
+	// Address is the server's host:port.
+	Address  string        `yaml:"address"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	From     string        `yaml:"from"`
+	To       []string      `yaml:"to"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// SLOConfig evaluates the SLOs kept with the graph and alerts when they
+// burn their error budgets too fast.
+type SLOConfig struct {
+	// Interval is how often SLOs are evaluated.
+	Interval time.Duration `yaml:"interval"`
+	// Alerts are multi-window burn-rate alerts. Each fires while the
+	// burn rate over both its windows is at least its factor, and feeds
+	// incidents like an Alertmanager alert.
+	Alerts []BurnAlertConfig `yaml:"alerts"`
+	// Queries are the PromQL of SLOs sourced from Prometheus.
+	Queries SLOQueries `yaml:"queries"`
+}
+
+// BurnAlertConfig is a burn-rate alert. The long window makes it
+// significant, the short one makes it reset soon after the burning
+// stops.
+type BurnAlertConfig struct {
+	// Name identifies the alert, e.g. page or ticket.
+	Name string `yaml:"name"`
+	// Severity is one of Severities.
+	Severity    string        `yaml:"severity"`
+	LongWindow  time.Duration `yaml:"long_window"`
+	ShortWindow time.Duration `yaml:"short_window"`
+	// Factor is the burn rate it fires from: 14.4 over 1h spends 2% of
+	// a 30-day budget.
+	Factor float64 `yaml:"factor"`
+}
+
+// SLOQueries are text/template PromQL expressions returning the share
+// of bad calls over .Window. They get .Selector and .ServiceSelector as
+// in PrometheusQueries; SlowRatio also gets .ThresholdSelector, .Selector
+// with an le matcher on the SLO's threshold in seconds, which must be a
+// bucket bound of the histogram.
+type SLOQueries struct {
+	// ErrorRatio is the share of failed calls, for availability SLOs.
+	ErrorRatio string `yaml:"error_ratio"`
+	// SlowRatio is the share of calls slower than the threshold, for
+	// latency SLOs.
+	SlowRatio string `yaml:"slow_ratio"`
+}
+
+// PrometheusConfig points the builder at the Prometheus HTTP API the
+// services' metrics are scraped into, to snapshot them onto the graph.
+type PrometheusConfig struct {
+	// URL is the server's base URL; empty disables metric snapshots.
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Headers are added to every request, e.g. Authorization.
+	Headers map[string]string `yaml:"headers"`
+	// Window is the range rates are taken over, unless a request asks
+	// for another.
+	Window time.Duration `yaml:"window"`
+	// Interval is how often every service and edge in the graph is
+	// snapshotted; 0 snapshots only on request.
+	Interval time.Duration `yaml:"interval"`
+	// ServiceLabel, EndpointLabel and MethodLabel are the metric labels
+	// selecting a service and, for an edge, the callee's endpoint.
+	ServiceLabel  string `yaml:"service_label"`
+	EndpointLabel string `yaml:"endpoint_label"`
+	MethodLabel   string `yaml:"method_label"`
+	// Queries are text/template PromQL expressions, see
+	// PrometheusQueries.
+	Queries PrometheusQueries `yaml:"queries"`
+}
+
+// PrometheusQueries are the PromQL expressions of a snapshot, each
+// returning a single value. They are templates over .Selector, the label
+// matchers of the service or edge in braces, .ServiceSelector, the same
+// for the service alone, and .Window, the rate range.
+type PrometheusQueries struct {
+	// RequestRate is in requests per second.
+	RequestRate string `yaml:"request_rate"`
+	// ErrorRate is in errors per request.
+	ErrorRate string `yaml:"error_rate"`
+	// LatencyP95 is in seconds.
+	LatencyP95 string `yaml:"latency_p95"`
+}
+
+// Default returns the configuration the builder shipped with before it
+// was configurable.
+func Default() *Config {
+	cfg := defaults()
+	cfg.applyDefaults()
+	return cfg
+}
+
+// defaults is Default without the defaults that depend on other
+// settings, which applyDefaults fills in once they are known.
+func defaults() *Config {
+	return &Config{
+		Server: ServerConfig{
+			OTLPAddress: "0.0.0.0:8083",
+			TLS: TLSConfig{
+				ClientAuth:     ClientAuthNone,
+				ReloadInterval: 30 * time.Second,
+			},
+			AdminTLS: TLSConfig{
+				ClientAuth:     ClientAuthNone,
+				ReloadInterval: 30 * time.Second,
+			},
+			ShutdownTimeout: 25 * time.Second,
+		},
+		Storage: StorageConfig{
+			Backend: BackendNeo4j,
+			Neo4j: Neo4jConfig{
+				URI:            "bolt://host.docker.internal:7687",
+				Username:       "neo4j",
+				Password:       "password",
+				ConnectTimeout: 5 * time.Second,
+			},
+			Reconnect: ReconnectConfig{
+				InitialBackoff: time.Second,
+				MaxBackoff:     time.Minute,
+			},
+			Spool: SpoolConfig{
+				MaxBytes: 256 << 20,
+			},
+		},
+		Cache: CacheConfig{
+			TTL: 10 * time.Minute,
+		},
+		Filter: FilterConfig{
+			HealthRoutePrefixes:     []string{"/health", "/live", "/ready"},
+			HealthOperationKeywords: []string{"health", "live", "ready"},
+		},
+		Kubernetes: KubernetesConfig{
+			Mode: K8sModeAuto,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "console",
+		},
+		Telemetry: TelemetryConfig{
+			Endpoint:    "localhost:4317",
+			Insecure:    true,
+			SampleRatio: 1,
+			ServiceName: "servicegraph-builder",
+		},
+		Record: RecordConfig{
+			Format: "protobuf",
+		},
+		Topology: TopologyConfig{
+			Infer: InferConfig{
+				Interval: 10 * time.Minute,
+			},
+		},
+		Sampling: SamplingConfig{
+			Probability: 1,
+			Burst:       100,
+		},
+		Pipeline: PipelineConfig{
+			Workers:   4,
+			QueueSize: 256,
+		},
+		DLQ: DLQConfig{
+			MaxEntries:     10000,
+			InitialBackoff: 10 * time.Second,
+			MaxBackoff:     10 * time.Minute,
+		},
+		Anomaly: AnomalyConfig{
+			Window:          time.Minute,
+			LearningPeriod:  30 * time.Minute,
+			VanishAfter:     15 * time.Minute,
+			RateShiftFactor: 3,
+			MinRate:         10,
+			Webhook: WebhookConfig{
+				Timeout: 5 * time.Second,
+			},
+		},
+		Alerts: AlertsConfig{
+			ServiceLabels:  []string{"service"},
+			NamespaceLabel: "namespace",
+			PodLabel:       "pod",
+		},
+		Context: ContextConfig{
+			Depth:      2,
+			MaxDepth:   5,
+			Window:     15 * time.Minute,
+			LogSamples: 5,
+		},
+		Incidents: IncidentsConfig{
+			Window:    10 * time.Minute,
+			MaxHops:   3,
+			Retention: 30 * 24 * time.Hour,
+			Store: IncidentStoreConfig{
+				Backend: IncidentStoreSQLite,
+				Path:    "incidents.db",
+			},
+		},
+		RCA: RCAConfig{
+			Auto:         true,
+			MinErrorRate: 0.05,
+			MinCalls:     10,
+		},
+		SLO: SLOConfig{
+			Interval: time.Minute,
+			// The multiwindow, multi-burn-rate alerts of the Google SRE
+			// workbook, for 30-day windows
+			Alerts: []BurnAlertConfig{
+				{Name: "page", Severity: "critical", LongWindow: time.Hour, ShortWindow: 5 * time.Minute, Factor: 14.4},
+				{Name: "ticket", Severity: "warning", LongWindow: 6 * time.Hour, ShortWindow: 30 * time.Minute, Factor: 6},
+			},
+			Queries: SLOQueries{
+				ErrorRatio: `sum(rate(errors_total{{.ServiceSelector}}[{{.Window}}])) / sum(rate(api_requests_total{{.Selector}}[{{.Window}}]))`,
+				SlowRatio:  `1 - sum(rate(api_request_latency_seconds_bucket{{.ThresholdSelector}}[{{.Window}}])) / sum(rate(api_request_latency_seconds_count{{.Selector}}[{{.Window}}]))`,
+			},
+		},
+		Prometheus: PrometheusConfig{
+			Timeout:       10 * time.Second,
+			Window:        5 * time.Minute,
+			ServiceLabel:  "service",
+			EndpointLabel: "endpoint",
+			MethodLabel:   "method",
+			// The metrics pkg/metrics in simple-microservices exports;
+			// errors_total has no endpoint label
+			Queries: PrometheusQueries{
+				RequestRate: `sum(rate(api_requests_total{{.Selector}}[{{.Window}}]))`,
+				ErrorRate:   `sum(rate(errors_total{{.ServiceSelector}}[{{.Window}}])) / sum(rate(api_requests_total{{.Selector}}[{{.Window}}]))`,
+				LatencyP95:  `histogram_quantile(0.95, sum(rate(api_request_latency_seconds_bucket{{.Selector}}[{{.Window}}])) by (le))`,
+			},
+		},
+		DataDir: "data",
+	}
+}
+
+// applyDefaults fills in settings left unset whose defaults depend on
+// others.
+func (cfg *Config) applyDefaults() {
+	if cfg.Server.AdminAddress == "" {
+		// Without a token, anyone reaching the port could read the graph
+		// and open or close incidents
+		cfg.Server.AdminAddress = "127.0.0.1:8084"
+		if cfg.Server.AdminAuth.Enabled() {
+			cfg.Server.AdminAddress = "0.0.0.0:8084"
+		}
+	}
+	for i, s := range cfg.Notifications.Sinks {
+		if (s.Type == SinkWebhook || s.Type == SinkSlack) && s.Webhook.Timeout == 0 {
+			cfg.Notifications.Sinks[i].Webhook.Timeout = DefaultSinkTimeout
+		}
+	}
+}
+
+// LoadFile overlays the YAML file at path onto cfg. Unknown keys are
+// rejected so typos don't silently fall back to defaults.
+func (cfg *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv overlays environment variables onto cfg. The NEO4J_* and
+// KUBECONFIG variables are honoured for compatibility with existing
+// deployments.
+func (cfg *Config) ApplyEnv() error {
+	setString(&cfg.Server.OTLPAddress, "SERVICEGRAPH_OTLP_ADDRESS")
+	setString(&cfg.Server.AdminAddress, "SERVICEGRAPH_ADMIN_ADDRESS")
+	setString(&cfg.Server.TLS.CertFile, "SERVICEGRAPH_TLS_CERT_FILE")
+	setString(&cfg.Server.TLS.KeyFile, "SERVICEGRAPH_TLS_KEY_FILE")
+	setString(&cfg.Server.TLS.ClientCAFile, "SERVICEGRAPH_TLS_CLIENT_CA_FILE")
+	setString(&cfg.Server.TLS.ClientAuth, "SERVICEGRAPH_TLS_CLIENT_AUTH")
+	setString(&cfg.Server.Auth.BearerTokenFile, "SERVICEGRAPH_BEARER_TOKEN_FILE")
+	setString(&cfg.Server.AdminTLS.CertFile, "SERVICEGRAPH_ADMIN_TLS_CERT_FILE")
+	setString(&cfg.Server.AdminTLS.KeyFile, "SERVICEGRAPH_ADMIN_TLS_KEY_FILE")
+	setString(&cfg.Server.AdminAuth.BearerTokenFile, "SERVICEGRAPH_ADMIN_BEARER_TOKEN_FILE")
+	setString(&cfg.Storage.Backend, "SERVICEGRAPH_STORAGE_BACKEND")
+	setString(&cfg.Storage.Neo4j.URI, "NEO4J_URI")
+	setString(&cfg.Storage.Neo4j.Username, "NEO4J_USERNAME")
+	setString(&cfg.Storage.Neo4j.Password, "NEO4J_PASSWORD")
+	setString(&cfg.Kubernetes.Mode, "SERVICEGRAPH_K8S_MODE")
+	setString(&cfg.Kubernetes.Kubeconfig, "KUBECONFIG")
+	setString(&cfg.Log.Level, "SERVICEGRAPH_LOG_LEVEL")
+	setString(&cfg.Log.Format, "SERVICEGRAPH_LOG_FORMAT")
+
+	if v, ok := lookupEnv("SERVICEGRAPH_TLS_ENABLED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_TLS_ENABLED: %w", err)
+		}
+		cfg.Server.TLS.Enabled = b
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_ADMIN_TLS_ENABLED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_ADMIN_TLS_ENABLED: %w", err)
+		}
+		cfg.Server.AdminTLS.Enabled = b
+	}
+	setString(&cfg.Telemetry.Endpoint, "SERVICEGRAPH_TELEMETRY_ENDPOINT")
+	setString(&cfg.Record.Dir, "SERVICEGRAPH_RECORD_DIR")
+	setString(&cfg.DLQ.Dir, "SERVICEGRAPH_DLQ_DIR")
+	setString(&cfg.Storage.Spool.Dir, "SERVICEGRAPH_SPOOL_DIR")
+	if v, ok := lookupEnv("SERVICEGRAPH_TOPOLOGY_FILES"); ok {
+		cfg.Topology.DeclaredFiles = SplitList(v)
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_TOPOLOGY_INFER"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_TOPOLOGY_INFER: %w", err)
+		}
+		cfg.Topology.Infer.Enabled = b
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_SAMPLING_PROBABILITY"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_SAMPLING_PROBABILITY: %w", err)
+		}
+		cfg.Sampling.Probability = f
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_SAMPLING_RATE_PER_SERVICE"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_SAMPLING_RATE_PER_SERVICE: %w", err)
+		}
+		cfg.Sampling.RatePerService = f
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_PIPELINE_WORKERS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_PIPELINE_WORKERS: %w", err)
+		}
+		cfg.Pipeline.Workers = n
+	}
+	setString(&cfg.Anomaly.Webhook.URL, "SERVICEGRAPH_ANOMALY_WEBHOOK_URL")
+	if v, ok := lookupEnv("SERVICEGRAPH_ANOMALY_ENABLED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_ANOMALY_ENABLED: %w", err)
+		}
+		cfg.Anomaly.Enabled = b
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_ALERT_SERVICE_LABELS"); ok {
+		cfg.Alerts.ServiceLabels = SplitList(v)
+	}
+	setString(&cfg.Prometheus.URL, "SERVICEGRAPH_PROMETHEUS_URL")
+	setString(&cfg.Notifications.BaseURL, "SERVICEGRAPH_NOTIFY_BASE_URL")
+	setString(&cfg.Incidents.Store.Backend, "SERVICEGRAPH_INCIDENT_STORE")
+	setString(&cfg.Incidents.Store.Path, "SERVICEGRAPH_INCIDENT_DB")
+	setString(&cfg.DataDir, "SERVICEGRAPH_DATA_DIR")
+	if v, ok := lookupEnv("SERVICEGRAPH_INCIDENT_STREAM_ORIGINS"); ok {
+		cfg.Incidents.StreamOrigins = SplitList(v)
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_RCA_AUTO"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_RCA_AUTO: %w", err)
+		}
+		cfg.RCA.Auto = b
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_TELEMETRY_ENABLED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_TELEMETRY_ENABLED: %w", err)
+		}
+		cfg.Telemetry.Enabled = b
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_CACHE_TTL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_CACHE_TTL: %w", err)
+		}
+		cfg.Cache.TTL = d
+	}
+	if v, ok := lookupEnv("SERVICEGRAPH_CACHE_MAX_ENTRIES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("SERVICEGRAPH_CACHE_MAX_ENTRIES: %w", err)
+		}
+		cfg.Cache.MaxEntries = n
+	}
+	return nil
+}
+
+// DataPath resolves path against DataDir, unless it is absolute.
+func (cfg *Config) DataPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(cfg.DataDir, path)
+}
+
+// Validate checks that the configuration is usable.
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(cfg.Server.OTLPAddress); err != nil {
+		errs = append(errs, fmt.Errorf("server.otlp_address: %w", err))
+	}
+	if _, _, err := net.SplitHostPort(cfg.Server.AdminAddress); err != nil {
+		errs = append(errs, fmt.Errorf("server.admin_address: %w", err))
+	}
+	if err := cfg.Server.TLS.validate("server.tls"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.Server.AdminTLS.validate("server.admin_tls"); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout must be positive"))
+	}
+
+	switch cfg.Storage.Backend {
+	case BackendNeo4j:
+		if cfg.Storage.Neo4j.URI == "" {
+			errs = append(errs, errors.New("storage.neo4j.uri is required"))
+		}
+		if cfg.Storage.Neo4j.ConnectTimeout <= 0 {
+			errs = append(errs, errors.New("storage.neo4j.connect_timeout must be positive"))
+		}
+	case BackendMemory:
+	default:
+		errs = append(errs, fmt.Errorf("storage.backend: unknown backend %q", cfg.Storage.Backend))
+	}
+
+	if r := cfg.Storage.Reconnect; r.InitialBackoff <= 0 || r.MaxBackoff < r.InitialBackoff {
+		errs = append(errs, errors.New("storage.reconnect: initial_backoff must be positive and max_backoff at least as long"))
+	}
+	if cfg.Storage.Spool.MaxBytes < 0 {
+		errs = append(errs, errors.New("storage.spool.max_bytes must not be negative"))
+	}
+
+	if cfg.Cache.TTL < time.Second {
+		errs = append(errs, errors.New("cache.ttl must be at least 1s"))
+	}
+	if cfg.Cache.MaxEntries < 0 {
+		errs = append(errs, errors.New("cache.max_entries must not be negative"))
+	}
+
+	switch cfg.Kubernetes.Mode {
+	case K8sModeAuto, K8sModeInCluster, K8sModeKubeconfig, K8sModeDisabled:
+	default:
+		errs = append(errs, fmt.Errorf("kubernetes.mode: unknown mode %q", cfg.Kubernetes.Mode))
+	}
+
+	if _, err := zerolog.ParseLevel(cfg.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	if cfg.Log.Format != "console" && cfg.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format: must be console or json, got %q", cfg.Log.Format))
+	}
+
+	if cfg.Record.Format != "protobuf" && cfg.Record.Format != "json" {
+		errs = append(errs, fmt.Errorf("record.format: must be protobuf or json, got %q", cfg.Record.Format))
+	}
+
+	if inf := cfg.Topology.Infer; inf.Enabled {
+		if inf.Interval < time.Minute {
+			errs = append(errs, errors.New("topology.infer.interval must be at least 1m"))
+		}
+		if cfg.Kubernetes.Mode == K8sModeDisabled {
+			errs = append(errs, errors.New("topology.infer needs kubernetes.mode other than disabled"))
+		}
+	}
+
+	if smp := cfg.Sampling; smp.Probability < 0 || smp.Probability > 1 {
+		errs = append(errs, errors.New("sampling.probability must be between 0 and 1"))
+	}
+	if cfg.Sampling.RatePerService < 0 {
+		errs = append(errs, errors.New("sampling.rate_per_service must not be negative"))
+	}
+	for name, r := range cfg.Sampling.ServiceRates {
+		if r < 0 {
+			errs = append(errs, fmt.Errorf("sampling.service_rates.%s must not be negative", name))
+		}
+	}
+	if cfg.Sampling.Burst < 1 {
+		errs = append(errs, errors.New("sampling.burst must be at least 1"))
+	}
+
+	if cfg.Pipeline.Workers < 1 {
+		errs = append(errs, errors.New("pipeline.workers must be at least 1"))
+	}
+	for stage, n := range cfg.Pipeline.StageWorkers {
+		if !slices.Contains(PipelineStages, stage) {
+			errs = append(errs, fmt.Errorf("pipeline.stage_workers: unknown stage %q (want one of %s)", stage, strings.Join(PipelineStages, ", ")))
+		} else if n < 1 {
+			errs = append(errs, fmt.Errorf("pipeline.stage_workers.%s must be at least 1", stage))
+		}
+	}
+	if cfg.Pipeline.QueueSize < 1 {
+		errs = append(errs, errors.New("pipeline.queue_size must be at least 1"))
+	}
+
+	if d := cfg.DLQ; d.Dir != "" {
+		if d.MaxEntries < 0 {
+			errs = append(errs, errors.New("dlq.max_entries must not be negative"))
+		}
+		if d.InitialBackoff < time.Second {
+			errs = append(errs, errors.New("dlq.initial_backoff must be at least 1s"))
+		}
+		if d.MaxBackoff < d.InitialBackoff {
+			errs = append(errs, errors.New("dlq.max_backoff must not be less than dlq.initial_backoff"))
+		}
+	}
+
+	if a := cfg.Anomaly; a.Enabled {
+		if a.Window < 10*time.Second {
+			errs = append(errs, errors.New("anomaly.window must be at least 10s"))
+		}
+		if a.LearningPeriod < a.Window {
+			errs = append(errs, errors.New("anomaly.learning_period must not be less than anomaly.window"))
+		}
+		if a.VanishAfter < a.Window {
+			errs = append(errs, errors.New("anomaly.vanish_after must not be less than anomaly.window"))
+		}
+		if a.RateShiftFactor <= 1 {
+			errs = append(errs, errors.New("anomaly.rate_shift_factor must be greater than 1"))
+		}
+		if a.MinRate < 0 {
+			errs = append(errs, errors.New("anomaly.min_rate must not be negative"))
+		}
+		if err := a.Webhook.validate("anomaly.webhook"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c := cfg.Context; c.Depth < 0 || c.MaxDepth < c.Depth {
+		errs = append(errs, errors.New("context: depth must not be negative and max_depth at least depth"))
+	}
+	if cfg.Context.Window < time.Minute {
+		errs = append(errs, errors.New("context.window must be at least 1m"))
+	}
+	if n := cfg.Context.LogSamples; n < 0 || n > 20 {
+		errs = append(errs, errors.New("context.log_samples must be between 0 and 20"))
+	}
+
+	if c := cfg.Incidents; c.Window <= 0 || c.Retention <= 0 {
+		errs = append(errs, errors.New("incidents: window and retention must be positive"))
+	}
+	if cfg.Incidents.MaxHops < 1 {
+		errs = append(errs, errors.New("incidents.max_hops must be at least 1"))
+	}
+	switch s := cfg.Incidents.Store; s.Backend {
+	case IncidentStoreMemory:
+	case IncidentStoreSQLite:
+		if s.Path == "" {
+			errs = append(errs, errors.New("incidents.store.path is required for sqlite"))
+		} else if cfg.DataDir == "" && !filepath.IsAbs(s.Path) {
+			errs = append(errs, errors.New("data_dir is required for a relative incidents.store.path"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("incidents.store.backend: unknown backend %q (want sqlite or memory)", s.Backend))
+	}
+
+	if r := cfg.RCA.MinErrorRate; r <= 0 || r > 1 {
+		errs = append(errs, errors.New("rca.min_error_rate must be above 0 and at most 1"))
+	}
+	if cfg.RCA.MinCalls < 1 {
+		errs = append(errs, errors.New("rca.min_calls must be at least 1"))
+	}
+
+	if err := cfg.Prometheus.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.Notifications.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.SLO.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if t := cfg.Telemetry; t.Enabled {
+		if _, _, err := net.SplitHostPort(t.Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("telemetry.endpoint: %w", err))
+		}
+		if t.SampleRatio < 0 || t.SampleRatio > 1 {
+			errs = append(errs, errors.New("telemetry.sample_ratio must be between 0 and 1"))
+		}
+		if t.ServiceName == "" {
+			errs = append(errs, errors.New("telemetry.service_name is required"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of cfg with secrets masked, suitable for printing.
+func (cfg *Config) Redacted() *Config {
+	out := *cfg
+	if out.Storage.Neo4j.Password != "" {
+		out.Storage.Neo4j.Password = "<redacted>"
+	}
+	if len(out.Server.Auth.BearerTokens) > 0 {
+		out.Server.Auth.BearerTokens = []string{"<redacted>"}
+	}
+	if len(out.Server.AdminAuth.BearerTokens) > 0 {
+		out.Server.AdminAuth.BearerTokens = []string{"<redacted>"}
+	}
+	out.Anomaly.Webhook = out.Anomaly.Webhook.redacted()
+	out.Prometheus.Headers = redactHeaders(out.Prometheus.Headers)
+	out.Notifications.Sinks = slices.Clone(out.Notifications.Sinks)
+	for i, s := range out.Notifications.Sinks {
+		s.Webhook = s.Webhook.redacted()
+		if s.SMTP.Password != "" {
+			s.SMTP.Password = "<redacted>"
+		}
+		out.Notifications.Sinks[i] = s
+	}
+	return &out
+}
+
+// validate checks a listener's TLS settings; key names them in errors.
+func (t TLSConfig) validate(key string) error {
+	var errs []error
+	if t.Enabled {
+		if t.CertFile == "" || t.KeyFile == "" {
+			errs = append(errs, fmt.Errorf("%s: cert_file and key_file are required when enabled", key))
+		}
+		switch t.ClientAuth {
+		case ClientAuthNone:
+		case ClientAuthRequest, ClientAuthRequire:
+			if t.ClientCAFile == "" {
+				errs = append(errs, fmt.Errorf("%s: client_ca_file is required for client_auth %q", key, t.ClientAuth))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s.client_auth: unknown policy %q", key, t.ClientAuth))
+		}
+	}
+	if t.ReloadInterval <= 0 {
+		errs = append(errs, fmt.Errorf("%s.reload_interval must be positive", key))
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks a webhook, if one is set; prefix names it in errors.
+func (w WebhookConfig) validate(prefix string) error {
+	if w.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("%s.url: %w", prefix, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s.url: must be an http or https URL, got %q", prefix, w.URL)
+	}
+	if w.Timeout <= 0 {
+		return fmt.Errorf("%s.timeout must be positive", prefix)
+	}
+	return nil
+}
+
+func (w WebhookConfig) redacted() WebhookConfig {
+	w.Headers = redactHeaders(w.Headers)
+	if w.Secret != "" {
+		w.Secret = "<redacted>"
+	}
+	return w
+}
+
+// redactHeaders masks header values, which usually carry credentials.
+func redactHeaders(h map[string]string) map[string]string {
+	if len(h) == 0 {
+		return h
+	}
+	out := make(map[string]string, len(h))
+	for k := range h {
+		out[k] = "<redacted>"
+	}
+	return out
+}
+
+func (p PrometheusConfig) validate() error {
+	if p.URL == "" {
+		return nil
+	}
+	var errs []error
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("prometheus.url: %w", err))
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("prometheus.url: must be an http or https URL, got %q", p.URL))
+	}
+	if p.Timeout <= 0 {
+		errs = append(errs, errors.New("prometheus.timeout must be positive"))
+	}
+	if p.Window < 30*time.Second {
+		errs = append(errs, errors.New("prometheus.window must be at least 30s"))
+	}
+	if p.Interval != 0 && p.Interval < 10*time.Second {
+		errs = append(errs, errors.New("prometheus.interval must be 0 or at least 10s"))
+	}
+	if p.ServiceLabel == "" {
+		errs = append(errs, errors.New("prometheus.service_label is required"))
+	}
+	for name, q := range map[string]string{
+		"request_rate": p.Queries.RequestRate,
+		"error_rate":   p.Queries.ErrorRate,
+		"latency_p95":  p.Queries.LatencyP95,
+	} {
+		if q == "" {
+			continue
+		}
+		if _, err := template.New(name).Option("missingkey=error").Parse(q); err != nil {
+			errs = append(errs, fmt.Errorf("prometheus.queries.%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n NotificationsConfig) validate() error {
+	var errs []error
+	if n.BaseURL != "" {
+		if u, err := url.Parse(n.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("notifications.base_url: must be an http or https URL, got %q", n.BaseURL))
+		}
+	}
+	names := make(map[string]bool)
+	for i, s := range n.Sinks {
+		prefix := fmt.Sprintf("notifications.sinks[%d]", i)
+		if s.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name is required", prefix))
+		} else if names[s.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate sink %q", prefix, s.Name))
+		}
+		names[s.Name] = true
+		switch s.Type {
+		case SinkWebhook, SinkSlack:
+			w := s.Webhook
+			if w.URL == "" {
+				errs = append(errs, fmt.Errorf("%s.webhook.url is required for %s sinks", prefix, s.Type))
+			} else if err := w.validate(prefix + ".webhook"); err != nil {
+				errs = append(errs, err)
+			}
+		case SinkEmail:
+			m := s.SMTP
+			if _, _, err := net.SplitHostPort(m.Address); err != nil {
+				errs = append(errs, fmt.Errorf("%s.smtp.address: %w", prefix, err))
+			}
+			if m.From == "" || len(m.To) == 0 {
+				errs = append(errs, fmt.Errorf("%s.smtp: from and to are required", prefix))
+			}
+			if m.Timeout < 0 {
+				errs = append(errs, fmt.Errorf("%s.smtp.timeout must not be negative", prefix))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s.type: unknown sink type %q (want webhook, slack or email)", prefix, s.Type))
+		}
+		for _, ev := range s.Events {
+			switch ev {
+			case NotifyOpened, NotifyResolved, NotifyClosed, NotifyReport:
+			default:
+				errs = append(errs, fmt.Errorf("%s.events: unknown event %q", prefix, ev))
+			}
+		}
+		if s.MinSeverity != "" && !slices.Contains(Severities, s.MinSeverity) {
+			errs = append(errs, fmt.Errorf("%s.min_severity: must be one of %s", prefix, strings.Join(Severities, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c SLOConfig) validate() error {
+	var errs []error
+	if c.Interval < 10*time.Second {
+		errs = append(errs, errors.New("slo.interval must be at least 10s"))
+	}
+	names := make(map[string]bool)
+	for i, a := range c.Alerts {
+		prefix := fmt.Sprintf("slo.alerts[%d]", i)
+		if a.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name is required", prefix))
+		} else if names[a.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate alert %q", prefix, a.Name))
+		}
+		names[a.Name] = true
+		if !slices.Contains(Severities, a.Severity) {
+			errs = append(errs, fmt.Errorf("%s.severity: must be one of %s", prefix, strings.Join(Severities, ", ")))
+		}
+		if a.ShortWindow < time.Minute || a.LongWindow <= a.ShortWindow {
+			errs = append(errs, fmt.Errorf("%s: short_window must be at least 1m and long_window longer", prefix))
+		}
+		if a.LongWindow > 7*24*time.Hour {
+			errs = append(errs, fmt.Errorf("%s.long_window must be at most 7d", prefix))
+		}
+		if a.Factor <= 0 {
+			errs = append(errs, fmt.Errorf("%s.factor must be positive", prefix))
+		}
+	}
+	for name, q := range map[string]string{
+		"error_ratio": c.Queries.ErrorRatio,
+		"slow_ratio":  c.Queries.SlowRatio,
+	} {
+		if _, err := template.New(name).Option("missingkey=error").Parse(q); err != nil {
+			errs = append(errs, fmt.Errorf("slo.queries.%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// YAML renders cfg as YAML.
+func (cfg *Config) YAML() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// lookupEnv returns the env var if it is set and non-empty.
+func lookupEnv(key string) (string, bool) {
+	v, ok := os.LookupEnv(key)
+	v = strings.TrimSpace(v)
+	return v, ok && v != ""
+}
+
+// SplitList splits a comma-separated list, dropping empty items.
+func SplitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func setString(dst *string, key string) {
+	if v, ok := lookupEnv(key); ok {
+		*dst = v
+	}
+}
@@ -0,0 +1,396 @@
+package config_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+)
+
+// load loads the config from a file holding yaml, with env set and the
+// flags args.
+func load(t *testing.T, yaml string, env map[string]string, args ...string) (*config.Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := config.RegisterFlags(fs)
+	if err := fs.Parse(append([]string{"-config", path}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return f.Load()
+}
+
+func TestLoad(t *testing.T) {
+	const yaml = `
+server:
+  otlp_address: 127.0.0.1:1
+log:
+  level: warn
+  format: json
+sampling:
+  probability: 0.5
+cache:
+  ttl: 1m
+`
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		args []string
+		got  func(*config.Config) any
+		want any
+	}{
+		{"default", nil, nil, func(c *config.Config) any { return c.Pipeline.Workers }, 4},
+		{"file over default", nil, nil, func(c *config.Config) any { return c.Cache.TTL }, time.Minute},
+		{"env over file", map[string]string{"SERVICEGRAPH_LOG_LEVEL": "debug"}, nil,
+			func(c *config.Config) any { return c.Log.Level }, "debug"},
+		{"flag over file", nil, []string{"-log-format", "console"},
+			func(c *config.Config) any { return c.Log.Format }, "console"},
+		{"flag over env", map[string]string{"SERVICEGRAPH_OTLP_ADDRESS": "127.0.0.1:2"}, []string{"-otlp-address", "127.0.0.1:3"},
+			func(c *config.Config) any { return c.Server.OTLPAddress }, "127.0.0.1:3"},
+		// A flag left unset doesn't override the file with its default
+		{"unset flag", nil, nil, func(c *config.Config) any { return c.Sampling.Probability }, 0.5},
+		{"flag set to its default", nil, []string{"-sampling-probability", "1"},
+			func(c *config.Config) any { return c.Sampling.Probability }, 1.0},
+		{"env list", map[string]string{"SERVICEGRAPH_TOPOLOGY_FILES": "a.yaml, ,b.yaml"}, nil,
+			func(c *config.Config) any { return c.Topology.DeclaredFiles }, []string{"a.yaml", "b.yaml"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := load(t, yaml, tc.env, tc.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tc.got(cfg); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		yaml string
+		env  map[string]string
+		want string
+	}{
+		{"unknown key", "log:\n  levle: debug\n", nil, "field levle not found"},
+		{"bad env", "", map[string]string{"SERVICEGRAPH_ANOMALY_ENABLED": "maybe"}, "SERVICEGRAPH_ANOMALY_ENABLED"},
+		{"invalid", "log:\n  format: text\n", nil, "log.format"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := load(t, tc.yaml, tc.env)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %v, want one mentioning %s", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		yaml string
+		got  func(*config.Config) any
+		want any
+	}{
+		{"admin on loopback without a token", "",
+			func(c *config.Config) any { return c.Server.AdminAddress }, "127.0.0.1:8084"},
+		{"admin on every interface with a token", "server:\n  admin_auth:\n    bearer_tokens: [t0ken]\n",
+			func(c *config.Config) any { return c.Server.AdminAddress }, "0.0.0.0:8084"},
+		{"admin address set", "server:\n  admin_address: 0.0.0.0:9000\n",
+			func(c *config.Config) any { return c.Server.AdminAddress }, "0.0.0.0:9000"},
+		{"sink timeout", "notifications:\n  sinks:\n  - {name: chat, type: slack, webhook: {url: https://hooks.example.com}}\n",
+			func(c *config.Config) any { return c.Notifications.Sinks[0].Webhook.Timeout }, config.DefaultSinkTimeout},
+		{"sink timeout set", "notifications:\n  sinks:\n  - {name: hook, type: webhook, webhook: {url: https://example.com, timeout: 3s}}\n",
+			func(c *config.Config) any { return c.Notifications.Sinks[0].Webhook.Timeout }, 3 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := load(t, tc.yaml, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tc.got(cfg); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func hookSink(name string) config.SinkConfig {
+	return config.SinkConfig{Name: name, Type: config.SinkWebhook,
+		Webhook: config.WebhookConfig{URL: "https://example.com", Timeout: time.Second}}
+}
+
+func mailSink() config.SinkConfig {
+	return config.SinkConfig{Name: "mail", Type: config.SinkEmail, SMTP: config.SMTPConfig{
+		Address: "smtp.example.com:25", From: "graph@example.com", To: []string{"oncall@example.com"}}}
+}
+
+func TestValidate(t *testing.T) {
+	if err := config.Default().Validate(); err != nil {
+		t.Fatalf("default config invalid: %v", err)
+	}
+	tls := func(c *config.TLSConfig) {
+		c.Enabled, c.CertFile, c.KeyFile = true, "tls.crt", "tls.key"
+	}
+	anomaly := func(c *config.Config) *config.AnomalyConfig {
+		c.Anomaly.Enabled = true
+		return &c.Anomaly
+	}
+	prom := func(c *config.Config) *config.PrometheusConfig {
+		c.Prometheus.URL = "http://prometheus:9090"
+		return &c.Prometheus
+	}
+	telemetry := func(c *config.Config) *config.TelemetryConfig {
+		c.Telemetry.Enabled = true
+		return &c.Telemetry
+	}
+	for _, tc := range []struct {
+		name   string
+		mutate func(*config.Config)
+		want   string
+	}{
+		{"otlp address", func(c *config.Config) { c.Server.OTLPAddress = "8083" }, "server.otlp_address: "},
+		{"admin address", func(c *config.Config) { c.Server.AdminAddress = "8084" }, "server.admin_address: "},
+		{"tls without a certificate", func(c *config.Config) { c.Server.TLS.Enabled = true },
+			"server.tls: cert_file and key_file are required when enabled"},
+		{"client auth without a CA", func(c *config.Config) {
+			tls(&c.Server.TLS)
+			c.Server.TLS.ClientAuth = config.ClientAuthRequire
+		}, `server.tls: client_ca_file is required for client_auth "require"`},
+		{"client auth policy", func(c *config.Config) {
+			tls(&c.Server.AdminTLS)
+			c.Server.AdminTLS.ClientAuth = "sometimes"
+		}, `server.admin_tls.client_auth: unknown policy "sometimes"`},
+		{"tls reload interval", func(c *config.Config) { c.Server.AdminTLS.ReloadInterval = 0 },
+			"server.admin_tls.reload_interval must be positive"},
+		{"shutdown timeout", func(c *config.Config) { c.Server.ShutdownTimeout = 0 }, "server.shutdown_timeout must be positive"},
+
+		{"neo4j uri", func(c *config.Config) { c.Storage.Neo4j.URI = "" }, "storage.neo4j.uri is required"},
+		{"neo4j connect timeout", func(c *config.Config) { c.Storage.Neo4j.ConnectTimeout = 0 },
+			"storage.neo4j.connect_timeout must be positive"},
+		{"storage backend", func(c *config.Config) { c.Storage.Backend = "mongo" }, `storage.backend: unknown backend "mongo"`},
+		{"reconnect backoff", func(c *config.Config) { c.Storage.Reconnect.MaxBackoff = time.Millisecond },
+			"storage.reconnect: initial_backoff must be positive and max_backoff at least as long"},
+		{"spool size", func(c *config.Config) { c.Storage.Spool.MaxBytes = -1 }, "storage.spool.max_bytes must not be negative"},
+
+		{"cache ttl", func(c *config.Config) { c.Cache.TTL = time.Millisecond }, "cache.ttl must be at least 1s"},
+		{"cache size", func(c *config.Config) { c.Cache.MaxEntries = -1 }, "cache.max_entries must not be negative"},
+		{"kubernetes mode", func(c *config.Config) { c.Kubernetes.Mode = "remote" }, `kubernetes.mode: unknown mode "remote"`},
+		{"log level", func(c *config.Config) { c.Log.Level = "loud" }, "log.level: "},
+		{"log format", func(c *config.Config) { c.Log.Format = "text" }, `log.format: must be console or json, got "text"`},
+		{"record format", func(c *config.Config) { c.Record.Format = "csv" }, `record.format: must be protobuf or json, got "csv"`},
+
+		{"infer interval", func(c *config.Config) {
+			c.Topology.Infer.Enabled = true
+			c.Topology.Infer.Interval = time.Second
+		}, "topology.infer.interval must be at least 1m"},
+		{"infer without kubernetes", func(c *config.Config) {
+			c.Topology.Infer.Enabled = true
+			c.Kubernetes.Mode = config.K8sModeDisabled
+		}, "topology.infer needs kubernetes.mode other than disabled"},
+
+		{"sampling probability", func(c *config.Config) { c.Sampling.Probability = 1.5 }, "sampling.probability must be between 0 and 1"},
+		{"sampling rate", func(c *config.Config) { c.Sampling.RatePerService = -1 }, "sampling.rate_per_service must not be negative"},
+		{"service rate", func(c *config.Config) { c.Sampling.ServiceRates = map[string]float64{"frontend": -1} },
+			"sampling.service_rates.frontend must not be negative"},
+		{"sampling burst", func(c *config.Config) { c.Sampling.Burst = 0 }, "sampling.burst must be at least 1"},
+
+		{"pipeline workers", func(c *config.Config) { c.Pipeline.Workers = 0 }, "pipeline.workers must be at least 1"},
+		{"pipeline stage", func(c *config.Config) { c.Pipeline.StageWorkers = map[string]int{"parse": 1} },
+			`pipeline.stage_workers: unknown stage "parse" (want one of filter, enrich, aggregate, k8s, write)`},
+		{"stage workers", func(c *config.Config) { c.Pipeline.StageWorkers = map[string]int{config.StageEnrich: 0} },
+			"pipeline.stage_workers.enrich must be at least 1"},
+		{"pipeline queue", func(c *config.Config) { c.Pipeline.QueueSize = 0 }, "pipeline.queue_size must be at least 1"},
+
+		{"dlq size", func(c *config.Config) {
+			c.DLQ.Dir = "dlq"
+			c.DLQ.MaxEntries = -1
+		}, "dlq.max_entries must not be negative"},
+		{"dlq initial backoff", func(c *config.Config) {
+			c.DLQ.Dir = "dlq"
+			c.DLQ.InitialBackoff = time.Millisecond
+		}, "dlq.initial_backoff must be at least 1s"},
+		{"dlq max backoff", func(c *config.Config) {
+			c.DLQ.Dir = "dlq"
+			c.DLQ.MaxBackoff = time.Second
+		}, "dlq.max_backoff must not be less than dlq.initial_backoff"},
+
+		{"anomaly window", func(c *config.Config) { anomaly(c).Window = time.Second }, "anomaly.window must be at least 10s"},
+		{"learning period", func(c *config.Config) { anomaly(c).LearningPeriod = time.Second },
+			"anomaly.learning_period must not be less than anomaly.window"},
+		{"vanish after", func(c *config.Config) { anomaly(c).VanishAfter = time.Second },
+			"anomaly.vanish_after must not be less than anomaly.window"},
+		{"rate shift factor", func(c *config.Config) { anomaly(c).RateShiftFactor = 1 }, "anomaly.rate_shift_factor must be greater than 1"},
+		{"anomaly min rate", func(c *config.Config) { anomaly(c).MinRate = -1 }, "anomaly.min_rate must not be negative"},
+		{"anomaly webhook url", func(c *config.Config) { anomaly(c).Webhook.URL = "ftp://example.com" },
+			`anomaly.webhook.url: must be an http or https URL, got "ftp://example.com"`},
+		{"anomaly webhook timeout", func(c *config.Config) {
+			a := anomaly(c)
+			a.Webhook.URL = "https://example.com"
+			a.Webhook.Timeout = 0
+		}, "anomaly.webhook.timeout must be positive"},
+
+		{"context depth", func(c *config.Config) { c.Context.Depth = 6 }, "context: depth must not be negative and max_depth at least depth"},
+		{"context window", func(c *config.Config) { c.Context.Window = time.Second }, "context.window must be at least 1m"},
+		{"log samples", func(c *config.Config) { c.Context.LogSamples = 21 }, "context.log_samples must be between 0 and 20"},
+
+		{"incident window", func(c *config.Config) { c.Incidents.Window = 0 }, "incidents: window and retention must be positive"},
+		{"incident hops", func(c *config.Config) { c.Incidents.MaxHops = 0 }, "incidents.max_hops must be at least 1"},
+		{"incident store path", func(c *config.Config) { c.Incidents.Store.Path = "" }, "incidents.store.path is required for sqlite"},
+		{"incident store data dir", func(c *config.Config) { c.DataDir = "" }, "data_dir is required for a relative incidents.store.path"},
+		{"incident store backend", func(c *config.Config) { c.Incidents.Store.Backend = "postgres" },
+			`incidents.store.backend: unknown backend "postgres" (want sqlite or memory)`},
+
+		{"rca error rate", func(c *config.Config) { c.RCA.MinErrorRate = 0 }, "rca.min_error_rate must be above 0 and at most 1"},
+		{"rca calls", func(c *config.Config) { c.RCA.MinCalls = 0 }, "rca.min_calls must be at least 1"},
+
+		{"prometheus url", func(c *config.Config) { c.Prometheus.URL = "prometheus:9090" },
+			`prometheus.url: must be an http or https URL, got "prometheus:9090"`},
+		{"prometheus timeout", func(c *config.Config) { prom(c).Timeout = 0 }, "prometheus.timeout must be positive"},
+		{"prometheus window", func(c *config.Config) { prom(c).Window = time.Second }, "prometheus.window must be at least 30s"},
+		{"prometheus interval", func(c *config.Config) { prom(c).Interval = time.Second }, "prometheus.interval must be 0 or at least 10s"},
+		{"prometheus service label", func(c *config.Config) { prom(c).ServiceLabel = "" }, "prometheus.service_label is required"},
+		{"prometheus query", func(c *config.Config) { prom(c).Queries.ErrorRate = "{{.Selector" }, "prometheus.queries.error_rate: "},
+
+		{"notification base url", func(c *config.Config) { c.Notifications.BaseURL = "graph.example.com" },
+			`notifications.base_url: must be an http or https URL, got "graph.example.com"`},
+		{"sink name", func(c *config.Config) { c.Notifications.Sinks = []config.SinkConfig{hookSink("")} },
+			"notifications.sinks[0].name is required"},
+		{"duplicate sink", func(c *config.Config) {
+			c.Notifications.Sinks = []config.SinkConfig{hookSink("hook"), hookSink("hook")}
+		},
+			`notifications.sinks[1].name: duplicate sink "hook"`},
+		{"sink type", func(c *config.Config) {
+			s := hookSink("hook")
+			s.Type = "pager"
+			c.Notifications.Sinks = []config.SinkConfig{s}
+		}, `notifications.sinks[0].type: unknown sink type "pager" (want webhook, slack or email)`},
+		{"sink webhook url", func(c *config.Config) {
+			s := hookSink("chat")
+			s.Type, s.Webhook.URL = config.SinkSlack, ""
+			c.Notifications.Sinks = []config.SinkConfig{s}
+		}, "notifications.sinks[0].webhook.url is required for slack sinks"},
+		// Validate leaves defaulting to Load
+		{"sink webhook timeout", func(c *config.Config) {
+			s := hookSink("hook")
+			s.Webhook.Timeout = 0
+			c.Notifications.Sinks = []config.SinkConfig{s}
+		}, "notifications.sinks[0].webhook.timeout must be positive"},
+		{"smtp address", func(c *config.Config) {
+			s := mailSink()
+			s.SMTP.Address = "smtp.example.com"
+			c.Notifications.Sinks = []config.SinkConfig{s}
+		}, "notifications.sinks[0].smtp.address: "},
+		{"smtp recipients", func(c *config.Config) {
+			s := mailSink()
+			s.SMTP.To = nil
+			c.Notifications.Sinks = []config.SinkConfig{s}
+		}, "notifications.sinks[0].smtp: from and to are required"},
+		{"smtp timeout", func(c *config.Config) {
+			s := mailSink()
+			s.SMTP.Timeout = -1
+			c.Notifications.Sinks = []config.SinkConfig{s}
+		}, "notifications.sinks[0].smtp.timeout must not be negative"},
+		{"sink event", func(c *config.Config) {
+			s := hookSink("hook")
+			s.Events = []string{config.NotifyOpened, "acked"}
+			c.Notifications.Sinks = []config.SinkConfig{s}
+		}, `notifications.sinks[0].events: unknown event "acked"`},
+		{"sink severity", func(c *config.Config) {
+			s := hookSink("hook")
+			s.MinSeverity = "page"
+			c.Notifications.Sinks = []config.SinkConfig{s}
+		}, "notifications.sinks[0].min_severity: must be one of info, warning, error, critical"},
+
+		{"slo interval", func(c *config.Config) { c.SLO.Interval = time.Second }, "slo.interval must be at least 10s"},
+		{"burn alert name", func(c *config.Config) { c.SLO.Alerts[1].Name = "" }, "slo.alerts[1].name is required"},
+		{"duplicate burn alert", func(c *config.Config) { c.SLO.Alerts[1].Name = "page" }, `slo.alerts[1].name: duplicate alert "page"`},
+		{"burn alert severity", func(c *config.Config) { c.SLO.Alerts[0].Severity = "page" },
+			"slo.alerts[0].severity: must be one of info, warning, error, critical"},
+		{"burn alert windows", func(c *config.Config) { c.SLO.Alerts[0].ShortWindow = 2 * time.Hour },
+			"slo.alerts[0]: short_window must be at least 1m and long_window longer"},
+		{"burn alert long window", func(c *config.Config) { c.SLO.Alerts[0].LongWindow = 8 * 24 * time.Hour },
+			"slo.alerts[0].long_window must be at most 7d"},
+		{"burn alert factor", func(c *config.Config) { c.SLO.Alerts[0].Factor = 0 }, "slo.alerts[0].factor must be positive"},
+		{"slo query", func(c *config.Config) { c.SLO.Queries.SlowRatio = "{{.Window" }, "slo.queries.slow_ratio: "},
+
+		{"telemetry endpoint", func(c *config.Config) { telemetry(c).Endpoint = "collector" }, "telemetry.endpoint: "},
+		{"telemetry sample ratio", func(c *config.Config) { telemetry(c).SampleRatio = 2 }, "telemetry.sample_ratio must be between 0 and 1"},
+		{"telemetry service name", func(c *config.Config) { telemetry(c).ServiceName = "" }, "telemetry.service_name is required"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, want := config.Default(), config.Default()
+			tc.mutate(cfg)
+			tc.mutate(want)
+			err := cfg.Validate()
+			if err == nil {
+				t.Fatalf("no error, want %s", tc.want)
+			}
+			// Each mistake is reported once, on its own
+			if msg := err.Error(); !strings.HasPrefix(msg, tc.want) || strings.Contains(msg, "\n") {
+				t.Errorf("error %q, want %q alone", msg, tc.want)
+			}
+			if !reflect.DeepEqual(cfg, want) {
+				t.Error("Validate changed the config")
+			}
+		})
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.Neo4j.Password = "s3cret"
+	cfg.Server.Auth.BearerTokens = []string{"a", "b"}
+	cfg.Server.AdminAuth.BearerTokens = []string{"c"}
+	cfg.Anomaly.Webhook = config.WebhookConfig{URL: "https://example.com/events", Secret: "hmac", Headers: map[string]string{"Authorization": "Bearer x"}}
+	cfg.Prometheus.Headers = map[string]string{"Authorization": "Basic y"}
+	hook := hookSink("hook")
+	hook.Webhook.Headers = map[string]string{"X-Token": "z"}
+	mail := mailSink()
+	mail.SMTP.Username, mail.SMTP.Password = "graph", "hunter2"
+	cfg.Notifications.Sinks = []config.SinkConfig{hook, mail}
+
+	out := cfg.Redacted()
+	data, err := out.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"s3cret", "Bearer x", "hmac", "Basic y", "hunter2", "X-Token: z"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("redacted config shows %q", secret)
+		}
+	}
+	if out.Storage.Neo4j.Password != "<redacted>" || strings.Join(out.Server.Auth.BearerTokens, ",") != "<redacted>" ||
+		strings.Join(out.Server.AdminAuth.BearerTokens, ",") != "<redacted>" {
+		t.Errorf("credentials %q, %v and %v", out.Storage.Neo4j.Password, out.Server.Auth.BearerTokens, out.Server.AdminAuth.BearerTokens)
+	}
+	// What isn't secret stays readable
+	if out.Anomaly.Webhook.URL != "https://example.com/events" || out.Anomaly.Webhook.Headers["Authorization"] != "<redacted>" ||
+		out.Notifications.Sinks[1].SMTP.Username != "graph" || out.Storage.Neo4j.Username != "neo4j" {
+		t.Errorf("redacted too much: %+v", out)
+	}
+
+	// The original is untouched
+	if cfg.Storage.Neo4j.Password != "s3cret" || cfg.Anomaly.Webhook.Headers["Authorization"] != "Bearer x" ||
+		cfg.Prometheus.Headers["Authorization"] != "Basic y" || cfg.Notifications.Sinks[0].Webhook.Headers["X-Token"] != "z" ||
+		cfg.Notifications.Sinks[1].SMTP.Password != "hunter2" {
+		t.Errorf("Redacted changed the config: %+v", cfg)
+	}
+
+	// Unset secrets aren't made up
+	if out := config.Default().Redacted(); out.Anomaly.Webhook.Secret != "" || out.Server.Auth.BearerTokens != nil {
+		t.Errorf("redacted default %+v", out)
+	}
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"time"
+)
+
+// Flags binds the builder's command-line flags. Flags take precedence
+// over the config file and the environment, but only when set explicitly.
+type Flags struct {
+	Path        string
+	PrintConfig bool
+
+	fs           *flag.FlagSet
+	otlpAddress  string
+	adminAddress string
+	backend      string
+	neo4jURI     string
+	cacheTTL     time.Duration
+	k8sMode      string
+	kubeconfig   string
+	logLevel     string
+	logFormat    string
+	recordDir    string
+	topology     string
+	infer        bool
+	sampleProb   float64
+	serviceRate  float64
+	workers      int
+	dlqDir       string
+	spoolDir     string
+	anomalies    bool
+	webhookURL   string
+	promURL      string
+}
+
+// RegisterFlags registers the config flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+	fs.StringVar(&f.Path, "config", os.Getenv("SERVICEGRAPH_CONFIG"), "path to a YAML config file")
+	fs.BoolVar(&f.PrintConfig, "print-config", false, "print the effective configuration and exit")
+	fs.StringVar(&f.otlpAddress, "otlp-address", "", "OTLP gRPC listen address")
+	fs.StringVar(&f.adminAddress, "admin-address", "", "admin HTTP listen address")
+	fs.StringVar(&f.backend, "storage-backend", "", "storage backend")
+	fs.StringVar(&f.neo4jURI, "neo4j-uri", "", "Neo4j bolt URI")
+	fs.DurationVar(&f.cacheTTL, "cache-ttl", 0, "dedup cache TTL")
+	fs.StringVar(&f.k8sMode, "k8s-mode", "", "kubernetes client mode: auto, in-cluster, kubeconfig or disabled")
+	fs.StringVar(&f.kubeconfig, "kubeconfig", "", "path to kubeconfig when not running in cluster")
+	fs.StringVar(&f.logLevel, "log-level", "", "log level")
+	fs.StringVar(&f.logFormat, "log-format", "", "log format: console or json")
+	fs.StringVar(&f.recordDir, "record", "", "write every incoming export request to this directory for replay")
+	fs.StringVar(&f.topology, "topology", "", "comma-separated declared topology files")
+	fs.BoolVar(&f.infer, "infer-topology", false, "infer edges from NetworkPolicies, Services and env vars")
+	fs.Float64Var(&f.sampleProb, "sampling-probability", 1, "fraction of traces processed beyond errors and new edges")
+	fs.Float64Var(&f.serviceRate, "rate-per-service", 0, "spans per second processed per service beyond errors and new edges; 0 is unlimited")
+	fs.IntVar(&f.workers, "pipeline-workers", 0, "workers per span pipeline stage")
+	fs.StringVar(&f.dlqDir, "dlq-dir", "", "keep spans that failed lookup or storage here and retry them")
+	fs.StringVar(&f.spoolDir, "spool-dir", "", "spool writes here while storage is unreachable and replay them when it returns")
+	fs.BoolVar(&f.anomalies, "detect-anomalies", false, "report new, vanished and rerouted edges and call rate shifts as graph events")
+	fs.StringVar(&f.webhookURL, "anomaly-webhook", "", "POST graph events to this URL")
+	fs.StringVar(&f.promURL, "prometheus-url", "", "Prometheus server to snapshot service metrics from")
+	return f
+}
+
+// Load builds the effective configuration from defaults, the config
+// file, the environment and explicitly set flags, in that order, and
+// validates the result.
+func (f *Flags) Load() (*Config, error) {
+	cfg := defaults()
+	if f.Path != "" {
+		if err := cfg.LoadFile(f.Path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "otlp-address":
+			cfg.Server.OTLPAddress = f.otlpAddress
+		case "admin-address":
+			cfg.Server.AdminAddress = f.adminAddress
+		case "storage-backend":
+			cfg.Storage.Backend = f.backend
+		case "neo4j-uri":
+			cfg.Storage.Neo4j.URI = f.neo4jURI
+		case "cache-ttl":
+			cfg.Cache.TTL = f.cacheTTL
+		case "k8s-mode":
+			cfg.Kubernetes.Mode = f.k8sMode
+		case "kubeconfig":
+			cfg.Kubernetes.Kubeconfig = f.kubeconfig
+		case "log-level":
+			cfg.Log.Level = f.logLevel
+		case "log-format":
+			cfg.Log.Format = f.logFormat
+		case "record":
+			cfg.Record.Dir = f.recordDir
+		case "topology":
+			cfg.Topology.DeclaredFiles = SplitList(f.topology)
+		case "infer-topology":
+			cfg.Topology.Infer.Enabled = f.infer
+		case "sampling-probability":
+			cfg.Sampling.Probability = f.sampleProb
+		case "rate-per-service":
+			cfg.Sampling.RatePerService = f.serviceRate
+		case "pipeline-workers":
+			cfg.Pipeline.Workers = f.workers
+		case "dlq-dir":
+			cfg.DLQ.Dir = f.dlqDir
+		case "spool-dir":
+			cfg.Storage.Spool.Dir = f.spoolDir
+		case "detect-anomalies":
+			cfg.Anomaly.Enabled = f.anomalies
+		case "anomaly-webhook":
+			cfg.Anomaly.Webhook.URL = f.webhookURL
+		case "prometheus-url":
+			cfg.Prometheus.URL = f.promURL
+		}
+	})
+
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,292 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/alerts"
+	"servicegraph-builder/pkg/models"
+)
+
+// MemoryStore keeps edges in process. It backs replays and tests, and
+// records what was written when wrapping another store.
+type MemoryStore struct {
+	next Store
+
+	mu       sync.Mutex
+	edges    map[string]models.Edge
+	stats    map[string]*models.EdgeStats
+	services map[string]models.Service
+	events   []models.GraphEvent // oldest first
+	eventIDs map[string]bool
+	alerts   map[string]models.AlertGroup
+	slos     map[string]models.SLO
+}
+
+// maxMemoryEvents caps the events a MemoryStore keeps, dropping the oldest.
+const maxMemoryEvents = 10000
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		edges:    make(map[string]models.Edge),
+		stats:    make(map[string]*models.EdgeStats),
+		services: make(map[string]models.Service),
+		eventIDs: make(map[string]bool),
+		alerts:   make(map[string]models.AlertGroup),
+		slos:     make(map[string]models.SLO),
+	}
+}
+
+// NewRecordingStore returns a MemoryStore that forwards writes to next
+// and remembers the edges next accepted.
+func NewRecordingStore(next Store) *MemoryStore {
+	s := NewMemoryStore()
+	s.next = next
+	return s
+}
+
+func (s *MemoryStore) WriteSpan(ctx context.Context, span *models.EnrichedSpan) error {
+	if s.next != nil {
+		if err := s.next.WriteSpan(ctx, span); err != nil {
+			return err
+		}
+	}
+	edge, ok := EdgeFromSpan(span)
+	if !ok {
+		return nil
+	}
+	now := time.Now().UTC()
+	key := edge.Caller + "\x00" + edge.Callee
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.edges[key] = edge
+	st := s.stats[key]
+	if st == nil {
+		st = &models.EdgeStats{FirstSeen: now}
+		s.stats[key] = st
+	}
+	st.Observations++
+	st.LastSeen = now
+
+	// K8s metadata describes the service that reported the span
+	for _, name := range []string{edge.Caller, edge.Callee} {
+		if _, ok := s.services[name]; !ok {
+			s.services[name] = models.Service{Name: name}
+		}
+	}
+	reporter := normaliseServiceName(span.ServiceName)
+	if edge.Namespace != "" && (reporter == edge.Caller || reporter == edge.Callee) {
+		s.services[reporter] = models.Service{
+			Name:      reporter,
+			Namespace: edge.Namespace,
+			OwnerKind: edge.OwnerKind,
+			OwnerName: edge.OwnerName,
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) MergeEdges(ctx context.Context, services []models.Service, edges []models.Edge) error {
+	if s.next != nil {
+		return s.next.MergeEdges(ctx, services, edges)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, svc := range services {
+		s.services[svc.Name] = mergeService(s.services[svc.Name], svc)
+	}
+	for _, e := range edges {
+		for _, name := range []string{e.Caller, e.Callee} {
+			if _, ok := s.services[name]; !ok {
+				s.services[name] = models.Service{Name: name}
+			}
+		}
+		key := e.Caller + "\x00" + e.Callee
+		if old, ok := s.edges[key]; ok {
+			if models.StrongerOrigin(old.Origin, e.Origin) == old.Origin {
+				continue
+			}
+		}
+		s.edges[key] = e
+	}
+	return nil
+}
+
+// mergeService fills the fields old is missing from svc.
+func mergeService(old, svc models.Service) models.Service {
+	old.Name = svc.Name
+	if old.Namespace == "" {
+		old.Namespace = svc.Namespace
+	}
+	if old.OwnerKind == "" {
+		old.OwnerKind, old.OwnerName = svc.OwnerKind, svc.OwnerName
+	}
+	return old
+}
+
+// ReadGraph returns the wrapped store's graph when recording, otherwise
+// the edges written to this store.
+func (s *MemoryStore) ReadGraph(ctx context.Context) (*models.Graph, error) {
+	if s.next != nil {
+		return s.next.ReadGraph(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := &models.Graph{}
+	for _, svc := range s.services {
+		g.Services = append(g.Services, svc)
+	}
+	for key, e := range s.edges {
+		ge := models.GraphEdge{Edge: e}
+		if st, ok := s.stats[key]; ok {
+			copied := *st
+			ge.Stats = &copied
+		}
+		g.Edges = append(g.Edges, ge)
+	}
+	SortGraph(g)
+	return g, nil
+}
+
+func (s *MemoryStore) WriteEvents(ctx context.Context, events []models.GraphEvent) error {
+	if s.next != nil {
+		return s.next.WriteEvents(ctx, events)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ev := range events {
+		if s.eventIDs[ev.ID] {
+			continue
+		}
+		s.eventIDs[ev.ID] = true
+		s.events = append(s.events, ev)
+	}
+	if n := len(s.events) - maxMemoryEvents; n > 0 {
+		for _, ev := range s.events[:n] {
+			delete(s.eventIDs, ev.ID)
+		}
+		s.events = slices.Clone(s.events[n:])
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReadEvents(ctx context.Context, since time.Time, limit int) ([]models.GraphEvent, error) {
+	if s.next != nil {
+		return s.next.ReadEvents(ctx, since, limit)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []models.GraphEvent
+	for _, ev := range s.events {
+		if !ev.DetectedAt.Before(since) {
+			out = append(out, ev)
+		}
+	}
+	SortEvents(out)
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) WriteAlertGroup(ctx context.Context, group models.AlertGroup) error {
+	if s.next != nil {
+		return s.next.WriteAlertGroup(ctx, group)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts[group.GroupKey] = alerts.MergeGroup(s.alerts[group.GroupKey], group)
+	return nil
+}
+
+func (s *MemoryStore) ReadAlertGroups(ctx context.Context, since time.Time) ([]models.AlertGroup, error) {
+	if s.next != nil {
+		return s.next.ReadAlertGroups(ctx, since)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []models.AlertGroup
+	for _, g := range s.alerts {
+		if !g.UpdatedAt.Before(since) {
+			g.Alerts = slices.Clone(g.Alerts)
+			out = append(out, g)
+		}
+	}
+	SortAlertGroups(out)
+	return out, nil
+}
+
+func (s *MemoryStore) WriteSLO(ctx context.Context, slo models.SLO) error {
+	if s.next != nil {
+		return s.next.WriteSLO(ctx, slo)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slos[slo.ID] = slo
+	if _, ok := s.services[slo.Service]; !ok {
+		s.services[slo.Service] = models.Service{Name: slo.Service}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReadSLOs(ctx context.Context) ([]models.SLO, error) {
+	if s.next != nil {
+		return s.next.ReadSLOs(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]models.SLO, 0, len(s.slos))
+	for _, slo := range s.slos {
+		out = append(out, slo)
+	}
+	SortSLOs(out)
+	return out, nil
+}
+
+func (s *MemoryStore) DeleteSLO(ctx context.Context, id string) error {
+	if s.next != nil {
+		return s.next.DeleteSLO(ctx, id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.slos, id)
+	return nil
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	if s.next != nil {
+		return s.next.Ping(ctx)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close(ctx context.Context) error {
+	if s.next != nil {
+		return s.next.Close(ctx)
+	}
+	return nil
+}
+
+// Edges returns the stored edges ordered by caller, then callee.
+func (s *MemoryStore) Edges() []models.Edge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]models.Edge, 0, len(s.edges))
+	for _, e := range s.edges {
+		out = append(out, e)
+	}
+	sortEdges(out, func(e models.Edge) models.Edge { return e })
+	return out
+}
@@ -4,11 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
-	"os"
-	"strings"
 	"time"
 
+	"servicegraph-builder/pkg/config"
 	"servicegraph-builder/pkg/models"
 
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
@@ -19,21 +17,17 @@ type Neo4jClient struct {
 	driver neo4j.DriverWithContext
 }
 
-func NewNeo4jClient() (*Neo4jClient, error) {
-	uri := getEnv("NEO4J_URI", "bolt://host.docker.internal:7687")
-	username := getEnv("NEO4J_USERNAME", "neo4j")
-	password := getEnv("NEO4J_PASSWORD", "password")
-
+func NewNeo4jClient(cfg config.Neo4jConfig) (*Neo4jClient, error) {
 	driver, err := neo4j.NewDriverWithContext(
-		uri,
-		neo4j.BasicAuth(username, password, ""),
+		cfg.URI,
+		neo4j.BasicAuth(cfg.Username, cfg.Password, ""),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Neo4j driver: %w", err)
 	}
 
 	// Verify connectivity (with timeout)
-	verifyCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	verifyCtx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
 	defer cancel()
 	if err := driver.VerifyConnectivity(verifyCtx); err != nil {
 		driver.Close(verifyCtx)
@@ -48,17 +42,19 @@ func (c *Neo4jClient) Close(ctx context.Context) error {
 	return c.driver.Close(ctx)
 }
 
+// Ping checks that the database is reachable.
+func (c *Neo4jClient) Ping(ctx context.Context) error {
+	return c.driver.VerifyConnectivity(ctx)
+}
+
 // WriteSpan upserts caller & callee nodes and a single CALLS
 // relationship, now safe against duplicates.
 func (c *Neo4jClient) WriteSpan(ctx context.Context, span *models.EnrichedSpan) error {
-	// Normalise service names (trim, lowercase)
-	caller := normaliseServiceName(span.CallerService)
-	callee := normaliseServiceName(span.CalleeService)
-
-	// Skip self-calls, unknown or IP-literal services
-	if caller == callee || caller == "" || callee == "" {
+	edge, ok := EdgeFromSpan(span)
+	if !ok {
 		return nil
 	}
+	caller, callee := edge.Caller, edge.Callee
 
 	// Convert attributes map to JSON string
 	attributesJSON, err := json.Marshal(span.Attributes)
@@ -105,9 +101,15 @@ func (c *Neo4jClient) WriteSpan(ctx context.Context, span *models.EnrichedSpan)
 			MATCH (c:Service {name:$caller}),
 			      (d:Service {name:$callee})
 			MERGE (c)-[r:CALLS]->(d)
+			ON CREATE SET r.first_seen = datetime()
+			SET   r.origin       = 'observed',
+			      r.operation    = $operation,
+			      r.last_seen    = datetime(),
+			      r.observations = coalesce(r.observations, 0) + 1
 		`, map[string]any{
-			"caller": caller,
-			"callee": callee,
+			"caller":    caller,
+			"callee":    callee,
+			"operation": span.OperationName,
 		})
 		return nil, e
 	})
@@ -119,22 +121,571 @@ func (c *Neo4jClient) WriteSpan(ctx context.Context, span *models.EnrichedSpan)
 	return nil
 }
 
-// normaliseServiceName trims, lower-cases, and rejects IP literals.
-func normaliseServiceName(raw string) string {
-	svc := strings.ToLower(strings.TrimSpace(raw))
-	if svc == "" {
-		return ""
+// MergeEdges upserts declared or inferred nodes and CALLS relationships.
+// Node metadata only fills properties that aren't set yet, and an edge's
+// origin is only ever upgraded, so traffic always wins.
+func (c *Neo4jClient) MergeEdges(ctx context.Context, services []models.Service, edges []models.Edge) error {
+	svcParams := make([]map[string]any, 0, len(services))
+	for _, s := range services {
+		svcParams = append(svcParams, map[string]any{
+			"name":      s.Name,
+			"namespace": nullIfEmpty(s.Namespace),
+			"ownerKind": nullIfEmpty(s.OwnerKind),
+			"ownerName": nullIfEmpty(s.OwnerName),
+		})
+	}
+	edgeParams := make([]map[string]any, 0, len(edges))
+	for _, e := range edges {
+		edgeParams = append(edgeParams, map[string]any{
+			"caller":    e.Caller,
+			"callee":    e.Callee,
+			"operation": nullIfEmpty(e.Operation),
+			"namespace": nullIfEmpty(e.Namespace),
+			"origin":    e.Origin,
+		})
+	}
+
+	session := c.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		_, e := tx.Run(ctx, `
+			UNWIND $services AS s
+			MERGE (n:Service {name:s.name})
+			SET   n.k8s_namespace  = coalesce(n.k8s_namespace, s.namespace),
+			      n.k8s_owner_kind = coalesce(n.k8s_owner_kind, s.ownerKind),
+			      n.k8s_owner_name = coalesce(n.k8s_owner_name, s.ownerName)
+		`, map[string]any{"services": svcParams})
+		if e != nil {
+			return nil, e
+		}
+
+		_, e = tx.Run(ctx, `
+			UNWIND $edges AS e
+			MERGE (c:Service {name:e.caller})
+			SET   c.k8s_namespace = coalesce(c.k8s_namespace, e.namespace)
+			MERGE (d:Service {name:e.callee})
+			MERGE (c)-[r:CALLS]->(d)
+			ON CREATE SET r.origin = e.origin, r.operation = e.operation
+			ON MATCH SET
+			      r.operation = coalesce(r.operation, e.operation),
+			      r.origin = CASE
+			        WHEN r.origin IS NULL OR 'observed' IN [r.origin, e.origin] THEN 'observed'
+			        WHEN 'declared' IN [r.origin, e.origin] THEN 'declared'
+			        ELSE e.origin
+			      END
+		`, map[string]any{"edges": edgeParams})
+		return nil, e
+	})
+	if err != nil {
+		return fmt.Errorf("failed to merge edges into Neo4j: %w", err)
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// ReadGraph returns every Service node and CALLS relationship.
+func (c *Neo4jClient) ReadGraph(ctx context.Context) (*models.Graph, error) {
+	session := c.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	res, err := session.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		g := &models.Graph{}
+		nodes, err := tx.Run(ctx, `
+			MATCH (s:Service)
+			RETURN s.name AS name, s.k8s_namespace AS namespace,
+			       s.k8s_owner_kind AS ownerKind, s.k8s_owner_name AS ownerName
+		`, nil)
+		if err != nil {
+			return nil, err
+		}
+		for nodes.Next(ctx) {
+			rec := nodes.Record()
+			g.Services = append(g.Services, models.Service{
+				Name:      recordString(rec, "name"),
+				Namespace: recordString(rec, "namespace"),
+				OwnerKind: recordString(rec, "ownerKind"),
+				OwnerName: recordString(rec, "ownerName"),
+			})
+		}
+		if err := nodes.Err(); err != nil {
+			return nil, err
+		}
+
+		rels, err := tx.Run(ctx, `
+			MATCH (c:Service)-[r:CALLS]->(d:Service)
+			RETURN c.name AS caller, d.name AS callee, r.operation AS operation,
+			       c.k8s_namespace AS namespace, c.k8s_owner_kind AS ownerKind,
+			       c.k8s_owner_name AS ownerName, r.observations AS observations,
+			       r.first_seen AS firstSeen, r.last_seen AS lastSeen,
+			       coalesce(r.origin, 'observed') AS origin
+		`, nil)
+		if err != nil {
+			return nil, err
+		}
+		for rels.Next(ctx) {
+			rec := rels.Record()
+			edge := models.GraphEdge{Edge: models.Edge{
+				Caller:    recordString(rec, "caller"),
+				Callee:    recordString(rec, "callee"),
+				Operation: recordString(rec, "operation"),
+				Namespace: recordString(rec, "namespace"),
+				OwnerKind: recordString(rec, "ownerKind"),
+				OwnerName: recordString(rec, "ownerName"),
+				Origin:    recordString(rec, "origin"),
+			}}
+			// Relationships written before stats were kept have none
+			if n, ok := recordValue(rec, "observations").(int64); ok {
+				edge.Stats = &models.EdgeStats{
+					Observations: n,
+					FirstSeen:    recordTime(rec, "firstSeen"),
+					LastSeen:     recordTime(rec, "lastSeen"),
+				}
+			}
+			g.Edges = append(g.Edges, edge)
+		}
+		return g, rels.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read graph from Neo4j: %w", err)
+	}
+	g := res.(*models.Graph)
+	SortGraph(g)
+	return g, nil
+}
+
+// WriteEvents creates a GraphEvent node per event, linked to the
+// services involved with CALLER, CALLEE and PREVIOUS_CALLEE relationships.
+func (c *Neo4jClient) WriteEvents(ctx context.Context, events []models.GraphEvent) error {
+	params := make([]map[string]any, 0, len(events))
+	for _, ev := range events {
+		params = append(params, map[string]any{
+			"id":             ev.ID,
+			"kind":           ev.Kind,
+			"caller":         ev.Caller,
+			"callee":         ev.Callee,
+			"previousCallee": nullIfEmpty(ev.PreviousCallee),
+			"baselineRate":   ev.BaselineRate,
+			"currentRate":    ev.CurrentRate,
+			"detectedAt":     ev.DetectedAt.UTC(),
+			"message":        ev.Message,
+		})
+	}
+
+	session := c.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		_, e := tx.Run(ctx, `
+			UNWIND $events AS ev
+			MERGE (g:GraphEvent {id:ev.id})
+			ON CREATE SET g.kind            = ev.kind,
+			              g.caller          = ev.caller,
+			              g.callee          = ev.callee,
+			              g.previous_callee = ev.previousCallee,
+			              g.baseline_rate   = ev.baselineRate,
+			              g.current_rate    = ev.currentRate,
+			              g.detected_at     = ev.detectedAt,
+			              g.message         = ev.message
+			MERGE (c:Service {name:ev.caller})
+			MERGE (d:Service {name:ev.callee})
+			MERGE (g)-[:CALLER]->(c)
+			MERGE (g)-[:CALLEE]->(d)
+			WITH g, ev WHERE ev.previousCallee IS NOT NULL
+			MERGE (p:Service {name:ev.previousCallee})
+			MERGE (g)-[:PREVIOUS_CALLEE]->(p)
+		`, map[string]any{"events": params})
+		return nil, e
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write events to Neo4j: %w", err)
+	}
+	return nil
+}
+
+// ReadEvents returns GraphEvent nodes, newest first.
+func (c *Neo4jClient) ReadEvents(ctx context.Context, since time.Time, limit int) ([]models.GraphEvent, error) {
+	query := `
+		MATCH (g:GraphEvent)
+		WHERE g.detected_at >= $since
+		RETURN g.id AS id, g.kind AS kind, g.caller AS caller, g.callee AS callee,
+		       g.previous_callee AS previousCallee, g.baseline_rate AS baselineRate,
+		       g.current_rate AS currentRate, g.detected_at AS detectedAt,
+		       g.message AS message
+		ORDER BY g.detected_at DESC, g.id`
+	params := map[string]any{"since": since.UTC()}
+	if limit > 0 {
+		query += "\n\t\tLIMIT $limit"
+		params["limit"] = limit
 	}
-	if net.ParseIP(svc) != nil {
-		return "" // treat as unknown service
+
+	session := c.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	res, err := session.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		rows, err := tx.Run(ctx, query, params)
+		if err != nil {
+			return nil, err
+		}
+		var events []models.GraphEvent
+		for rows.Next(ctx) {
+			rec := rows.Record()
+			events = append(events, models.GraphEvent{
+				ID:             recordString(rec, "id"),
+				Kind:           recordString(rec, "kind"),
+				Caller:         recordString(rec, "caller"),
+				Callee:         recordString(rec, "callee"),
+				PreviousCallee: recordString(rec, "previousCallee"),
+				BaselineRate:   recordFloat(rec, "baselineRate"),
+				CurrentRate:    recordFloat(rec, "currentRate"),
+				DetectedAt:     recordTime(rec, "detectedAt"),
+				Message:        recordString(rec, "message"),
+			})
+		}
+		return events, rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events from Neo4j: %w", err)
 	}
-	return svc
+	return res.([]models.GraphEvent), nil
 }
 
-// getEnv returns the env var or default.
-func getEnv(key, defaultValue string) string {
-	if v, ok := os.LookupEnv(key); ok && v != "" {
-		return v
+// WriteAlertGroup upserts an AlertGroup node and the Alert nodes it
+// CONTAINS. Firing alerts get a FIRING_ON relationship to each of their
+// services; once resolved it is replaced by FIRED_ON with resolved_at.
+func (c *Neo4jClient) WriteAlertGroup(ctx context.Context, group models.AlertGroup) error {
+	groupLabels, _ := json.Marshal(group.GroupLabels)
+	commonLabels, _ := json.Marshal(group.CommonLabels)
+	commonAnnotations, _ := json.Marshal(group.CommonAnnotations)
+	alertParams := make([]map[string]any, 0, len(group.Alerts))
+	for _, a := range group.Alerts {
+		labels, _ := json.Marshal(a.Labels)
+		annotations, _ := json.Marshal(a.Annotations)
+		var endsAt any
+		if !a.EndsAt.IsZero() {
+			endsAt = a.EndsAt
+		}
+		services := a.Services
+		if services == nil {
+			services = []string{}
+		}
+		alertParams = append(alertParams, map[string]any{
+			"fingerprint":  a.Fingerprint,
+			"name":         a.Name,
+			"status":       a.Status,
+			"severity":     nullIfEmpty(a.Severity),
+			"summary":      nullIfEmpty(a.Summary),
+			"labels":       string(labels),
+			"annotations":  string(annotations),
+			"startsAt":     a.StartsAt,
+			"endsAt":       endsAt,
+			"generatorURL": nullIfEmpty(a.GeneratorURL),
+			"namespace":    nullIfEmpty(a.Namespace),
+			"services":     services,
+		})
 	}
-	return defaultValue
+
+	session := c.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		_, e := tx.Run(ctx, `
+			MERGE (g:AlertGroup {group_key:$groupKey})
+			SET   g.receiver                = $receiver,
+			      g.group_labels_json       = $groupLabels,
+			      g.common_labels_json      = $commonLabels,
+			      g.common_annotations_json = $commonAnnotations,
+			      g.external_url            = $externalURL,
+			      g.updated_at              = $updatedAt
+			WITH g
+			UNWIND $alerts AS al
+			MERGE (a:Alert {fingerprint:al.fingerprint})
+			SET   a.name             = al.name,
+			      a.status           = al.status,
+			      a.severity         = al.severity,
+			      a.summary          = al.summary,
+			      a.labels_json      = al.labels,
+			      a.annotations_json = al.annotations,
+			      a.starts_at        = al.startsAt,
+			      a.ends_at          = al.endsAt,
+			      a.generator_url    = al.generatorURL,
+			      a.namespace        = al.namespace,
+			      a.services         = al.services
+			MERGE (g)-[:CONTAINS]->(a)
+		`, map[string]any{
+			"groupKey":          group.GroupKey,
+			"receiver":          group.Receiver,
+			"groupLabels":       string(groupLabels),
+			"commonLabels":      string(commonLabels),
+			"commonAnnotations": string(commonAnnotations),
+			"externalURL":       group.ExternalURL,
+			"updatedAt":         group.UpdatedAt,
+			"alerts":            alertParams,
+		})
+		if e != nil {
+			return nil, e
+		}
+
+		_, e = tx.Run(ctx, `
+			UNWIND $alerts AS al
+			WITH al WHERE al.status = 'firing'
+			MATCH (a:Alert {fingerprint:al.fingerprint})
+			UNWIND al.services AS svc
+			MERGE (s:Service {name:svc})
+			MERGE (a)-[:FIRING_ON]->(s)
+		`, map[string]any{"alerts": alertParams})
+		if e != nil {
+			return nil, e
+		}
+
+		_, e = tx.Run(ctx, `
+			UNWIND $alerts AS al
+			WITH al WHERE al.status = 'resolved'
+			MATCH (a:Alert {fingerprint:al.fingerprint})-[r:FIRING_ON]->(s:Service)
+			MERGE (a)-[f:FIRED_ON]->(s)
+			SET   f.resolved_at = coalesce(al.endsAt, datetime())
+			DELETE r
+		`, map[string]any{"alerts": alertParams})
+		if e != nil {
+			return nil, e
+		}
+
+		// The group fires while any alert it has carried still does
+		_, e = tx.Run(ctx, `
+			MATCH (g:AlertGroup {group_key:$groupKey})-[:CONTAINS]->(a:Alert)
+			WITH g, collect(a.status) AS statuses
+			SET g.status = CASE WHEN 'firing' IN statuses THEN 'firing' ELSE 'resolved' END
+		`, map[string]any{"groupKey": group.GroupKey})
+		return nil, e
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write alert group to Neo4j: %w", err)
+	}
+	return nil
+}
+
+// ReadAlertGroups returns AlertGroup nodes with their alerts.
+func (c *Neo4jClient) ReadAlertGroups(ctx context.Context, since time.Time) ([]models.AlertGroup, error) {
+	session := c.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	res, err := session.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		rows, err := tx.Run(ctx, `
+			MATCH (g:AlertGroup)
+			WHERE g.updated_at >= $since
+			OPTIONAL MATCH (g)-[:CONTAINS]->(a:Alert)
+			WITH g, a ORDER BY a.starts_at
+			RETURN g.group_key AS groupKey, g.receiver AS receiver, g.status AS status,
+			       g.group_labels_json AS groupLabels, g.common_labels_json AS commonLabels,
+			       g.common_annotations_json AS commonAnnotations,
+			       g.external_url AS externalURL, g.updated_at AS updatedAt,
+			       collect(a {.*}) AS alerts
+			ORDER BY updatedAt DESC, groupKey
+		`, map[string]any{"since": since.UTC()})
+		if err != nil {
+			return nil, err
+		}
+		var groups []models.AlertGroup
+		for rows.Next(ctx) {
+			rec := rows.Record()
+			g := models.AlertGroup{
+				GroupKey:          recordString(rec, "groupKey"),
+				Receiver:          recordString(rec, "receiver"),
+				Status:            recordString(rec, "status"),
+				GroupLabels:       jsonMap(recordString(rec, "groupLabels")),
+				CommonLabels:      jsonMap(recordString(rec, "commonLabels")),
+				CommonAnnotations: jsonMap(recordString(rec, "commonAnnotations")),
+				ExternalURL:       recordString(rec, "externalURL"),
+				UpdatedAt:         recordTime(rec, "updatedAt"),
+			}
+			raw, _ := recordValue(rec, "alerts").([]any)
+			for _, r := range raw {
+				props, ok := r.(map[string]any)
+				if !ok {
+					continue
+				}
+				g.Alerts = append(g.Alerts, alertFromProps(props))
+			}
+			groups = append(groups, g)
+		}
+		return groups, rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert groups from Neo4j: %w", err)
+	}
+	return res.([]models.AlertGroup), nil
+}
+
+// WriteSLO upserts an SLO node, linked to its service with HAS_SLO.
+// A service changed on update is relinked.
+func (c *Neo4jClient) WriteSLO(ctx context.Context, slo models.SLO) error {
+	session := c.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		_, e := tx.Run(ctx, `
+			MERGE (o:SLO {id:$id})
+			SET   o.service      = $service,
+			      o.endpoint     = $endpoint,
+			      o.kind         = $kind,
+			      o.objective    = $objective,
+			      o.threshold_ms = $thresholdMS,
+			      o.window_days  = $windowDays,
+			      o.source       = $source,
+			      o.description  = $description,
+			      o.created_at   = $createdAt,
+			      o.updated_at   = $updatedAt
+			WITH o
+			OPTIONAL MATCH (:Service)-[old:HAS_SLO]->(o)
+			DELETE old
+			WITH DISTINCT o
+			MERGE (s:Service {name:$service})
+			MERGE (s)-[:HAS_SLO]->(o)
+		`, map[string]any{
+			"id":          slo.ID,
+			"service":     slo.Service,
+			"endpoint":    nullIfEmpty(slo.Endpoint),
+			"kind":        slo.Kind,
+			"objective":   slo.Objective,
+			"thresholdMS": slo.ThresholdMS,
+			"windowDays":  slo.WindowDays,
+			"source":      slo.Source,
+			"description": nullIfEmpty(slo.Description),
+			"createdAt":   slo.CreatedAt.UTC(),
+			"updatedAt":   slo.UpdatedAt.UTC(),
+		})
+		return nil, e
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write SLO to Neo4j: %w", err)
+	}
+	return nil
+}
+
+// ReadSLOs returns the SLO nodes.
+func (c *Neo4jClient) ReadSLOs(ctx context.Context) ([]models.SLO, error) {
+	session := c.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	res, err := session.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		rows, err := tx.Run(ctx, `
+			MATCH (o:SLO)
+			RETURN o.id AS id, o.service AS service, o.endpoint AS endpoint,
+			       o.kind AS kind, o.objective AS objective,
+			       o.threshold_ms AS thresholdMS, o.window_days AS windowDays,
+			       o.source AS source, o.description AS description,
+			       o.created_at AS createdAt, o.updated_at AS updatedAt
+			ORDER BY o.service, o.id
+		`, nil)
+		if err != nil {
+			return nil, err
+		}
+		slos := []models.SLO{}
+		for rows.Next(ctx) {
+			rec := rows.Record()
+			days, _ := recordValue(rec, "windowDays").(int64)
+			slos = append(slos, models.SLO{
+				ID:          recordString(rec, "id"),
+				Service:     recordString(rec, "service"),
+				Endpoint:    recordString(rec, "endpoint"),
+				Kind:        recordString(rec, "kind"),
+				Objective:   recordFloat(rec, "objective"),
+				ThresholdMS: recordFloat(rec, "thresholdMS"),
+				WindowDays:  int(days),
+				Source:      recordString(rec, "source"),
+				Description: recordString(rec, "description"),
+				CreatedAt:   recordTime(rec, "createdAt"),
+				UpdatedAt:   recordTime(rec, "updatedAt"),
+			})
+		}
+		return slos, rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SLOs from Neo4j: %w", err)
+	}
+	return res.([]models.SLO), nil
+}
+
+// DeleteSLO deletes an SLO node and its relationship.
+func (c *Neo4jClient) DeleteSLO(ctx context.Context, id string) error {
+	session := c.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		_, e := tx.Run(ctx, `MATCH (o:SLO {id:$id}) DETACH DELETE o`, map[string]any{"id": id})
+		return nil, e
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete SLO from Neo4j: %w", err)
+	}
+	return nil
+}
+
+func alertFromProps(props map[string]any) models.Alert {
+	str := func(key string) string {
+		s, _ := props[key].(string)
+		return s
+	}
+	tm := func(key string) time.Time {
+		t, _ := props[key].(time.Time)
+		return t.UTC()
+	}
+	a := models.Alert{
+		Fingerprint:  str("fingerprint"),
+		Name:         str("name"),
+		Status:       str("status"),
+		Severity:     str("severity"),
+		Summary:      str("summary"),
+		Labels:       jsonMap(str("labels_json")),
+		Annotations:  jsonMap(str("annotations_json")),
+		StartsAt:     tm("starts_at"),
+		GeneratorURL: str("generator_url"),
+		Namespace:    str("namespace"),
+	}
+	if _, ok := props["ends_at"].(time.Time); ok {
+		a.EndsAt = tm("ends_at")
+	}
+	services, _ := props["services"].([]any)
+	for _, s := range services {
+		if name, ok := s.(string); ok {
+			a.Services = append(a.Services, name)
+		}
+	}
+	return a
+}
+
+// jsonMap decodes a string map stored as JSON, ignoring bad values.
+func jsonMap(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	var m map[string]string
+	json.Unmarshal([]byte(s), &m)
+	return m
+}
+
+func recordString(rec *neo4j.Record, key string) string {
+	s, _ := recordValue(rec, key).(string)
+	return s
+}
+
+func recordFloat(rec *neo4j.Record, key string) float64 {
+	f, _ := recordValue(rec, key).(float64)
+	return f
+}
+
+func recordTime(rec *neo4j.Record, key string) time.Time {
+	t, _ := recordValue(rec, key).(time.Time)
+	return t.UTC()
+}
+
+func recordValue(rec *neo4j.Record, key string) any {
+	v, _ := rec.Get(key)
+	return v
 }
@@ -0,0 +1,447 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/wal"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnavailable is returned while the backend is unreachable and the
+// write could not be spooled.
+var ErrUnavailable = errors.New("storage unavailable")
+
+const (
+	// pingTimeout bounds the reachability check after a failed write.
+	pingTimeout = 3 * time.Second
+	// replayBatch is how many spooled records are replayed per read.
+	replayBatch = 500
+)
+
+// spoolRecord is one write in the write-ahead log.
+type spoolRecord struct {
+	Span     *models.EnrichedSpan `json:"span,omitempty"`
+	Services []models.Service     `json:"services,omitempty"`
+	Edges    []models.Edge        `json:"edges,omitempty"`
+	Events   []models.GraphEvent  `json:"events,omitempty"`
+	Alerts   *models.AlertGroup   `json:"alerts,omitempty"`
+	SLO      *models.SLO          `json:"slo,omitempty"`
+	// DeletedSLO is the ID of an SLO deleted.
+	DeletedSLO string `json:"deleted_slo,omitempty"`
+}
+
+// apply writes rec to b.
+func (rec *spoolRecord) apply(ctx context.Context, b Store) error {
+	switch {
+	case rec.Span != nil:
+		return b.WriteSpan(ctx, rec.Span)
+	case rec.Events != nil:
+		return b.WriteEvents(ctx, rec.Events)
+	case rec.Alerts != nil:
+		return b.WriteAlertGroup(ctx, *rec.Alerts)
+	case rec.SLO != nil:
+		return b.WriteSLO(ctx, *rec.SLO)
+	case rec.DeletedSLO != "":
+		return b.DeleteSLO(ctx, rec.DeletedSLO)
+	}
+	return b.MergeEdges(ctx, rec.Services, rec.Edges)
+}
+
+// ResilientStore keeps the builder running while its backend is down. It
+// connects in the background with exponential backoff and, when a spool
+// directory is configured, appends writes to a write-ahead log until the
+// backend is back, then replays them in order.
+type ResilientStore struct {
+	open      func() (Store, error)
+	reconnect config.ReconnectConfig
+	spool     *wal.Log // nil when spooling is disabled
+
+	// mu guards backend and lastErr, and orders writes against replay:
+	// writers hold it shared, and the last stretch of a replay holds it
+	// exclusively so no write slips in between the spool being drained
+	// and writes going direct again.
+	mu      sync.RWMutex
+	backend Store // nil until the first successful open
+	lastErr error
+	up      atomic.Bool
+	// opened is backend again, for a Close that can't wait on mu while
+	// a stuck replay holds it. Whoever swaps it out closes it.
+	opened atomic.Pointer[Store]
+	// replayed is the spool offset already written to the backend, and
+	// replayedRecs the number of records before it
+	replayed     atomic.Int64
+	replayedRecs atomic.Int64
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenResilient wraps the backend selected in cfg in a ResilientStore.
+// It never fails because the backend is unreachable, only if the spool
+// can't be opened.
+func OpenResilient(cfg config.StorageConfig) (*ResilientStore, error) {
+	return NewResilientStore(func() (Store, error) { return Open(cfg) }, cfg.Reconnect, cfg.Spool)
+}
+
+// NewResilientStore returns a store that opens its backend with open.
+func NewResilientStore(open func() (Store, error), reconnect config.ReconnectConfig, spool config.SpoolConfig) (*ResilientStore, error) {
+	s := &ResilientStore{
+		open:      open,
+		reconnect: reconnect,
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if spool.Dir != "" {
+		l, err := wal.Open(spool.Dir, spool.MaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("open spool: %w", err)
+		}
+		s.spool = l
+		if n := l.Len(); n > 0 {
+			log.Info().Int("records", n).Msg("Found spooled writes from a previous run")
+		}
+	}
+	s.updateMetrics()
+
+	// One synchronous attempt, so a healthy backend is ready before the
+	// first span arrives
+	s.connect()
+	go s.run()
+	return s, nil
+}
+
+// Spooling reports whether writes are currently going to the spool.
+func (s *ResilientStore) Spooling() bool {
+	return s.spool != nil && (!s.up.Load() || s.pending())
+}
+
+// LastError returns why the backend is unreachable, or nil.
+func (s *ResilientStore) LastError() error {
+	if s.up.Load() {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastErr
+}
+
+func (s *ResilientStore) WriteSpan(ctx context.Context, span *models.EnrichedSpan) error {
+	return s.write(ctx, spoolRecord{Span: span}, func(b Store) error { return b.WriteSpan(ctx, span) })
+}
+
+func (s *ResilientStore) MergeEdges(ctx context.Context, services []models.Service, edges []models.Edge) error {
+	return s.write(ctx, spoolRecord{Services: services, Edges: edges}, func(b Store) error {
+		return b.MergeEdges(ctx, services, edges)
+	})
+}
+
+func (s *ResilientStore) WriteEvents(ctx context.Context, events []models.GraphEvent) error {
+	return s.write(ctx, spoolRecord{Events: events}, func(b Store) error { return b.WriteEvents(ctx, events) })
+}
+
+func (s *ResilientStore) WriteAlertGroup(ctx context.Context, group models.AlertGroup) error {
+	return s.write(ctx, spoolRecord{Alerts: &group}, func(b Store) error { return b.WriteAlertGroup(ctx, group) })
+}
+
+func (s *ResilientStore) WriteSLO(ctx context.Context, slo models.SLO) error {
+	return s.write(ctx, spoolRecord{SLO: &slo}, func(b Store) error { return b.WriteSLO(ctx, slo) })
+}
+
+func (s *ResilientStore) DeleteSLO(ctx context.Context, id string) error {
+	return s.write(ctx, spoolRecord{DeletedSLO: id}, func(b Store) error { return b.DeleteSLO(ctx, id) })
+}
+
+func (s *ResilientStore) ReadGraph(ctx context.Context) (*models.Graph, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return b.ReadGraph(ctx)
+}
+
+func (s *ResilientStore) ReadEvents(ctx context.Context, since time.Time, limit int) ([]models.GraphEvent, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return b.ReadEvents(ctx, since, limit)
+}
+
+func (s *ResilientStore) ReadAlertGroups(ctx context.Context, since time.Time) ([]models.AlertGroup, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return b.ReadAlertGroups(ctx, since)
+}
+
+func (s *ResilientStore) ReadSLOs(ctx context.Context) ([]models.SLO, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return b.ReadSLOs(ctx)
+}
+
+func (s *ResilientStore) Ping(ctx context.Context) error {
+	b, err := s.current()
+	if err != nil {
+		return err
+	}
+	return b.Ping(ctx)
+}
+
+// Close stops reconnecting and closes the backend and the spool. Spooled
+// writes stay on disk for the next run. If ctx expires before the
+// reconnect loop stops, they are closed under it, which also ends a
+// replay stuck on the backend.
+func (s *ResilientStore) Close(ctx context.Context) error {
+	close(s.stop)
+	var errs []error
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+	if b := s.opened.Swap(nil); b != nil {
+		errs = append(errs, (*b).Close(ctx))
+	}
+	if s.spool != nil {
+		errs = append(errs, s.spool.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ResilientStore) current() (Store, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.up.Load() {
+		return nil, s.unavailableLocked()
+	}
+	return s.backend, nil
+}
+
+func (s *ResilientStore) write(ctx context.Context, rec spoolRecord, fn func(Store) error) error {
+	s.mu.RLock()
+	if s.up.Load() && !s.pending() {
+		b := s.backend
+		s.mu.RUnlock()
+		err := fn(b)
+		if err == nil || s.reachable(ctx, b) {
+			return err
+		}
+		s.markDown(b, err)
+		s.mu.RLock()
+	}
+	defer s.mu.RUnlock()
+
+	if s.spool == nil {
+		return s.unavailableLocked()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := s.spool.Append(data); err != nil {
+		return fmt.Errorf("%w: spool: %v", ErrUnavailable, err)
+	}
+	s.updateMetrics()
+	return nil
+}
+
+// pending reports whether spooled writes are waiting for replay.
+func (s *ResilientStore) pending() bool {
+	return s.spool != nil && s.spool.Size() > s.replayed.Load()
+}
+
+// unavailableLocked wraps the last connection error. Callers hold s.mu.
+func (s *ResilientStore) unavailableLocked() error {
+	if s.lastErr != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, s.lastErr)
+	}
+	return ErrUnavailable
+}
+
+// reachable tells a write that failed on bad data from one that failed
+// because the backend went away.
+func (s *ResilientStore) reachable(ctx context.Context, b Store) bool {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pingTimeout)
+	defer cancel()
+	return b.Ping(ctx) == nil
+}
+
+func (s *ResilientStore) markDown(b Store, err error) {
+	s.mu.Lock()
+	if s.backend == b && s.up.Load() {
+		s.up.Store(false)
+		s.lastErr = err
+		log.Warn().Err(err).Msg("Storage unreachable, reconnecting")
+	}
+	s.mu.Unlock()
+	s.updateMetrics()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run reconnects with exponential backoff whenever the backend is down.
+func (s *ResilientStore) run() {
+	defer close(s.done)
+	delay := s.reconnect.InitialBackoff
+	for {
+		var timer <-chan time.Time
+		if !s.up.Load() {
+			timer = time.After(delay)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+			continue
+		case <-timer:
+		}
+
+		if s.connect() {
+			delay = s.reconnect.InitialBackoff
+		} else {
+			delay = min(delay*2, s.reconnect.MaxBackoff)
+		}
+	}
+}
+
+// connect opens or pings the backend and replays the spool, returning
+// whether the store is up afterwards.
+func (s *ResilientStore) connect() bool {
+	s.mu.RLock()
+	b := s.backend
+	s.mu.RUnlock()
+
+	var err error
+	if b == nil {
+		b, err = s.open()
+		if err == nil {
+			opened := &b
+			s.mu.Lock()
+			s.backend = b
+			s.opened.Store(opened)
+			s.mu.Unlock()
+			select {
+			case <-s.stop:
+				// Close gave up waiting while this was opening
+				if s.opened.CompareAndSwap(opened, nil) {
+					b.Close(context.Background())
+				}
+				return false
+			default:
+			}
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err = b.Ping(ctx)
+		cancel()
+	}
+	if err == nil {
+		err = s.replay(b)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+		s.updateMetrics()
+		log.Warn().Err(err).Msg("Storage unavailable")
+		return false
+	}
+	s.updateMetrics()
+	log.Info().Msg("Storage connected")
+	return true
+}
+
+// replay writes the spool to b and marks the store up. The bulk is
+// replayed while writes keep spooling; the last stretch, the reset and
+// going up happen under the exclusive lock, so order is kept.
+func (s *ResilientStore) replay(b Store) error {
+	if s.spool != nil {
+		for {
+			n, err := s.replayFrom(b, replayBatch)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.spool != nil {
+		if _, err := s.replayFrom(b, 0); err != nil {
+			return err
+		}
+		if n := s.replayedRecs.Load(); n > 0 {
+			log.Info().Int64("records", n).Msg("Replayed spooled writes")
+		}
+		if err := s.spool.Reset(); err != nil {
+			return err
+		}
+		s.replayed.Store(0)
+		s.replayedRecs.Store(0)
+	}
+	s.up.Store(true)
+	s.lastErr = nil
+	return nil
+}
+
+// replayFrom writes up to max records after the replayed offset.
+func (s *ResilientStore) replayFrom(b Store, max int) (int, error) {
+	records, err := s.spool.Read(s.replayed.Load(), max)
+	if err != nil {
+		return 0, fmt.Errorf("read spool: %w", err)
+	}
+	ctx := context.Background()
+	for i, r := range records {
+		var rec spoolRecord
+		if err = json.Unmarshal(r.Data, &rec); err != nil {
+			log.Error().Err(err).Msg("Skipping unreadable spool record")
+		} else {
+			err = rec.apply(ctx, b)
+		}
+		if err != nil {
+			if !s.reachable(ctx, b) {
+				// Resume from this record next time
+				return i, err
+			}
+			log.Error().Err(err).Msg("Dropping spooled write the backend rejected")
+		}
+		s.replayed.Store(r.Next)
+		s.replayedRecs.Add(1)
+		metrics.SpoolReplayed.Inc()
+		s.updateMetrics()
+	}
+	return len(records), nil
+}
+
+func (s *ResilientStore) updateMetrics() {
+	if s.spool != nil {
+		metrics.SpoolDepth.Set(float64(int64(s.spool.Len()) - s.replayedRecs.Load()))
+		metrics.SpoolBytes.Set(float64(s.spool.Size()))
+	}
+	up := 0.0
+	if s.up.Load() {
+		up = 1
+	}
+	metrics.StorageConnected.Set(up)
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+)
+
+// flakyStore is a memory store whose connection can be dropped: while
+// down, opening it, pinging it and writing to it fail. With dropOnWrite
+// it goes down on the next write.
+type flakyStore struct {
+	*MemoryStore
+	down        atomic.Bool
+	dropOnWrite atomic.Bool
+}
+
+var errDown = errors.New("backend down")
+
+func (f *flakyStore) open() (Store, error) {
+	if f.down.Load() {
+		return nil, errDown
+	}
+	return f, nil
+}
+
+func (f *flakyStore) failWrite() bool {
+	if f.dropOnWrite.Load() {
+		f.down.Store(true)
+	}
+	return f.down.Load()
+}
+
+func (f *flakyStore) Ping(ctx context.Context) error {
+	if f.down.Load() {
+		return errDown
+	}
+	return nil
+}
+
+func (f *flakyStore) WriteEvents(ctx context.Context, events []models.GraphEvent) error {
+	if f.failWrite() {
+		return errDown
+	}
+	return f.MemoryStore.WriteEvents(ctx, events)
+}
+
+func (f *flakyStore) WriteAlertGroup(ctx context.Context, group models.AlertGroup) error {
+	if f.failWrite() {
+		return errDown
+	}
+	return f.MemoryStore.WriteAlertGroup(ctx, group)
+}
+
+func (f *flakyStore) MergeEdges(ctx context.Context, services []models.Service, edges []models.Edge) error {
+	if f.failWrite() {
+		return errDown
+	}
+	return f.MemoryStore.MergeEdges(ctx, services, edges)
+}
+
+func TestReplayKeepsRecordsWhileBackendFails(t *testing.T) {
+	ctx := context.Background()
+	backend := &flakyStore{MemoryStore: NewMemoryStore()}
+	backend.down.Store(true)
+	// Reconnects are driven by the test alone
+	s, err := NewResilientStore(backend.open,
+		config.ReconnectConfig{InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+		config.SpoolConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close(ctx) })
+
+	now := time.Now().UTC()
+	ev := models.GraphEvent{ID: "ev-1", Kind: models.EventNewEdge, Caller: "a", Callee: "b", DetectedAt: now}
+	group := models.AlertGroup{GroupKey: "g", Status: models.AlertFiring, UpdatedAt: now,
+		Alerts: []models.Alert{{Fingerprint: "f", Name: "HighErrorRate", Status: models.AlertFiring}}}
+	edge := models.Edge{Caller: "a", Callee: "b", Operation: "GET /b"}
+	if err := s.WriteEvents(ctx, []models.GraphEvent{ev}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteAlertGroup(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MergeEdges(ctx, nil, []models.Edge{edge}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.spool.Len(); got != 3 {
+		t.Fatalf("spooled %d records, want 3", got)
+	}
+
+	// The backend opens, then goes away again on the first replayed write
+	backend.down.Store(false)
+	backend.dropOnWrite.Store(true)
+	if s.connect() {
+		t.Fatal("connected though the backend went away mid-replay")
+	}
+	if got := s.spool.Len(); got != 3 {
+		t.Fatalf("spool holds %d records after a failed replay, want 3", got)
+	}
+	if got := s.replayed.Load(); got != 0 {
+		t.Fatalf("replayed offset %d after a failed replay, want 0", got)
+	}
+
+	backend.dropOnWrite.Store(false)
+	backend.down.Store(false)
+	if !s.connect() {
+		t.Fatalf("not connected once the backend is back: %v", s.LastError())
+	}
+	if s.Spooling() {
+		t.Fatal("still spooling after replay")
+	}
+	events, err := backend.ReadEvents(ctx, time.Time{}, 0)
+	if err != nil || len(events) != 1 || events[0].ID != ev.ID {
+		t.Fatalf("events after replay = %v, %v; want %s", events, err, ev.ID)
+	}
+	groups, err := backend.ReadAlertGroups(ctx, time.Time{})
+	if err != nil || len(groups) != 1 || groups[0].GroupKey != group.GroupKey {
+		t.Fatalf("alert groups after replay = %v, %v; want %s", groups, err, group.GroupKey)
+	}
+	if edges := backend.Edges(); len(edges) != 1 {
+		t.Fatalf("edges after replay = %v, want 1", edges)
+	}
+}
+
+// stuckStore is a memory store whose edge writes hang until it is
+// closed.
+type stuckStore struct {
+	*MemoryStore
+	writing chan struct{}
+	closed  chan struct{}
+}
+
+func (s *stuckStore) MergeEdges(ctx context.Context, services []models.Service, edges []models.Edge) error {
+	close(s.writing)
+	<-s.closed
+	return errDown
+}
+
+func (s *stuckStore) Close(ctx context.Context) error {
+	close(s.closed)
+	return nil
+}
+
+func TestCloseDuringStuckReplay(t *testing.T) {
+	backend := &stuckStore{MemoryStore: NewMemoryStore(), writing: make(chan struct{}), closed: make(chan struct{})}
+	var up atomic.Bool
+	open := func() (Store, error) {
+		if !up.Load() {
+			return nil, errDown
+		}
+		return backend, nil
+	}
+	s, err := NewResilientStore(open,
+		config.ReconnectConfig{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond},
+		config.SpoolConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MergeEdges(context.Background(), nil, []models.Edge{{Caller: "a", Callee: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	up.Store(true)
+	<-backend.writing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close = %v, want the deadline exceeded", err)
+	}
+	select {
+	case <-backend.closed:
+	default:
+		t.Fatal("backend left open")
+	}
+	if err := s.spool.Append([]byte("{}")); err == nil {
+		t.Fatal("spool left open")
+	}
+	// The replay, unstuck, lets the loop exit
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reconnect loop still running")
+	}
+}
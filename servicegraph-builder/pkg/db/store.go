@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+)
+
+// Store is a service graph storage backend.
+type Store interface {
+	// WriteSpan upserts the caller → callee edge described by span.
+	WriteSpan(ctx context.Context, span *models.EnrichedSpan) error
+	// MergeEdges upserts declared or inferred services and edges. An
+	// existing edge keeps its origin unless the new one ranks higher.
+	MergeEdges(ctx context.Context, services []models.Service, edges []models.Edge) error
+	// ReadGraph returns every service and edge in the store.
+	ReadGraph(ctx context.Context) (*models.Graph, error)
+	// WriteEvents stores graph events. Writing an event ID again is a
+	// no-op.
+	WriteEvents(ctx context.Context, events []models.GraphEvent) error
+	// ReadEvents returns up to limit events detected at or after since,
+	// newest first. limit <= 0 means no limit.
+	ReadEvents(ctx context.Context, since time.Time, limit int) ([]models.GraphEvent, error)
+	// WriteAlertGroup upserts an alert group and its alerts, linking
+	// firing alerts to their services and unlinking resolved ones.
+	WriteAlertGroup(ctx context.Context, group models.AlertGroup) error
+	// ReadAlertGroups returns the groups updated at or after since, most
+	// recently updated first.
+	ReadAlertGroups(ctx context.Context, since time.Time) ([]models.AlertGroup, error)
+	// WriteSLO upserts an SLO, linked to its service.
+	WriteSLO(ctx context.Context, slo models.SLO) error
+	// ReadSLOs returns every SLO, ordered by service, then ID.
+	ReadSLOs(ctx context.Context) ([]models.SLO, error)
+	// DeleteSLO deletes an SLO. Deleting one that doesn't exist is a
+	// no-op.
+	DeleteSLO(ctx context.Context, id string) error
+	// Ping checks that the backend is reachable.
+	Ping(ctx context.Context) error
+	// Close releases the backend's resources.
+	Close(ctx context.Context) error
+}
+
+// Open connects to the backend selected in cfg.
+func Open(cfg config.StorageConfig) (Store, error) {
+	switch cfg.Backend {
+	case config.BackendNeo4j:
+		return NewNeo4jClient(cfg.Neo4j)
+	case config.BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// EdgeFromSpan returns the normalised edge span would write, and false
+// for self-calls and spans whose caller or callee is unusable.
+func EdgeFromSpan(span *models.EnrichedSpan) (models.Edge, bool) {
+	edge, ok := ObservedEdge(span.CallerService, span.CalleeService, span.OperationName)
+	if !ok {
+		return edge, false
+	}
+	edge.Namespace = span.K8sMetadata.Namespace
+	edge.OwnerKind = span.K8sMetadata.OwnerKind
+	edge.OwnerName = span.K8sMetadata.OwnerName
+	return edge, true
+}
+
+// ObservedEdge returns the normalised edge of a call from caller to
+// callee, without Kubernetes metadata, and false for self-calls and
+// unusable names.
+func ObservedEdge(caller, callee, operation string) (models.Edge, bool) {
+	// Normalise service names (trim, lowercase)
+	caller = normaliseServiceName(caller)
+	callee = normaliseServiceName(callee)
+
+	// Skip self-calls, unknown or IP-literal services
+	if caller == callee || caller == "" || callee == "" {
+		return models.Edge{}, false
+	}
+
+	return models.Edge{
+		Caller:    caller,
+		Callee:    callee,
+		Operation: operation,
+		Origin:    models.OriginObserved,
+	}, true
+}
+
+// normaliseServiceName trims, lower-cases, and rejects IP literals.
+func normaliseServiceName(raw string) string {
+	svc := strings.ToLower(strings.TrimSpace(raw))
+	if svc == "" {
+		return ""
+	}
+	if net.ParseIP(svc) != nil {
+		return "" // treat as unknown service
+	}
+	return svc
+}
+
+// SortGraph orders services by name and edges by caller, then callee, so
+// exports are stable.
+func SortGraph(g *models.Graph) {
+	sort.Slice(g.Services, func(i, j int) bool { return g.Services[i].Name < g.Services[j].Name })
+	sortEdges(g.Edges, func(e models.GraphEdge) models.Edge { return e.Edge })
+}
+
+// SortEvents orders events newest first, then by ID.
+func SortEvents(events []models.GraphEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].DetectedAt.Equal(events[j].DetectedAt) {
+			return events[i].DetectedAt.After(events[j].DetectedAt)
+		}
+		return events[i].ID < events[j].ID
+	})
+}
+
+// SortAlertGroups orders groups most recently updated first, then by key.
+func SortAlertGroups(groups []models.AlertGroup) {
+	sort.Slice(groups, func(i, j int) bool {
+		if !groups[i].UpdatedAt.Equal(groups[j].UpdatedAt) {
+			return groups[i].UpdatedAt.After(groups[j].UpdatedAt)
+		}
+		return groups[i].GroupKey < groups[j].GroupKey
+	})
+}
+
+// SortSLOs orders SLOs by service, then ID.
+func SortSLOs(slos []models.SLO) {
+	sort.Slice(slos, func(i, j int) bool {
+		if slos[i].Service != slos[j].Service {
+			return slos[i].Service < slos[j].Service
+		}
+		return slos[i].ID < slos[j].ID
+	})
+}
+
+func sortEdges[T any](edges []T, edge func(T) models.Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edge(edges[i]), edge(edges[j])
+		if a.Caller != b.Caller {
+			return a.Caller < b.Caller
+		}
+		return a.Callee < b.Callee
+	})
+}
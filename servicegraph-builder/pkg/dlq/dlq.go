@@ -0,0 +1,280 @@
+// Package dlq is an on-disk dead-letter queue for spans the builder
+// failed to resolve or store. Entries are keyed by edge, so repeated
+// failures of one edge update a single entry instead of piling up.
+package dlq
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/models"
+)
+
+// ErrNotFound is returned for unknown entry IDs.
+var ErrNotFound = errors.New("dead-letter entry not found")
+
+// Stages an entry can have failed in.
+const (
+	StageK8s   = "k8s"
+	StageWrite = "write"
+)
+
+// Entry is one dead-lettered span.
+type Entry struct {
+	ID          string              `json:"id"`
+	Edge        string              `json:"edge"`
+	Stage       string              `json:"stage"`
+	Error       string              `json:"error"`
+	Attempts    int                 `json:"attempts"`
+	FirstFailed time.Time           `json:"first_failed"`
+	LastFailed  time.Time           `json:"last_failed"`
+	NextRetry   time.Time           `json:"next_retry"`
+	Span        models.EnrichedSpan `json:"span"`
+	// Resource holds the string resource attributes the Kubernetes
+	// lookup reads, so it can be redone on retry.
+	Resource map[string]string `json:"resource,omitempty"`
+}
+
+// Queue keeps one JSON file per entry in a directory. It is safe for
+// concurrent use.
+type Queue struct {
+	dir        string
+	maxEntries int
+	backoff    Backoff
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// Backoff is the retry schedule: Initial after the first failure,
+// doubling up to Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay returns the wait after the given number of failed attempts.
+func (b Backoff) Delay(attempts int) time.Duration {
+	d := b.Initial
+	for i := 1; i < attempts && d < b.Max; i++ {
+		d *= 2
+	}
+	return min(d, b.Max)
+}
+
+// Open loads the queue in dir, creating it if needed. When more than
+// maxEntries are queued the oldest are evicted; 0 means unbounded.
+func Open(dir string, maxEntries int, backoff Backoff) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create dead-letter dir: %w", err)
+	}
+	q := &Queue{dir: dir, maxEntries: maxEntries, backoff: backoff, entries: make(map[string]*Entry)}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil || e.ID != strings.TrimSuffix(filepath.Base(path), ".json") {
+			// A torn or foreign file; keep it aside rather than failing
+			// startup or losing it
+			os.Rename(path, path+".corrupt")
+			continue
+		}
+		q.entries[e.ID] = &e
+	}
+	return q, nil
+}
+
+// ID returns the entry ID for an edge.
+func ID(edge string) string {
+	sum := sha256.Sum256([]byte(edge))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Put records a failure of span in stage and schedules a retry. A
+// failure for an edge already queued replaces the span and counts as
+// another attempt. It returns the number of entries evicted to stay
+// under the cap.
+func (q *Queue) Put(stage string, span models.EnrichedSpan, resource map[string]string, cause error) (int, error) {
+	now := time.Now()
+	id := ID(span.HashableName)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[id]
+	if !ok {
+		e = &Entry{ID: id, Edge: span.HashableName, FirstFailed: now}
+	}
+	e.Stage = stage
+	e.Error = cause.Error()
+	e.Attempts++
+	e.LastFailed = now
+	e.NextRetry = now.Add(q.backoff.Delay(e.Attempts))
+	e.Span = span
+	e.Resource = resource
+
+	if err := q.save(e); err != nil {
+		return 0, err
+	}
+	q.entries[id] = e
+	return q.evict(), nil
+}
+
+// Due returns the entries whose retry time has passed.
+func (q *Queue) Due(now time.Time) []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []Entry
+	for _, e := range q.entries {
+		if !e.NextRetry.After(now) {
+			out = append(out, *e)
+		}
+	}
+	sortEntries(out)
+	return out
+}
+
+// List returns every entry, oldest first.
+func (q *Queue) List() []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		out = append(out, *e)
+	}
+	sortEntries(out)
+	return out
+}
+
+// Get returns the entry with id.
+func (q *Queue) Get(id string) (Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[id]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return *e, nil
+}
+
+// Delete removes the entry with id.
+func (q *Queue) Delete(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.entries[id]; !ok {
+		return ErrNotFound
+	}
+	delete(q.entries, id)
+	if err := os.Remove(q.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Resolve removes the entry with id after a successful retry, unless it
+// has failed again since the retry read it with attempts. It reports
+// whether the entry was removed.
+func (q *Queue) Resolve(id string, attempts int) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[id]
+	if !ok || e.Attempts != attempts {
+		return false, nil
+	}
+	delete(q.entries, id)
+	if err := os.Remove(q.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return true, err
+	}
+	return true, nil
+}
+
+// RetryNow makes the entry with id due, or every entry if id is empty,
+// regardless of its backoff. It returns the number of entries made due.
+func (q *Queue) RetryNow(id string, now time.Time) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := q.entries
+	if id != "" {
+		e, ok := q.entries[id]
+		if !ok {
+			return 0, ErrNotFound
+		}
+		entries = map[string]*Entry{id: e}
+	}
+	for _, e := range entries {
+		if !e.NextRetry.After(now) {
+			continue
+		}
+		e.NextRetry = now
+		if err := q.save(e); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
+
+// Len returns the number of queued entries.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// save writes e atomically. Callers hold q.mu.
+func (q *Queue) save(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(q.dir, "."+e.ID+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(e.ID))
+}
+
+// evict drops the oldest entries past the cap. Callers hold q.mu.
+func (q *Queue) evict() int {
+	if q.maxEntries <= 0 || len(q.entries) <= q.maxEntries {
+		return 0
+	}
+	all := make([]Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		all = append(all, *e)
+	}
+	sortEntries(all)
+	n := len(all) - q.maxEntries
+	for _, e := range all[:n] {
+		delete(q.entries, e.ID)
+		os.Remove(q.path(e.ID))
+	}
+	return n
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].FirstFailed.Equal(entries[j].FirstFailed) {
+			return entries[i].FirstFailed.Before(entries[j].FirstFailed)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+}
@@ -0,0 +1,76 @@
+package dlq_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"servicegraph-builder/pkg/dlq"
+	"servicegraph-builder/pkg/models"
+)
+
+func open(t *testing.T) *dlq.Queue {
+	t.Helper()
+	q, err := dlq.Open(t.TempDir(), 0, dlq.Backoff{Initial: time.Minute, Max: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestResolve(t *testing.T) {
+	q := open(t)
+	span := models.EnrichedSpan{HashableName: "frontend-frontend-orders"}
+	id := dlq.ID(span.HashableName)
+	if _, err := q.Put(dlq.StageWrite, span, nil, errors.New("down")); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := q.Get(id)
+
+	// The span failed again while its retry was in flight
+	if _, err := q.Put(dlq.StageWrite, span, nil, errors.New("still down")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := q.Resolve(id, e.Attempts); ok || err != nil {
+		t.Fatalf("Resolve of a stale attempt = %v, %v; want the entry kept", ok, err)
+	}
+	e, _ = q.Get(id)
+	if ok, err := q.Resolve(id, e.Attempts); !ok || err != nil {
+		t.Fatalf("Resolve = %v, %v; want the entry removed", ok, err)
+	}
+	if q.Len() != 0 {
+		t.Errorf("%d entries left", q.Len())
+	}
+	if ok, err := q.Resolve(id, e.Attempts); ok || err != nil {
+		t.Errorf("Resolve of a removed entry = %v, %v", ok, err)
+	}
+}
+
+func TestRetryNow(t *testing.T) {
+	q := open(t)
+	for _, edge := range []string{"a", "b"} {
+		if _, err := q.Put(dlq.StageK8s, models.EnrichedSpan{HashableName: edge}, nil, errors.New("lookup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	if due := q.Due(now); len(due) != 0 {
+		t.Fatalf("%d entries due before their backoff", len(due))
+	}
+
+	if n, err := q.RetryNow(dlq.ID("a"), now); n != 1 || err != nil {
+		t.Fatalf("RetryNow(a) = %d, %v", n, err)
+	}
+	if due := q.Due(now); len(due) != 1 || due[0].Edge != "a" {
+		t.Errorf("due %v, want a", due)
+	}
+	if n, err := q.RetryNow("", now); n != 2 || err != nil {
+		t.Fatalf("RetryNow() = %d, %v", n, err)
+	}
+	if due := q.Due(now); len(due) != 2 {
+		t.Errorf("%d entries due, want both", len(due))
+	}
+	if _, err := q.RetryNow("missing", now); !errors.Is(err, dlq.ErrNotFound) {
+		t.Errorf("RetryNow of an unknown entry: %v", err)
+	}
+}
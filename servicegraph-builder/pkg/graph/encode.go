@@ -0,0 +1,360 @@
+package graph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/models"
+)
+
+// Export formats.
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+	FormatGraphML = "graphml"
+	FormatJSON    = "json"
+)
+
+// Formats lists the supported export formats.
+var Formats = []string{FormatDOT, FormatMermaid, FormatGraphML, FormatJSON}
+
+// SchemaVersion is bumped on incompatible changes to the JSON export.
+const SchemaVersion = 1
+
+// ContentType returns the MIME type for format.
+func ContentType(format string) string {
+	switch format {
+	case FormatDOT:
+		return "text/vnd.graphviz; charset=utf-8"
+	case FormatGraphML:
+		return "application/graphml+xml; charset=utf-8"
+	case FormatJSON:
+		return "application/json"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// Encode writes g to w in format.
+func Encode(w io.Writer, g *models.Graph, format string) error {
+	switch format {
+	case FormatDOT:
+		return encodeDOT(w, g)
+	case FormatMermaid:
+		return encodeMermaid(w, g)
+	case FormatGraphML:
+		return encodeGraphML(w, g)
+	case FormatJSON:
+		return encodeJSON(w, g)
+	default:
+		return fmt.Errorf("unknown format %q (want one of %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+type jsonDocument struct {
+	Version  int                `json:"version"`
+	Services []models.Service   `json:"services"`
+	Edges    []models.GraphEdge `json:"edges"`
+}
+
+func encodeJSON(w io.Writer, g *models.Graph) error {
+	doc := jsonDocument{
+		Version:  SchemaVersion,
+		Services: g.Services,
+		Edges:    g.Edges,
+	}
+	if doc.Services == nil {
+		doc.Services = []models.Service{}
+	}
+	if doc.Edges == nil {
+		doc.Edges = []models.GraphEdge{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// byNamespace groups service names by namespace; "" holds the rest.
+func byNamespace(g *models.Graph) ([]string, map[string][]string) {
+	groups := make(map[string][]string)
+	for _, s := range g.Services {
+		groups[s.Namespace] = append(groups[s.Namespace], s.Name)
+	}
+	namespaces := make([]string, 0, len(groups))
+	for ns := range groups {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces, groups
+}
+
+func edgeLabel(e models.GraphEdge) string {
+	var lines []string
+	if e.Operation != "" {
+		lines = append(lines, e.Operation)
+	}
+	if e.Stats != nil {
+		lines = append(lines, strconv.FormatInt(e.Stats.Observations, 10)+" obs")
+	}
+	if e.Origin == models.OriginDeclared || e.Origin == models.OriginInferred {
+		lines = append(lines, e.Origin)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// burning returns the burn-rate alerts firing on a service's SLOs, when
+// the graph carries SLO status.
+func burning(s models.Service) []string {
+	var out []string
+	for _, st := range s.SLOs {
+		for _, a := range st.Alerts {
+			if !slices.Contains(out, a) {
+				out = append(out, a)
+			}
+		}
+	}
+	return out
+}
+
+func encodeDOT(w io.Writer, g *models.Graph) error {
+	var b strings.Builder
+	b.WriteString("digraph servicegraph {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+
+	namespaces, groups := byNamespace(g)
+	alerts := burningServices(g)
+	for _, ns := range namespaces {
+		indent := "  "
+		if ns != "" {
+			fmt.Fprintf(&b, "  subgraph %s {\n    label=%s;\n", dotQuote("cluster_"+ns), dotQuote(ns))
+			indent = "    "
+		}
+		for _, name := range groups[ns] {
+			// Services burning their error budgets stand out
+			if a := alerts[name]; len(a) > 0 {
+				fmt.Fprintf(&b, "%s%s [color=red, xlabel=%s];\n", indent, dotQuote(name), dotQuote("SLO burn: "+strings.Join(a, ", ")))
+				continue
+			}
+			fmt.Fprintf(&b, "%s%s;\n", indent, dotQuote(name))
+		}
+		if ns != "" {
+			b.WriteString("  }\n")
+		}
+	}
+	for _, e := range g.Edges {
+		var attrs []string
+		if label := edgeLabel(e); label != "" {
+			attrs = append(attrs, "label="+dotQuote(label))
+		}
+		switch e.Origin {
+		case models.OriginDeclared:
+			attrs = append(attrs, "style=dashed")
+		case models.OriginInferred:
+			attrs = append(attrs, "style=dotted")
+		}
+		fmt.Fprintf(&b, "  %s -> %s", dotQuote(e.Caller), dotQuote(e.Callee))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// burningServices maps the services of g burning their error budgets
+// to the burn-rate alerts firing on them.
+func burningServices(g *models.Graph) map[string][]string {
+	out := make(map[string][]string)
+	for _, s := range g.Services {
+		if a := burning(s); len(a) > 0 {
+			out[s.Name] = a
+		}
+	}
+	return out
+}
+
+func encodeMermaid(w io.Writer, g *models.Graph) error {
+	// Mermaid IDs are restricted, so nodes get positional IDs and keep
+	// their names as labels
+	ids := make(map[string]string, len(g.Services))
+	id := func(name string) string {
+		if v, ok := ids[name]; ok {
+			return v
+		}
+		v := "s" + strconv.Itoa(len(ids))
+		ids[name] = v
+		return v
+	}
+
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	namespaces, groups := byNamespace(g)
+	for i, ns := range namespaces {
+		indent := "  "
+		if ns != "" {
+			fmt.Fprintf(&b, "  subgraph ns%d[%s]\n", i, mermaidQuote(ns))
+			indent = "    "
+		}
+		for _, name := range groups[ns] {
+			fmt.Fprintf(&b, "%s%s[%s]\n", indent, id(name), mermaidQuote(name))
+		}
+		if ns != "" {
+			b.WriteString("  end\n")
+		}
+	}
+	for _, e := range g.Edges {
+		// Edges not seen in traffic are drawn dotted
+		arrow := "-->"
+		if e.Origin == models.OriginDeclared || e.Origin == models.OriginInferred {
+			arrow = "-.->"
+		}
+		if label := edgeLabel(e); label != "" {
+			fmt.Fprintf(&b, "  %s %s|%s| %s\n", id(e.Caller), arrow, mermaidQuote(label), id(e.Callee))
+		} else {
+			fmt.Fprintf(&b, "  %s %s %s\n", id(e.Caller), arrow, id(e.Callee))
+		}
+	}
+	if alerts := burningServices(g); len(alerts) > 0 {
+		b.WriteString("  classDef burning stroke:#d00,stroke-width:3px\n")
+		for _, s := range g.Services {
+			if len(alerts[s.Name]) > 0 {
+				fmt.Fprintf(&b, "  class %s burning\n", id(s.Name))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func mermaidQuote(s string) string {
+	r := strings.NewReplacer(`"`, "#quot;", "\n", "<br/>")
+	return `"` + r.Replace(s) + `"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+var graphMLKeys = []graphMLKey{
+	{ID: "namespace", For: "node", Name: "namespace", Type: "string"},
+	{ID: "owner_kind", For: "node", Name: "owner_kind", Type: "string"},
+	{ID: "owner_name", For: "node", Name: "owner_name", Type: "string"},
+	{ID: "slo_burning", For: "node", Name: "slo_burning", Type: "string"},
+	{ID: "operation", For: "edge", Name: "operation", Type: "string"},
+	{ID: "origin", For: "edge", Name: "origin", Type: "string"},
+	{ID: "observations", For: "edge", Name: "observations", Type: "long"},
+	{ID: "first_seen", For: "edge", Name: "first_seen", Type: "string"},
+	{ID: "last_seen", For: "edge", Name: "last_seen", Type: "string"},
+}
+
+func encodeGraphML(w io.Writer, g *models.Graph) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  graphMLKeys,
+		Graph: graphMLGraph{ID: "servicegraph", EdgeDefault: "directed"},
+	}
+	for _, s := range g.Services {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: s.Name,
+			Data: nonEmpty(
+				graphMLData{"namespace", s.Namespace},
+				graphMLData{"owner_kind", s.OwnerKind},
+				graphMLData{"owner_name", s.OwnerName},
+				graphMLData{"slo_burning", strings.Join(burning(s), ",")},
+			),
+		})
+	}
+	for i, e := range g.Edges {
+		data := []graphMLData{{"operation", e.Operation}, {"origin", e.Origin}}
+		if e.Stats != nil {
+			data = append(data,
+				graphMLData{"observations", strconv.FormatInt(e.Stats.Observations, 10)},
+				graphMLData{"first_seen", formatTime(e.Stats.FirstSeen)},
+				graphMLData{"last_seen", formatTime(e.Stats.LastSeen)},
+			)
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     "e" + strconv.Itoa(i),
+			Source: e.Caller,
+			Target: e.Callee,
+			Data:   nonEmpty(data...),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func nonEmpty(data ...graphMLData) []graphMLData {
+	var out []graphMLData
+	for _, d := range data {
+		if d.Value != "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,132 @@
+package graph
+
+import (
+	"fmt"
+
+	"servicegraph-builder/pkg/models"
+)
+
+// Traversal directions from the root service.
+const (
+	DirectionBoth       = "both"
+	DirectionDownstream = "downstream"
+	DirectionUpstream   = "upstream"
+)
+
+// Filter selects a subgraph. The zero value selects everything.
+type Filter struct {
+	// Namespace keeps edges with at least one endpoint in the namespace.
+	Namespace string
+	// Root keeps services reachable from this service.
+	Root string
+	// Depth limits how many hops from Root are followed; 0 is unlimited.
+	Depth int
+	// Direction is downstream (callees), upstream (callers) or both.
+	Direction string
+}
+
+// Validate checks the filter's values.
+func (f Filter) Validate() error {
+	if f.Depth < 0 {
+		return fmt.Errorf("depth must be >= 0, got %d", f.Depth)
+	}
+	switch f.Direction {
+	case "", DirectionBoth, DirectionDownstream, DirectionUpstream:
+	default:
+		return fmt.Errorf("unknown direction %q", f.Direction)
+	}
+	if f.Depth > 0 && f.Root == "" {
+		return fmt.Errorf("depth requires a root service")
+	}
+	return nil
+}
+
+// Apply returns the subgraph of g selected by f. Services are kept when
+// an edge in the subgraph touches them, or when they are the root.
+func Apply(g *models.Graph, f Filter) *models.Graph {
+	services := make(map[string]models.Service, len(g.Services))
+	for _, s := range g.Services {
+		services[s.Name] = s
+	}
+
+	edges := g.Edges
+	if f.Namespace != "" {
+		edges = nil
+		for _, e := range g.Edges {
+			if e.Namespace == f.Namespace ||
+				services[e.Caller].Namespace == f.Namespace ||
+				services[e.Callee].Namespace == f.Namespace {
+				edges = append(edges, e)
+			}
+		}
+	}
+	if f.Root != "" {
+		edges = reachable(edges, f)
+	}
+
+	out := &models.Graph{Edges: edges}
+	keep := make(map[string]bool)
+	if f.Root != "" {
+		keep[f.Root] = true
+	}
+	for _, e := range edges {
+		keep[e.Caller], keep[e.Callee] = true, true
+	}
+	if f.Namespace == "" && f.Root == "" {
+		keep = nil // everything, including isolated services
+	}
+	for _, s := range g.Services {
+		if keep == nil || keep[s.Name] {
+			out.Services = append(out.Services, s)
+		}
+	}
+	return out
+}
+
+// reachable keeps the edges walked breadth-first from f.Root.
+func reachable(edges []models.GraphEdge, f Filter) []models.GraphEdge {
+	down := f.Direction != DirectionUpstream
+	up := f.Direction != DirectionDownstream
+
+	out := make(map[string][]int)
+	in := make(map[string][]int)
+	for i, e := range edges {
+		out[e.Caller] = append(out[e.Caller], i)
+		in[e.Callee] = append(in[e.Callee], i)
+	}
+
+	kept := make([]bool, len(edges))
+	seen := map[string]bool{f.Root: true}
+	frontier := []string{f.Root}
+	for depth := 0; len(frontier) > 0 && (f.Depth == 0 || depth < f.Depth); depth++ {
+		var next []string
+		visit := func(i int, name string) {
+			kept[i] = true
+			if !seen[name] {
+				seen[name] = true
+				next = append(next, name)
+			}
+		}
+		for _, name := range frontier {
+			if down {
+				for _, i := range out[name] {
+					visit(i, edges[i].Callee)
+				}
+			}
+			if up {
+				for _, i := range in[name] {
+					visit(i, edges[i].Caller)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	var res []models.GraphEdge
+	for i, e := range edges {
+		if kept[i] {
+			res = append(res, e)
+		}
+	}
+	return res
+}
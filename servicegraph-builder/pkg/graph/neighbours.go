@@ -0,0 +1,117 @@
+package graph
+
+import (
+	"sort"
+
+	"servicegraph-builder/pkg/models"
+)
+
+// Neighbour is a service reached from a set of root services.
+type Neighbour struct {
+	Name string
+	// Direction is upstream (a caller of a root, transitively),
+	// downstream (a callee) or both.
+	Direction string
+	// Depth is the fewest hops from any root.
+	Depth int
+}
+
+// Neighbourhood walks up to depth hops upstream and downstream of roots.
+// Unlike a Filter with DirectionBoth, each direction is followed on its
+// own, so a callee's other callers are not pulled in. It returns the
+// neighbours, ordered by depth and name, and the edges walked.
+func Neighbourhood(g *models.Graph, roots []string, depth int) ([]Neighbour, []models.GraphEdge) {
+	isRoot := make(map[string]bool, len(roots))
+	for _, r := range roots {
+		isRoot[r] = true
+	}
+
+	out := make(map[string][]int)
+	in := make(map[string][]int)
+	for i, e := range g.Edges {
+		out[e.Caller] = append(out[e.Caller], i)
+		in[e.Callee] = append(in[e.Callee], i)
+	}
+
+	kept := make([]bool, len(g.Edges))
+	found := make(map[string]*Neighbour)
+	walk := func(direction string, next map[string][]int, far func(models.GraphEdge) string) {
+		seen := make(map[string]bool)
+		frontier := append([]string(nil), roots...)
+		for _, r := range roots {
+			seen[r] = true
+		}
+		for d := 1; d <= depth && len(frontier) > 0; d++ {
+			var following []string
+			for _, name := range frontier {
+				for _, i := range next[name] {
+					kept[i] = true
+					other := far(g.Edges[i])
+					if seen[other] {
+						continue
+					}
+					seen[other] = true
+					following = append(following, other)
+					if isRoot[other] {
+						continue
+					}
+					if n, ok := found[other]; ok {
+						if n.Direction != direction {
+							n.Direction = DirectionBoth
+						}
+						n.Depth = min(n.Depth, d)
+					} else {
+						found[other] = &Neighbour{Name: other, Direction: direction, Depth: d}
+					}
+				}
+			}
+			frontier = following
+		}
+	}
+	walk(DirectionDownstream, out, func(e models.GraphEdge) string { return e.Callee })
+	walk(DirectionUpstream, in, func(e models.GraphEdge) string { return e.Caller })
+
+	neighbours := make([]Neighbour, 0, len(found))
+	for _, n := range found {
+		neighbours = append(neighbours, *n)
+	}
+	sort.Slice(neighbours, func(i, j int) bool {
+		if neighbours[i].Depth != neighbours[j].Depth {
+			return neighbours[i].Depth < neighbours[j].Depth
+		}
+		return neighbours[i].Name < neighbours[j].Name
+	})
+	var edges []models.GraphEdge
+	for i, e := range g.Edges {
+		if kept[i] {
+			edges = append(edges, e)
+		}
+	}
+	return neighbours, edges
+}
+
+// Downstream returns the services reachable from root by following calls
+// up to depth hops, with the fewest hops to each. Root itself is not
+// included.
+func Downstream(g *models.Graph, root string, depth int) map[string]int {
+	out := make(map[string][]string)
+	for _, e := range g.Edges {
+		out[e.Caller] = append(out[e.Caller], e.Callee)
+	}
+	dist := make(map[string]int)
+	frontier := []string{root}
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var following []string
+		for _, name := range frontier {
+			for _, callee := range out[name] {
+				if _, seen := dist[callee]; seen || callee == root {
+					continue
+				}
+				dist[callee] = d
+				following = append(following, callee)
+			}
+		}
+		frontier = following
+	}
+	return dist
+}
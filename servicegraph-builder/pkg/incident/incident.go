@@ -0,0 +1,407 @@
+// Package incident clusters alerts into incidents. Alerts that start
+// firing close together on services connected in the graph are taken to
+// share a cause, so one upstream failure makes one incident instead of an
+// alert per service it cascades through.
+package incident
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/graph"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+)
+
+// Correlator is safe for concurrent use. It holds open incidents only;
+// a Store keeps the rest.
+type Correlator struct {
+	cfg config.IncidentsConfig
+
+	mu        sync.Mutex
+	incidents map[string]*models.Incident
+	// open maps the fingerprint of each alert in an open incident to it
+	open map[string]*models.Incident
+}
+
+// Update is an incident changed by Add, with what happened to it.
+type Update struct {
+	Incident models.Incident
+	Opened   bool
+	Events   []models.TimelineEvent
+}
+
+// New returns an empty correlator.
+func New(cfg config.IncidentsConfig) *Correlator {
+	return &Correlator{
+		cfg:       cfg,
+		incidents: make(map[string]*models.Incident),
+		open:      make(map[string]*models.Incident),
+	}
+}
+
+// Restore takes up open incidents, e.g. read back from a Store after a
+// restart. Others are ignored.
+func (c *Correlator) Restore(incs []models.Incident) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, inc := range incs {
+		if inc.Status != models.IncidentOpen {
+			continue
+		}
+		inc := clone(&inc)
+		c.incidents[inc.ID] = &inc
+		for _, a := range inc.Alerts {
+			c.open[a.Fingerprint] = &inc
+		}
+	}
+}
+
+// Add folds the alerts of g into incidents, using topo to tell which
+// services are connected, and returns the incidents it changed.
+//
+// A firing alert already in an open incident updates it. A new one joins
+// the open incidents it connects to, merging them if there are several,
+// or opens its own. Alerts on no service are never connected. An incident
+// resolves once all its alerts have; a resolved alert that isn't in an
+// open incident is ignored.
+func (c *Correlator) Add(g models.AlertGroup, topo *models.Graph, now time.Time) []Update {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now = now.UTC()
+
+	reach := newReach(topo, c.cfg.MaxHops)
+	touched := make(map[string]*Update)
+	var order []*models.Incident
+	touch := func(inc *models.Incident) *Update {
+		u, ok := touched[inc.ID]
+		if !ok {
+			u = &Update{}
+			touched[inc.ID] = u
+			order = append(order, inc)
+		}
+		return u
+	}
+	for _, a := range g.Alerts {
+		ia := models.IncidentAlert{
+			Fingerprint: a.Fingerprint,
+			GroupKey:    g.GroupKey,
+			Name:        a.Name,
+			Status:      a.Status,
+			Severity:    a.Severity,
+			Summary:     a.Summary,
+			Services:    a.Services,
+			StartsAt:    a.StartsAt,
+			EndsAt:      a.EndsAt,
+		}
+		if ia.StartsAt.IsZero() {
+			ia.StartsAt = now
+		}
+		if inc, ok := c.open[a.Fingerprint]; ok {
+			i := slices.IndexFunc(inc.Alerts, func(x models.IncidentAlert) bool { return x.Fingerprint == a.Fingerprint })
+			u := touch(inc)
+			if inc.Alerts[i].Status != ia.Status {
+				u.Events = append(u.Events, alertEvent(ia, now))
+			}
+			inc.Alerts[i] = ia
+			continue
+		}
+		if a.Status != models.AlertFiring {
+			continue
+		}
+
+		var related []*models.Incident
+		for _, inc := range c.incidents {
+			if c.concurrent(inc, ia.StartsAt) && reach.connected(inc.Services, a.Services) {
+				related = append(related, inc)
+			}
+		}
+		var inc *models.Incident
+		if len(related) == 0 {
+			inc = &models.Incident{
+				ID:       incidentID(a.Fingerprint, now),
+				Status:   models.IncidentOpen,
+				OpenedAt: now,
+			}
+			c.incidents[inc.ID] = inc
+			u := touch(inc)
+			u.Opened = true
+			u.Events = append(u.Events, models.TimelineEvent{Time: now, Kind: models.TimelineOpened, Message: "Incident opened"})
+			metrics.IncidentsOpened.Inc()
+		} else {
+			// The oldest survives, so its ID stays good
+			sort.Slice(related, func(i, j int) bool {
+				if !related[i].OpenedAt.Equal(related[j].OpenedAt) {
+					return related[i].OpenedAt.Before(related[j].OpenedAt)
+				}
+				return related[i].ID < related[j].ID
+			})
+			inc = related[0]
+			for _, other := range related[1:] {
+				c.merge(inc, other, now)
+				uo, ui := touch(other), touch(inc)
+				uo.Events = append(uo.Events, models.TimelineEvent{Time: now, Kind: models.TimelineMerged, Message: "Merged into " + inc.ID})
+				ui.Events = append(ui.Events, models.TimelineEvent{Time: now, Kind: models.TimelineMerged, Message: "Took in " + other.ID})
+			}
+		}
+		inc.Alerts = append(inc.Alerts, ia)
+		// Later alerts of the group connect to inc through these before
+		// update recomputes them
+		for _, s := range a.Services {
+			if !slices.Contains(inc.Services, s) {
+				inc.Services = append(inc.Services, s)
+			}
+		}
+		c.open[a.Fingerprint] = inc
+		u := touch(inc)
+		u.Events = append(u.Events, alertEvent(ia, now))
+	}
+
+	out := make([]Update, 0, len(order))
+	for _, inc := range order {
+		u := touched[inc.ID]
+		if inc.Status == models.IncidentOpen {
+			if c.update(inc, reach, now) {
+				u.Events = append(u.Events, models.TimelineEvent{Time: now, Kind: models.TimelineResolved, Message: "All alerts resolved"})
+			}
+		}
+		u.Incident = clone(inc)
+		out = append(out, *u)
+	}
+	return out
+}
+
+func alertEvent(a models.IncidentAlert, now time.Time) models.TimelineEvent {
+	ev := models.TimelineEvent{Time: now, Kind: models.TimelineAlertFired, Message: a.Name + " firing"}
+	if a.Status == models.AlertResolved {
+		ev.Kind, ev.Message = models.TimelineAlertResolved, a.Name+" resolved"
+	}
+	if len(a.Services) > 0 {
+		ev.Service = a.Services[0]
+		ev.Message += " on " + strings.Join(a.Services, ", ")
+	}
+	return ev
+}
+
+// concurrent reports whether an alert starting at t fires close enough
+// to the latest alert in inc to be part of it.
+func (c *Correlator) concurrent(inc *models.Incident, t time.Time) bool {
+	var latest time.Time
+	for _, a := range inc.Alerts {
+		if a.StartsAt.After(latest) {
+			latest = a.StartsAt
+		}
+	}
+	d := t.Sub(latest)
+	return d <= c.cfg.Window && d >= -c.cfg.Window
+}
+
+// merge folds other into inc.
+func (c *Correlator) merge(inc, other *models.Incident, now time.Time) {
+	for _, a := range other.Alerts {
+		inc.Alerts = append(inc.Alerts, a)
+		c.open[a.Fingerprint] = inc
+	}
+	if other.OpenedAt.Before(inc.OpenedAt) {
+		inc.OpenedAt = other.OpenedAt
+	}
+	other.Status = models.IncidentMerged
+	other.MergedInto = inc.ID
+	other.UpdatedAt = now
+	delete(c.incidents, other.ID)
+}
+
+// update recomputes what inc derives from its alerts. It reports whether
+// inc resolved.
+func (c *Correlator) update(inc *models.Incident, reach *reach, now time.Time) bool {
+	inc.UpdatedAt = now
+	sort.SliceStable(inc.Alerts, func(i, j int) bool { return inc.Alerts[i].StartsAt.Before(inc.Alerts[j].StartsAt) })
+
+	var services []string
+	firing := false
+	for _, a := range inc.Alerts {
+		for _, s := range a.Services {
+			if !slices.Contains(services, s) {
+				services = append(services, s)
+			}
+		}
+		firing = firing || a.Status == models.AlertFiring
+	}
+	sort.Strings(services)
+	inc.Services = services
+	inc.Origin = reach.origin(services)
+	inc.Title = title(inc)
+
+	if firing {
+		return false
+	}
+	inc.Status = models.IncidentResolved
+	inc.ResolvedAt = now
+	c.forget(inc)
+	return true
+}
+
+// Close closes the open incident id by hand. It returns false if there
+// is no such open incident.
+func (c *Correlator) Close(id string, now time.Time) (models.Incident, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	inc, ok := c.incidents[id]
+	if !ok {
+		return models.Incident{}, false
+	}
+	inc.Status = models.IncidentClosed
+	inc.ClosedAt = now.UTC()
+	inc.UpdatedAt = now.UTC()
+	c.forget(inc)
+	return clone(inc), true
+}
+
+// Tracks reports whether the alert with fingerprint is in an open
+// incident.
+func (c *Correlator) Tracks(fingerprint string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.open[fingerprint]
+	return ok
+}
+
+// Open returns the open incidents, most recently updated first.
+func (c *Correlator) Open() []models.Incident {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]models.Incident, 0, len(c.incidents))
+	for _, inc := range c.incidents {
+		out = append(out, clone(inc))
+	}
+	SortIncidents(out)
+	return out
+}
+
+// forget drops a no longer open incident. Its alerts open new incidents
+// if they fire again.
+func (c *Correlator) forget(inc *models.Incident) {
+	delete(c.incidents, inc.ID)
+	for _, a := range inc.Alerts {
+		if c.open[a.Fingerprint] == inc {
+			delete(c.open, a.Fingerprint)
+		}
+	}
+}
+
+// SortIncidents orders incidents most recently updated first.
+func SortIncidents(incs []models.Incident) {
+	sort.Slice(incs, func(i, j int) bool {
+		if !incs[i].UpdatedAt.Equal(incs[j].UpdatedAt) {
+			return incs[i].UpdatedAt.After(incs[j].UpdatedAt)
+		}
+		return incs[i].ID < incs[j].ID
+	})
+}
+
+func clone(inc *models.Incident) models.Incident {
+	out := *inc
+	out.Services = slices.Clone(inc.Services)
+	out.Alerts = slices.Clone(inc.Alerts)
+	return out
+}
+
+func incidentID(fingerprint string, now time.Time) string {
+	sum := sha256.Sum256([]byte(fingerprint + "\x00" + strconv.FormatInt(now.UnixNano(), 10)))
+	return "inc-" + hex.EncodeToString(sum[:6])
+}
+
+// title names the origin, or failing that the first alert, and how many
+// services are involved.
+func title(inc *models.Incident) string {
+	name := inc.Alerts[0].Name
+	for _, a := range inc.Alerts {
+		if inc.Origin != "" && slices.Contains(a.Services, inc.Origin) {
+			name = a.Name
+			break
+		}
+	}
+	switch {
+	case inc.Origin == "":
+		return name
+	case len(inc.Services) > 1:
+		return fmt.Sprintf("%s on %s (+%d services)", name, inc.Origin, len(inc.Services)-1)
+	}
+	return fmt.Sprintf("%s on %s", name, inc.Origin)
+}
+
+// reach answers downstream reachability questions on a graph, caching
+// each service's walk.
+type reach struct {
+	g     *models.Graph
+	depth int
+	down  map[string]map[string]int
+}
+
+func newReach(g *models.Graph, depth int) *reach {
+	if g == nil {
+		g = &models.Graph{}
+	}
+	return &reach{g: g, depth: depth, down: make(map[string]map[string]int)}
+}
+
+// calls reports whether from reaches to within the hop limit.
+func (r *reach) calls(from, to string) bool {
+	d, ok := r.down[from]
+	if !ok {
+		d = graph.Downstream(r.g, from, r.depth)
+		r.down[from] = d
+	}
+	_, ok = d[to]
+	return ok
+}
+
+// connected reports whether a service in a is, or calls or is called by,
+// a service in b.
+func (r *reach) connected(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y || r.calls(x, y) || r.calls(y, x) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// origin picks the most downstream of services: one calling no other,
+// reached by the most of them. Ties go to the first name.
+func (r *reach) origin(services []string) string {
+	best, bestCallers := "", -1
+	for _, s := range services {
+		callers, sink := 0, true
+		for _, o := range services {
+			if o == s {
+				continue
+			}
+			if r.calls(s, o) {
+				sink = false
+				break
+			}
+			if r.calls(o, s) {
+				callers++
+			}
+		}
+		if sink && callers > bestCallers {
+			best, bestCallers = s, callers
+		}
+	}
+	if best == "" && len(services) > 0 {
+		// Every service calls another, around a cycle
+		return services[0]
+	}
+	return best
+}
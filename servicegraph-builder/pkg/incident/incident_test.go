@@ -0,0 +1,237 @@
+package incident_test
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/incident"
+	"servicegraph-builder/pkg/models"
+)
+
+var t0 = time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+// topo is frontend → checkout → payments ← admin, with batch apart and
+// a → b → c → a a cycle.
+var topo = graph("frontend>checkout", "checkout>payments", "admin>payments", "a>b", "b>c", "c>a")
+
+func graph(edges ...string) *models.Graph {
+	g := &models.Graph{}
+	for _, e := range edges {
+		caller, callee, _ := strings.Cut(e, ">")
+		g.Edges = append(g.Edges, models.GraphEdge{Edge: models.Edge{Caller: caller, Callee: callee}})
+	}
+	return g
+}
+
+// alert is a HighErrorRate alert with fingerprint fp on service, firing
+// unless resolved, starting at start past t0.
+type alert struct {
+	fp, service string
+	start       time.Duration
+	resolved    bool
+}
+
+// step posts a group of alerts at at past t0.
+type step struct {
+	at     time.Duration
+	alerts []alert
+}
+
+func (s step) group() models.AlertGroup {
+	g := models.AlertGroup{GroupKey: "{}:{alertname=\"HighErrorRate\"}"}
+	for _, a := range s.alerts {
+		status := models.AlertFiring
+		if a.resolved {
+			status = models.AlertResolved
+		}
+		g.Alerts = append(g.Alerts, models.Alert{
+			Fingerprint: a.fp,
+			Name:        "HighErrorRate",
+			Status:      status,
+			StartsAt:    t0.Add(a.start),
+			Services:    []string{a.service},
+		})
+	}
+	return g
+}
+
+// describe sums up an incident as its status, origin, services and alert
+// fingerprints.
+func describe(inc models.Incident) string {
+	var fps []string
+	for _, a := range inc.Alerts {
+		fps = append(fps, a.Fingerprint)
+	}
+	return fmt.Sprintf("%s origin=%s services=%s alerts=%s",
+		inc.Status, inc.Origin, strings.Join(inc.Services, ","), strings.Join(fps, ","))
+}
+
+// describeUpdate adds whether u opened its incident and the kinds of its
+// events.
+func describeUpdate(u incident.Update) string {
+	var kinds []string
+	for _, ev := range u.Events {
+		kinds = append(kinds, ev.Kind)
+	}
+	s := describe(u.Incident) + " events=" + strings.Join(kinds, ",")
+	if u.Opened {
+		s = "new " + s
+	}
+	return s
+}
+
+func TestAdd(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		steps []step
+		// last are the updates of the last step, and open the incidents
+		// open after it, sorted
+		last []string
+		open []string
+	}{
+		{
+			name:  "opens an incident",
+			steps: []step{{0, []alert{{"fe", "frontend", 0, false}}}},
+			last:  []string{"new open origin=frontend services=frontend alerts=fe events=opened,alert_fired"},
+			open:  []string{"open origin=frontend services=frontend alerts=fe"},
+		},
+		{
+			name: "connected alerts join",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, false}}},
+				{time.Minute, []alert{{"pay", "payments", time.Minute, false}}},
+			},
+			last: []string{"open origin=payments services=frontend,payments alerts=fe,pay events=alert_fired"},
+			open: []string{"open origin=payments services=frontend,payments alerts=fe,pay"},
+		},
+		{
+			name: "unconnected alerts stay apart",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, false}}},
+				{0, []alert{{"batch", "batch", 0, false}}},
+			},
+			last: []string{"new open origin=batch services=batch alerts=batch events=opened,alert_fired"},
+			open: []string{
+				"open origin=batch services=batch alerts=batch",
+				"open origin=frontend services=frontend alerts=fe",
+			},
+		},
+		{
+			name: "an alert connecting two open incidents merges them",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, false}}},
+				{time.Minute, []alert{{"adm", "admin", time.Minute, false}}},
+				{2 * time.Minute, []alert{{"pay", "payments", 2 * time.Minute, false}}},
+			},
+			// The older frontend incident takes in the admin one
+			last: []string{
+				"merged origin=admin services=admin alerts=adm events=merged",
+				"open origin=payments services=admin,frontend,payments alerts=fe,adm,pay events=merged,alert_fired",
+			},
+			open: []string{"open origin=payments services=admin,frontend,payments alerts=fe,adm,pay"},
+		},
+		{
+			name: "an alert at the end of the window joins",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, false}}},
+				{5 * time.Minute, []alert{{"co", "checkout", 5 * time.Minute, false}}},
+			},
+			last: []string{"open origin=checkout services=checkout,frontend alerts=fe,co events=alert_fired"},
+			open: []string{"open origin=checkout services=checkout,frontend alerts=fe,co"},
+		},
+		{
+			name: "an alert past the window opens its own",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, false}}},
+				{5 * time.Minute, []alert{{"co", "checkout", 5*time.Minute + time.Second, false}}},
+			},
+			last: []string{"new open origin=checkout services=checkout alerts=co events=opened,alert_fired"},
+			open: []string{
+				"open origin=checkout services=checkout alerts=co",
+				"open origin=frontend services=frontend alerts=fe",
+			},
+		},
+		{
+			name: "an alert that started a window earlier joins",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, false}}},
+				{time.Minute, []alert{{"co", "checkout", -5 * time.Minute, false}}},
+			},
+			last: []string{"open origin=checkout services=checkout,frontend alerts=co,fe events=alert_fired"},
+			open: []string{"open origin=checkout services=checkout,frontend alerts=co,fe"},
+		},
+		{
+			name: "an alert that started before the window opens its own",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, false}}},
+				{time.Minute, []alert{{"co", "checkout", -5*time.Minute - time.Second, false}}},
+			},
+			last: []string{"new open origin=checkout services=checkout alerts=co events=opened,alert_fired"},
+			open: []string{
+				"open origin=checkout services=checkout alerts=co",
+				"open origin=frontend services=frontend alerts=fe",
+			},
+		},
+		{
+			name: "resolves once every alert has",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, false}, {"co", "checkout", 0, false}}},
+				{time.Minute, []alert{{"fe", "frontend", 0, true}}},
+				{2 * time.Minute, []alert{{"co", "checkout", 0, true}}},
+			},
+			last: []string{"resolved origin=checkout services=checkout,frontend alerts=fe,co events=alert_resolved,resolved"},
+		},
+		{
+			name: "a resolved alert outside an open incident is ignored",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, true}}},
+			},
+		},
+		{
+			name: "an alert firing again after resolving opens a new incident",
+			steps: []step{
+				{0, []alert{{"fe", "frontend", 0, false}}},
+				{time.Minute, []alert{{"fe", "frontend", 0, true}}},
+				{2 * time.Minute, []alert{{"fe", "frontend", 2 * time.Minute, false}}},
+			},
+			last: []string{"new open origin=frontend services=frontend alerts=fe events=opened,alert_fired"},
+			open: []string{"open origin=frontend services=frontend alerts=fe"},
+		},
+		{
+			name: "the origin of a cycle is its first service",
+			steps: []step{
+				{0, []alert{{"b", "b", 0, false}, {"c", "c", 0, false}, {"a", "a", 0, false}}},
+			},
+			last: []string{"new open origin=a services=a,b,c alerts=b,c,a events=opened,alert_fired,alert_fired,alert_fired"},
+			open: []string{"open origin=a services=a,b,c alerts=b,c,a"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := incident.New(config.IncidentsConfig{Window: 5 * time.Minute, MaxHops: 3})
+			var updates []incident.Update
+			for _, s := range tc.steps {
+				updates = c.Add(s.group(), topo, t0.Add(s.at))
+			}
+			var last []string
+			for _, u := range updates {
+				last = append(last, describeUpdate(u))
+			}
+			if !reflect.DeepEqual(last, tc.last) {
+				t.Errorf("last updates\n%s\nwant\n%s", strings.Join(last, "\n"), strings.Join(tc.last, "\n"))
+			}
+			var open []string
+			for _, inc := range c.Open() {
+				open = append(open, describe(inc))
+			}
+			slices.Sort(open)
+			if !reflect.DeepEqual(open, tc.open) {
+				t.Errorf("open incidents\n%s\nwant\n%s", strings.Join(open, "\n"), strings.Join(tc.open, "\n"))
+			}
+		})
+	}
+}
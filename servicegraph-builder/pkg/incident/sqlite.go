@@ -0,0 +1,502 @@
+package incident
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"servicegraph-builder/pkg/models"
+
+	_ "modernc.org/sqlite"
+)
+
+// schema is applied on open. Times are Unix nanoseconds, 0 for unset;
+// lists and graphs are JSON.
+const schema = `
+CREATE TABLE IF NOT EXISTS incidents (
+	id          TEXT PRIMARY KEY,
+	status      TEXT NOT NULL,
+	title       TEXT NOT NULL,
+	origin      TEXT NOT NULL,
+	services    TEXT NOT NULL,
+	opened_at   INTEGER NOT NULL,
+	updated_at  INTEGER NOT NULL,
+	resolved_at INTEGER NOT NULL,
+	closed_at   INTEGER NOT NULL,
+	merged_into TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS incidents_status_updated ON incidents (status, updated_at);
+CREATE TABLE IF NOT EXISTS incident_alerts (
+	incident_id TEXT NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+	fingerprint TEXT NOT NULL,
+	group_key   TEXT NOT NULL,
+	name        TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	severity    TEXT NOT NULL,
+	summary     TEXT NOT NULL,
+	services    TEXT NOT NULL,
+	starts_at   INTEGER NOT NULL,
+	ends_at     INTEGER NOT NULL,
+	PRIMARY KEY (incident_id, fingerprint)
+);
+CREATE TABLE IF NOT EXISTS incident_graphs (
+	incident_id TEXT PRIMARY KEY REFERENCES incidents (id) ON DELETE CASCADE,
+	graph       TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS incident_timeline (
+	seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+	incident_id TEXT NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+	time        INTEGER NOT NULL,
+	kind        TEXT NOT NULL,
+	service     TEXT NOT NULL,
+	message     TEXT NOT NULL,
+	data        TEXT
+);
+CREATE INDEX IF NOT EXISTS incident_timeline_incident ON incident_timeline (incident_id, seq);
+CREATE TABLE IF NOT EXISTS incident_reports (
+	incident_id TEXT PRIMARY KEY REFERENCES incidents (id) ON DELETE CASCADE,
+	source      TEXT NOT NULL,
+	created_at  INTEGER NOT NULL,
+	summary     TEXT NOT NULL,
+	root_cause  TEXT NOT NULL,
+	confidence  REAL NOT NULL,
+	details     TEXT
+);
+CREATE TABLE IF NOT EXISTS maintenance_windows (
+	id            TEXT PRIMARY KEY,
+	services      TEXT NOT NULL,
+	namespaces    TEXT NOT NULL,
+	upstream_hops INTEGER NOT NULL,
+	reason        TEXT NOT NULL,
+	created_by    TEXT NOT NULL,
+	created_at    INTEGER NOT NULL,
+	starts_at     INTEGER NOT NULL,
+	ends_at       INTEGER NOT NULL,
+	expired_at    INTEGER NOT NULL,
+	expired_by    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS maintenance_windows_ends ON maintenance_windows (ends_at);
+CREATE TABLE IF NOT EXISTS maintenance_audit (
+	seq       INTEGER PRIMARY KEY AUTOINCREMENT,
+	time      INTEGER NOT NULL,
+	action    TEXT NOT NULL,
+	window_id TEXT NOT NULL,
+	actor     TEXT NOT NULL,
+	message   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS maintenance_audit_window ON maintenance_audit (window_id, seq);
+`
+
+// SQLiteStore keeps incidents in a SQLite database file.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens, creating it and its directory if need be, the
+// database at path.
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create incident database directory: %w", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("open incident database: %w", err)
+	}
+	// One writer at a time is all SQLite allows anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create incident schema in %s: %w", path, err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) SaveIncident(ctx context.Context, inc models.Incident) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO incidents (id, status, title, origin, services, opened_at, updated_at, resolved_at, closed_at, merged_into)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET
+				status = excluded.status, title = excluded.title, origin = excluded.origin,
+				services = excluded.services, opened_at = excluded.opened_at, updated_at = excluded.updated_at,
+				resolved_at = excluded.resolved_at, closed_at = excluded.closed_at, merged_into = excluded.merged_into`,
+			inc.ID, inc.Status, inc.Title, inc.Origin, jsonList(inc.Services),
+			unixNano(inc.OpenedAt), unixNano(inc.UpdatedAt), unixNano(inc.ResolvedAt), unixNano(inc.ClosedAt), inc.MergedInto)
+		if err != nil {
+			return err
+		}
+		// Alerts move with merges, so the incident's set is replaced whole
+		if _, err := tx.ExecContext(ctx, `DELETE FROM incident_alerts WHERE incident_id = ?`, inc.ID); err != nil {
+			return err
+		}
+		for _, a := range inc.Alerts {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO incident_alerts (incident_id, fingerprint, group_key, name, status, severity, summary, services, starts_at, ends_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				inc.ID, a.Fingerprint, a.GroupKey, a.Name, a.Status, a.Severity, a.Summary, jsonList(a.Services),
+				unixNano(a.StartsAt), unixNano(a.EndsAt))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) SaveGraph(ctx context.Context, id string, g *models.Graph) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO incident_graphs (incident_id, graph) VALUES (?, ?) ON CONFLICT DO NOTHING`, id, string(data))
+	return notFound(err)
+}
+
+func (s *SQLiteStore) AppendTimeline(ctx context.Context, id string, events []models.TimelineEvent) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		for _, ev := range events {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO incident_timeline (incident_id, time, kind, service, message, data)
+				VALUES (?, ?, ?, ?, ?, ?)`,
+				id, unixNano(ev.Time), ev.Kind, ev.Service, ev.Message, nullJSON(ev.Data))
+			if err != nil {
+				return notFound(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) SaveReport(ctx context.Context, id string, r models.RCAReport) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO incident_reports (incident_id, source, created_at, summary, root_cause, confidence, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (incident_id) DO UPDATE SET
+			source = excluded.source, created_at = excluded.created_at, summary = excluded.summary,
+			root_cause = excluded.root_cause, confidence = excluded.confidence, details = excluded.details`,
+		id, r.Source, unixNano(r.CreatedAt), r.Summary, r.RootCause, r.Confidence, nullJSON(r.Details))
+	return notFound(err)
+}
+
+func (s *SQLiteStore) Incident(ctx context.Context, id string) (models.IncidentDetail, error) {
+	var d models.IncidentDetail
+	incs, err := s.query(ctx, `WHERE id = ?`, id)
+	if err != nil {
+		return d, err
+	}
+	if len(incs) == 0 {
+		return d, ErrNotFound
+	}
+	d.Incident = incs[0]
+
+	var graph string
+	err = s.db.QueryRowContext(ctx, `SELECT graph FROM incident_graphs WHERE incident_id = ?`, id).Scan(&graph)
+	switch {
+	case err == nil:
+		d.Graph = &models.Graph{}
+		if err := json.Unmarshal([]byte(graph), d.Graph); err != nil {
+			return d, fmt.Errorf("decode incident graph: %w", err)
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return d, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT time, kind, service, message, data FROM incident_timeline
+		WHERE incident_id = ? ORDER BY seq`, id)
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+	d.Timeline = []models.TimelineEvent{}
+	for rows.Next() {
+		var ev models.TimelineEvent
+		var t int64
+		var data sql.NullString
+		if err := rows.Scan(&t, &ev.Kind, &ev.Service, &ev.Message, &data); err != nil {
+			return d, err
+		}
+		ev.Time = fromUnixNano(t)
+		if data.Valid {
+			ev.Data = json.RawMessage(data.String)
+		}
+		d.Timeline = append(d.Timeline, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return d, err
+	}
+
+	var r models.RCAReport
+	var created int64
+	var details sql.NullString
+	err = s.db.QueryRowContext(ctx, `
+		SELECT source, created_at, summary, root_cause, confidence, details
+		FROM incident_reports WHERE incident_id = ?`, id).
+		Scan(&r.Source, &created, &r.Summary, &r.RootCause, &r.Confidence, &details)
+	switch {
+	case err == nil:
+		r.CreatedAt = fromUnixNano(created)
+		if details.Valid {
+			r.Details = json.RawMessage(details.String)
+		}
+		d.Report = &r
+	case !errors.Is(err, sql.ErrNoRows):
+		return d, err
+	}
+	return d, nil
+}
+
+func (s *SQLiteStore) Incidents(ctx context.Context, status string, limit int) ([]models.Incident, error) {
+	where, args := `WHERE 1 = 1`, []any{}
+	if status != "" {
+		where, args = `WHERE status = ?`, append(args, status)
+	}
+	where += ` ORDER BY updated_at DESC, id`
+	if limit > 0 {
+		where, args = where+` LIMIT ?`, append(args, limit)
+	}
+	return s.query(ctx, where, args...)
+}
+
+func (s *SQLiteStore) SaveWindow(ctx context.Context, w models.MaintenanceWindow) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO maintenance_windows (id, services, namespaces, upstream_hops, reason, created_by, created_at, starts_at, ends_at, expired_at, expired_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			services = excluded.services, namespaces = excluded.namespaces, upstream_hops = excluded.upstream_hops,
+			reason = excluded.reason, created_by = excluded.created_by, created_at = excluded.created_at,
+			starts_at = excluded.starts_at, ends_at = excluded.ends_at,
+			expired_at = excluded.expired_at, expired_by = excluded.expired_by`,
+		w.ID, jsonList(w.Services), jsonList(w.Namespaces), w.UpstreamHops, w.Reason, w.CreatedBy,
+		unixNano(w.CreatedAt), unixNano(w.StartsAt), unixNano(w.EndsAt), unixNano(w.ExpiredAt), w.ExpiredBy)
+	return err
+}
+
+func (s *SQLiteStore) Window(ctx context.Context, id string) (models.MaintenanceWindow, error) {
+	ws, err := s.windows(ctx, `WHERE id = ?`, id)
+	if err != nil {
+		return models.MaintenanceWindow{}, err
+	}
+	if len(ws) == 0 {
+		return models.MaintenanceWindow{}, ErrWindowNotFound
+	}
+	return ws[0], nil
+}
+
+func (s *SQLiteStore) Windows(ctx context.Context, after time.Time) ([]models.MaintenanceWindow, error) {
+	return s.windows(ctx, `WHERE ends_at > ? ORDER BY starts_at DESC, id`, unixNano(after))
+}
+
+// windows reads the maintenance windows selected by the clause that
+// follows FROM.
+func (s *SQLiteStore) windows(ctx context.Context, clause string, args ...any) ([]models.MaintenanceWindow, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, services, namespaces, upstream_hops, reason, created_by, created_at, starts_at, ends_at, expired_at, expired_by
+		FROM maintenance_windows `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.MaintenanceWindow{}
+	for rows.Next() {
+		var w models.MaintenanceWindow
+		var services, namespaces string
+		var created, starts, ends, expired int64
+		if err := rows.Scan(&w.ID, &services, &namespaces, &w.UpstreamHops, &w.Reason, &w.CreatedBy,
+			&created, &starts, &ends, &expired, &w.ExpiredBy); err != nil {
+			return nil, err
+		}
+		w.Services, w.Namespaces = fromJSONList(services), fromJSONList(namespaces)
+		w.CreatedAt, w.StartsAt = fromUnixNano(created), fromUnixNano(starts)
+		w.EndsAt, w.ExpiredAt = fromUnixNano(ends), fromUnixNano(expired)
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteStore) AppendAudit(ctx context.Context, entries []models.AuditEntry) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		for _, e := range entries {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO maintenance_audit (time, action, window_id, actor, message) VALUES (?, ?, ?, ?, ?)`,
+				unixNano(e.Time), e.Action, e.Window, e.Actor, e.Message)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) Audit(ctx context.Context, window string, limit int) ([]models.AuditEntry, error) {
+	where, args := `WHERE 1 = 1`, []any{}
+	if window != "" {
+		where, args = `WHERE window_id = ?`, append(args, window)
+	}
+	where += ` ORDER BY seq DESC`
+	if limit > 0 {
+		where, args = where+` LIMIT ?`, append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT time, action, window_id, actor, message FROM maintenance_audit `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var t int64
+		if err := rows.Scan(&t, &e.Action, &e.Window, &e.Actor, &e.Message); err != nil {
+			return nil, err
+		}
+		e.Time = fromUnixNano(t)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+	err := s.tx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM incidents WHERE status != ? AND updated_at < ?`,
+			models.IncidentOpen, unixNano(before))
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM maintenance_windows WHERE ends_at < ?`, unixNano(before)); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM maintenance_audit WHERE time < ?`, unixNano(before))
+		return err
+	})
+	return int(n), err
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// query reads the incidents selected by the clause that follows FROM,
+// with their alerts.
+func (s *SQLiteStore) query(ctx context.Context, clause string, args ...any) ([]models.Incident, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, status, title, origin, services, opened_at, updated_at, resolved_at, closed_at, merged_into
+		FROM incidents `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	out := []models.Incident{}
+	for rows.Next() {
+		var inc models.Incident
+		var services string
+		var opened, updated, resolved, closed int64
+		if err := rows.Scan(&inc.ID, &inc.Status, &inc.Title, &inc.Origin, &services,
+			&opened, &updated, &resolved, &closed, &inc.MergedInto); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		inc.Services = fromJSONList(services)
+		inc.OpenedAt, inc.UpdatedAt = fromUnixNano(opened), fromUnixNano(updated)
+		inc.ResolvedAt, inc.ClosedAt = fromUnixNano(resolved), fromUnixNano(closed)
+		out = append(out, inc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range out {
+		if out[i].Alerts, err = s.alerts(ctx, out[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (s *SQLiteStore) alerts(ctx context.Context, id string) ([]models.IncidentAlert, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT fingerprint, group_key, name, status, severity, summary, services, starts_at, ends_at
+		FROM incident_alerts WHERE incident_id = ? ORDER BY starts_at, fingerprint`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.IncidentAlert{}
+	for rows.Next() {
+		var a models.IncidentAlert
+		var services string
+		var starts, ends int64
+		if err := rows.Scan(&a.Fingerprint, &a.GroupKey, &a.Name, &a.Status, &a.Severity, &a.Summary,
+			&services, &starts, &ends); err != nil {
+			return nil, err
+		}
+		a.Services = fromJSONList(services)
+		a.StartsAt, a.EndsAt = fromUnixNano(starts), fromUnixNano(ends)
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteStore) tx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// notFound maps a foreign key failure, writing to an unknown incident,
+// to ErrNotFound.
+func notFound(err error) error {
+	if err != nil && err.Error() == "FOREIGN KEY constraint failed" {
+		return ErrNotFound
+	}
+	return err
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+func jsonList(l []string) string {
+	if l == nil {
+		l = []string{}
+	}
+	data, _ := json.Marshal(l)
+	return string(data)
+}
+
+func fromJSONList(s string) []string {
+	var l []string
+	json.Unmarshal([]byte(s), &l)
+	return l
+}
+
+func nullJSON(data json.RawMessage) any {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
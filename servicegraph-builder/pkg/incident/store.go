@@ -0,0 +1,240 @@
+package incident
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+)
+
+// ErrNotFound is returned for unknown incident IDs.
+var ErrNotFound = errors.New("incident not found")
+
+// ErrWindowNotFound is returned for unknown maintenance window IDs.
+var ErrWindowNotFound = errors.New("maintenance window not found")
+
+// Store keeps incidents and what happened to them.
+type Store interface {
+	// SaveIncident upserts an incident and its alerts.
+	SaveIncident(ctx context.Context, inc models.Incident) error
+	// SaveGraph records the graph around an incident. A graph already
+	// recorded is kept.
+	SaveGraph(ctx context.Context, id string, g *models.Graph) error
+	// AppendTimeline adds events to an incident's timeline.
+	AppendTimeline(ctx context.Context, id string, events []models.TimelineEvent) error
+	// SaveReport sets an incident's RCA report, replacing any earlier one.
+	SaveReport(ctx context.Context, id string, report models.RCAReport) error
+	// Incident returns an incident with its graph, timeline and report.
+	Incident(ctx context.Context, id string) (models.IncidentDetail, error)
+	// Incidents returns up to limit incidents with the given status, or
+	// any for "", most recently updated first. limit <= 0 means no
+	// limit.
+	Incidents(ctx context.Context, status string, limit int) ([]models.Incident, error)
+	// SaveWindow upserts a maintenance window.
+	SaveWindow(ctx context.Context, w models.MaintenanceWindow) error
+	// Window returns a maintenance window.
+	Window(ctx context.Context, id string) (models.MaintenanceWindow, error)
+	// Windows returns the maintenance windows ending after after, latest
+	// starting first.
+	Windows(ctx context.Context, after time.Time) ([]models.MaintenanceWindow, error)
+	// AppendAudit adds entries to the maintenance audit log.
+	AppendAudit(ctx context.Context, entries []models.AuditEntry) error
+	// Audit returns up to limit audit entries of a window, or of all for
+	// "", newest first. limit <= 0 means no limit.
+	Audit(ctx context.Context, window string, limit int) ([]models.AuditEntry, error)
+	// Prune deletes incidents no longer open that were last updated
+	// before before, and maintenance windows ended and audit entries
+	// logged before it. It returns how many incidents it deleted.
+	Prune(ctx context.Context, before time.Time) (int, error)
+	Close() error
+}
+
+// OpenStore opens the backend selected in cfg.
+func OpenStore(cfg config.IncidentStoreConfig) (Store, error) {
+	switch cfg.Backend {
+	case config.IncidentStoreSQLite:
+		return OpenSQLite(cfg.Path)
+	case config.IncidentStoreMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown incident store backend %q", cfg.Backend)
+	}
+}
+
+// MemoryStore keeps incidents until the process exits.
+type MemoryStore struct {
+	mu        sync.Mutex
+	incidents map[string]*models.IncidentDetail
+	windows   map[string]models.MaintenanceWindow
+	audit     []models.AuditEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		incidents: make(map[string]*models.IncidentDetail),
+		windows:   make(map[string]models.MaintenanceWindow),
+	}
+}
+
+func (s *MemoryStore) SaveIncident(_ context.Context, inc models.Incident) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.incidents[inc.ID]
+	if !ok {
+		d = &models.IncidentDetail{Timeline: []models.TimelineEvent{}}
+		s.incidents[inc.ID] = d
+	}
+	d.Incident = clone(&inc)
+	return nil
+}
+
+func (s *MemoryStore) SaveGraph(_ context.Context, id string, g *models.Graph) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.incidents[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if d.Graph == nil {
+		d.Graph = g
+	}
+	return nil
+}
+
+func (s *MemoryStore) AppendTimeline(_ context.Context, id string, events []models.TimelineEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.incidents[id]
+	if !ok {
+		return ErrNotFound
+	}
+	d.Timeline = append(d.Timeline, events...)
+	return nil
+}
+
+func (s *MemoryStore) SaveReport(_ context.Context, id string, report models.RCAReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.incidents[id]
+	if !ok {
+		return ErrNotFound
+	}
+	d.Report = &report
+	return nil
+}
+
+func (s *MemoryStore) Incident(_ context.Context, id string) (models.IncidentDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.incidents[id]
+	if !ok {
+		return models.IncidentDetail{}, ErrNotFound
+	}
+	out := *d
+	out.Incident = clone(&d.Incident)
+	out.Timeline = slices.Clone(d.Timeline)
+	return out, nil
+}
+
+func (s *MemoryStore) Incidents(_ context.Context, status string, limit int) ([]models.Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []models.Incident{}
+	for _, d := range s.incidents {
+		if status == "" || d.Status == status {
+			out = append(out, clone(&d.Incident))
+		}
+	}
+	SortIncidents(out)
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) SaveWindow(_ context.Context, w models.MaintenanceWindow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Services, w.Namespaces = slices.Clone(w.Services), slices.Clone(w.Namespaces)
+	s.windows[w.ID] = w
+	return nil
+}
+
+func (s *MemoryStore) Window(_ context.Context, id string) (models.MaintenanceWindow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[id]
+	if !ok {
+		return w, ErrWindowNotFound
+	}
+	return w, nil
+}
+
+func (s *MemoryStore) Windows(_ context.Context, after time.Time) ([]models.MaintenanceWindow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []models.MaintenanceWindow{}
+	for _, w := range s.windows {
+		if w.EndsAt.After(after) {
+			out = append(out, w)
+		}
+	}
+	SortWindows(out)
+	return out, nil
+}
+
+func (s *MemoryStore) AppendAudit(_ context.Context, entries []models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = append(s.audit, entries...)
+	return nil
+}
+
+func (s *MemoryStore) Audit(_ context.Context, window string, limit int) ([]models.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []models.AuditEntry{}
+	for i := len(s.audit) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		if window == "" || s.audit[i].Window == window {
+			out = append(out, s.audit[i])
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) Prune(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, d := range s.incidents {
+		if d.Status != models.IncidentOpen && d.UpdatedAt.Before(before) {
+			delete(s.incidents, id)
+			n++
+		}
+	}
+	for id, w := range s.windows {
+		if w.EndsAt.Before(before) {
+			delete(s.windows, id)
+		}
+	}
+	s.audit = slices.DeleteFunc(s.audit, func(e models.AuditEntry) bool { return e.Time.Before(before) })
+	return n, nil
+}
+
+func (s *MemoryStore) Close() error { return nil }
+
+// SortWindows orders maintenance windows latest starting first.
+func SortWindows(ws []models.MaintenanceWindow) {
+	sort.Slice(ws, func(i, j int) bool {
+		if !ws[i].StartsAt.Equal(ws[j].StartsAt) {
+			return ws[i].StartsAt.After(ws[j].StartsAt)
+		}
+		return ws[i].ID < ws[j].ID
+	})
+}
@@ -0,0 +1,102 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Phase is a stage in the builder's lifetime.
+type Phase string
+
+const (
+	PhaseStarting Phase = "starting"
+	PhaseServing  Phase = "serving"
+	PhaseDraining Phase = "draining"
+	PhaseStopped  Phase = "stopped"
+)
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Manager tracks the current phase and runs shutdown hooks.
+type Manager struct {
+	mu    sync.RWMutex
+	phase Phase
+	hooks []hook
+	once  sync.Once
+}
+
+func New() *Manager {
+	return &Manager{phase: PhaseStarting}
+}
+
+// Phase returns the current phase.
+func (m *Manager) Phase() Phase {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.phase
+}
+
+// Ready reports whether the builder should receive traffic.
+func (m *Manager) Ready() bool {
+	return m.Phase() == PhaseServing
+}
+
+// SetPhase moves to phase p.
+func (m *Manager) SetPhase(p Phase) {
+	m.mu.Lock()
+	prev := m.phase
+	m.phase = p
+	m.mu.Unlock()
+	if prev != p {
+		log.Info().Str("from", string(prev)).Str("to", string(p)).Msg("Lifecycle phase changed")
+	}
+}
+
+// OnShutdown registers fn to run during Shutdown. Hooks run one at a
+// time in reverse registration order, like defers, so a component
+// registered right after it starts is stopped before the things it
+// depends on: storage is registered first and closed last.
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// Shutdown enters the draining phase, runs every hook under ctx and then
+// enters the stopped phase. Hooks still run after an earlier one fails
+// so resources are released; their errors are joined. Only the first
+// call does anything.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	var err error
+	m.once.Do(func() {
+		m.SetPhase(PhaseDraining)
+
+		m.mu.RLock()
+		hooks := append([]hook(nil), m.hooks...)
+		m.mu.RUnlock()
+
+		var errs []error
+		for i := len(hooks) - 1; i >= 0; i-- {
+			h := hooks[i]
+			start := time.Now()
+			if herr := h.fn(ctx); herr != nil {
+				log.Error().Err(herr).Str("hook", h.name).Msg("Shutdown step failed")
+				errs = append(errs, fmt.Errorf("%s: %w", h.name, herr))
+				continue
+			}
+			log.Info().Str("hook", h.name).Dur("took", time.Since(start)).Msg("Shutdown step finished")
+		}
+
+		m.SetPhase(PhaseStopped)
+		err = errors.Join(errs...)
+	})
+	return err
+}
@@ -0,0 +1,163 @@
+// Package maintenance keeps the maintenance windows that are on or
+// coming up, and tells which alerts they cover. Alerts on services under
+// planned work, and optionally on the callers those services' failures
+// cascade to, are expected and shouldn't open incidents.
+package maintenance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/graph"
+	"servicegraph-builder/pkg/models"
+)
+
+// Schedule is safe for concurrent use. It forgets windows once they end;
+// a Store keeps them.
+type Schedule struct {
+	mu      sync.Mutex
+	windows map[string]models.MaintenanceWindow
+	// suppressed holds the fingerprints of the alerts each window has
+	// suppressed, by window ID
+	suppressed map[string]map[string]bool
+}
+
+// New returns a schedule of ws.
+func New(ws []models.MaintenanceWindow) *Schedule {
+	s := &Schedule{
+		windows:    make(map[string]models.MaintenanceWindow),
+		suppressed: make(map[string]map[string]bool),
+	}
+	for _, w := range ws {
+		s.windows[w.ID] = w
+	}
+	return s
+}
+
+// Put adds or replaces w. Windows ended by now are dropped.
+func (s *Schedule) Put(w models.MaintenanceWindow, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows[w.ID] = w
+	for id, w := range s.windows {
+		if w.StatusAt(now) == models.MaintenanceEnded {
+			delete(s.windows, id)
+			delete(s.suppressed, id)
+		}
+	}
+}
+
+// Suppress records that ws suppressed the alert with fingerprint and
+// returns those that hadn't already, so an alert Alertmanager keeps
+// re-sending is only counted once per window.
+func (s *Schedule) Suppress(fingerprint string, ws []models.MaintenanceWindow) []models.MaintenanceWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []models.MaintenanceWindow
+	for _, w := range ws {
+		fps := s.suppressed[w.ID]
+		if fps == nil {
+			fps = make(map[string]bool)
+			s.suppressed[w.ID] = fps
+		}
+		if !fps[fingerprint] {
+			fps[fingerprint] = true
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// Covering returns the windows active at now that cover a, or none if
+// any of its services is left out. Services are covered by the windows
+// naming them or their namespace in g, or by the windows reaching them
+// upstream. An alert from a namespace under maintenance is covered
+// whatever its services.
+func (s *Schedule) Covering(a models.Alert, g *models.Graph, now time.Time) []models.MaintenanceWindow {
+	s.mu.Lock()
+	var active []models.MaintenanceWindow
+	for _, w := range s.windows {
+		if w.StatusAt(now) == models.MaintenanceActive {
+			active = append(active, w)
+		}
+	}
+	s.mu.Unlock()
+	if len(active) == 0 {
+		return nil
+	}
+	// Map order would make the windows credited vary
+	slices.SortFunc(active, func(a, b models.MaintenanceWindow) int { return a.StartsAt.Compare(b.StartsAt) })
+
+	if a.Namespace != "" {
+		for _, w := range active {
+			if slices.Contains(w.Namespaces, a.Namespace) {
+				return []models.MaintenanceWindow{w}
+			}
+		}
+	}
+	if len(a.Services) == 0 {
+		return nil
+	}
+	if g == nil {
+		g = &models.Graph{}
+	}
+	reached := make([]map[string]bool, len(active))
+	var out []models.MaintenanceWindow
+	for _, svc := range a.Services {
+		i := slices.IndexFunc(active, func(w models.MaintenanceWindow) bool {
+			return slices.Contains(w.Services, svc)
+		})
+		for j := 0; i < 0 && j < len(active); j++ {
+			if reached[j] == nil {
+				reached[j] = Reach(active[j], g)
+			}
+			if reached[j][svc] {
+				i = j
+			}
+		}
+		if i < 0 {
+			return nil
+		}
+		if !slices.ContainsFunc(out, func(w models.MaintenanceWindow) bool { return w.ID == active[i].ID }) {
+			out = append(out, active[i])
+		}
+	}
+	return out
+}
+
+// Reach returns the services of g that w covers: those it names, those
+// in its namespaces and their callers up to its upstream hops.
+func Reach(w models.MaintenanceWindow, g *models.Graph) map[string]bool {
+	out := make(map[string]bool)
+	for _, s := range w.Services {
+		out[s] = true
+	}
+	for _, s := range g.Services {
+		if s.Namespace != "" && slices.Contains(w.Namespaces, s.Namespace) {
+			out[s.Name] = true
+		}
+	}
+	if w.UpstreamHops > 0 {
+		roots := make([]string, 0, len(out))
+		for s := range out {
+			roots = append(roots, s)
+		}
+		neighbours, _ := graph.Neighbourhood(g, roots, w.UpstreamHops)
+		for _, n := range neighbours {
+			if n.Direction != graph.DirectionDownstream {
+				out[n.Name] = true
+			}
+		}
+	}
+	return out
+}
+
+// NewID returns a random window ID.
+func NewID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "mw-" + hex.EncodeToString(b)
+}
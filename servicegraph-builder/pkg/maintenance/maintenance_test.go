@@ -0,0 +1,117 @@
+package maintenance_test
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"servicegraph-builder/pkg/maintenance"
+	"servicegraph-builder/pkg/models"
+)
+
+var t0 = time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+// topo is frontend → checkout → payments → db, with admin also calling
+// payments, payments and ledger in billing and frontend in web.
+var topo = &models.Graph{
+	Services: []models.Service{
+		{Name: "frontend", Namespace: "web"},
+		{Name: "payments", Namespace: "billing"},
+		{Name: "ledger", Namespace: "billing"},
+	},
+	Edges: []models.GraphEdge{
+		{Edge: models.Edge{Caller: "frontend", Callee: "checkout"}},
+		{Edge: models.Edge{Caller: "checkout", Callee: "payments"}},
+		{Edge: models.Edge{Caller: "admin", Callee: "payments"}},
+		{Edge: models.Edge{Caller: "payments", Callee: "db"}},
+	},
+}
+
+func TestReach(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		w    models.MaintenanceWindow
+		want string
+	}{
+		{"named services", models.MaintenanceWindow{Services: []string{"payments"}}, "payments"},
+		{"one hop upstream", models.MaintenanceWindow{Services: []string{"payments"}, UpstreamHops: 1}, "admin,checkout,payments"},
+		{"two hops upstream", models.MaintenanceWindow{Services: []string{"payments"}, UpstreamHops: 2}, "admin,checkout,frontend,payments"},
+		{"namespace", models.MaintenanceWindow{Namespaces: []string{"billing"}}, "ledger,payments"},
+		{"namespace and a hop upstream", models.MaintenanceWindow{Namespaces: []string{"billing"}, UpstreamHops: 1}, "admin,checkout,ledger,payments"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for s := range maintenance.Reach(tc.w, topo) {
+				got = append(got, s)
+			}
+			slices.Sort(got)
+			if strings.Join(got, ",") != tc.want {
+				t.Errorf("reach %v, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCovering(t *testing.T) {
+	s := maintenance.New([]models.MaintenanceWindow{
+		{ID: "payments", Services: []string{"payments"}, UpstreamHops: 1, StartsAt: t0.Add(-time.Hour), EndsAt: t0.Add(time.Hour)},
+		{ID: "web", Namespaces: []string{"web"}, StartsAt: t0.Add(-time.Minute), EndsAt: t0.Add(time.Hour)},
+		{ID: "later", Services: []string{"batch"}, StartsAt: t0.Add(time.Minute), EndsAt: t0.Add(time.Hour)},
+		{ID: "over", Services: []string{"batch"}, StartsAt: t0.Add(-time.Hour), EndsAt: t0},
+	})
+	for _, tc := range []struct {
+		name      string
+		namespace string
+		services  []string
+		want      []string
+	}{
+		{"named service", "", []string{"payments"}, []string{"payments"}},
+		{"upstream", "", []string{"checkout"}, []string{"payments"}},
+		{"namespace in the graph", "", []string{"frontend"}, []string{"web"}},
+		{"alert namespace", "web", []string{"db"}, []string{"web"}},
+		{"services under several windows", "", []string{"checkout", "frontend"}, []string{"payments", "web"}},
+		{"partly covered", "", []string{"checkout", "db"}, nil},
+		{"downstream", "", []string{"db"}, nil},
+		{"windows not active", "", []string{"batch"}, nil},
+		{"no services", "", nil, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := models.Alert{Fingerprint: "a", Namespace: tc.namespace, Services: tc.services}
+			var got []string
+			for _, w := range s.Covering(a, topo, t0) {
+				got = append(got, w.ID)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("covering windows %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSuppress(t *testing.T) {
+	ws := []models.MaintenanceWindow{{ID: "a"}, {ID: "b"}}
+	s := maintenance.New(ws)
+	ids := func(ws []models.MaintenanceWindow) string {
+		var out []string
+		for _, w := range ws {
+			out = append(out, w.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got := ids(s.Suppress("fp", ws[:1])); got != "a" {
+		t.Errorf("first suppression by %q, want a", got)
+	}
+	// Re-sent, the alert is only new to b
+	if got := ids(s.Suppress("fp", ws)); got != "b" {
+		t.Errorf("re-sent alert suppressed by %q, want b", got)
+	}
+	if got := ids(s.Suppress("fp", ws)); got != "" {
+		t.Errorf("re-sent alert suppressed again by %q", got)
+	}
+	if got := ids(s.Suppress("other", ws)); got != "a,b" {
+		t.Errorf("another alert suppressed by %q, want a,b", got)
+	}
+}
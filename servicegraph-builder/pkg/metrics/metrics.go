@@ -0,0 +1,274 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Builder self-metrics, served on the admin server's /metrics.
+var (
+	// SpansReceived counts spans in incoming OTLP export requests.
+	SpansReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_spans_received_total",
+		Help: "Spans received over OTLP",
+	})
+
+	// SpansFiltered counts spans dropped before reaching storage.
+	SpansFiltered = Counter(
+		"servicegraph_spans_filtered_total",
+		"Spans dropped by filtering rules",
+		"reason",
+	)
+
+	// SpansDeduped counts spans whose edge was already written within the
+	// cache TTL.
+	SpansDeduped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_spans_deduped_total",
+		Help: "Spans skipped because their edge is in the dedup cache",
+	})
+
+	// SamplingDecisions counts ingress sampling decisions. Spans with
+	// reason sampled_out or rate_limited were dropped before enrichment.
+	SamplingDecisions = Counter(
+		"servicegraph_sampling_decisions_total",
+		"Ingress sampling decisions",
+		"reason",
+	)
+
+	// SpansWritten counts spans successfully written to storage.
+	SpansWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_spans_written_total",
+		Help: "Spans written to storage",
+	})
+
+	// StorageWriteLatency tracks storage write latency.
+	StorageWriteLatency = Histogram(
+		"servicegraph_storage_write_duration_seconds",
+		"Latency of storage writes in seconds",
+		prometheus.DefBuckets,
+		"backend",
+	)
+
+	// StorageWriteErrors counts failed storage writes.
+	StorageWriteErrors = Counter(
+		"servicegraph_storage_write_errors_total",
+		"Failed storage writes",
+		"backend",
+	)
+
+	// PipelineQueueDepth is the number of items waiting for each stage of
+	// the span processing pipeline.
+	PipelineQueueDepth = Gauge(
+		"servicegraph_pipeline_queue_depth",
+		"Items queued for each pipeline stage",
+		"stage",
+	)
+
+	// PipelineStageDuration tracks how long each stage takes per item.
+	PipelineStageDuration = Histogram(
+		"servicegraph_pipeline_stage_duration_seconds",
+		"Time spent processing one item in each pipeline stage",
+		prometheus.DefBuckets,
+		"stage",
+	)
+
+	// PipelineDropped counts items a stage removed from the pipeline,
+	// whether filtered, deduplicated, failed or sampled out once counted.
+	PipelineDropped = Counter(
+		"servicegraph_pipeline_dropped_total",
+		"Items that left the pipeline before the last stage",
+		"stage",
+	)
+
+	// StorageConnected is 1 while the storage backend is reachable.
+	StorageConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "servicegraph_storage_connected",
+		Help: "Whether the storage backend is reachable",
+	})
+
+	// SpoolDepth is the number of writes waiting in the write-ahead log.
+	SpoolDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "servicegraph_spool_depth",
+		Help: "Writes spooled to the write-ahead log awaiting replay",
+	})
+
+	// SpoolBytes is the size of the write-ahead log.
+	SpoolBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "servicegraph_spool_bytes",
+		Help: "Size of the write-ahead log in bytes",
+	})
+
+	// SpoolReplayed counts spooled writes replayed into storage.
+	SpoolReplayed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_spool_replayed_total",
+		Help: "Spooled writes replayed into storage",
+	})
+
+	// DLQDepth is the number of spans waiting in the dead-letter queue.
+	DLQDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "servicegraph_dlq_depth",
+		Help: "Spans in the dead-letter queue",
+	})
+
+	// DLQEnqueued counts failures sent to the dead-letter queue.
+	DLQEnqueued = Counter(
+		"servicegraph_dlq_enqueued_total",
+		"Failures sent to the dead-letter queue",
+		"stage",
+	)
+
+	// DLQRetries counts dead-letter retries.
+	DLQRetries = Counter(
+		"servicegraph_dlq_retries_total",
+		"Dead-letter queue retries",
+		"result",
+	)
+
+	// DLQEvicted counts entries dropped to keep the queue under its cap.
+	DLQEvicted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_dlq_evicted_total",
+		Help: "Dead-letter entries evicted by the size cap",
+	})
+
+	// GraphEvents counts graph events reported by anomaly detection.
+	GraphEvents = Counter(
+		"servicegraph_graph_events_total",
+		"Graph events reported by anomaly detection",
+		"kind",
+	)
+
+	// AnomalyBaselineEdges is the number of edges anomaly detection knows.
+	AnomalyBaselineEdges = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "servicegraph_anomaly_baseline_edges",
+		Help: "Edges in the anomaly detection baseline",
+	})
+
+	// WebhookDeliveries counts webhook POSTs by result.
+	WebhookDeliveries = Counter(
+		"servicegraph_webhook_deliveries_total",
+		"Webhook deliveries",
+		"result",
+	)
+
+	// Notifications counts incident notifications by sink and result:
+	// sent, failed or dropped for a full queue.
+	Notifications = Counter(
+		"servicegraph_notifications_total",
+		"Incident notifications",
+		"sink", "result",
+	)
+
+	// IncidentsOpened counts incidents opened from alerts.
+	IncidentsOpened = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_incidents_opened_total",
+		Help: "Incidents opened from received alerts",
+	})
+
+	// StreamSubscribers is the number of clients following incidents.
+	StreamSubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "servicegraph_incident_stream_subscribers",
+		Help: "Clients subscribed to incident progress streams",
+	})
+
+	// StreamDropped counts subscribers cut off for falling behind.
+	StreamDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_incident_stream_dropped_total",
+		Help: "Incident stream subscribers dropped for not keeping up",
+	})
+
+	// PrometheusQueries counts Prometheus API queries by result: ok,
+	// empty or error.
+	PrometheusQueries = Counter(
+		"servicegraph_prometheus_queries_total",
+		"Prometheus API queries",
+		"result",
+	)
+
+	// AlertsReceived counts alerts in Alertmanager notifications.
+	AlertsReceived = Counter(
+		"servicegraph_alerts_received_total",
+		"Alerts received from Alertmanager",
+		"status",
+	)
+
+	// AlertsUnmapped counts received alerts no service could be found for.
+	AlertsUnmapped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_alerts_unmapped_total",
+		Help: "Received alerts whose labels match no graph service",
+	})
+
+	// AlertsSuppressed counts firing alerts kept out of incidents by
+	// maintenance windows.
+	AlertsSuppressed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_alerts_suppressed_total",
+		Help: "Firing alerts suppressed by maintenance windows",
+	})
+
+	// SLOBurnRate is each SLO's error budget burn rate over each alert
+	// window, as of its last evaluation.
+	SLOBurnRate = Gauge(
+		"servicegraph_slo_burn_rate",
+		"Error budget burn rate of each SLO over each burn-rate alert window",
+		"slo", "window",
+	)
+
+	// SLOBudgetRemaining is the share of each SLO's error budget left
+	// over its window.
+	SLOBudgetRemaining = Gauge(
+		"servicegraph_slo_budget_remaining",
+		"Share of each SLO's error budget left over its window",
+		"slo",
+	)
+
+	// SLOAlerts counts burn-rate alerts raised and resolved.
+	SLOAlerts = Counter(
+		"servicegraph_slo_alerts_total",
+		"Burn-rate alerts raised and resolved",
+		"alert", "status",
+	)
+
+	// K8sLookupErrors counts failed Kubernetes metadata lookups.
+	K8sLookupErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "servicegraph_k8s_lookup_errors_total",
+		Help: "Failed Kubernetes metadata lookups",
+	})
+)
+
+// Reasons for SpansFiltered.
+const (
+	FilterHealth         = "health"
+	FilterIgnoredService = "ignored_service"
+	FilterUnknownService = "unknown_service"
+	FilterSelf           = "self"
+)
+
+func Counter(name, help string, labelKeys ...string) *prometheus.CounterVec {
+	return promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: name,
+			Help: help,
+		},
+		labelKeys,
+	)
+}
+
+func Gauge(name, help string, labelKeys ...string) *prometheus.GaugeVec {
+	return promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		},
+		labelKeys,
+	)
+}
+
+func Histogram(name, help string, buckets []float64, labelKeys ...string) *prometheus.HistogramVec {
+	return promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    name,
+			Help:    help,
+			Buckets: buckets,
+		},
+		labelKeys,
+	)
+}
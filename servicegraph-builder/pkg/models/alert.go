@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Alert statuses, as Alertmanager reports them.
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertGroup is one Alertmanager notification group, identified by its
+// group key. Alerts accumulate across notifications.
+type AlertGroup struct {
+	GroupKey          string            `json:"group_key"`
+	Receiver          string            `json:"receiver,omitempty"`
+	Status            string            `json:"status"`
+	GroupLabels       map[string]string `json:"group_labels,omitempty"`
+	CommonLabels      map[string]string `json:"common_labels,omitempty"`
+	CommonAnnotations map[string]string `json:"common_annotations,omitempty"`
+	ExternalURL       string            `json:"external_url,omitempty"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Alert is one alert in a group.
+type Alert struct {
+	Fingerprint  string            `json:"fingerprint"`
+	Name         string            `json:"name"`
+	Status       string            `json:"status"`
+	Severity     string            `json:"severity,omitempty"`
+	Summary      string            `json:"summary,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"starts_at"`
+	EndsAt       time.Time         `json:"ends_at,omitzero"`
+	GeneratorURL string            `json:"generator_url,omitempty"`
+	Namespace    string            `json:"namespace,omitempty"`
+	// Services are the graph nodes the alert fires on.
+	Services []string `json:"services,omitempty"`
+}
+
+// Firing reports whether any alert in g is firing.
+func (g AlertGroup) Firing() bool {
+	for _, a := range g.Alerts {
+		if a.Status == AlertFiring {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Graph event kinds.
+const (
+	// EventNewEdge is a caller → callee pair never seen before.
+	EventNewEdge = "new_edge"
+	// EventVanishedEdge is an established edge that stopped carrying calls.
+	EventVanishedEdge = "vanished_edge"
+	// EventRateShift is an edge whose call rate moved far from its baseline.
+	EventRateShift = "rate_shift"
+	// EventRerouted is a caller that started calling a new callee while
+	// calls to another dried up in the same window.
+	EventRerouted = "rerouted"
+)
+
+// GraphEvent is a change in the observed graph worth a look during root
+// cause analysis. Rates are calls per minute.
+type GraphEvent struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Caller string `json:"caller"`
+	Callee string `json:"callee"`
+	// PreviousCallee is the callee traffic moved away from, for rerouted.
+	PreviousCallee string    `json:"previous_callee,omitempty"`
+	BaselineRate   float64   `json:"baseline_rate"`
+	CurrentRate    float64   `json:"current_rate"`
+	DetectedAt     time.Time `json:"detected_at"`
+	Message        string    `json:"message"`
+}
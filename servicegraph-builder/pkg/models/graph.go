@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+// Edge origins, strongest first: traffic proves an edge, a declaration
+// asserts it, inference only suggests it.
+const (
+	OriginObserved = "observed"
+	OriginDeclared = "declared"
+	OriginInferred = "inferred"
+)
+
+// StrongerOrigin returns whichever of a and b ranks higher. An empty
+// origin is treated as observed, which is what edges written before
+// origins existed are.
+func StrongerOrigin(a, b string) string {
+	rank := func(o string) int {
+		switch o {
+		case OriginDeclared:
+			return 1
+		case OriginInferred:
+			return 0
+		default:
+			return 2
+		}
+	}
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// Graph is a snapshot of the service dependency graph.
+type Graph struct {
+	Services []Service   `json:"services"`
+	Edges    []GraphEdge `json:"edges"`
+}
+
+// Service is a node in the graph.
+type Service struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	OwnerKind string `json:"owner_kind,omitempty"`
+	OwnerName string `json:"owner_name,omitempty"`
+	// Metrics is set when the graph is served with metric snapshots.
+	Metrics *MetricsSnapshot `json:"metrics,omitempty"`
+	// SLOs is set when the graph is served with SLO status.
+	SLOs []SLOStatus `json:"slos,omitempty"`
+}
+
+// GraphEdge is an edge with whatever stats the backend keeps for it.
+type GraphEdge struct {
+	Edge
+	Stats   *EdgeStats       `json:"stats,omitempty"`
+	Metrics *MetricsSnapshot `json:"metrics,omitempty"`
+}
+
+// EdgeStats counts observations of an edge. Spans deduplicated by the
+// builder's cache are not counted.
+type EdgeStats struct {
+	Observations int64     `json:"observations"`
+	FirstSeen    time.Time `json:"first_seen,omitzero"`
+	LastSeen     time.Time `json:"last_seen,omitzero"`
+}
+
+// MetricsSnapshot is a service's or an edge's metrics, read back from
+// Prometheus. A value is nil when Prometheus had no data for it.
+type MetricsSnapshot struct {
+	Window string    `json:"window"`
+	At     time.Time `json:"at"`
+	// RequestRate is in requests per second.
+	RequestRate *float64 `json:"request_rate"`
+	// ErrorRate is in errors per request.
+	ErrorRate *float64 `json:"error_rate"`
+	// LatencyP95 is in seconds.
+	LatencyP95 *float64 `json:"latency_p95_seconds"`
+}
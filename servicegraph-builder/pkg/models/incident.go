@@ -0,0 +1,96 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Incident statuses.
+const (
+	IncidentOpen     = "open"
+	IncidentResolved = "resolved"
+	// IncidentMerged is an incident folded into another once an alert
+	// connected the two; MergedInto names the survivor.
+	IncidentMerged = "merged"
+	// IncidentClosed is an incident closed by hand, firing or not.
+	IncidentClosed = "closed"
+)
+
+// Timeline event kinds. Clients may add their own.
+const (
+	TimelineOpened        = "opened"
+	TimelineAlertFired    = "alert_fired"
+	TimelineAlertResolved = "alert_resolved"
+	TimelineMerged        = "merged"
+	TimelineResolved      = "resolved"
+	TimelineClosed        = "closed"
+	// TimelineChange is a graph event on one of the incident's services.
+	TimelineChange      = "change_detected"
+	TimelineRCAStarted  = "rca_started"
+	TimelineRCAFinished = "rca_finished"
+)
+
+// Incident is a cluster of alerts firing together on services connected
+// in the graph, investigated once rather than alert by alert.
+type Incident struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Title  string `json:"title"`
+	// Origin is the suspected source: the most downstream alerting
+	// service. It is empty when no alert maps to a service.
+	Origin string `json:"origin,omitempty"`
+	// Services are the alerting services, sorted.
+	Services   []string        `json:"services"`
+	OpenedAt   time.Time       `json:"opened_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	ResolvedAt time.Time       `json:"resolved_at,omitzero"`
+	ClosedAt   time.Time       `json:"closed_at,omitzero"`
+	MergedInto string          `json:"merged_into,omitempty"`
+	Alerts     []IncidentAlert `json:"alerts"`
+}
+
+// IncidentAlert is an alert in an incident, deduplicated by fingerprint.
+type IncidentAlert struct {
+	Fingerprint string    `json:"fingerprint"`
+	GroupKey    string    `json:"group_key"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Severity    string    `json:"severity,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Services    []string  `json:"services,omitempty"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at,omitzero"`
+}
+
+// TimelineEvent is something that happened to an incident.
+type TimelineEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Service string    `json:"service,omitempty"`
+	Message string    `json:"message"`
+	// Data is kind-specific detail, e.g. the graph event of a change.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// RCAReport is the outcome of a root cause analysis of an incident.
+type RCAReport struct {
+	// Source names what produced the report, e.g. agents.
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+	Summary   string    `json:"summary"`
+	// RootCause is the service found at fault, if any.
+	RootCause  string  `json:"root_cause,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+	// Details is the producer's full output.
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// IncidentDetail is an incident with everything recorded about it.
+type IncidentDetail struct {
+	Incident
+	// Graph is the neighbourhood of the incident's services when it
+	// opened.
+	Graph    *Graph          `json:"graph,omitempty"`
+	Timeline []TimelineEvent `json:"timeline"`
+	Report   *RCAReport      `json:"report,omitempty"`
+}
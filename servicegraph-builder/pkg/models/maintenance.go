@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Maintenance window statuses, which follow from the time.
+const (
+	MaintenanceScheduled = "scheduled"
+	MaintenanceActive    = "active"
+	MaintenanceEnded     = "ended"
+)
+
+// MaintenanceWindow is planned work on services, during which their
+// alerts don't open or join incidents.
+type MaintenanceWindow struct {
+	ID string `json:"id"`
+	// Status is set when a window is served.
+	Status string `json:"status,omitempty"`
+	// Services and Namespaces are under maintenance: the services named
+	// and all services in the namespaces.
+	Services   []string `json:"services,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// UpstreamHops extends the window to the callers of those services
+	// up to this many calls away, whose alerts are their failures
+	// cascading.
+	UpstreamHops int       `json:"upstream_hops,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	CreatedBy    string    `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	StartsAt     time.Time `json:"starts_at"`
+	EndsAt       time.Time `json:"ends_at"`
+	// ExpiredAt is when the window was ended early, by ExpiredBy.
+	ExpiredAt time.Time `json:"expired_at,omitzero"`
+	ExpiredBy string    `json:"expired_by,omitempty"`
+}
+
+// StatusAt returns the window's status at now.
+func (w *MaintenanceWindow) StatusAt(now time.Time) string {
+	switch {
+	case !now.Before(w.EndsAt):
+		return MaintenanceEnded
+	case now.Before(w.StartsAt):
+		return MaintenanceScheduled
+	}
+	return MaintenanceActive
+}
+
+// Maintenance audit actions.
+const (
+	AuditCreated = "created"
+	AuditExpired = "expired"
+	// AuditSuppressed is an alert kept out of incidents by a window.
+	AuditSuppressed = "suppressed"
+)
+
+// AuditEntry is a change to a maintenance window, or an alert it
+// suppressed.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Window  string    `json:"window"`
+	Actor   string    `json:"actor,omitempty"`
+	Message string    `json:"message"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// SLO kinds.
+const (
+	// SLOAvailability counts calls that didn't fail as good.
+	SLOAvailability = "availability"
+	// SLOLatency counts calls that took at most the threshold as good.
+	SLOLatency = "latency"
+)
+
+// SLO sources, where the good and total calls are counted.
+const (
+	// SLOSourceRED is the builder's own RED stats of the calls into the
+	// service, as the traces show them.
+	SLOSourceRED = "red"
+	// SLOSourcePrometheus is the service's own metrics.
+	SLOSourcePrometheus = "prometheus"
+)
+
+// SLO is a service level objective on a service or one of its endpoints,
+// kept with the graph.
+type SLO struct {
+	ID      string `json:"id"`
+	Service string `json:"service"`
+	// Endpoint narrows the SLO to one operation, e.g. "GET /users";
+	// empty covers all calls into the service. Only Prometheus can tell
+	// endpoints apart.
+	Endpoint string `json:"endpoint,omitempty"`
+	Kind     string `json:"kind"`
+	// Objective is the share of calls that must be good, e.g. 0.999.
+	Objective float64 `json:"objective"`
+	// ThresholdMS is the latency, in milliseconds, good calls of a
+	// latency SLO stay within.
+	ThresholdMS float64 `json:"threshold_ms,omitempty"`
+	// WindowDays is the period the error budget is spent over.
+	WindowDays  int       `json:"window_days"`
+	Source      string    `json:"source"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Budget is the share of calls an SLO allows to be bad.
+func (s *SLO) Budget() float64 {
+	return 1 - s.Objective
+}
+
+// SLOStatus is how fast an SLO spends its error budget, as of At.
+type SLOStatus struct {
+	SLO       string    `json:"slo"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+	Kind      string    `json:"kind"`
+	Objective float64   `json:"objective"`
+	At        time.Time `json:"at"`
+	// Windows are the burn rates over the windows the burn-rate alerts
+	// look at, shortest first.
+	Windows []BurnWindow `json:"windows"`
+	// BudgetRemaining is the share of the error budget left over the
+	// SLO's window, negative once overspent; nil without data.
+	BudgetRemaining *float64 `json:"budget_remaining"`
+	// Alerts name the burn-rate alerts firing, most severe first.
+	Alerts []string `json:"alerts,omitempty"`
+	// Error is why the status is incomplete, if it is.
+	Error string `json:"error,omitempty"`
+}
+
+// Burning reports whether any burn-rate alert is firing on the SLO.
+func (s *SLOStatus) Burning() bool {
+	return len(s.Alerts) > 0
+}
+
+// BurnWindow is an SLO's burn rate over one window: its share of bad
+// calls over the share its objective allows. A burn rate of 1 spends
+// the budget exactly over the SLO's window.
+type BurnWindow struct {
+	Window string `json:"window"`
+	// BadRatio and BurnRate are nil when there were no calls.
+	BadRatio *float64 `json:"bad_ratio"`
+	BurnRate *float64 `json:"burn_rate"`
+}
@@ -1,22 +1,33 @@
 package models
 
 type Span struct {
-	OperationName string
-	Attributes    map[string]string
+	OperationName string            `json:"operation_name"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
 }
 
 type K8sMetadata struct {
-	Namespace string
-	OwnerKind string
-	OwnerName string
-	OwnerUID  string
+	Namespace string `json:"namespace,omitempty"`
+	OwnerKind string `json:"owner_kind,omitempty"`
+	OwnerName string `json:"owner_name,omitempty"`
+	OwnerUID  string `json:"owner_uid,omitempty"`
 }
 
 type EnrichedSpan struct {
 	Span
-	ServiceName   string
-	HashableName  string
-	CallerService string
-	CalleeService string
-	K8sMetadata   K8sMetadata
+	ServiceName   string      `json:"service_name"`
+	HashableName  string      `json:"hashable_name"`
+	CallerService string      `json:"caller_service"`
+	CalleeService string      `json:"callee_service"`
+	K8sMetadata   K8sMetadata `json:"k8s_metadata"`
+}
+
+// Edge is a normalised caller → callee dependency.
+type Edge struct {
+	Caller    string `json:"caller"`
+	Callee    string `json:"callee"`
+	Operation string `json:"operation,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	OwnerKind string `json:"owner_kind,omitempty"`
+	OwnerName string `json:"owner_name,omitempty"`
+	Origin    string `json:"origin,omitempty"`
 }
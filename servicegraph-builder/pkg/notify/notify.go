@@ -0,0 +1,306 @@
+// Package notify tells people about incidents: it sends their events to
+// sinks, generic JSON webhooks, Slack incoming webhooks and email, each
+// choosing the events, severities and namespaces it wants.
+//
+// Sending happens in the background, so a slow or failing sink never
+// holds up the incident it reports on. Each sink retries transient
+// failures with backoff.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// queueLen is how many notifications may wait to be sent before more are
+// dropped.
+const queueLen = 256
+
+// Notification is an incident event, and what templates render.
+type Notification struct {
+	// Event is one of the config.Notify events.
+	Event    string          `json:"event"`
+	Time     time.Time       `json:"time"`
+	Incident models.Incident `json:"incident"`
+	// Severity is the most severe of the incident's alerts'.
+	Severity string `json:"severity,omitempty"`
+	// Namespaces are those of the incident's services, sorted.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Graph is the neighbourhood of the incident's services.
+	Graph *models.Graph `json:"graph,omitempty"`
+	// Report is set on report events.
+	Report *models.RCAReport `json:"report,omitempty"`
+	// URL links to the incident in the frontend, if its address is
+	// configured.
+	URL string `json:"url,omitempty"`
+}
+
+// Sink delivers notifications somewhere.
+type Sink interface {
+	Send(ctx context.Context, n *Notification) error
+}
+
+// route is a sink and the notifications it wants.
+type route struct {
+	cfg  config.SinkConfig
+	sink Sink
+}
+
+type job struct {
+	n      Notification
+	routes []*route
+}
+
+// Notifier is safe for concurrent use.
+type Notifier struct {
+	baseURL string
+	routes  []*route
+	queue   chan job
+
+	mu      sync.RWMutex
+	stopped bool
+	// ctx is cancelled when Stop gives up waiting, cutting sends short
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New returns a notifier for the sinks of cfg. It fails if a sink's
+// templates don't parse.
+func New(cfg config.NotificationsConfig) (*Notifier, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		queue:   make(chan job, queueLen),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	for i, sc := range cfg.Sinks {
+		sink, err := newSink(sc)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("notifications.sinks[%d]: %w", i, err)
+		}
+		n.routes = append(n.routes, &route{cfg: sc, sink: sink})
+	}
+	return n, nil
+}
+
+// newSink returns the sink sc configures.
+func newSink(sc config.SinkConfig) (Sink, error) {
+	switch sc.Type {
+	case config.SinkWebhook:
+		return newWebhookSink(sc)
+	case config.SinkSlack:
+		return newSlackSink(sc)
+	case config.SinkEmail:
+		return newEmailSink(sc)
+	}
+	return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+}
+
+// Start launches the sender.
+func (n *Notifier) Start() {
+	go n.run()
+}
+
+// Notify queues ev on inc for the sinks that want it. g, if set, is the
+// neighbourhood of the incident's services and rep its report.
+func (n *Notifier) Notify(ev string, inc models.Incident, g *models.Graph, rep *models.RCAReport) {
+	x := Notification{
+		Event:      ev,
+		Time:       time.Now().UTC(),
+		Incident:   inc,
+		Severity:   severity(inc),
+		Namespaces: namespaces(inc, g),
+		Graph:      g,
+		Report:     rep,
+	}
+	if n.baseURL != "" {
+		x.URL = n.baseURL + "/incidents/" + inc.ID
+	}
+	var routes []*route
+	for _, r := range n.routes {
+		if r.wants(&x) {
+			routes = append(routes, r)
+		}
+	}
+	if len(routes) == 0 {
+		return
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.stopped {
+		return
+	}
+	select {
+	case n.queue <- job{n: x, routes: routes}:
+	default:
+		for _, r := range routes {
+			metrics.Notifications.WithLabelValues(r.cfg.Name, "dropped").Inc()
+		}
+		log.Warn().Str("incident", inc.ID).Str("event", ev).Msg("Notification queue full, dropping notification")
+	}
+}
+
+// Stop stops accepting notifications and waits for queued ones to be
+// sent, or for ctx to be done, when the rest are abandoned.
+func (n *Notifier) Stop(ctx context.Context) error {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return nil
+	}
+	n.stopped = true
+	close(n.queue)
+	n.mu.Unlock()
+
+	select {
+	case <-n.done:
+		n.cancel()
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		return ctx.Err()
+	}
+}
+
+// run sends queued notifications in order, to all their sinks at once.
+func (n *Notifier) run() {
+	defer close(n.done)
+	for j := range n.queue {
+		var wg sync.WaitGroup
+		for _, r := range j.routes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.send(n.ctx, &j.n)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func (r *route) send(ctx context.Context, n *Notification) {
+	if err := r.sink.Send(ctx, n); err != nil {
+		metrics.Notifications.WithLabelValues(r.cfg.Name, "failed").Inc()
+		log.Error().Err(err).
+			Str("sink", r.cfg.Name).
+			Str("incident", n.Incident.ID).
+			Str("event", n.Event).
+			Msg("Failed to send notification")
+		return
+	}
+	metrics.Notifications.WithLabelValues(r.cfg.Name, "sent").Inc()
+}
+
+// wants reports whether the sink takes n.
+func (r *route) wants(n *Notification) bool {
+	c := r.cfg
+	if len(c.Events) > 0 && !slices.Contains(c.Events, n.Event) {
+		return false
+	}
+	if c.MinSeverity != "" && rank(n.Severity) < rank(c.MinSeverity) {
+		return false
+	}
+	if len(c.Namespaces) > 0 && !slices.ContainsFunc(n.Namespaces, func(ns string) bool {
+		return slices.Contains(c.Namespaces, ns)
+	}) {
+		return false
+	}
+	return true
+}
+
+// severity returns the most severe of inc's alerts' severities. Those
+// not in config.Severities rank lowest.
+func severity(inc models.Incident) string {
+	out := ""
+	for _, a := range inc.Alerts {
+		if a.Severity != "" && (out == "" || rank(a.Severity) > rank(out)) {
+			out = a.Severity
+		}
+	}
+	return out
+}
+
+func rank(severity string) int {
+	return slices.Index(config.Severities, strings.ToLower(severity))
+}
+
+// namespaces returns the namespaces g puts inc's services in.
+func namespaces(inc models.Incident, g *models.Graph) []string {
+	if g == nil {
+		return nil
+	}
+	var out []string
+	for _, s := range g.Services {
+		if s.Namespace != "" && slices.Contains(inc.Services, s.Name) && !slices.Contains(out, s.Namespace) {
+			out = append(out, s.Namespace)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// funcs are available to templates besides the built-in ones.
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	// json renders a value as JSON, for webhook bodies
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+}
+
+// parse parses text, or def if text is empty.
+func parse(name, text, def string) (*template.Template, error) {
+	if text == "" {
+		text = def
+	}
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+func render(t *template.Template, n *Notification) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, n); err != nil {
+		return "", fmt.Errorf("render %s: %w", t.Name(), err)
+	}
+	return b.String(), nil
+}
+
+// defaultText is the message of Slack and email sinks.
+const defaultText = `[{{upper .Event}}] {{.Incident.Title}}
+Incident {{.Incident.ID}} is {{.Incident.Status}}{{with .Severity}}, severity {{.}}{{end}}{{with .URL}}: {{.}}{{end}}
+Services: {{join .Incident.Services ", "}}{{with .Incident.Origin}}, suspected origin {{.}}{{end}}{{with .Namespaces}}
+Namespaces: {{join . ", "}}{{end}}
+{{- with .Report}}
+Root cause ({{.Source}}): {{or .RootCause "unknown"}}{{if .Confidence}}, confidence {{printf "%.2f" .Confidence}}{{end}}
+{{.Summary}}
+{{- end}}
+Alerts:
+{{- range .Incident.Alerts}}
+- {{.Name}} {{.Status}}{{with .Services}} on {{join . ", "}}{{end}}{{with .Summary}}: {{.}}{{end}}
+{{- end}}
+{{- with .Graph}}{{if .Edges}}
+Calls:
+{{- range .Edges}}
+- {{.Caller}} → {{.Callee}}{{with .Operation}} ({{.}}){{end}}
+{{- end}}{{end}}{{end}}
+`
+
+const defaultSubject = `[{{.Event}}] {{.Incident.Title}}`
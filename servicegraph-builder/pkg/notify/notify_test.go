@@ -0,0 +1,297 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/notify"
+	"servicegraph-builder/pkg/notify/notifytest"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	incident = models.Incident{
+		ID:       "inc-1",
+		Status:   models.IncidentOpen,
+		Title:    "payments failing",
+		Origin:   "payments",
+		Services: []string{"frontend", "payments"},
+		Alerts: []models.IncidentAlert{
+			{Fingerprint: "a", Name: "HighErrorRate", Status: models.AlertFiring, Severity: "critical", Services: []string{"payments"}},
+			{Fingerprint: "b", Name: "HighLatency", Status: models.AlertFiring, Severity: "warning", Services: []string{"frontend"}},
+		},
+	}
+	graph = &models.Graph{
+		Services: []models.Service{{Name: "frontend", Namespace: "web"}, {Name: "payments", Namespace: "billing"}},
+		Edges:    []models.GraphEdge{{Edge: models.Edge{Caller: "frontend", Callee: "payments", Operation: "POST /pay"}}},
+	}
+)
+
+// notifier starts a notifier for sinks. Stop it, or let the test's
+// cleanup, to have what it queued sent.
+func notifier(t *testing.T, sinks ...config.SinkConfig) *notify.Notifier {
+	t.Helper()
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	cfg := config.NotificationsConfig{BaseURL: "https://graph.example.com/", Sinks: sinks}
+	n, err := notify.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Stop(context.Background()) })
+	return n
+}
+
+func webhookSink(t *testing.T, name, typ string) (config.SinkConfig, *notifytest.Webhook) {
+	t.Helper()
+	srv := notifytest.NewWebhook()
+	t.Cleanup(srv.Close)
+	return config.SinkConfig{Name: name, Type: typ, Webhook: config.WebhookConfig{URL: srv.URL}}, srv
+}
+
+func stop(t *testing.T, n *notify.Notifier) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := n.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	sc, srv := webhookSink(t, "hook", config.SinkWebhook)
+	sc.Webhook.Headers = map[string]string{"Authorization": "Bearer t0ken"}
+	n := notifier(t, sc)
+	n.Start()
+	rep := &models.RCAReport{RootCause: "payments"}
+	n.Notify(config.NotifyReport, incident, graph, rep)
+	stop(t, n)
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("webhook took %d requests, want 1", len(reqs))
+	}
+	if got := reqs[0].Header.Get("Authorization"); got != "Bearer t0ken" {
+		t.Errorf("Authorization header %q, want the configured one", got)
+	}
+	var got notify.Notification
+	if err := json.Unmarshal(reqs[0].Body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != config.NotifyReport || got.Incident.ID != incident.ID {
+		t.Errorf("notification of %s on %s, want %s on %s", got.Event, got.Incident.ID, config.NotifyReport, incident.ID)
+	}
+	if got.Severity != "critical" {
+		t.Errorf("severity %q, want the most severe alert's", got.Severity)
+	}
+	if strings.Join(got.Namespaces, ",") != "billing,web" {
+		t.Errorf("namespaces %v, want billing and web", got.Namespaces)
+	}
+	if got.URL != "https://graph.example.com/incidents/inc-1" {
+		t.Errorf("URL %q", got.URL)
+	}
+	if got.Report == nil || got.Report.RootCause != "payments" {
+		t.Errorf("report %+v, want the one notified", got.Report)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	sc, srv := webhookSink(t, "hook", config.SinkWebhook)
+	sc.Template = `{"id": {{json .Incident.ID}}, "services": {{json .Incident.Services}}}`
+	bad, badSrv := webhookSink(t, "bad", config.SinkWebhook)
+	bad.Template = `id={{.Incident.ID}}`
+	n := notifier(t, sc, bad)
+	n.Start()
+	n.Notify(config.NotifyOpened, incident, nil, nil)
+	stop(t, n)
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 || string(reqs[0].Body) != `{"id": "inc-1", "services": ["frontend","payments"]}` {
+		t.Errorf("templated webhook took %v", reqs)
+	}
+	// A template not rendering JSON is never sent
+	if reqs := badSrv.Requests(); len(reqs) != 0 {
+		t.Errorf("webhook with a non-JSON template took %d requests", len(reqs))
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	sc, srv := webhookSink(t, "hook", config.SinkWebhook)
+	srv.Fail(1, 503)
+	n := notifier(t, sc)
+	n.Start()
+	n.Notify(config.NotifyOpened, incident, nil, nil)
+	stop(t, n)
+
+	if reqs := srv.Requests(); len(reqs) != 2 {
+		t.Errorf("webhook took %d requests, want a failed one and its retry", len(reqs))
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	sc, srv := webhookSink(t, "chat", config.SinkSlack)
+	n := notifier(t, sc)
+	n.Start()
+	n.Notify(config.NotifyOpened, incident, graph, nil)
+	stop(t, n)
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("slack webhook took %d requests, want 1", len(reqs))
+	}
+	var msg struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(reqs[0].Body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"[OPENED] payments failing",
+		"Incident inc-1 is open, severity critical: https://graph.example.com/incidents/inc-1",
+		"Services: frontend, payments, suspected origin payments",
+		"Namespaces: billing, web",
+		"- HighErrorRate firing on payments",
+		"- frontend → payments (POST /pay)",
+	} {
+		if !strings.Contains(msg.Text, want) {
+			t.Errorf("slack text lacks %q:\n%s", want, msg.Text)
+		}
+	}
+}
+
+func emailSink(t *testing.T) (config.SinkConfig, *notifytest.SMTP) {
+	t.Helper()
+	srv, err := notifytest.NewSMTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return config.SinkConfig{Name: "mail", Type: config.SinkEmail, SMTP: config.SMTPConfig{
+		Address: srv.Addr,
+		From:    "graph@example.com",
+		To:      []string{"oncall@example.com", "sre@example.com"},
+	}}, srv
+}
+
+func TestEmailDelivery(t *testing.T) {
+	sc, srv := emailSink(t)
+	sc.Subject = "{{.Incident.Title}}\nBcc: someone@example.com"
+	n := notifier(t, sc)
+	n.Start()
+	n.Notify(config.NotifyResolved, incident, nil, nil)
+	stop(t, n)
+
+	mails := srv.Mails()
+	if len(mails) != 1 {
+		t.Fatalf("SMTP server took %d mails, want 1", len(mails))
+	}
+	m := mails[0]
+	if m.From != "graph@example.com" || strings.Join(m.To, ",") != "oncall@example.com,sre@example.com" {
+		t.Errorf("mail from %s to %v", m.From, m.To)
+	}
+	header, body, _ := strings.Cut(m.Data, "\r\n\r\n")
+	if !strings.Contains(header, "To: oncall@example.com, sre@example.com\r\n") {
+		t.Errorf("mail header lacks the recipients:\n%s", header)
+	}
+	// The subject's line break is encoded rather than starting a header
+	if strings.Contains(header, "\r\nBcc:") || !strings.Contains(header, "Subject: =?utf-8?q?payments_failing") {
+		t.Errorf("subject not encoded:\n%s", header)
+	}
+	if !strings.Contains(body, "[RESOLVED] payments failing\r\n") {
+		t.Errorf("mail body:\n%s", body)
+	}
+}
+
+func TestEmailRetries(t *testing.T) {
+	sc, srv := emailSink(t)
+	srv.Fail(1, "451 try again later")
+	n := notifier(t, sc)
+	n.Start()
+	n.Notify(config.NotifyOpened, incident, nil, nil)
+	stop(t, n)
+	if got := len(srv.Mails()); got != 1 {
+		t.Errorf("SMTP server took %d mails after a transient refusal, want 1", got)
+	}
+
+	// Permanent refusals aren't retried
+	sc, srv = emailSink(t)
+	srv.Fail(1, "550 no such user")
+	n = notifier(t, sc)
+	n.Start()
+	n.Notify(config.NotifyOpened, incident, nil, nil)
+	stop(t, n)
+	if got := len(srv.Mails()); got != 0 {
+		t.Errorf("SMTP server took %d mails after a permanent refusal, want none", got)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	all, allSrv := webhookSink(t, "all", config.SinkWebhook)
+	reports, reportsSrv := webhookSink(t, "reports", config.SinkWebhook)
+	reports.Events = []string{config.NotifyReport}
+	critical, criticalSrv := webhookSink(t, "critical", config.SinkWebhook)
+	critical.MinSeverity = "critical"
+	billing, billingSrv := webhookSink(t, "billing", config.SinkWebhook)
+	billing.Namespaces = []string{"billing"}
+	n := notifier(t, all, reports, critical, billing)
+	n.Start()
+
+	warning := incident
+	warning.ID = "inc-2"
+	warning.Alerts = incident.Alerts[1:]
+	warning.Services = []string{"frontend"}
+	n.Notify(config.NotifyOpened, incident, graph, nil)
+	n.Notify(config.NotifyOpened, warning, graph, nil)
+	n.Notify(config.NotifyReport, warning, graph, &models.RCAReport{})
+	// Without a graph, namespaces are unknown
+	n.Notify(config.NotifyClosed, incident, nil, nil)
+	stop(t, n)
+
+	for name, tc := range map[string]struct {
+		srv  *notifytest.Webhook
+		want []string
+	}{
+		"all":      {allSrv, []string{"opened inc-1", "opened inc-2", "report inc-2", "closed inc-1"}},
+		"reports":  {reportsSrv, []string{"report inc-2"}},
+		"critical": {criticalSrv, []string{"opened inc-1", "closed inc-1"}},
+		"billing":  {billingSrv, []string{"opened inc-1"}},
+	} {
+		var got []string
+		for _, r := range tc.srv.Requests() {
+			var x notify.Notification
+			if err := json.Unmarshal(r.Body, &x); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, x.Event+" "+x.Incident.ID)
+		}
+		if strings.Join(got, ", ") != strings.Join(tc.want, ", ") {
+			t.Errorf("%s sink took %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	sc, srv := webhookSink(t, "hook", config.SinkWebhook)
+	n := notifier(t, sc)
+	// Not started, so nothing leaves the queue until it's full
+	const queueLen = 256
+	for range queueLen + 10 {
+		n.Notify(config.NotifyOpened, incident, nil, nil)
+	}
+	n.Start()
+	stop(t, n)
+
+	if got := len(srv.Requests()); got != queueLen {
+		t.Errorf("webhook took %d requests, want the %d queued before the queue filled", got, queueLen)
+	}
+	// Notifications after Stop are dropped
+	n.Notify(config.NotifyClosed, incident, nil, nil)
+	if got := len(srv.Requests()); got != queueLen {
+		t.Errorf("webhook took %d requests after Stop, want %d", got, queueLen)
+	}
+}
@@ -0,0 +1,215 @@
+// Package notifytest provides stub webhook and SMTP servers for
+// exercising notification sinks without real endpoints.
+package notifytest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Request is a request the webhook stub took.
+type Request struct {
+	Header http.Header
+	Body   []byte
+}
+
+// Webhook records the requests POSTed to it. Close it when done.
+type Webhook struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []Request
+	failures int
+	status   int
+}
+
+// NewWebhook starts a stub webhook answering 200.
+func NewWebhook() *Webhook {
+	s := &Webhook{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Fail makes the next n requests fail with status, e.g. 503 to have them
+// retried.
+func (s *Webhook) Fail(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures, s.status = n, status
+}
+
+// Requests returns the requests taken so far, failed ones included, in
+// order.
+func (s *Webhook) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Webhook) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Header: r.Header.Clone(), Body: body})
+	fail := s.failures > 0
+	if fail {
+		s.failures--
+	}
+	status := s.status
+	s.mu.Unlock()
+	if fail {
+		w.WriteHeader(status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Mail is a message the SMTP stub took.
+type Mail struct {
+	From string
+	To   []string
+	// Data is the message as sent, headers and body, with CRLF line
+	// endings.
+	Data string
+}
+
+// SMTP is a plain SMTP server that takes every message, with no TLS or
+// authentication. Close it when done.
+type SMTP struct {
+	// Addr is the host:port it listens on.
+	Addr string
+
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	mails    []Mail
+	failures int
+	reply    string
+}
+
+// NewSMTP starts a stub SMTP server on a local port.
+func NewSMTP() (*SMTP, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &SMTP{Addr: ln.Addr().String(), ln: ln}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Fail makes the next n messages be refused with reply, e.g.
+// "451 try again later".
+func (s *SMTP) Fail(n int, reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures, s.reply = n, reply
+}
+
+// Mails returns the messages taken so far, in order.
+func (s *SMTP) Mails() []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Mail(nil), s.mails...)
+}
+
+// Close stops listening and waits for open sessions to end.
+func (s *SMTP) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *SMTP) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.session(conn)
+		}()
+	}
+}
+
+// session speaks just enough SMTP for net/smtp.
+func (s *SMTP) session(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+	reply("220 notifytest ready")
+	var m Mail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250 notifytest")
+		case "MAIL":
+			m = Mail{From: address(arg)}
+			reply("250 OK")
+		case "RCPT":
+			m.To = append(m.To, address(arg))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				// Undo dot-stuffing
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			m.Data = data.String()
+			s.mu.Lock()
+			fail := s.failures > 0
+			if fail {
+				s.failures--
+			} else {
+				s.mails = append(s.mails, m)
+			}
+			refusal := s.reply
+			s.mu.Unlock()
+			if fail {
+				reply(refusal)
+				continue
+			}
+			reply("250 OK: queued")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// address takes the address out of a MAIL FROM:<a> or RCPT TO:<a>
+// argument.
+func address(arg string) string {
+	_, a, _ := strings.Cut(arg, ":")
+	a, _, _ = strings.Cut(strings.TrimSpace(a), " ")
+	return strings.Trim(a, "<>")
+}
@@ -0,0 +1,221 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/webhook"
+)
+
+const (
+	// attempts is how many times an email is sent before giving up.
+	attempts = 3
+	// retryDelay is the wait after the first failed attempt, doubling
+	// after each further one.
+	retryDelay = time.Second
+)
+
+// webhookSink POSTs notifications as JSON, by default the notification
+// itself.
+type webhookSink struct {
+	client *webhook.Client
+	body   *template.Template
+}
+
+func newWebhookSink(sc config.SinkConfig) (*webhookSink, error) {
+	s := &webhookSink{client: webhook.New(withTimeout(sc.Webhook))}
+	if sc.Template != "" {
+		t, err := parse("template", sc.Template, "")
+		if err != nil {
+			return nil, err
+		}
+		s.body = t
+	}
+	return s, nil
+}
+
+func (s *webhookSink) Send(ctx context.Context, n *Notification) error {
+	if s.body == nil {
+		return s.client.Post(ctx, n)
+	}
+	body, err := render(s.body, n)
+	if err != nil {
+		return err
+	}
+	if !json.Valid([]byte(body)) {
+		return errors.New("template did not render JSON")
+	}
+	return s.client.PostRaw(ctx, []byte(body))
+}
+
+// slackSink posts notifications as incoming-webhook messages, which
+// Slack and chat servers mimicking it take.
+type slackSink struct {
+	client *webhook.Client
+	text   *template.Template
+}
+
+func newSlackSink(sc config.SinkConfig) (*slackSink, error) {
+	t, err := parse("template", sc.Template, defaultText)
+	if err != nil {
+		return nil, err
+	}
+	return &slackSink{client: webhook.New(withTimeout(sc.Webhook)), text: t}, nil
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+func (s *slackSink) Send(ctx context.Context, n *Notification) error {
+	text, err := render(s.text, n)
+	if err != nil {
+		return err
+	}
+	return s.client.Post(ctx, slackMessage{Text: text})
+}
+
+func withTimeout(w config.WebhookConfig) config.WebhookConfig {
+	if w.Timeout == 0 {
+		w.Timeout = config.DefaultSinkTimeout
+	}
+	return w
+}
+
+// emailSink mails notifications as plain text.
+type emailSink struct {
+	cfg     config.SMTPConfig
+	subject *template.Template
+	body    *template.Template
+}
+
+func newEmailSink(sc config.SinkConfig) (*emailSink, error) {
+	subject, err := parse("subject", sc.Subject, defaultSubject)
+	if err != nil {
+		return nil, err
+	}
+	body, err := parse("template", sc.Template, defaultText)
+	if err != nil {
+		return nil, err
+	}
+	s := &emailSink{cfg: sc.SMTP, subject: subject, body: body}
+	if s.cfg.Timeout == 0 {
+		s.cfg.Timeout = config.DefaultSinkTimeout
+	}
+	return s, nil
+}
+
+// Send mails n. Connection failures and 4xx replies are retried; 5xx
+// replies, which the server will give again, are not.
+func (s *emailSink) Send(ctx context.Context, n *Notification) error {
+	subject, err := render(s.subject, n)
+	if err != nil {
+		return err
+	}
+	body, err := render(s.body, n)
+	if err != nil {
+		return err
+	}
+	msg := s.message(subject, body, n.Time)
+	delay := retryDelay
+	for i := 1; ; i++ {
+		err := s.deliver(ctx, msg)
+		if err == nil || permanent(err) || i == attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (s *emailSink) message(subject, body string, t time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	// Encoding also keeps line breaks in rendered subjects out of the
+	// headers
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", t.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	// The DATA writer turns line feeds into CRLFs
+	b.WriteString(body)
+	return b.Bytes()
+}
+
+func (s *emailSink) deliver(ctx context.Context, msg []byte) error {
+	host, _, err := net.SplitHostPort(s.cfg.Address)
+	if err != nil {
+		return err
+	}
+	d := net.Dialer{Timeout: s.cfg.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", s.cfg.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		// PlainAuth refuses to send credentials in the clear, except to
+		// localhost
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range s.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// permanent reports whether err is an SMTP reply saying the mail will
+// never be taken.
+func permanent(err error) bool {
+	var te *textproto.Error
+	return errors.As(err, &te) && te.Code >= 500
+}
@@ -0,0 +1,189 @@
+package otlpfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Dump formats.
+const (
+	FormatProtobuf = "protobuf"
+	FormatJSON     = "json"
+)
+
+// maxLineSize bounds a single JSON line from the collector's file exporter.
+const maxLineSize = 64 << 20
+
+// Expand returns the dump files named by paths. Directories expand to the
+// regular files directly inside them, in name order, which for recorded
+// dumps is arrival order.
+func Expand(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, e := range entries {
+			if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+				names = append(names, filepath.Join(p, e.Name()))
+			}
+		}
+		sort.Strings(names)
+		files = append(files, names...)
+	}
+	return files, nil
+}
+
+// ReadFile decodes the export requests in path. Files ending in .json or
+// .jsonl hold OTLP JSON: either a single request or one per line, as the
+// collector's file exporter writes them. Any other file is one binary
+// protobuf ExportTraceServiceRequest.
+func ReadFile(path string) ([]*coltracepb.ExportTraceServiceRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonl":
+		return decodeJSON(data)
+	default:
+		req := &coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			return nil, fmt.Errorf("decode protobuf: %w", err)
+		}
+		return []*coltracepb.ExportTraceServiceRequest{req}, nil
+	}
+}
+
+func decodeJSON(data []byte) ([]*coltracepb.ExportTraceServiceRequest, error) {
+	if req, err := unmarshalJSON(data); err == nil {
+		return []*coltracepb.ExportTraceServiceRequest{req}, nil
+	}
+
+	var reqs []*coltracepb.ExportTraceServiceRequest
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, maxLineSize)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		req, err := unmarshalJSON(sc.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, sc.Err()
+}
+
+// unmarshalJSON decodes one OTLP JSON request. OTLP JSON encodes trace and
+// span IDs as hex while protojson expects base64, so IDs are converted
+// first.
+func unmarshalJSON(data []byte) (*coltracepb.ExportTraceServiceRequest, error) {
+	// UseNumber keeps nanosecond timestamps exact through the round trip
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after request")
+	}
+	fixIDs(raw)
+	fixed, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	req := &coltracepb.ExportTraceServiceRequest{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(fixed, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func fixIDs(v any) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			s, ok := val.(string)
+			switch {
+			case ok && (k == "traceId" || k == "spanId" || k == "parentSpanId"):
+				if b, err := hex.DecodeString(s); err == nil && (len(b) == 16 || len(b) == 8) {
+					t[k] = base64.StdEncoding.EncodeToString(b)
+				}
+			default:
+				fixIDs(val)
+			}
+		}
+	case []any:
+		for _, val := range t {
+			fixIDs(val)
+		}
+	}
+}
+
+// Recorder writes every export request to its own file in a directory
+// so it can be replayed later.
+type Recorder struct {
+	dir    string
+	format string
+	seq    atomic.Uint64
+}
+
+// NewRecorder creates dir if needed.
+func NewRecorder(dir, format string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create record dir: %w", err)
+	}
+	return &Recorder{dir: dir, format: format}, nil
+}
+
+// Record writes req atomically, named so files sort in arrival order.
+func (r *Recorder) Record(req *coltracepb.ExportTraceServiceRequest) error {
+	var data []byte
+	var err error
+	ext := ".binpb"
+	if r.format == FormatJSON {
+		data, err = protojson.Marshal(req)
+		ext = ".json"
+	} else {
+		data, err = proto.Marshal(req)
+	}
+	if err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), r.seq.Add(1), ext)
+	tmp := filepath.Join(r.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(r.dir, name))
+}
@@ -0,0 +1,167 @@
+// Package pipeline runs items through a chain of stages, each with its
+// own worker pool and queues.
+//
+// Every stage shards items across its workers by key, and each worker
+// handles its shard in arrival order. Items with the same key therefore
+// pass through every stage in the order they were submitted, while items
+// with different keys proceed in parallel and a slow item only holds up
+// the keys that share its shard.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/metrics"
+)
+
+// ErrStopped is returned by Submit after Stop.
+var ErrStopped = errors.New("pipeline stopped")
+
+// Stage is one step of a Pipeline.
+type Stage[T any] struct {
+	Name string
+	// Workers is the number of goroutines, and shards, for the stage.
+	Workers int
+	// Process handles one item and returns false to drop it from the
+	// rest of the pipeline.
+	Process func(item T) bool
+}
+
+// Pipeline passes items through its stages in order. Done is called
+// exactly once per submitted item, after the last stage or when a stage
+// drops it.
+type Pipeline[T any] struct {
+	stages []Stage[T]
+	// queues[i][w] feeds worker w of stage i
+	queues [][]chan T
+	key    func(T) string
+	done   func(T)
+
+	mu      sync.RWMutex
+	stopped bool
+	workers []sync.WaitGroup
+}
+
+// New returns a pipeline over stages. key picks an item's shard and done
+// is called when an item leaves the pipeline. Each worker queue holds up
+// to queueSize items; a full queue blocks the stage feeding it.
+func New[T any](queueSize int, key func(T) string, done func(T), stages ...Stage[T]) *Pipeline[T] {
+	p := &Pipeline[T]{
+		stages:  stages,
+		queues:  make([][]chan T, len(stages)),
+		key:     key,
+		done:    done,
+		workers: make([]sync.WaitGroup, len(stages)),
+	}
+	for i := range stages {
+		if stages[i].Workers < 1 {
+			stages[i].Workers = 1
+		}
+		p.queues[i] = make([]chan T, stages[i].Workers)
+		for w := range p.queues[i] {
+			p.queues[i][w] = make(chan T, queueSize)
+		}
+	}
+	return p
+}
+
+// Start launches the stage workers.
+func (p *Pipeline[T]) Start() {
+	for i := range p.stages {
+		for w := range p.queues[i] {
+			p.workers[i].Add(1)
+			go p.work(i, p.queues[i][w])
+		}
+	}
+}
+
+// Submit queues item for the first stage, blocking while its queue is
+// full until ctx is done.
+func (p *Pipeline[T]) Submit(ctx context.Context, item T) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return ErrStopped
+	}
+	depth := metrics.PipelineQueueDepth.WithLabelValues(p.stages[0].Name)
+	depth.Inc()
+	select {
+	case p.shard(0, item) <- item:
+		return nil
+	case <-ctx.Done():
+		depth.Dec()
+		return ctx.Err()
+	}
+}
+
+// Stop stops accepting items and waits for queued ones to finish, or for
+// ctx to be done.
+func (p *Pipeline[T]) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return nil
+	}
+	p.stopped = true
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		// Close each stage once the one before it has finished, so
+		// nothing is sent on a closed queue
+		for i := range p.stages {
+			for _, q := range p.queues[i] {
+				close(q)
+			}
+			p.workers[i].Wait()
+		}
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pipeline[T]) work(stage int, queue chan T) {
+	defer p.workers[stage].Done()
+	st := p.stages[stage]
+	depth := metrics.PipelineQueueDepth.WithLabelValues(st.Name)
+	duration := metrics.PipelineStageDuration.WithLabelValues(st.Name)
+	dropped := metrics.PipelineDropped.WithLabelValues(st.Name)
+
+	for item := range queue {
+		depth.Dec()
+		start := time.Now()
+		keep := st.Process(item)
+		duration.Observe(time.Since(start).Seconds())
+
+		if !keep {
+			dropped.Inc()
+			p.done(item)
+			continue
+		}
+		if stage == len(p.stages)-1 {
+			p.done(item)
+			continue
+		}
+		metrics.PipelineQueueDepth.WithLabelValues(p.stages[stage+1].Name).Inc()
+		p.shard(stage+1, item) <- item
+	}
+}
+
+func (p *Pipeline[T]) shard(stage int, item T) chan T {
+	queues := p.queues[stage]
+	if len(queues) == 1 {
+		return queues[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(p.key(item)))
+	return queues[h.Sum32()%uint32(len(queues))]
+}
@@ -0,0 +1,252 @@
+// Package prom reads service and edge metrics back from the Prometheus
+// HTTP API as snapshots for the graph.
+package prom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/metrics"
+	"servicegraph-builder/pkg/models"
+)
+
+// maxResponseBytes bounds a query response. Snapshot queries return a
+// single sample.
+const maxResponseBytes = 1 << 20
+
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// Client is safe for concurrent use.
+type Client struct {
+	cfg     config.PrometheusConfig
+	base    *url.URL
+	http    *http.Client
+	queries []query
+}
+
+type query struct {
+	tmpl *template.Template
+	// set stores a result in a snapshot.
+	set func(*models.MetricsSnapshot, *float64)
+}
+
+// New returns a client for cfg.URL. Empty queries are skipped.
+func New(cfg config.PrometheusConfig) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("prometheus url: %w", err)
+	}
+	c := &Client{cfg: cfg, base: base, http: &http.Client{Timeout: cfg.Timeout}}
+	for _, q := range []struct {
+		name, expr string
+		set        func(*models.MetricsSnapshot, *float64)
+	}{
+		{"request_rate", cfg.Queries.RequestRate, func(s *models.MetricsSnapshot, v *float64) { s.RequestRate = v }},
+		{"error_rate", cfg.Queries.ErrorRate, func(s *models.MetricsSnapshot, v *float64) { s.ErrorRate = v }},
+		{"latency_p95", cfg.Queries.LatencyP95, func(s *models.MetricsSnapshot, v *float64) { s.LatencyP95 = v }},
+	} {
+		if q.expr == "" {
+			continue
+		}
+		tmpl, err := template.New(q.name).Option("missingkey=error").Parse(q.expr)
+		if err != nil {
+			return nil, fmt.Errorf("prometheus query %s: %w", q.name, err)
+		}
+		c.queries = append(c.queries, query{tmpl: tmpl, set: q.set})
+	}
+	return c, nil
+}
+
+// Service snapshots service's metrics over window, as of at.
+func (c *Client) Service(ctx context.Context, service string, window time.Duration, at time.Time) (models.MetricsSnapshot, error) {
+	sel := c.matchers(service, "", "")
+	return c.snapshot(ctx, sel, sel, window, at)
+}
+
+// Edge snapshots an edge over window, as of at. Service metrics carry no
+// caller, so this is the callee's view of the endpoint the edge's
+// operation names, or of all its traffic when the operation names none.
+func (c *Client) Edge(ctx context.Context, e models.Edge, window time.Duration, at time.Time) (models.MetricsSnapshot, error) {
+	sel, svcSel := c.Selectors(e.Callee, e.Operation)
+	return c.snapshot(ctx, sel, svcSel, window, at)
+}
+
+func (c *Client) snapshot(ctx context.Context, sel, svcSel string, window time.Duration, at time.Time) (models.MetricsSnapshot, error) {
+	snap := models.MetricsSnapshot{Window: FormatDuration(window), At: at.UTC()}
+	data := map[string]string{"Selector": sel, "ServiceSelector": svcSel, "Window": snap.Window}
+	var errs []error
+	for _, q := range c.queries {
+		var expr strings.Builder
+		if err := q.tmpl.Execute(&expr, data); err != nil {
+			errs = append(errs, fmt.Errorf("query %s: %w", q.tmpl.Name(), err))
+			continue
+		}
+		v, ok, err := c.Query(ctx, expr.String(), at)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("query %s: %w", q.tmpl.Name(), err))
+			continue
+		}
+		if ok {
+			q.set(&snap, &v)
+		}
+	}
+	return snap, errors.Join(errs...)
+}
+
+// Selectors returns the label matchers of the endpoint operation names
+// on service, or of all its traffic when it names none, and those of
+// service alone: what queries get as .Selector and .ServiceSelector.
+func (c *Client) Selectors(service, operation string) (sel, svcSel string) {
+	method, endpoint := SplitOperation(operation)
+	return c.matchers(service, endpoint, method), c.matchers(service, "", "")
+}
+
+// matchers renders label matchers in braces. Empty values are left out.
+func (c *Client) matchers(service, endpoint, method string) string {
+	var ms []string
+	for _, m := range [][2]string{
+		{c.cfg.ServiceLabel, service},
+		{c.cfg.EndpointLabel, endpoint},
+		{c.cfg.MethodLabel, method},
+	} {
+		if m[0] != "" && m[1] != "" {
+			ms = append(ms, m[0]+"="+strconv.Quote(m[1]))
+		}
+	}
+	return "{" + strings.Join(ms, ",") + "}"
+}
+
+// SplitOperation reads an HTTP method and path out of a span name such as
+// "POST /users" or "/users". Either is empty when the name lacks it.
+func SplitOperation(op string) (method, path string) {
+	first, rest, _ := strings.Cut(strings.TrimSpace(op), " ")
+	for _, m := range httpMethods {
+		if strings.EqualFold(first, m) {
+			method, first = m, strings.TrimSpace(rest)
+			break
+		}
+	}
+	if strings.HasPrefix(first, "/") {
+		path, _, _ = strings.Cut(first, "?")
+	}
+	return method, path
+}
+
+// FormatDuration renders d as a PromQL duration, e.g. 5m or 90s.
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	case d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
+
+// apiResponse is the envelope of every Prometheus API response.
+type apiResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// Query runs an instant query at at. It returns false when the result is
+// empty or not a number, as a ratio of zero rates is. A vector result
+// must hold one sample; aggregate the query to ensure it.
+func (c *Client) Query(ctx context.Context, expr string, at time.Time) (float64, bool, error) {
+	v, ok, err := c.query(ctx, expr, at)
+	switch {
+	case err != nil:
+		metrics.PrometheusQueries.WithLabelValues("error").Inc()
+	case !ok:
+		metrics.PrometheusQueries.WithLabelValues("empty").Inc()
+	default:
+		metrics.PrometheusQueries.WithLabelValues("ok").Inc()
+	}
+	return v, ok, err
+}
+
+func (c *Client) query(ctx context.Context, expr string, at time.Time) (float64, bool, error) {
+	form := url.Values{
+		"query": {expr},
+		"time":  {strconv.FormatFloat(float64(at.UnixMilli())/1000, 'f', 3, 64)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base.JoinPath("api", "v1", "query").String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range c.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	var r apiResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&r); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return 0, false, fmt.Errorf("prometheus: %s", resp.Status)
+		}
+		return 0, false, fmt.Errorf("decode prometheus response: %w", err)
+	}
+	if r.Status != "success" {
+		return 0, false, fmt.Errorf("prometheus: %s: %s", r.ErrorType, r.Error)
+	}
+
+	var sample []any
+	switch r.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(r.Data.Result, &sample); err != nil {
+			return 0, false, fmt.Errorf("decode scalar result: %w", err)
+		}
+	case "vector":
+		var vec []struct {
+			Value []any `json:"value"`
+		}
+		if err := json.Unmarshal(r.Data.Result, &vec); err != nil {
+			return 0, false, fmt.Errorf("decode vector result: %w", err)
+		}
+		switch len(vec) {
+		case 0:
+			return 0, false, nil
+		case 1:
+			sample = vec[0].Value
+		default:
+			return 0, false, fmt.Errorf("query returned %d series, want 1", len(vec))
+		}
+	default:
+		return 0, false, fmt.Errorf("unsupported result type %q", r.Data.ResultType)
+	}
+	if len(sample) != 2 {
+		return 0, false, errors.New("malformed sample")
+	}
+	s, _ := sample[1].(string)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("sample value %q: %w", s, err)
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false, nil
+	}
+	return v, true, nil
+}
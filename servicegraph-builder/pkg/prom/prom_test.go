@@ -0,0 +1,176 @@
+package prom_test
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/prom"
+	"servicegraph-builder/pkg/prom/promtest"
+)
+
+func newClient(t *testing.T) (*prom.Client, *promtest.Server) {
+	t.Helper()
+	srv := promtest.NewServer()
+	t.Cleanup(srv.Close)
+	pcfg := config.Default().Prometheus
+	pcfg.URL = srv.URL + "/"
+	pcfg.Headers = map[string]string{"Authorization": "Bearer t0ken"}
+	c, err := prom.New(pcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, srv
+}
+
+func TestQuery(t *testing.T) {
+	c, srv := newClient(t)
+	srv.Set("up", 0.25)
+	srv.Set("ratio", math.NaN())
+	srv.Respond("scalar(up)", http.StatusOK,
+		`{"status":"success","data":{"resultType":"scalar","result":[1700000000,"2"]}}`)
+
+	for _, tc := range []struct {
+		expr string
+		want float64
+		ok   bool
+	}{
+		{"up", 0.25, true},
+		{"scalar(up)", 2, true},
+		// An empty vector, and a ratio of zero rates, have no value
+		{"absent", 0, false},
+		{"ratio", 0, false},
+	} {
+		v, ok, err := c.Query(context.Background(), tc.expr, time.Now())
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if v != tc.want || ok != tc.ok {
+			t.Errorf("%s = %v, %v; want %v, %v", tc.expr, v, ok, tc.want, tc.ok)
+		}
+	}
+	if got := srv.Header().Get("Authorization"); got != "Bearer t0ken" {
+		t.Errorf("Authorization header %q, want the configured one", got)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	c, srv := newClient(t)
+	srv.Respond("bad", http.StatusBadRequest,
+		`{"status":"error","errorType":"bad_data","error":"parse error"}`)
+	srv.Respond("down", http.StatusBadGateway, `<html>bad gateway</html>`)
+	srv.Respond("many", http.StatusOK,
+		`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"1"]},{"metric":{},"value":[1,"2"]}]}}`)
+	srv.Respond("matrix", http.StatusOK,
+		`{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+	srv.Respond("garbled", http.StatusOK,
+		`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"one"]}]}}`)
+	srv.Respond("short", http.StatusOK,
+		`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1]}]}}`)
+
+	for expr, want := range map[string]string{
+		"bad":     "bad_data: parse error",
+		"down":    "502 Bad Gateway",
+		"many":    "2 series",
+		"matrix":  `unsupported result type "matrix"`,
+		"garbled": `sample value "one"`,
+		"short":   "malformed sample",
+	} {
+		_, ok, err := c.Query(context.Background(), expr, time.Now())
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want one containing %q", expr, err, want)
+		}
+		if ok {
+			t.Errorf("%s: reported a value along with the error", expr)
+		}
+	}
+}
+
+func TestQueryUnreachable(t *testing.T) {
+	c, srv := newClient(t)
+	srv.Close()
+	if _, _, err := c.Query(context.Background(), "up", time.Now()); err == nil {
+		t.Fatal("no error from a closed server")
+	}
+}
+
+func TestEdgeSnapshot(t *testing.T) {
+	c, srv := newClient(t)
+	sel := `{service="payments",endpoint="/pay",method="POST"}`
+	svcSel := `{service="payments"}`
+	srv.Set(`sum(rate(api_requests_total`+sel+`[5m]))`, 12.5)
+	srv.Set(`sum(rate(errors_total`+svcSel+`[5m])) / sum(rate(api_requests_total`+sel+`[5m]))`, 0.1)
+
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	snap, err := c.Edge(context.Background(),
+		models.Edge{Caller: "frontend", Callee: "payments", Operation: "POST /pay?id=1"}, 5*time.Minute, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Window != "5m" || !snap.At.Equal(at) {
+		t.Errorf("snapshot window %s at %s, want 5m at %s", snap.Window, snap.At, at)
+	}
+	if snap.RequestRate == nil || *snap.RequestRate != 12.5 {
+		t.Errorf("request rate %v, want 12.5", snap.RequestRate)
+	}
+	if snap.ErrorRate == nil || *snap.ErrorRate != 0.1 {
+		t.Errorf("error rate %v, want 0.1", snap.ErrorRate)
+	}
+	// No latency histogram in the stub
+	if snap.LatencyP95 != nil {
+		t.Errorf("latency %v, want none", *snap.LatencyP95)
+	}
+	if n := len(srv.Queries()); n != 3 {
+		t.Errorf("%d queries, want one per configured query", n)
+	}
+}
+
+func TestServiceSnapshotErrors(t *testing.T) {
+	c, srv := newClient(t)
+	srv.Respond(`sum(rate(api_requests_total{service="orders"}[1m]))`, http.StatusServiceUnavailable,
+		`{"status":"error","errorType":"unavailable","error":"overloaded"}`)
+	srv.Set(`histogram_quantile(0.95, sum(rate(api_request_latency_seconds_bucket{service="orders"}[1m])) by (le))`, 0.3)
+
+	snap, err := c.Service(context.Background(), "orders", time.Minute, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "query request_rate") {
+		t.Fatalf("error %v, want one naming request_rate", err)
+	}
+	// The other queries still fill in the snapshot
+	if snap.LatencyP95 == nil || *snap.LatencyP95 != 0.3 {
+		t.Errorf("latency %v, want 0.3 despite the failed query", snap.LatencyP95)
+	}
+}
+
+func TestSplitOperation(t *testing.T) {
+	for op, want := range map[string][2]string{
+		"POST /users":     {"POST", "/users"},
+		"get /users?id=1": {"GET", "/users"},
+		"/health":         {"", "/health"},
+		"GET":             {"GET", ""},
+		"HTTP GET":        {"", ""},
+	} {
+		method, path := prom.SplitOperation(op)
+		if method != want[0] || path != want[1] {
+			t.Errorf("SplitOperation(%q) = %q, %q; want %q, %q", op, method, path, want[0], want[1])
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		2 * time.Hour:           "2h",
+		90 * time.Minute:        "90m",
+		90 * time.Second:        "90s",
+		1500 * time.Millisecond: "1500ms",
+	} {
+		if got := prom.FormatDuration(d); got != want {
+			t.Errorf("FormatDuration(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package promtest provides a stub Prometheus HTTP API for exercising the
+// prom client without a Prometheus server.
+package promtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Server answers instant queries with the values or responses set for
+// their exact expression, and with an empty vector otherwise. Close it
+// when done.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	values    map[string]float64
+	responses map[string]response
+	queries   []string
+	header    http.Header
+}
+
+type response struct {
+	status int
+	body   string
+}
+
+// NewServer starts a stub server.
+func NewServer() *Server {
+	s := &Server{values: make(map[string]float64), responses: make(map[string]response)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/query", s.handleQuery)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Set makes queries for expr return v.
+func (s *Server) Set(expr string, v float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[expr] = v
+}
+
+// Respond makes queries for expr get body, as is, with status.
+func (s *Server) Respond(expr string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[expr] = response{status, body}
+}
+
+// Header returns the headers of the last query.
+func (s *Server) Header() http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.header.Clone()
+}
+
+// Queries returns the expressions queried so far, in order.
+func (s *Server) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...)
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	expr := r.FormValue("query")
+	if expr == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"status": "error", "errorType": "bad_data", "error": "missing query"})
+		return
+	}
+	at := float64(time.Now().Unix())
+	if t, err := strconv.ParseFloat(r.FormValue("time"), 64); err == nil {
+		at = t
+	}
+
+	s.mu.Lock()
+	s.queries = append(s.queries, expr)
+	s.header = r.Header.Clone()
+	v, ok := s.values[expr]
+	resp, raw := s.responses[expr]
+	s.mu.Unlock()
+
+	if raw {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+		return
+	}
+
+	result := []any{}
+	if ok {
+		result = append(result, map[string]any{
+			"metric": map[string]string{},
+			"value":  []any{at, strconv.FormatFloat(v, 'g', -1, 64)},
+		})
+	}
+	writeJSON(w, map[string]any{
+		"status": "success",
+		"data":   map[string]any{"resultType": "vector", "result": result},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,201 @@
+// Package rca is a deterministic first pass of root cause analysis. Rules
+// look at the alerting services, the graph around them, their RED stats
+// and recent changes, and each finds evidence against candidate causes.
+// Candidates are ranked on their combined score, so cheap cases are
+// answered without an agent run and the agents start from the ranking.
+package rca
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/red"
+)
+
+// Source is the RCA report source of rule-based analyses.
+const Source = "rules"
+
+// RoleAlerting is the role of alerting services; their neighbours are
+// upstream, downstream or both, as in graph.Neighbour.
+const RoleAlerting = "alerting"
+
+// Change kinds, besides graph event kinds.
+const (
+	ChangeRollout = "rollout"
+	ChangeRestart = "restart"
+)
+
+// Pod reasons the rules look for.
+const (
+	ReasonOOMKilled        = "OOMKilled"
+	ReasonCrashLoopBackOff = "CrashLoopBackOff"
+)
+
+// Input is what the rules look at, the contents of an RCA context pack.
+type Input struct {
+	// Since is the start of the window the stats and changes cover.
+	Since time.Time
+	// Alerting maps each alerting service to when its earliest firing
+	// alert started.
+	Alerting map[string]time.Time
+	// Services are the alerting services and their neighbours.
+	Services []Service
+	Edges    []Edge
+	// Changes are rollouts, restarts and graph events on the services.
+	Changes []Change
+}
+
+// Service is a service in the neighbourhood of the alerting ones.
+type Service struct {
+	Name string
+	// Role is alerting, upstream, downstream or both.
+	Role string
+	// Inbound is the RED stats of all calls into the service.
+	Inbound *red.Stats
+	// Metrics is the service's Prometheus snapshot, if any.
+	Metrics *models.MetricsSnapshot
+	// SLOs is the latest status of the service's SLOs.
+	SLOs []models.SLOStatus
+	Pods []Pod
+}
+
+type Pod struct {
+	Name     string
+	Ready    bool
+	Restarts int32
+	// Reason is why a container is waiting or last terminated.
+	Reason string
+}
+
+type Edge struct {
+	Caller string
+	Callee string
+	RED    *red.Stats
+}
+
+type Change struct {
+	Time    time.Time
+	Kind    string
+	Service string
+	// Reason is a restarted container's termination reason.
+	Reason string
+	// Caller and Callee are set for graph events.
+	Caller  string
+	Callee  string
+	Message string
+}
+
+// Rule finds evidence against candidate causes.
+type Rule struct {
+	Name     string
+	Evaluate func(in *Input) []Finding
+}
+
+// Finding is a rule's evidence that a service is the cause. Scores are
+// between 0 and 1.
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Service  string   `json:"service"`
+	Score    float64  `json:"score"`
+	Evidence []string `json:"evidence"`
+}
+
+// Candidate is a possible cause with everything found against it.
+type Candidate struct {
+	Service  string    `json:"service"`
+	Score    float64   `json:"score"`
+	Findings []Finding `json:"findings"`
+}
+
+// Explanation is the ranked outcome of an analysis.
+type Explanation struct {
+	Summary string `json:"summary"`
+	// RootCause is the top candidate, if any rule found one.
+	RootCause  string      `json:"root_cause,omitempty"`
+	Confidence float64     `json:"confidence"`
+	Candidates []Candidate `json:"candidates"`
+	// Rules names the rules evaluated, in order.
+	Rules []string `json:"rules"`
+	// Warnings name inputs that couldn't be filled in.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Analyze evaluates rules against in, passing each rule's findings to
+// observe if it's set. A candidate's score combines its findings as
+// independent evidence: 1 - ∏(1 - score).
+func Analyze(in *Input, rules []Rule, observe func(rule string, found []Finding)) Explanation {
+	ex := Explanation{Candidates: []Candidate{}, Rules: make([]string, 0, len(rules))}
+	byService := make(map[string]*Candidate)
+	for _, rule := range rules {
+		ex.Rules = append(ex.Rules, rule.Name)
+		found := rule.Evaluate(in)
+		for i := range found {
+			found[i].Rule = rule.Name
+		}
+		if observe != nil {
+			observe(rule.Name, found)
+		}
+		for _, f := range found {
+			c, ok := byService[f.Service]
+			if !ok {
+				c = &Candidate{Service: f.Service}
+				byService[f.Service] = c
+			}
+			c.Findings = append(c.Findings, f)
+		}
+	}
+	for _, c := range byService {
+		doubt := 1.0
+		for _, f := range c.Findings {
+			doubt *= 1 - f.Score
+		}
+		c.Score = round(1 - doubt)
+		sort.SliceStable(c.Findings, func(i, j int) bool { return c.Findings[i].Score > c.Findings[j].Score })
+		ex.Candidates = append(ex.Candidates, *c)
+	}
+	sort.Slice(ex.Candidates, func(i, j int) bool {
+		a, b := ex.Candidates[i], ex.Candidates[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Service < b.Service
+	})
+
+	if len(ex.Candidates) == 0 {
+		ex.Summary = "No rule matched; the cause needs a closer look"
+		return ex
+	}
+	top := ex.Candidates[0]
+	ex.RootCause, ex.Confidence = top.Service, top.Score
+	var evidence []string
+	for _, f := range top.Findings {
+		if len(evidence) == 2 {
+			break
+		}
+		evidence = append(evidence, f.Evidence[0])
+	}
+	ex.Summary = fmt.Sprintf("%s is the most likely cause (score %.2f): %s", top.Service, top.Score, strings.Join(evidence, "; "))
+	return ex
+}
+
+// Report turns ex into an RCA report, with ex as its details.
+func (ex Explanation) Report(now time.Time) models.RCAReport {
+	details, _ := json.Marshal(ex)
+	return models.RCAReport{
+		Source:     Source,
+		CreatedAt:  now.UTC(),
+		Summary:    ex.Summary,
+		RootCause:  ex.RootCause,
+		Confidence: ex.Confidence,
+		Details:    details,
+	}
+}
+
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
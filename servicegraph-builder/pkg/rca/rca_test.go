@@ -0,0 +1,163 @@
+package rca_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/graph"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/rca"
+	"servicegraph-builder/pkg/red"
+)
+
+var t0 = time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+func ptr(f float64) *float64 { return &f }
+
+// input is an incident where checkout alerts first and frontend, its
+// caller, follows, while payments, which checkout calls, is failing and
+// crashing after a rollout.
+func input() *rca.Input {
+	return &rca.Input{
+		Since:    t0.Add(-15 * time.Minute),
+		Alerting: map[string]time.Time{"checkout": t0, "frontend": t0.Add(2 * time.Minute)},
+		Services: []rca.Service{
+			{Name: "frontend", Role: graph.DirectionUpstream, SLOs: []models.SLOStatus{{
+				SLO: "frontend-availability", Kind: models.SLOAvailability, Objective: 0.999,
+				Windows: []models.BurnWindow{
+					{Window: "5m", BurnRate: ptr(14.4)},
+					{Window: "1h", BurnRate: ptr(14.4)},
+				},
+				BudgetRemaining: ptr(0.25),
+				Alerts:          []string{"FastBurn"},
+			}}},
+			{Name: "checkout", Role: rca.RoleAlerting},
+			{Name: "payments", Role: graph.DirectionDownstream,
+				Metrics: &models.MetricsSnapshot{Window: "5m", ErrorRate: ptr(0.6)},
+				Pods: []rca.Pod{
+					{Name: "payments-1", Restarts: 3, Reason: rca.ReasonOOMKilled},
+					{Name: "payments-2", Restarts: 5, Reason: rca.ReasonCrashLoopBackOff},
+				}},
+		},
+		Edges: []rca.Edge{
+			{Caller: "frontend", Callee: "checkout",
+				RED: &red.Stats{Requests: 100, Errors: 20, ErrorRate: 0.2, FirstError: t0.Add(time.Minute)}},
+			{Caller: "checkout", Callee: "payments",
+				RED: &red.Stats{Requests: 200, Errors: 100, ErrorRate: 0.5, FirstError: t0.Add(-5 * time.Minute)}},
+			// Too few calls to judge
+			{Caller: "checkout", Callee: "cache", RED: &red.Stats{Requests: 5, Errors: 5, ErrorRate: 1}},
+		},
+		Changes: []rca.Change{
+			{Time: t0.Add(-10 * time.Minute), Kind: rca.ChangeRollout, Service: "payments", Message: "payments rolled out"},
+			{Time: t0.Add(5 * time.Minute), Kind: rca.ChangeRollout, Service: "frontend", Message: "frontend rolled out"},
+			{Time: t0, Kind: rca.ChangeRestart, Service: "checkout", Reason: "Error", Message: "checkout restarted"},
+			{Time: t0, Kind: rca.ChangeRestart, Service: "payments", Reason: rca.ReasonOOMKilled, Message: "payments restarted"},
+			{Time: t0, Kind: models.EventNewEdge, Service: "checkout", Caller: "checkout", Callee: "cache", Message: "checkout started calling cache"},
+			{Time: t0, Kind: models.EventRerouted, Service: "checkout", Caller: "checkout", Callee: "payments-v2", Message: "checkout rerouted to payments-v2"},
+		},
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules := make(map[string]rca.Rule)
+	for _, r := range rca.Rules(config.Default().RCA) {
+		rules[r.Name] = r
+	}
+	for _, tc := range []struct {
+		rule string
+		want []rca.Finding
+	}{
+		{"failing_calls", []rca.Finding{
+			{Service: "checkout", Score: 0.4, Evidence: []string{"frontend → checkout: 20.0% of 100 calls failed"}},
+			{Service: "payments", Score: 0.6, Evidence: []string{
+				"payments: Prometheus error rate 60.0% over 5m",
+				"checkout → payments: 50.0% of 200 calls failed",
+			}},
+		}},
+		{"failed_first", []rca.Finding{
+			{Service: "payments", Score: 0.4, Evidence: []string{"payments failed first, 5m0s before checkout"}},
+		}},
+		{"budget_burn", []rca.Finding{
+			// Halved, as frontend only calls the alerting service
+			{Service: "frontend", Score: 0.244, Evidence: []string{
+				"frontend availability SLO (99.9%) burning its error budget 14.4x over 1h; FastBurn firing, 25.0% of the budget left",
+			}},
+		}},
+		{"recent_rollout", []rca.Finding{
+			{Service: "payments", Score: 0.5, Evidence: []string{"payments rolled out, 10m0s before the first alert"}},
+			{Service: "frontend", Score: 0.1, Evidence: []string{"frontend rolled out, 5m0s after the first alert"}},
+		}},
+		{"oom_killed", []rca.Finding{
+			{Service: "payments", Score: 0.6, Evidence: []string{"pod payments-1 was OOMKilled (3 restarts)", "payments restarted"}},
+		}},
+		{"restarts", []rca.Finding{
+			{Service: "payments", Score: 0.45, Evidence: []string{"pod payments-2 is in CrashLoopBackOff (5 restarts)"}},
+			{Service: "checkout", Score: 0.3, Evidence: []string{"checkout restarted"}},
+		}},
+		{"new_edge", []rca.Finding{
+			{Service: "cache", Score: 0.3, Evidence: []string{"checkout started calling cache"}},
+			{Service: "payments-v2", Score: 0.35, Evidence: []string{"checkout rerouted to payments-v2"}},
+		}},
+		{"downstream_alert", []rca.Finding{
+			{Service: "checkout", Score: 0.25, Evidence: []string{"checkout is downstream of alerting frontend and calls no other alerting service"}},
+		}},
+	} {
+		t.Run(tc.rule, func(t *testing.T) {
+			r, ok := rules[tc.rule]
+			if !ok {
+				t.Fatalf("no rule %s", tc.rule)
+			}
+			if got := r.Evaluate(input()); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("findings\n%+v\nwant\n%+v", got, tc.want)
+			}
+		})
+	}
+	if len(rules) != 8 {
+		t.Errorf("%d rules, want a test for each", len(rules))
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	var observed []string
+	ex := rca.Analyze(input(), rca.Rules(config.Default().RCA), func(rule string, found []rca.Finding) {
+		for _, f := range found {
+			if f.Rule != rule {
+				t.Errorf("%s finding on %s credited to %q", rule, f.Service, f.Rule)
+			}
+		}
+		observed = append(observed, rule)
+	})
+
+	if !reflect.DeepEqual(observed, ex.Rules) || len(ex.Rules) != 8 {
+		t.Errorf("observed rules %v, evaluated %v", observed, ex.Rules)
+	}
+	var ranking []string
+	scores := make(map[string]float64)
+	for _, c := range ex.Candidates {
+		ranking = append(ranking, c.Service)
+		scores[c.Service] = c.Score
+	}
+	if want := "payments, checkout, payments-v2, frontend, cache"; strings.Join(ranking, ", ") != want {
+		t.Errorf("ranking %v, want %s", ranking, want)
+	}
+	// 1 - 0.4 × 0.6 × 0.5 × 0.4 × 0.55
+	if scores["payments"] != 0.974 || scores["checkout"] != 0.685 || scores["frontend"] != 0.32 {
+		t.Errorf("scores %v", scores)
+	}
+	if ex.RootCause != "payments" || ex.Confidence != 0.974 {
+		t.Errorf("root cause %s at %v, want payments at 0.974", ex.RootCause, ex.Confidence)
+	}
+	// The two strongest findings make the summary
+	want := "payments is the most likely cause (score 0.97): payments: Prometheus error rate 60.0% over 5m; pod payments-1 was OOMKilled (3 restarts)"
+	if ex.Summary != want {
+		t.Errorf("summary %q, want %q", ex.Summary, want)
+	}
+
+	ex = rca.Analyze(&rca.Input{}, rca.Rules(config.Default().RCA), nil)
+	if ex.RootCause != "" || len(ex.Candidates) != 0 || !strings.HasPrefix(ex.Summary, "No rule matched") {
+		t.Errorf("analysis of nothing = %+v", ex)
+	}
+}
@@ -0,0 +1,356 @@
+package rca
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/graph"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/red"
+)
+
+// Rules returns the rules, in the order they are evaluated.
+func Rules(cfg config.RCAConfig) []Rule {
+	return []Rule{
+		{Name: "failing_calls", Evaluate: failingCalls(cfg)},
+		{Name: "failed_first", Evaluate: failedFirst(cfg)},
+		{Name: "budget_burn", Evaluate: budgetBurn},
+		{Name: "recent_rollout", Evaluate: recentRollout},
+		{Name: "oom_killed", Evaluate: oomKilled},
+		{Name: "restarts", Evaluate: restarts},
+		{Name: "new_edge", Evaluate: newEdge},
+		{Name: "downstream_alert", Evaluate: downstreamAlert},
+	}
+}
+
+// failingCalls blames services that calls fail into, more the higher the
+// error rate.
+func failingCalls(cfg config.RCAConfig) func(in *Input) []Finding {
+	return func(in *Input) []Finding {
+		var fs findings
+		for _, e := range in.Edges {
+			if !failing(cfg, e.RED) {
+				continue
+			}
+			fs.add(e.Callee, errorScore(e.RED.ErrorRate),
+				fmt.Sprintf("%s → %s: %s of %d calls failed", e.Caller, e.Callee, percent(e.RED.ErrorRate), e.RED.Requests))
+		}
+		for _, s := range in.Services {
+			if m := s.Metrics; m != nil && m.ErrorRate != nil && *m.ErrorRate >= cfg.MinErrorRate {
+				fs.add(s.Name, errorScore(*m.ErrorRate),
+					fmt.Sprintf("%s: Prometheus error rate %s over %s", s.Name, percent(*m.ErrorRate), m.Window))
+			}
+		}
+		return fs.list()
+	}
+}
+
+// failedFirst blames the service whose alerts or failing calls started
+// before any other's. Onsets are compared to the minute, the resolution
+// of the RED stats, and no further back than the stats reach.
+func failedFirst(cfg config.RCAConfig) func(in *Input) []Finding {
+	return func(in *Input) []Finding {
+		onsets := make(map[string]time.Time)
+		first := func(service string, t time.Time) {
+			if t.Before(in.Since) {
+				t = in.Since
+			}
+			t = t.Truncate(time.Minute)
+			if o, ok := onsets[service]; !ok || t.Before(o) {
+				onsets[service] = t
+			}
+		}
+		for s, t := range in.Alerting {
+			first(s, t)
+		}
+		for _, e := range in.Edges {
+			if failing(cfg, e.RED) && !e.RED.FirstError.IsZero() {
+				first(e.Callee, e.RED.FirstError)
+			}
+		}
+		if len(onsets) < 2 {
+			return nil
+		}
+		names := make([]string, 0, len(onsets))
+		for s := range onsets {
+			names = append(names, s)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if !onsets[names[i]].Equal(onsets[names[j]]) {
+				return onsets[names[i]].Before(onsets[names[j]])
+			}
+			return names[i] < names[j]
+		})
+		lead := onsets[names[1]].Sub(onsets[names[0]])
+		if lead <= 0 {
+			return nil
+		}
+		return []Finding{{
+			Service:  names[0],
+			Score:    0.4,
+			Evidence: []string{fmt.Sprintf("%s failed first, %s before %s", names[0], lead, names[1])},
+		}}
+	}
+}
+
+// budgetBurn blames services burning their SLOs' error budgets fast
+// enough for a burn-rate alert, more the faster they burn, and their
+// callers less, since they may only pass on failures.
+func budgetBurn(in *Input) []Finding {
+	var fs findings
+	for _, s := range in.Services {
+		for _, st := range s.SLOs {
+			if !st.Burning() {
+				continue
+			}
+			var burn float64
+			var window string
+			for _, w := range st.Windows {
+				// The longest of the fastest-burning windows
+				if w.BurnRate != nil && *w.BurnRate >= burn {
+					burn, window = *w.BurnRate, w.Window
+				}
+			}
+			score := min(0.2+0.02*burn, 0.5)
+			if s.Role == graph.DirectionUpstream {
+				score /= 2
+			}
+			name := s.Name
+			if st.Endpoint != "" {
+				name += " " + st.Endpoint
+			}
+			evidence := fmt.Sprintf("%s %s SLO (%s) burning its error budget %.1fx over %s; %s firing",
+				name, st.Kind, objective(st.Objective), burn, window, strings.Join(st.Alerts, ", "))
+			switch r := st.BudgetRemaining; {
+			case r == nil:
+			case *r > 0:
+				evidence += fmt.Sprintf(", %s of the budget left", percent(*r))
+			default:
+				evidence += ", the budget is spent"
+			}
+			fs.add(s.Name, round(score), evidence)
+		}
+	}
+	return fs.list()
+}
+
+// recentRollout blames services rolled out shortly before the alerts
+// started, alerting ones and their dependencies more than their callers.
+func recentRollout(in *Input) []Finding {
+	start := earliest(in.Alerting)
+	var fs findings
+	for _, c := range in.Changes {
+		if c.Kind != ChangeRollout {
+			continue
+		}
+		score := 0.5
+		evidence := c.Message
+		switch {
+		case !start.IsZero() && c.Time.After(start):
+			// More likely a response to the incident than its cause
+			score = 0.1
+			evidence += fmt.Sprintf(", %s after the first alert", c.Time.Sub(start).Round(time.Second))
+		case in.role(c.Service) == graph.DirectionUpstream:
+			score = 0.2
+		}
+		if score > 0.1 && !start.IsZero() {
+			evidence += fmt.Sprintf(", %s before the first alert", start.Sub(c.Time).Round(time.Second))
+		}
+		fs.add(c.Service, score, evidence)
+	}
+	return fs.list()
+}
+
+// oomKilled blames services whose containers ran out of memory.
+func oomKilled(in *Input) []Finding {
+	var fs findings
+	for _, s := range in.Services {
+		for _, p := range s.Pods {
+			if p.Reason == ReasonOOMKilled {
+				fs.add(s.Name, 0.6, fmt.Sprintf("pod %s was OOMKilled (%d restarts)", p.Name, p.Restarts))
+			}
+		}
+	}
+	for _, c := range in.Changes {
+		if c.Kind == ChangeRestart && c.Reason == ReasonOOMKilled {
+			fs.add(c.Service, 0.6, c.Message)
+		}
+	}
+	return fs.list()
+}
+
+// restarts blames services whose containers crash for other reasons.
+func restarts(in *Input) []Finding {
+	var fs findings
+	for _, s := range in.Services {
+		for _, p := range s.Pods {
+			if p.Reason == ReasonCrashLoopBackOff {
+				fs.add(s.Name, 0.45, fmt.Sprintf("pod %s is in CrashLoopBackOff (%d restarts)", p.Name, p.Restarts))
+			}
+		}
+	}
+	for _, c := range in.Changes {
+		if c.Kind == ChangeRestart && c.Reason != ReasonOOMKilled {
+			fs.add(c.Service, 0.3, c.Message)
+		}
+	}
+	return fs.list()
+}
+
+// newEdge blames services that started taking calls they didn't before,
+// a sign of a config change or failover.
+func newEdge(in *Input) []Finding {
+	var fs findings
+	for _, c := range in.Changes {
+		switch c.Kind {
+		case models.EventNewEdge:
+			fs.add(c.Callee, 0.3, c.Message)
+		case models.EventRerouted:
+			fs.add(c.Callee, 0.35, c.Message)
+		}
+	}
+	return fs.list()
+}
+
+// downstreamAlert blames alerting services that call no other alerting
+// service, since failures cascade to callers. The more alerting callers
+// one has, the likelier.
+func downstreamAlert(in *Input) []Finding {
+	if len(in.Alerting) == 1 {
+		for s := range in.Alerting {
+			return []Finding{{Service: s, Score: 0.2, Evidence: []string{s + " is the only alerting service"}}}
+		}
+	}
+	calls := make(map[string][]string)
+	for _, e := range in.Edges {
+		calls[e.Caller] = append(calls[e.Caller], e.Callee)
+	}
+	reaches := func(from, to string) bool {
+		seen := map[string]bool{from: true}
+		next := []string{from}
+		for len(next) > 0 {
+			s := next[0]
+			next = next[1:]
+			for _, c := range calls[s] {
+				if c == to {
+					return true
+				}
+				if !seen[c] {
+					seen[c] = true
+					next = append(next, c)
+				}
+			}
+		}
+		return false
+	}
+
+	var fs findings
+	for _, s := range sortedKeys(in.Alerting) {
+		var callers []string
+		sink := true
+		for _, o := range sortedKeys(in.Alerting) {
+			if o == s {
+				continue
+			}
+			if reaches(s, o) {
+				sink = false
+				break
+			}
+			if reaches(o, s) {
+				callers = append(callers, o)
+			}
+		}
+		if sink && len(callers) > 0 {
+			fs.add(s, min(0.15+0.1*float64(len(callers)), 0.45),
+				fmt.Sprintf("%s is downstream of alerting %s and calls no other alerting service", s, strings.Join(callers, ", ")))
+		}
+	}
+	return fs.list()
+}
+
+// findings keeps one finding per service, in the order first found, with
+// the highest score added and all the evidence.
+type findings struct {
+	order []*Finding
+	by    map[string]*Finding
+}
+
+func (fs *findings) add(service string, score float64, evidence string) {
+	if fs.by == nil {
+		fs.by = make(map[string]*Finding)
+	}
+	f, ok := fs.by[service]
+	if !ok {
+		f = &Finding{Service: service}
+		fs.by[service] = f
+		fs.order = append(fs.order, f)
+	}
+	if score > f.Score {
+		f.Score = score
+		// The strongest evidence leads
+		f.Evidence = append([]string{evidence}, f.Evidence...)
+		return
+	}
+	f.Evidence = append(f.Evidence, evidence)
+}
+
+func (fs *findings) list() []Finding {
+	out := make([]Finding, len(fs.order))
+	for i, f := range fs.order {
+		out[i] = *f
+	}
+	return out
+}
+
+func (in *Input) role(service string) string {
+	for _, s := range in.Services {
+		if s.Name == service {
+			return s.Role
+		}
+	}
+	return ""
+}
+
+func failing(cfg config.RCAConfig, st *red.Stats) bool {
+	return st != nil && st.Requests >= cfg.MinCalls && st.ErrorRate >= cfg.MinErrorRate
+}
+
+// errorScore grows with the error rate, from 0.3 to 0.8 when every call
+// fails.
+func errorScore(rate float64) float64 {
+	return round(0.3 + 0.5*min(rate, 1))
+}
+
+func earliest(ts map[string]time.Time) time.Time {
+	var out time.Time
+	for _, t := range ts {
+		if out.IsZero() || t.Before(out) {
+			out = t
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]time.Time) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// objective formats an SLO objective as a percentage, as precise as it
+// is set, e.g. 99.95%.
+func objective(f float64) string {
+	return strconv.FormatFloat(math.Round(f*1e6)/1e4, 'f', -1, 64) + "%"
+}
+
+func percent(f float64) string {
+	return fmt.Sprintf("%.1f%%", f*100)
+}
@@ -0,0 +1,275 @@
+// Package red keeps recent rate, error and duration (RED) stats per edge,
+// and recent error samples per service, from the spans the builder
+// processes. Stats are kept in one-minute slots for a fixed retention, so
+// memory per edge is bounded.
+package red
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	slotWidth = time.Minute
+	// maxEdges bounds the edges tracked; calls on further edges are not
+	// counted.
+	maxEdges = 10000
+	// maxSamples is how many error samples are kept per service.
+	maxSamples = 20
+)
+
+// bounds are the upper bounds of the duration histogram buckets, in
+// milliseconds. The last bucket is unbounded.
+var bounds = [...]float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Call is one processed span.
+type Call struct {
+	Caller   string
+	Callee   string
+	Duration time.Duration
+	Error    bool
+}
+
+// Sample is an error seen in a span, standing in for a log line: the
+// span's status message or an exception event.
+type Sample struct {
+	Time time.Time `json:"time"`
+	// Service is the service that reported the span, which for a client
+	// span is the caller of the service the sample is kept for.
+	Service   string `json:"service"`
+	TraceID   string `json:"trace_id,omitempty"`
+	SpanID    string `json:"span_id,omitempty"`
+	Operation string `json:"operation"`
+	Type      string `json:"type,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Stats are RED stats over a window. Durations are in milliseconds,
+// estimated from histogram buckets.
+type Stats struct {
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	Rate      float64 `json:"rate"`
+	ErrorRate float64 `json:"error_rate"`
+	P50       float64 `json:"p50_ms"`
+	P95       float64 `json:"p95_ms"`
+	P99       float64 `json:"p99_ms"`
+	// FirstError is the start of the earliest minute in the window with
+	// an error.
+	FirstError time.Time `json:"first_error,omitzero"`
+}
+
+type slot struct {
+	start    int64 // minute since the epoch
+	requests int64
+	errors   int64
+	hist     [len(bounds) + 1]int64
+	// firstError is the earliest minute with errors, in sums of slots
+	firstError int64
+}
+
+type edgeKey struct {
+	caller, callee string
+}
+
+// Tracker is safe for concurrent use.
+type Tracker struct {
+	slots int
+
+	mu      sync.Mutex
+	edges   map[edgeKey][]slot
+	samples map[string][]Sample // newest last
+}
+
+// New returns a tracker that keeps retention worth of stats.
+func New(retention time.Duration) *Tracker {
+	return &Tracker{
+		slots:   max(int(retention/slotWidth), 1),
+		edges:   make(map[edgeKey][]slot),
+		samples: make(map[string][]Sample),
+	}
+}
+
+// Retention is how far back the tracker keeps stats.
+func (t *Tracker) Retention() time.Duration {
+	return time.Duration(t.slots) * slotWidth
+}
+
+// Record counts c at now.
+func (t *Tracker) Record(now time.Time, c Call) {
+	minute := now.Unix() / int64(slotWidth/time.Second)
+	k := edgeKey{c.Caller, c.Callee}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ring, ok := t.edges[k]
+	if !ok {
+		if len(t.edges) >= maxEdges {
+			return
+		}
+		ring = make([]slot, t.slots)
+		t.edges[k] = ring
+	}
+	s := &ring[minute%int64(t.slots)]
+	if s.start != minute {
+		*s = slot{start: minute}
+	}
+	s.requests++
+	if c.Error {
+		s.errors++
+	}
+	ms := float64(c.Duration) / float64(time.Millisecond)
+	s.hist[sort.SearchFloat64s(bounds[:], ms)]++
+}
+
+// AddSample keeps s as one of service's recent errors.
+func (t *Tracker) AddSample(service string, s Sample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	samples := append(t.samples[service], s)
+	if len(samples) > maxSamples {
+		samples = samples[len(samples)-maxSamples:]
+	}
+	t.samples[service] = samples
+}
+
+// Samples returns up to limit of service's errors since since, newest
+// first.
+func (t *Tracker) Samples(service string, since time.Time, limit int) []Sample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []Sample
+	samples := t.samples[service]
+	for i := len(samples) - 1; i >= 0 && len(out) < limit; i-- {
+		if !samples[i].Time.Before(since) {
+			out = append(out, samples[i])
+		}
+	}
+	return out
+}
+
+// Edge returns the caller → callee stats over the window ending at now,
+// and false if the edge had no calls in it.
+func (t *Tracker) Edge(caller, callee string, window time.Duration, now time.Time) (Stats, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sum slot
+	t.add(&sum, t.edges[edgeKey{caller, callee}], window, now)
+	return stats(sum, window)
+}
+
+// Inbound returns the stats of every call into callee over the window
+// ending at now, and false if there were none.
+func (t *Tracker) Inbound(callee string, window time.Duration, now time.Time) (Stats, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sum slot
+	for k, ring := range t.edges {
+		if k.callee == callee {
+			t.add(&sum, ring, window, now)
+		}
+	}
+	return stats(sum, window)
+}
+
+// Good counts the calls into callee over the window ending at now, and
+// how many of them were good: those that didn't fail or, with within
+// set, that took at most within. Durations are told apart at bucket
+// resolution, interpolating within the bucket holding within.
+func (t *Tracker) Good(callee string, within, window time.Duration, now time.Time) (good, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sum slot
+	for k, ring := range t.edges {
+		if k.callee == callee {
+			t.add(&sum, ring, window, now)
+		}
+	}
+	if within <= 0 {
+		return sum.requests - sum.errors, sum.requests
+	}
+	return below(sum.hist[:], float64(within)/float64(time.Millisecond)), sum.requests
+}
+
+// below estimates how many durations in hist are at most ms. None in
+// the unbounded bucket are.
+func below(hist []int64, ms float64) int64 {
+	var n float64
+	lower := 0.0
+	for i, b := range bounds {
+		if ms >= b {
+			n += float64(hist[i])
+		} else if ms > lower {
+			n += float64(hist[i]) * (ms - lower) / (b - lower)
+		}
+		lower = b
+	}
+	return int64(math.Round(n))
+}
+
+// add folds the slots of ring inside the window into sum. Callers hold
+// t.mu.
+func (t *Tracker) add(sum *slot, ring []slot, window time.Duration, now time.Time) {
+	minute := now.Unix() / int64(slotWidth/time.Second)
+	n := min(max(int64(window/slotWidth), 1), int64(t.slots))
+	for _, s := range ring {
+		if s.start > minute-n && s.start <= minute {
+			sum.requests += s.requests
+			sum.errors += s.errors
+			if s.errors > 0 && (sum.firstError == 0 || s.start < sum.firstError) {
+				sum.firstError = s.start
+			}
+			for i, c := range s.hist {
+				sum.hist[i] += c
+			}
+		}
+	}
+}
+
+func stats(s slot, window time.Duration) (Stats, bool) {
+	if s.requests == 0 {
+		return Stats{}, false
+	}
+	st := Stats{
+		Requests:  s.requests,
+		Errors:    s.errors,
+		Rate:      round(float64(s.requests) / window.Seconds()),
+		ErrorRate: round(float64(s.errors) / float64(s.requests)),
+		P50:       round(quantile(s.hist[:], s.requests, 0.5)),
+		P95:       round(quantile(s.hist[:], s.requests, 0.95)),
+		P99:       round(quantile(s.hist[:], s.requests, 0.99)),
+	}
+	if s.firstError != 0 {
+		st.FirstError = time.Unix(s.firstError*int64(slotWidth/time.Second), 0).UTC()
+	}
+	return st, true
+}
+
+// quantile interpolates linearly within the bucket holding q, like
+// Prometheus' histogram_quantile. The unbounded bucket reports the
+// highest bound.
+func quantile(hist []int64, total int64, q float64) float64 {
+	rank := q * float64(total)
+	var seen int64
+	for i, c := range hist {
+		if c == 0 || float64(seen+c) < rank {
+			seen += c
+			continue
+		}
+		if i == len(bounds) {
+			return bounds[len(bounds)-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = bounds[i-1]
+		}
+		return lower + (bounds[i]-lower)*(rank-float64(seen))/float64(c)
+	}
+	return bounds[len(bounds)-1]
+}
+
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
@@ -0,0 +1,159 @@
+// Package sampling decides which incoming spans are worth enriching.
+// Almost every span repeats an edge already in the dedup cache, so under
+// load most of them can be shed before any per-span work is done.
+package sampling
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"sync"
+
+	"servicegraph-builder/pkg/config"
+
+	"golang.org/x/time/rate"
+)
+
+// Reasons for a decision.
+const (
+	KeepError       = "error"
+	KeepNewEdge     = "new_edge"
+	KeepSampled     = "sampled"
+	DropSampledOut  = "sampled_out"
+	DropRateLimited = "rate_limited"
+)
+
+// maxServices bounds the per-service state. Service names come from
+// untrusted resource attributes; past the bound, new names share the
+// OtherService bucket.
+const maxServices = 10000
+
+// OtherService collects services past the per-service bound.
+const OtherService = "_other"
+
+// Span is what a decision is based on, all of it available without
+// enriching the span.
+type Span struct {
+	Service string
+	TraceID []byte
+	// Error is set when the span, or any span of its trace in the same
+	// export request, has an error status.
+	Error bool
+	// NewEdge is set when the span's edge is not in the dedup cache.
+	NewEdge bool
+}
+
+// ServiceStats counts decisions for one service.
+type ServiceStats struct {
+	Kept        int64 `json:"kept"`
+	SampledOut  int64 `json:"sampled_out"`
+	RateLimited int64 `json:"rate_limited"`
+}
+
+// Sampler applies trace-consistent probabilistic sampling and a token
+// bucket per service. It is safe for concurrent use.
+type Sampler struct {
+	cfg config.SamplingConfig
+	// threshold is compared against the low 63 bits of the trace ID, as
+	// the OpenTelemetry TraceIDRatioBased sampler does, so every span of
+	// a trace gets the same answer here and in other samplers.
+	threshold uint64
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	stats    map[string]*ServiceStats
+}
+
+// New returns a Sampler for cfg.
+func New(cfg config.SamplingConfig) *Sampler {
+	return &Sampler{
+		cfg:       cfg,
+		threshold: uint64(cfg.Probability * (1 << 63)),
+		limiters:  make(map[string]*rate.Limiter),
+		stats:     make(map[string]*ServiceStats),
+	}
+}
+
+// Decide reports whether s should be processed, and why. Errors and new
+// edges are always kept; everything else must pass the trace sample and
+// then the service's rate limit.
+func (smp *Sampler) Decide(s Span) (bool, string) {
+	keep, reason := true, KeepSampled
+	switch {
+	case s.Error:
+		reason = KeepError
+	case s.NewEdge:
+		reason = KeepNewEdge
+	case !smp.sampleTrace(s.TraceID):
+		keep, reason = false, DropSampledOut
+	}
+
+	smp.mu.Lock()
+	defer smp.mu.Unlock()
+	service := smp.bucket(s.Service)
+	if keep && reason == KeepSampled {
+		limiter, ok := smp.limiters[service]
+		if !ok {
+			limiter = smp.newLimiter(service)
+			smp.limiters[service] = limiter
+		}
+		if !limiter.Allow() {
+			keep, reason = false, DropRateLimited
+		}
+	}
+
+	st, ok := smp.stats[service]
+	if !ok {
+		st = &ServiceStats{}
+		smp.stats[service] = st
+	}
+	switch reason {
+	case DropSampledOut:
+		st.SampledOut++
+	case DropRateLimited:
+		st.RateLimited++
+	default:
+		st.Kept++
+	}
+	return keep, reason
+}
+
+// Stats returns a copy of the per-service counts.
+func (smp *Sampler) Stats() map[string]ServiceStats {
+	smp.mu.Lock()
+	defer smp.mu.Unlock()
+	out := make(map[string]ServiceStats, len(smp.stats))
+	for name, st := range smp.stats {
+		out[name] = *st
+	}
+	return out
+}
+
+func (smp *Sampler) sampleTrace(traceID []byte) bool {
+	if smp.cfg.Probability >= 1 {
+		return true
+	}
+	if len(traceID) != 16 {
+		return rand.Float64() < smp.cfg.Probability
+	}
+	return binary.BigEndian.Uint64(traceID[8:16])>>1 < smp.threshold
+}
+
+// bucket maps service to the name its state is kept under. Callers hold
+// smp.mu.
+func (smp *Sampler) bucket(service string) string {
+	if _, ok := smp.stats[service]; ok || len(smp.stats) < maxServices {
+		return service
+	}
+	return OtherService
+}
+
+func (smp *Sampler) newLimiter(service string) *rate.Limiter {
+	r, ok := smp.cfg.ServiceRates[service]
+	if !ok {
+		r = smp.cfg.RatePerService
+	}
+	if r <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(r), smp.cfg.Burst)
+}
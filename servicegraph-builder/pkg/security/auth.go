@@ -0,0 +1,184 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TokenAuth rejects gRPC calls, or HTTP requests, that don't carry an
+// accepted "authorization: Bearer <token>" header.
+type TokenAuth struct {
+	static    []string
+	tokenFile string
+	interval  time.Duration
+
+	mu        sync.Mutex
+	fromFile  []string
+	files     *fileSet
+	lastCheck time.Time
+}
+
+// NewTokenAuth builds the authenticator from cfg. The token file is
+// re-read at most once per interval when it changes.
+func NewTokenAuth(cfg config.AuthConfig, interval time.Duration) (*TokenAuth, error) {
+	a := &TokenAuth{
+		static:    cfg.BearerTokens,
+		tokenFile: cfg.BearerTokenFile,
+		interval:  interval,
+	}
+	if a.tokenFile != "" {
+		a.files = newFileSet(a.tokenFile)
+		a.files.changed()
+		tokens, err := readTokenFile(a.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a.fromFile = tokens
+		a.lastCheck = time.Now()
+	}
+	return a, nil
+}
+
+// healthMethodPrefix is exempt from auth so kubelet gRPC probes, which
+// can't send credentials, keep working.
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// UnaryInterceptor authenticates unary calls such as OTLP Export.
+func (a *TokenAuth) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(ctx, req)
+		}
+		if err := a.authorize(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor authenticates streaming calls.
+func (a *TokenAuth) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(srv, ss)
+		}
+		if err := a.authorize(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (a *TokenAuth) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var header string
+	if values := md.Get("authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	if err := a.check(header); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+// Middleware rejects HTTP requests to next that don't carry an accepted
+// "Authorization: Bearer <token>" header, unless open lets them through.
+func (a *TokenAuth) Middleware(next http.Handler, open func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if open != nil && open(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := a.check(r.Header.Get("Authorization")); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check verifies an authorization header value.
+func (a *TokenAuth) check(header string) error {
+	if header == "" {
+		return errors.New("missing bearer token")
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return errors.New("malformed authorization header")
+	}
+	if !a.accepts(strings.TrimSpace(token)) {
+		return errors.New("invalid bearer token")
+	}
+	return nil
+}
+
+func (a *TokenAuth) accepts(token string) bool {
+	a.mu.Lock()
+	a.maybeReload()
+	candidates := append(append([]string(nil), a.static...), a.fromFile...)
+	a.mu.Unlock()
+
+	// Compare against every token so timing doesn't reveal which matched.
+	match := 0
+	for _, c := range candidates {
+		match |= subtle.ConstantTimeCompare([]byte(c), []byte(token))
+	}
+	return match == 1
+}
+
+// maybeReload re-reads the token file if it changed. Callers must hold a.mu.
+func (a *TokenAuth) maybeReload() {
+	if a.files == nil || time.Since(a.lastCheck) < a.interval {
+		return
+	}
+	a.lastCheck = time.Now()
+	if !a.files.changed() {
+		return
+	}
+	tokens, err := readTokenFile(a.tokenFile)
+	if err != nil {
+		log.Error().Err(err).Msg("Bearer token reload failed, keeping previous tokens")
+		return
+	}
+	a.fromFile = tokens
+	log.Info().Str("token_file", a.tokenFile).Int("tokens", len(tokens)).Msg("Reloaded bearer tokens")
+}
+
+// readTokenFile returns the non-empty, non-comment lines of path.
+func readTokenFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read token file: %w", err)
+	}
+	var tokens []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens found in %s", path)
+	}
+	return tokens, nil
+}
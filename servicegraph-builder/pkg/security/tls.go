@@ -0,0 +1,155 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CertReloader serves the listener certificate and client CA bundle from
+// disk and picks up rotated files without a restart.
+type CertReloader struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+	interval   time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	files     *fileSet
+	lastCheck time.Time
+}
+
+// NewCertReloader loads the certificates named in cfg. It fails if they
+// can't be loaded the first time; later reload failures keep the
+// previous certificates.
+func NewCertReloader(cfg config.TLSConfig) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile:   cfg.CertFile,
+		keyFile:    cfg.KeyFile,
+		caFile:     cfg.ClientCAFile,
+		clientAuth: clientAuthType(cfg.ClientAuth),
+		interval:   cfg.ReloadInterval,
+	}
+	paths := []string{cfg.CertFile, cfg.KeyFile}
+	if cfg.ClientCAFile != "" {
+		paths = append(paths, cfg.ClientCAFile)
+	}
+	r.files = newFileSet(paths...)
+	r.files.changed() // record initial mod times
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.lastCheck = time.Now()
+	return r, nil
+}
+
+// TLSConfig returns a server config that resolves the current
+// certificates on every handshake.
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.configForClient,
+	}
+}
+
+func (r *CertReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maybeReload()
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*r.cert},
+		ClientAuth:   r.clientAuth,
+		ClientCAs:    r.clientCAs,
+		NextProtos:   []string{"h2"},
+	}, nil
+}
+
+// maybeReload re-reads the files if they changed since the last check.
+// Callers must hold r.mu.
+func (r *CertReloader) maybeReload() {
+	if time.Since(r.lastCheck) < r.interval {
+		return
+	}
+	r.lastCheck = time.Now()
+	if !r.files.changed() {
+		return
+	}
+	if err := r.load(); err != nil {
+		log.Error().Err(err).Msg("TLS reload failed, keeping previous certificates")
+		return
+	}
+	log.Info().Str("cert_file", r.certFile).Msg("Reloaded TLS certificates")
+}
+
+func (r *CertReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("read client CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.cert, r.clientCAs = &cert, pool
+	return nil
+}
+
+func clientAuthType(policy string) tls.ClientAuthType {
+	switch policy {
+	case config.ClientAuthRequest:
+		return tls.VerifyClientCertIfGiven
+	case config.ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert
+	}
+}
+
+// fileSet tracks modification times of a set of files.
+type fileSet struct {
+	paths    []string
+	modTimes map[string]time.Time
+}
+
+func newFileSet(paths ...string) *fileSet {
+	return &fileSet{paths: paths, modTimes: make(map[string]time.Time, len(paths))}
+}
+
+// changed reports whether any file's modification time differs from the
+// last call. Files that can't be stat'ed are treated as unchanged.
+func (f *fileSet) changed() bool {
+	changed := false
+	for _, p := range f.paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(f.modTimes[p]) {
+			f.modTimes[p] = info.ModTime()
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,71 @@
+package slo
+
+import (
+	"fmt"
+	"time"
+
+	"servicegraph-builder/pkg/models"
+)
+
+// history counts an SLO's good and total calls per minute over the
+// longest alert window, and per hour over the SLO's window.
+type history struct {
+	// key is what the counts depend on; a change starts afresh
+	key string
+	// sampled is the last minute counted, in minutes since the epoch
+	sampled int64
+	minutes ring
+	hours   ring
+}
+
+func newHistory(s *models.SLO, longest time.Duration) *history {
+	return &history{
+		key:     historyKey(s),
+		minutes: make(ring, max(int(longest/time.Minute), 1)),
+		hours:   make(ring, s.WindowDays*24+1),
+	}
+}
+
+func historyKey(s *models.SLO) string {
+	return fmt.Sprintf("%s\x00%s\x00%g\x00%d", s.Service, s.Kind, s.ThresholdMS, s.WindowDays)
+}
+
+// add counts the calls of a minute.
+func (h *history) add(minute, good, total int64) {
+	h.minutes.add(minute, good, total)
+	h.hours.add(minute/60, good, total)
+}
+
+// ring holds counts in slots of a fixed width, indexed by their number
+// since the epoch.
+type ring []counts
+
+type counts struct {
+	n           int64
+	good, total int64
+}
+
+func (r ring) add(n, good, total int64) {
+	c := &r[n%int64(len(r))]
+	if c.n != n {
+		*c = counts{n: n}
+	}
+	c.good += good
+	c.total += total
+}
+
+// badRatio returns the share of bad calls in the slots from from up to
+// to, not including it, and false if there were none.
+func (r ring) badRatio(from, to int64) (float64, bool) {
+	var good, total int64
+	for _, c := range r {
+		if c.n >= from && c.n < to {
+			good += c.good
+			total += c.total
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(total-good) / float64(total), true
+}
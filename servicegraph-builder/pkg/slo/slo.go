@@ -0,0 +1,335 @@
+// Package slo evaluates service level objectives: how fast each spends
+// its error budget over the windows of the multi-window burn-rate alerts,
+// and how much of it is left over the SLO's own window.
+//
+// SLOs sourced from the builder's RED stats are sampled every minute
+// into a history of their own, since the RED stats only reach back
+// minutes; until the history fills, windows cover what it holds. SLOs
+// sourced from Prometheus are queried over each window.
+package slo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+	"servicegraph-builder/pkg/models"
+	"servicegraph-builder/pkg/prom"
+	"servicegraph-builder/pkg/red"
+)
+
+const (
+	// DefaultWindowDays is the window of SLOs that don't set one.
+	DefaultWindowDays = 30
+	// MaxWindowDays caps an SLO's window.
+	MaxWindowDays = 90
+)
+
+// Validate checks that s can be evaluated.
+func Validate(s *models.SLO) error {
+	var errs []error
+	if s.Service == "" {
+		errs = append(errs, errors.New("service is required"))
+	}
+	switch s.Kind {
+	case models.SLOAvailability:
+		if s.ThresholdMS != 0 {
+			errs = append(errs, errors.New("threshold_ms is only for latency SLOs"))
+		}
+	case models.SLOLatency:
+		if s.ThresholdMS <= 0 {
+			errs = append(errs, errors.New("threshold_ms must be positive for latency SLOs"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid kind %q: want availability or latency", s.Kind))
+	}
+	if s.Objective <= 0 || s.Objective >= 1 {
+		errs = append(errs, errors.New("objective must be between 0 and 1, e.g. 0.999"))
+	}
+	if s.WindowDays < 1 || s.WindowDays > MaxWindowDays {
+		errs = append(errs, fmt.Errorf("window_days must be between 1 and %d", MaxWindowDays))
+	}
+	switch s.Source {
+	case models.SLOSourcePrometheus:
+	case models.SLOSourceRED:
+		if s.Endpoint != "" {
+			errs = append(errs, errors.New("endpoint SLOs need source prometheus; RED stats don't tell endpoints apart"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid source %q: want red or prometheus", s.Source))
+	}
+	return errors.Join(errs...)
+}
+
+// NewID returns a random SLO ID.
+func NewID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "slo-" + hex.EncodeToString(b)
+}
+
+// Evaluator keeps the status of SLOs. Status and ByService are safe for
+// concurrent use; Evaluate is meant to be called from one goroutine.
+type Evaluator struct {
+	cfg  config.SLOConfig
+	red  *red.Tracker
+	prom *prom.Client
+	// errorRatio and slowRatio are the Prometheus queries
+	errorRatio, slowRatio *template.Template
+	// windows are the alert windows, shortest first
+	windows []time.Duration
+	history map[string]*history
+
+	mu     sync.RWMutex
+	status map[string]status
+}
+
+type status struct {
+	service string
+	models.SLOStatus
+}
+
+// New returns an evaluator reading RED stats from tracker and Prometheus
+// through client, either of which may be nil, leaving SLOs on that
+// source without data.
+func New(cfg config.SLOConfig, tracker *red.Tracker, client *prom.Client) (*Evaluator, error) {
+	e := &Evaluator{
+		cfg:     cfg,
+		red:     tracker,
+		prom:    client,
+		history: make(map[string]*history),
+		status:  make(map[string]status),
+	}
+	var err error
+	if e.errorRatio, err = template.New("error_ratio").Option("missingkey=error").Parse(cfg.Queries.ErrorRatio); err != nil {
+		return nil, fmt.Errorf("slo.queries.error_ratio: %w", err)
+	}
+	if e.slowRatio, err = template.New("slow_ratio").Option("missingkey=error").Parse(cfg.Queries.SlowRatio); err != nil {
+		return nil, fmt.Errorf("slo.queries.slow_ratio: %w", err)
+	}
+	for _, a := range cfg.Alerts {
+		for _, w := range []time.Duration{a.ShortWindow, a.LongWindow} {
+			if !slices.Contains(e.windows, w) {
+				e.windows = append(e.windows, w)
+			}
+		}
+	}
+	slices.Sort(e.windows)
+	return e, nil
+}
+
+// Evaluate brings the status of slos up to now and returns it, in the
+// order of slos. SLOs no longer among slos are forgotten.
+func (e *Evaluator) Evaluate(ctx context.Context, slos []models.SLO, now time.Time) []models.SLOStatus {
+	out := make([]models.SLOStatus, 0, len(slos))
+	next := make(map[string]status, len(slos))
+	for i := range slos {
+		s := &slos[i]
+		var st models.SLOStatus
+		if s.Source == models.SLOSourcePrometheus {
+			st = e.fromPrometheus(ctx, s, now)
+		} else {
+			st = e.fromRED(s, now)
+		}
+		st.Alerts = e.firing(st.Windows)
+		out = append(out, st)
+		next[s.ID] = status{service: s.Service, SLOStatus: st}
+	}
+	for id := range e.history {
+		if _, ok := next[id]; !ok {
+			delete(e.history, id)
+		}
+	}
+	e.mu.Lock()
+	e.status = next
+	e.mu.Unlock()
+	return out
+}
+
+// Status returns the latest status of the SLO with the given ID, and
+// false if it hasn't been evaluated.
+func (e *Evaluator) Status(id string) (models.SLOStatus, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	st, ok := e.status[id]
+	return st.SLOStatus, ok
+}
+
+// ByService returns the latest statuses by service, each service's
+// ordered by SLO ID.
+func (e *Evaluator) ByService() map[string][]models.SLOStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make(map[string][]models.SLOStatus)
+	for _, st := range e.status {
+		out[st.service] = append(out[st.service], st.SLOStatus)
+	}
+	for _, sts := range out {
+		sort.Slice(sts, func(i, j int) bool { return sts[i].SLO < sts[j].SLO })
+	}
+	return out
+}
+
+// firing returns the names of the alerts whose windows both burn at
+// least their factor, most severe first.
+func (e *Evaluator) firing(windows []models.BurnWindow) []string {
+	burn := func(d time.Duration) float64 {
+		w := prom.FormatDuration(d)
+		for _, bw := range windows {
+			if bw.Window == w && bw.BurnRate != nil {
+				return *bw.BurnRate
+			}
+		}
+		return math.NaN()
+	}
+	var fired []config.BurnAlertConfig
+	for _, a := range e.cfg.Alerts {
+		// NaN, for windows without calls, compares false
+		if burn(a.LongWindow) >= a.Factor && burn(a.ShortWindow) >= a.Factor {
+			fired = append(fired, a)
+		}
+	}
+	sort.SliceStable(fired, func(i, j int) bool {
+		return slices.Index(config.Severities, fired[i].Severity) > slices.Index(config.Severities, fired[j].Severity)
+	})
+	var out []string
+	for _, a := range fired {
+		out = append(out, a.Name)
+	}
+	return out
+}
+
+func newStatus(s *models.SLO, now time.Time) models.SLOStatus {
+	return models.SLOStatus{
+		SLO:       s.ID,
+		Endpoint:  s.Endpoint,
+		Kind:      s.Kind,
+		Objective: s.Objective,
+		At:        now.UTC(),
+		Windows:   []models.BurnWindow{},
+	}
+}
+
+// burnWindow turns a bad ratio over window into a burn rate against s's
+// budget.
+func burnWindow(s *models.SLO, window time.Duration, bad float64, ok bool) models.BurnWindow {
+	bw := models.BurnWindow{Window: prom.FormatDuration(window)}
+	if ok {
+		bad = round(min(max(bad, 0), 1))
+		burn := round(bad / s.Budget())
+		bw.BadRatio, bw.BurnRate = &bad, &burn
+	}
+	return bw
+}
+
+// remaining is the share of s's budget left at a bad ratio.
+func remaining(s *models.SLO, bad float64) *float64 {
+	r := round(1 - min(max(bad, 0), 1)/s.Budget())
+	return &r
+}
+
+// fromRED samples the minutes the RED stats hold that s's history
+// doesn't yet, and burns s's history.
+func (e *Evaluator) fromRED(s *models.SLO, now time.Time) models.SLOStatus {
+	st := newStatus(s, now)
+	if e.red == nil {
+		st.Error = "RED stats are not kept"
+		return st
+	}
+	h := e.history[s.ID]
+	if h == nil || h.key != historyKey(s) {
+		h = newHistory(s, e.longest())
+		e.history[s.ID] = h
+	}
+	minute := now.Unix() / 60
+	// Only complete minutes, and no further back than the stats reach
+	from := max(h.sampled+1, minute-int64(e.red.Retention()/time.Minute)+1)
+	var within time.Duration
+	if s.Kind == models.SLOLatency {
+		within = time.Duration(s.ThresholdMS * float64(time.Millisecond))
+	}
+	for m := from; m < minute; m++ {
+		good, total := e.red.Good(s.Service, within, time.Minute, time.Unix(m*60, 0))
+		h.add(m, good, total)
+	}
+	h.sampled = max(h.sampled, minute-1)
+
+	for _, w := range e.windows {
+		bad, ok := h.minutes.badRatio(minute-int64(w/time.Minute), minute)
+		st.Windows = append(st.Windows, burnWindow(s, w, bad, ok))
+	}
+	hour := minute / 60
+	if bad, ok := h.hours.badRatio(hour-int64(s.WindowDays)*24+1, hour+1); ok {
+		st.BudgetRemaining = remaining(s, bad)
+	}
+	return st
+}
+
+// longest is the longest alert window.
+func (e *Evaluator) longest() time.Duration {
+	if len(e.windows) == 0 {
+		return time.Minute
+	}
+	return e.windows[len(e.windows)-1]
+}
+
+// fromPrometheus queries s's bad ratio over each alert window and over
+// its own window.
+func (e *Evaluator) fromPrometheus(ctx context.Context, s *models.SLO, now time.Time) models.SLOStatus {
+	st := newStatus(s, now)
+	if e.prom == nil {
+		st.Error = "prometheus not configured"
+		return st
+	}
+	sel, svcSel := e.prom.Selectors(s.Service, s.Endpoint)
+	tmpl, data := e.errorRatio, map[string]string{"Selector": sel, "ServiceSelector": svcSel}
+	if s.Kind == models.SLOLatency {
+		tmpl = e.slowRatio
+		le := strconv.FormatFloat(s.ThresholdMS/1000, 'f', -1, 64)
+		sep := ","
+		if sel == "{}" {
+			sep = ""
+		}
+		data["ThresholdSelector"] = strings.TrimSuffix(sel, "}") + sep + `le="` + le + `"}`
+	}
+	var errs []error
+	query := func(window time.Duration) (float64, bool) {
+		data["Window"] = prom.FormatDuration(window)
+		var expr strings.Builder
+		if err := tmpl.Execute(&expr, data); err != nil {
+			errs = append(errs, err)
+			return 0, false
+		}
+		v, ok, err := e.prom.Query(ctx, expr.String(), now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", data["Window"], err))
+		}
+		return v, ok
+	}
+	for _, w := range e.windows {
+		bad, ok := query(w)
+		st.Windows = append(st.Windows, burnWindow(s, w, bad, ok))
+	}
+	if bad, ok := query(time.Duration(s.WindowDays) * 24 * time.Hour); ok {
+		st.BudgetRemaining = remaining(s, bad)
+	}
+	if err := errors.Join(errs...); err != nil {
+		st.Error = err.Error()
+	}
+	return st
+}
+
+func round(f float64) float64 {
+	return math.Round(f*10000) / 10000
+}
@@ -0,0 +1,235 @@
+// Package stream fans the progress of incidents out to live clients: graph
+// lookups, rule evaluations and status changes, in the message shapes the
+// frontend already handles for agent runs. Each incident keeps a short
+// history, so clients joining late, or reconnecting, catch up.
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"servicegraph-builder/pkg/metrics"
+)
+
+// Message types, as sent by the agents.
+const (
+	TypeAgentStarted  = "agent_started"
+	TypeAgentUpdated  = "agent_updated"
+	TypeToolCall      = "tool_call"
+	TypeToolOutput    = "tool_output"
+	TypeMessageOutput = "message_output"
+	TypeStatus        = "status"
+	TypeError         = "error"
+)
+
+// Agents the builder reports as. The graph agent's message outputs carry
+// graphs, which the frontend draws.
+const (
+	AgentGraph = "neo4j"
+	AgentRules = "rules"
+)
+
+const (
+	// historyLen is how many messages are kept per incident.
+	historyLen = 256
+	// maxIncidents bounds the incidents histories are kept for; the
+	// least recently published to go first.
+	maxIncidents = 1000
+	// bufferLen is how many messages a subscriber may fall behind by
+	// before it's dropped.
+	bufferLen = 64
+)
+
+// Message is one step of an incident's progress. Agent is nil for status
+// and error messages.
+type Message struct {
+	// Seq numbers an incident's messages from 1, for resuming.
+	Seq      int64     `json:"seq"`
+	Incident string    `json:"incident"`
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Agent    *string   `json:"agent"`
+	Data     any       `json:"data"`
+}
+
+// AgentStarted announces an agent, by name, starting on an incident.
+func AgentStarted(agent, name string) Message {
+	return Message{Type: TypeAgentStarted, Agent: &agent, Data: name}
+}
+
+// ToolCall reports a call, with its arguments JSON-encoded the way the
+// agents send them.
+func ToolCall(agent, function string, args any) Message {
+	b, _ := json.Marshal(args)
+	return Message{Type: TypeToolCall, Agent: &agent, Data: map[string]any{
+		"function_name": function,
+		"arguments":     string(b),
+	}}
+}
+
+// ToolOutput reports what a call returned.
+func ToolOutput(agent, function string, output any) Message {
+	return Message{Type: TypeToolOutput, Agent: &agent, Data: map[string]any{
+		"function_name": function,
+		"output":        output,
+	}}
+}
+
+// MessageOutput is an agent's text. The graph agent's is a JSON graph.
+func MessageOutput(agent, text string) Message {
+	return Message{Type: TypeMessageOutput, Agent: &agent, Data: text}
+}
+
+func Status(text string) Message {
+	return Message{Type: TypeStatus, Data: text}
+}
+
+func Error(text string) Message {
+	return Message{Type: TypeError, Data: text}
+}
+
+// Hub is safe for concurrent use.
+type Hub struct {
+	mu        sync.Mutex
+	incidents map[string]*feed
+	// order lists incidents least recently published to first
+	order  []string
+	closed bool
+}
+
+type feed struct {
+	seq     int64
+	history []Message
+	subs    map[*Subscription]struct{}
+}
+
+// Subscription receives an incident's messages on C until it's closed:
+// by Unsubscribe, by Close, or for falling behind.
+type Subscription struct {
+	C        <-chan Message
+	c        chan Message
+	incident string
+}
+
+func NewHub() *Hub {
+	return &Hub{incidents: make(map[string]*feed)}
+}
+
+// Publish numbers msgs, stamps them and sends them to the incident's
+// subscribers.
+func (h *Hub) Publish(incident string, msgs ...Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	f := h.feed(incident)
+	h.touch(incident)
+	now := time.Now().UTC()
+	for _, m := range msgs {
+		f.seq++
+		m.Seq, m.Incident = f.seq, incident
+		if m.Time.IsZero() {
+			m.Time = now
+		}
+		f.history = append(f.history, m)
+		for s := range f.subs {
+			select {
+			case s.c <- m:
+			default:
+				h.drop(f, s)
+				metrics.StreamDropped.Inc()
+			}
+		}
+	}
+	if len(f.history) > historyLen {
+		f.history = f.history[len(f.history)-historyLen:]
+	}
+}
+
+// Subscribe follows an incident. It returns the kept messages after seq
+// after, which the subscription then carries on from.
+func (h *Hub) Subscribe(incident string, after int64) (*Subscription, []Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := make(chan Message, bufferLen)
+	s := &Subscription{C: c, c: c, incident: incident}
+	if h.closed {
+		close(c)
+		return s, nil
+	}
+	f := h.feed(incident)
+	f.subs[s] = struct{}{}
+	metrics.StreamSubscribers.Inc()
+	var backlog []Message
+	for _, m := range f.history {
+		if m.Seq > after {
+			backlog = append(backlog, m)
+		}
+	}
+	return s, backlog
+}
+
+// Unsubscribe stops s.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if f, ok := h.incidents[s.incident]; ok {
+		if _, ok := f.subs[s]; ok {
+			h.drop(f, s)
+		}
+	}
+}
+
+// Close ends every subscription; later publishes are dropped.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, f := range h.incidents {
+		for s := range f.subs {
+			h.drop(f, s)
+		}
+	}
+}
+
+// feed returns the incident's feed, making it if need be. Callers hold
+// h.mu.
+func (h *Hub) feed(incident string) *feed {
+	f, ok := h.incidents[incident]
+	if !ok {
+		f = &feed{subs: make(map[*Subscription]struct{})}
+		h.incidents[incident] = f
+		h.order = append(h.order, incident)
+	}
+	return f
+}
+
+// touch moves incident to the back of the order and forgets the history
+// of incidents past maxIncidents nobody follows. Callers hold h.mu.
+func (h *Hub) touch(incident string) {
+	for i, id := range h.order {
+		if id == incident {
+			h.order = append(h.order[:i], h.order[i+1:]...)
+			break
+		}
+	}
+	h.order = append(h.order, incident)
+	for i := 0; len(h.incidents) > maxIncidents && i < len(h.order); {
+		id := h.order[i]
+		if len(h.incidents[id].subs) > 0 {
+			i++
+			continue
+		}
+		delete(h.incidents, id)
+		h.order = append(h.order[:i], h.order[i+1:]...)
+	}
+}
+
+// drop ends s. Callers hold h.mu.
+func (h *Hub) drop(f *feed, s *Subscription) {
+	delete(f.subs, s)
+	close(s.c)
+	metrics.StreamSubscribers.Dec()
+}
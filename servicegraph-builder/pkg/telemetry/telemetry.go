@@ -0,0 +1,63 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+
+	"servicegraph-builder/pkg/config"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+// SelfAttribute marks the builder's own resource so its traces can be
+// recognised and kept out of the service graph if they loop back in.
+const SelfAttribute = "servicegraph.self"
+
+// Setup installs a global tracer provider exporting the builder's own
+// spans over OTLP gRPC. When telemetry is disabled the global no-op
+// provider stays in place. The returned func flushes and stops the
+// exporter.
+func Setup(ctx context.Context, cfg config.TelemetryConfig) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP trace exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		attribute.Bool(SelfAttribute, true),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("build telemetry resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	log.Info().
+		Str("endpoint", cfg.Endpoint).
+		Float64("sample_ratio", cfg.SampleRatio).
+		Msg("Self-tracing enabled")
+	return tp.Shutdown, nil
+}
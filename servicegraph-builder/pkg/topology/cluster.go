@@ -0,0 +1,226 @@
+package topology
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Cluster is the subset of cluster state inference reads.
+type Cluster struct {
+	Namespaces      []corev1.Namespace
+	Services        []corev1.Service
+	Workloads       []Workload
+	NetworkPolicies []networkingv1.NetworkPolicy
+}
+
+// Workload is anything with a pod template.
+type Workload struct {
+	Kind       string
+	Name       string
+	Namespace  string
+	Labels     map[string]string
+	Containers []corev1.Container
+}
+
+// Add records obj if it is a kind inference uses and ignores it otherwise.
+func (c *Cluster) Add(obj runtime.Object) {
+	switch o := obj.(type) {
+	case *corev1.Namespace:
+		c.Namespaces = append(c.Namespaces, *o)
+	case *corev1.Service:
+		c.Services = append(c.Services, *o)
+	case *networkingv1.NetworkPolicy:
+		c.NetworkPolicies = append(c.NetworkPolicies, *o)
+	case *appsv1.Deployment:
+		c.addWorkload("Deployment", o.ObjectMeta, o.Spec.Template)
+	case *appsv1.StatefulSet:
+		c.addWorkload("StatefulSet", o.ObjectMeta, o.Spec.Template)
+	case *appsv1.DaemonSet:
+		c.addWorkload("DaemonSet", o.ObjectMeta, o.Spec.Template)
+	case *batchv1.CronJob:
+		c.addWorkload("CronJob", o.ObjectMeta, o.Spec.JobTemplate.Spec.Template)
+	case *corev1.List:
+		for _, item := range o.Items {
+			if item.Object != nil {
+				c.Add(item.Object)
+			}
+		}
+	}
+}
+
+func (c *Cluster) addWorkload(kind string, meta metav1.ObjectMeta, tmpl corev1.PodTemplateSpec) {
+	ns := meta.Namespace
+	if ns == "" {
+		ns = metav1.NamespaceDefault
+	}
+	c.Workloads = append(c.Workloads, Workload{
+		Kind:       kind,
+		Name:       meta.Name,
+		Namespace:  ns,
+		Labels:     tmpl.Labels,
+		Containers: append(append([]corev1.Container(nil), tmpl.Spec.InitContainers...), tmpl.Spec.Containers...),
+	})
+}
+
+// LoadCluster lists the objects inference needs from the API server,
+// limited to namespaces when any are given.
+func LoadCluster(ctx context.Context, client kubernetes.Interface, namespaces []string) (*Cluster, error) {
+	c := &Cluster{}
+	opts := metav1.ListOptions{}
+
+	nsList, err := client.CoreV1().Namespaces().List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list namespaces: %w", err)
+	}
+	c.Namespaces = nsList.Items
+
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	for _, ns := range namespaces {
+		svcs, err := client.CoreV1().Services(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("list services: %w", err)
+		}
+		c.Services = append(c.Services, svcs.Items...)
+
+		pols, err := client.NetworkingV1().NetworkPolicies(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("list network policies: %w", err)
+		}
+		c.NetworkPolicies = append(c.NetworkPolicies, pols.Items...)
+
+		deps, err := client.AppsV1().Deployments(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("list deployments: %w", err)
+		}
+		for i := range deps.Items {
+			c.Add(&deps.Items[i])
+		}
+		sts, err := client.AppsV1().StatefulSets(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("list statefulsets: %w", err)
+		}
+		for i := range sts.Items {
+			c.Add(&sts.Items[i])
+		}
+		dss, err := client.AppsV1().DaemonSets(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("list daemonsets: %w", err)
+		}
+		for i := range dss.Items {
+			c.Add(&dss.Items[i])
+		}
+		cjs, err := client.BatchV1().CronJobs(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("list cronjobs: %w", err)
+		}
+		for i := range cjs.Items {
+			c.Add(&cjs.Items[i])
+		}
+	}
+	return c, nil
+}
+
+// ReadManifests builds a Cluster from manifest files. Directories expand
+// to the .yaml, .yml and .json files inside them.
+func ReadManifests(paths []string) (*Cluster, error) {
+	objs, err := DecodeManifests(paths)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{}
+	for _, obj := range objs {
+		c.Add(obj)
+	}
+	return c, nil
+}
+
+// DecodeManifests decodes every Kubernetes object in the files at paths.
+// Documents that aren't built-in Kubernetes kinds, such as CRDs or Helm
+// values files, are skipped.
+func DecodeManifests(paths []string) ([]runtime.Object, error) {
+	files, err := manifestFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+
+	var objs []runtime.Object
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+		for {
+			doc, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if len(bytes.TrimSpace(doc)) == 0 {
+				continue
+			}
+			obj, _, err := decode(doc, nil, nil)
+			if runtime.IsNotRegisteredError(err) || runtime.IsMissingKind(err) || runtime.IsMissingVersion(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			objs = append(objs, obj)
+		}
+	}
+	return objs, nil
+}
+
+func manifestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".yaml", ".yml", ".json":
+				if e.Type().IsRegular() {
+					names = append(names, filepath.Join(p, e.Name()))
+				}
+			}
+		}
+		sort.Strings(names)
+		files = append(files, names...)
+	}
+	return files, nil
+}
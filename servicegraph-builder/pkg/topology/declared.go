@@ -0,0 +1,116 @@
+package topology
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"servicegraph-builder/pkg/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Declaration is a YAML file of services and edges that exist whether or
+// not traces have shown them, such as cron jobs, rarely called backends
+// and third-party APIs.
+type Declaration struct {
+	Services []DeclaredService `yaml:"services"`
+	Edges    []DeclaredEdge    `yaml:"edges"`
+}
+
+type DeclaredService struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	OwnerKind string `yaml:"owner_kind"`
+	OwnerName string `yaml:"owner_name"`
+}
+
+type DeclaredEdge struct {
+	Caller    string `yaml:"caller"`
+	Callee    string `yaml:"callee"`
+	Operation string `yaml:"operation"`
+	Namespace string `yaml:"namespace"`
+}
+
+// Declared is the merged content of one or more declaration files.
+type Declared struct {
+	Services []models.Service
+	Edges    []models.Edge
+}
+
+// LoadDeclared reads and merges the declaration files at paths. Service
+// names are normalised the way observed names are, and duplicate edges
+// across files are collapsed.
+func LoadDeclared(paths []string) (*Declared, error) {
+	out := &Declared{}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		decl, err := readDeclaration(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var errs []error
+		for i, s := range decl.Services {
+			name := normalise(s.Name)
+			if name == "" {
+				errs = append(errs, fmt.Errorf("services[%d]: name is required", i))
+				continue
+			}
+			out.Services = append(out.Services, models.Service{
+				Name:      name,
+				Namespace: s.Namespace,
+				OwnerKind: s.OwnerKind,
+				OwnerName: s.OwnerName,
+			})
+		}
+		for i, e := range decl.Edges {
+			caller, callee := normalise(e.Caller), normalise(e.Callee)
+			switch {
+			case caller == "" || callee == "":
+				errs = append(errs, fmt.Errorf("edges[%d]: caller and callee are required", i))
+				continue
+			case caller == callee:
+				errs = append(errs, fmt.Errorf("edges[%d]: %s calls itself", i, caller))
+				continue
+			}
+			if key := caller + "\x00" + callee; !seen[key] {
+				seen[key] = true
+				out.Edges = append(out.Edges, models.Edge{
+					Caller:    caller,
+					Callee:    callee,
+					Operation: e.Operation,
+					Namespace: e.Namespace,
+					Origin:    models.OriginDeclared,
+				})
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, fmt.Errorf("topology %s: %w", path, err)
+		}
+	}
+	return out, nil
+}
+
+func readDeclaration(path string) (*Declaration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read topology: %w", err)
+	}
+	var decl Declaration
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&decl); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse topology %s: %w", path, err)
+	}
+	return &decl, nil
+}
+
+// normalise matches the trimming and lower-casing applied to observed
+// service names before they are stored.
+func normalise(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
@@ -0,0 +1,95 @@
+package topology
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"servicegraph-builder/pkg/models"
+)
+
+// Report compares declared edges with edges seen in traffic.
+type Report struct {
+	// Matched counts edges both declared and observed.
+	Matched int `json:"matched"`
+	// DeclaredNotObserved are declared edges no trace has shown yet.
+	DeclaredNotObserved []models.Edge `json:"declared_not_observed"`
+	// ObservedNotDeclared are edges seen in traffic that no declaration
+	// covers.
+	ObservedNotDeclared []models.Edge `json:"observed_not_declared"`
+}
+
+// Diff compares declared against the observed edges in g. Edges that are
+// only declared or inferred in g don't count as observed.
+func Diff(declared []models.Edge, g *models.Graph) Report {
+	observed := make(map[string]models.Edge)
+	for _, e := range g.Edges {
+		if e.Origin == models.OriginObserved || e.Origin == "" {
+			observed[edgeKey(e.Edge)] = e.Edge
+		}
+	}
+
+	r := Report{
+		DeclaredNotObserved: []models.Edge{},
+		ObservedNotDeclared: []models.Edge{},
+	}
+	isDeclared := make(map[string]bool, len(declared))
+	for _, e := range declared {
+		key := edgeKey(e)
+		isDeclared[key] = true
+		if _, ok := observed[key]; ok {
+			r.Matched++
+		} else {
+			r.DeclaredNotObserved = append(r.DeclaredNotObserved, e)
+		}
+	}
+	for key, e := range observed {
+		if !isDeclared[key] {
+			r.ObservedNotDeclared = append(r.ObservedNotDeclared, e)
+		}
+	}
+	sortEdges(r.DeclaredNotObserved)
+	sortEdges(r.ObservedNotDeclared)
+	return r
+}
+
+// WriteText writes r for humans.
+func (r Report) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%d declared edges observed\n", r.Matched)
+	if err != nil {
+		return err
+	}
+	sections := []struct {
+		title string
+		edges []models.Edge
+	}{
+		{"declared but never observed", r.DeclaredNotObserved},
+		{"observed but not declared", r.ObservedNotDeclared},
+	}
+	for _, s := range sections {
+		fmt.Fprintf(w, "\n%d %s:\n", len(s.edges), s.title)
+		for _, e := range s.edges {
+			fmt.Fprintf(w, "  %s -> %s", e.Caller, e.Callee)
+			if e.Operation != "" {
+				fmt.Fprintf(w, " (%s)", e.Operation)
+			}
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func edgeKey(e models.Edge) string {
+	return e.Caller + "\x00" + e.Callee
+}
+
+func sortEdges(edges []models.Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Caller != edges[j].Caller {
+			return edges[i].Caller < edges[j].Caller
+		}
+		return edges[i].Callee < edges[j].Callee
+	})
+}
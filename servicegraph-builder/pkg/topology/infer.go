@@ -0,0 +1,299 @@
+package topology
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"servicegraph-builder/pkg/models"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// InferredEdge is a proposed edge and the objects that suggested it.
+type InferredEdge struct {
+	models.Edge
+	Evidence []string `json:"evidence"`
+}
+
+// Inferred is the result of an inference pass.
+type Inferred struct {
+	Services []models.Service
+	Edges    []InferredEdge
+}
+
+// ModelEdges returns the edges without their evidence.
+func (i *Inferred) ModelEdges() []models.Edge {
+	out := make([]models.Edge, len(i.Edges))
+	for n, e := range i.Edges {
+		out[n] = e.Edge
+	}
+	return out
+}
+
+// envSuffixes mark env vars whose values name another service.
+var envSuffixes = []string{"_URL", "_URI", "_ADDR", "_ADDRESS", "_HOST", "_ENDPOINT"}
+
+// Infer proposes CALLS edges from cluster state alone:
+//
+//   - env vars like USER_SERVICE_URL=http://user-service:8081 pointing at
+//     a Service in the cluster
+//   - NetworkPolicy ingress and egress rules between pod selectors
+//
+// Workloads are named after the Service selecting their pods, which is
+// what they report as service.name, and fall back to the workload name.
+func Infer(c *Cluster) *Inferred {
+	inf := newInferrer(c)
+	for _, w := range c.Workloads {
+		inf.fromEnv(w)
+	}
+	for _, p := range c.NetworkPolicies {
+		inf.fromNetworkPolicy(p)
+	}
+	return inf.result()
+}
+
+type inferrer struct {
+	cluster  *Cluster
+	services map[string]corev1.Service // "ns/name"
+	nodes    map[string]models.Service
+	edges    map[string]*InferredEdge
+}
+
+func newInferrer(c *Cluster) *inferrer {
+	inf := &inferrer{
+		cluster:  c,
+		services: make(map[string]corev1.Service),
+		nodes:    make(map[string]models.Service),
+		edges:    make(map[string]*InferredEdge),
+	}
+	for _, s := range c.Services {
+		inf.services[namespaceOf(s.ObjectMeta)+"/"+s.Name] = s
+	}
+	return inf
+}
+
+// nodeName is the graph name for w: the first Service, by name, whose
+// selector matches w's pods.
+func (inf *inferrer) nodeName(w Workload) string {
+	var matches []string
+	for _, s := range inf.cluster.Services {
+		if namespaceOf(s.ObjectMeta) != w.Namespace || len(s.Spec.Selector) == 0 {
+			continue
+		}
+		if labels.SelectorFromSet(s.Spec.Selector).Matches(labels.Set(w.Labels)) {
+			matches = append(matches, s.Name)
+		}
+	}
+	if len(matches) == 0 {
+		return normalise(w.Name)
+	}
+	sort.Strings(matches)
+	return normalise(matches[0])
+}
+
+func (inf *inferrer) workloadNode(w Workload) string {
+	name := inf.nodeName(w)
+	if _, ok := inf.nodes[name]; !ok {
+		inf.nodes[name] = models.Service{
+			Name:      name,
+			Namespace: w.Namespace,
+			OwnerKind: w.Kind,
+			OwnerName: w.Name,
+		}
+	}
+	return name
+}
+
+func (inf *inferrer) serviceNode(s corev1.Service) string {
+	name := normalise(s.Name)
+	if _, ok := inf.nodes[name]; !ok {
+		inf.nodes[name] = models.Service{Name: name, Namespace: namespaceOf(s.ObjectMeta)}
+	}
+	return name
+}
+
+func (inf *inferrer) add(caller, callee, namespace, evidence string) {
+	if caller == callee {
+		return
+	}
+	key := caller + "\x00" + callee
+	e, ok := inf.edges[key]
+	if !ok {
+		e = &InferredEdge{Edge: models.Edge{
+			Caller:    caller,
+			Callee:    callee,
+			Namespace: namespace,
+			Origin:    models.OriginInferred,
+		}}
+		inf.edges[key] = e
+	}
+	e.Evidence = append(e.Evidence, evidence)
+}
+
+func (inf *inferrer) fromEnv(w Workload) {
+	for _, ctr := range w.Containers {
+		for _, env := range ctr.Env {
+			host := envHost(env)
+			if host == "" {
+				continue
+			}
+			svc, ok := inf.resolve(host, w.Namespace)
+			if !ok {
+				continue
+			}
+			inf.add(inf.workloadNode(w), inf.serviceNode(svc), w.Namespace,
+				fmt.Sprintf("env %s %s/%s %s", strings.ToLower(w.Kind), w.Namespace, w.Name, env.Name))
+		}
+	}
+}
+
+// envHost returns the host an env var points at, or "" if it doesn't
+// look like a service address.
+func envHost(env corev1.EnvVar) string {
+	v := strings.TrimSpace(env.Value)
+	if v == "" {
+		return ""
+	}
+	if strings.Contains(v, "://") {
+		u, err := url.Parse(v)
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	}
+	name := strings.ToUpper(env.Name)
+	hasSuffix := false
+	for _, suffix := range envSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			hasSuffix = true
+			break
+		}
+	}
+	if !hasSuffix {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		return host
+	}
+	if strings.ContainsAny(v, "/ ") {
+		return ""
+	}
+	return v
+}
+
+// resolve maps a DNS name as seen from namespace ns (name, name.ns,
+// name.ns.svc or the fully qualified form) to a Service.
+func (inf *inferrer) resolve(host, ns string) (corev1.Service, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	host = strings.TrimSuffix(host, ".cluster.local")
+	host = strings.TrimSuffix(host, ".svc")
+	parts := strings.Split(host, ".")
+	switch len(parts) {
+	case 1:
+	case 2:
+		ns = parts[1]
+	default:
+		return corev1.Service{}, false
+	}
+	svc, ok := inf.services[ns+"/"+parts[0]]
+	return svc, ok
+}
+
+func (inf *inferrer) fromNetworkPolicy(p networkingv1.NetworkPolicy) {
+	ns := namespaceOf(p.ObjectMeta)
+	targets := inf.selectWorkloads(ns, &p.Spec.PodSelector, nil, true)
+	evidence := fmt.Sprintf("networkpolicy %s/%s", ns, p.Name)
+
+	for _, rule := range p.Spec.Ingress {
+		for _, peer := range rule.From {
+			for _, src := range inf.selectPeer(ns, peer) {
+				for _, dst := range targets {
+					inf.add(inf.workloadNode(src), inf.workloadNode(dst), ns, evidence+" ingress")
+				}
+			}
+		}
+	}
+	for _, rule := range p.Spec.Egress {
+		for _, peer := range rule.To {
+			for _, dst := range inf.selectPeer(ns, peer) {
+				for _, src := range targets {
+					inf.add(inf.workloadNode(src), inf.workloadNode(dst), ns, evidence+" egress")
+				}
+			}
+		}
+	}
+}
+
+// selectPeer returns the workloads a policy peer names. Peers without a
+// pod selector, or with an empty one, allow whole namespaces or address
+// ranges and say nothing about specific dependencies, so they are skipped.
+func (inf *inferrer) selectPeer(ns string, peer networkingv1.NetworkPolicyPeer) []Workload {
+	if peer.PodSelector == nil || len(peer.PodSelector.MatchLabels)+len(peer.PodSelector.MatchExpressions) == 0 {
+		return nil
+	}
+	return inf.selectWorkloads(ns, peer.PodSelector, peer.NamespaceSelector, false)
+}
+
+// selectWorkloads returns workloads matching podSel, in ns or in the
+// namespaces nsSel matches. An empty podSel matches everything only when
+// allowEmpty is set.
+func (inf *inferrer) selectWorkloads(ns string, podSel, nsSel *metav1.LabelSelector, allowEmpty bool) []Workload {
+	pods, err := metav1.LabelSelectorAsSelector(podSel)
+	if err != nil || (pods.Empty() && !allowEmpty) {
+		return nil
+	}
+	inNamespace := func(name string) bool { return name == ns }
+	if nsSel != nil {
+		sel, err := metav1.LabelSelectorAsSelector(nsSel)
+		if err != nil {
+			return nil
+		}
+		matched := make(map[string]bool)
+		for _, n := range inf.cluster.Namespaces {
+			if sel.Matches(labels.Set(n.Labels)) {
+				matched[n.Name] = true
+			}
+		}
+		inNamespace = func(name string) bool { return matched[name] }
+	}
+
+	var out []Workload
+	for _, w := range inf.cluster.Workloads {
+		if inNamespace(w.Namespace) && pods.Matches(labels.Set(w.Labels)) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+func (inf *inferrer) result() *Inferred {
+	out := &Inferred{}
+	for _, s := range inf.nodes {
+		out.Services = append(out.Services, s)
+	}
+	sort.Slice(out.Services, func(i, j int) bool { return out.Services[i].Name < out.Services[j].Name })
+	for _, e := range inf.edges {
+		sort.Strings(e.Evidence)
+		out.Edges = append(out.Edges, *e)
+	}
+	sort.Slice(out.Edges, func(i, j int) bool {
+		if out.Edges[i].Caller != out.Edges[j].Caller {
+			return out.Edges[i].Caller < out.Edges[j].Caller
+		}
+		return out.Edges[i].Callee < out.Edges[j].Callee
+	})
+	return out
+}
+
+func namespaceOf(meta metav1.ObjectMeta) string {
+	if meta.Namespace == "" {
+		return metav1.NamespaceDefault
+	}
+	return meta.Namespace
+}
@@ -0,0 +1,172 @@
+// Package wal is a single-file append-only log of opaque records.
+//
+// Each record is framed as a 4-byte big-endian length, a 4-byte CRC-32
+// of the payload and the payload itself, and is fsynced before Append
+// returns. A torn record at the end of the file, left by a crash during
+// Append, is cut off when the log is opened.
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrFull is returned by Append when the record would take the log past
+// its size limit.
+var ErrFull = errors.New("write-ahead log is full")
+
+const (
+	fileName   = "spool.wal"
+	headerSize = 8
+)
+
+// Log is safe for concurrent use.
+type Log struct {
+	mu       sync.Mutex
+	f        *os.File
+	maxBytes int64
+	size     int64
+	records  int
+}
+
+// Open opens or creates the log in dir. maxBytes limits the file size;
+// 0 means unbounded.
+func Open(dir string, maxBytes int64) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, fileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l := &Log{f: f, maxBytes: maxBytes}
+
+	// Find the end of the last intact record
+	for {
+		_, next, err := l.readAt(l.size, info.Size())
+		if err != nil {
+			break
+		}
+		l.size = next
+		l.records++
+	}
+	if err := f.Truncate(l.size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append writes data as one record and syncs it to disk.
+func (l *Log) Append(data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := int64(headerSize + len(data))
+	if l.maxBytes > 0 && l.size+n > l.maxBytes {
+		return ErrFull
+	}
+	buf := make([]byte, n)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[headerSize:], data)
+	if _, err := l.f.WriteAt(buf, l.size); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.size += n
+	l.records++
+	return nil
+}
+
+// Record is one entry read back from the log.
+type Record struct {
+	Data []byte
+	// Next is the offset of the following record.
+	Next int64
+}
+
+// Read returns up to max records starting at offset off. max <= 0 reads
+// to the end.
+func (l *Log) Read(off int64, max int) ([]Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []Record
+	for off < l.size && (max <= 0 || len(out) < max) {
+		payload, next, err := l.readAt(off, l.size)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, Record{Data: payload, Next: next})
+		off = next
+	}
+	return out, nil
+}
+
+// Reset empties the log.
+func (l *Log) Reset() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	l.size, l.records = 0, 0
+	return l.f.Sync()
+}
+
+// Len returns the number of records in the log.
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.records
+}
+
+// Size returns the log size in bytes.
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// readAt decodes the record at off, which must end by end. Callers hold
+// l.mu, except Open.
+func (l *Log) readAt(off, end int64) ([]byte, int64, error) {
+	var header [headerSize]byte
+	if off+headerSize > end {
+		return nil, off, io.EOF
+	}
+	if _, err := l.f.ReadAt(header[:], off); err != nil {
+		return nil, off, err
+	}
+	n := int64(binary.BigEndian.Uint32(header[0:4]))
+	if off+headerSize+n > end {
+		return nil, off, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, n)
+	if _, err := l.f.ReadAt(payload, off+headerSize); err != nil {
+		return nil, off, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, off, errors.New("checksum mismatch")
+	}
+	return payload, off + headerSize + int64(n), nil
+}
@@ -0,0 +1,110 @@
+// Package webhook POSTs JSON payloads to an HTTP endpoint, retrying
+// transient failures.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"servicegraph-builder/pkg/config"
+)
+
+const (
+	// attempts is how many times a payload is sent before giving up.
+	attempts = 3
+	// retryDelay is the wait after the first failed attempt, doubling
+	// after each further one.
+	retryDelay = time.Second
+)
+
+// Signature headers, set when the webhook has a secret. The signature is
+// "sha256=" and the hex HMAC-SHA256, keyed with the secret, of the
+// timestamp, a dot and the body.
+const (
+	HeaderSignature = "X-Servicegraph-Signature"
+	HeaderTimestamp = "X-Servicegraph-Timestamp"
+)
+
+// Client is safe for concurrent use.
+type Client struct {
+	cfg  config.WebhookConfig
+	http *http.Client
+}
+
+func New(cfg config.WebhookConfig) *Client {
+	return &Client{cfg: cfg, http: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// Post sends v as JSON. Network errors, 429s and 5xx responses are
+// retried; other 4xx responses are not.
+func (c *Client) Post(ctx context.Context, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.PostRaw(ctx, body)
+}
+
+// PostRaw sends body, which must be JSON, as Post does.
+func (c *Client) PostRaw(ctx context.Context, body []byte) error {
+	delay := retryDelay
+	for i := 1; ; i++ {
+		retry, err := c.post(ctx, body)
+		if err == nil || !retry || i == attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) post(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if c.cfg.Secret != "" {
+		// Signed per attempt, so retries carry a fresh timestamp
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, Sign(c.cfg.Secret, ts, body))
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook %s: %s", c.cfg.URL, resp.Status)
+}
+
+// Sign returns the signature header value of body sent at timestamp ts,
+// for receivers to compare against, in constant time, what they got.
+func Sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}